@@ -17,10 +17,12 @@ var _fv float64
 var _uv uintptr
 
 var preloads [int(preloadLimit)]LValue
+var intPreloads [int(preloadLimit)]LValue
 
 func init() {
 	for i := 0; i < int(preloadLimit); i++ {
 		preloads[i] = LNumber(i)
+		intPreloads[i] = LInteger(i)
 	}
 }
 
@@ -29,9 +31,12 @@ type allocator struct {
 	size    int
 	fptrs   []float64
 	fheader *reflect.SliceHeader
+	iptrs   []int64
 
-	scratchValue  LValue
-	scratchValueP *iface
+	scratchValue    LValue
+	scratchValueP   *iface
+	scratchInteger  LValue
+	scratchIntegerP *iface
 }
 
 func newAllocator(size int) *allocator {
@@ -43,6 +48,9 @@ func newAllocator(size int) *allocator {
 	al.fheader = (*reflect.SliceHeader)(unsafe.Pointer(&al.fptrs))
 	al.scratchValue = LNumber(0)
 	al.scratchValueP = (*iface)(unsafe.Pointer(&al.scratchValue))
+	al.iptrs = make([]int64, 0, size)
+	al.scratchInteger = LInteger(0)
+	al.scratchIntegerP = (*iface)(unsafe.Pointer(&al.scratchInteger))
 
 	return al
 }
@@ -77,3 +85,20 @@ func (al *allocator) LNumber2I(v LNumber) LValue {
 
 	return al.scratchValue
 }
+
+// LInteger2I takes an integer value and returns an interface LValue representing the same integer.
+// See LNumber2I.
+func (al *allocator) LInteger2I(v LInteger) LValue {
+	if v >= 0 && v < LInteger(preloadLimit) {
+		return intPreloads[int(v)]
+	}
+
+	if cap(al.iptrs) == len(al.iptrs) {
+		al.iptrs = make([]int64, 0, al.size)
+	}
+
+	al.iptrs = append(al.iptrs, int64(v))
+	al.scratchIntegerP.word = unsafe.Pointer(&al.iptrs[len(al.iptrs)-1])
+
+	return al.scratchInteger
+}
@@ -61,11 +61,16 @@ type IdentExpr struct {
 	Value string
 }
 
+type SuperExpr struct {
+	ExprBase
+}
+
 type AttrGetExpr struct {
 	ExprBase
 
-	Object Expr
-	Key    Expr
+	Object   Expr
+	Key      Expr
+	Optional bool
 }
 
 type TableExpr struct {
@@ -82,6 +87,8 @@ type FuncCallExpr struct {
 	Method    string
 	Args      []Expr
 	AdjustRet bool
+	Optional  bool
+	NamedArgs bool // called as f{...}
 }
 
 type LogicalOpExpr struct {
@@ -92,6 +99,30 @@ type LogicalOpExpr struct {
 	Rhs      Expr
 }
 
+// RangePattern matches numbers from Lo to Hi inclusive in a match arm.
+type RangePattern struct {
+	ExprBase
+
+	Lo Expr
+	Hi Expr
+}
+
+// TypePattern matches values of the given type in a match arm and binds
+// them to Name.
+type TypePattern struct {
+	ExprBase
+
+	Name string
+	Type *TypeHint
+}
+
+type NilCoalesceOpExpr struct {
+	ExprBase
+
+	Lhs Expr
+	Rhs Expr
+}
+
 type RelationalOpExpr struct {
 	ExprBase
 
@@ -107,6 +138,12 @@ type StringConcatOpExpr struct {
 	Rhs Expr
 }
 
+type InterpolatedStringExpr struct {
+	ExprBase
+
+	Parts []Expr
+}
+
 type ArithmeticOpExpr struct {
 	ExprBase
 
@@ -130,9 +167,16 @@ type UnaryLenOpExpr struct {
 	Expr Expr
 }
 
+type UnaryBNotOpExpr struct {
+	ExprBase
+	Expr Expr
+}
+
 type FunctionExpr struct {
 	ExprBase
 
-	ParList *ParList
-	Stmts   []Stmt
+	Name        string
+	ParList     *ParList
+	ReturnTypes []*TypeHint
+	Stmts       []Stmt
 }
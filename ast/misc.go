@@ -8,6 +8,8 @@ type Field struct {
 type ParList struct {
 	HasVargs bool
 	Names    []string
+	Types    []*TypeHint
+	Defaults []Expr // default values evaluated when an argument is nil, may be nil
 }
 
 type FuncName struct {
@@ -15,3 +17,43 @@ type FuncName struct {
 	Receiver Expr
 	Method   string
 }
+
+// ClassMember is a method, a static function or a static field of a class.
+type ClassMember struct {
+	Node
+
+	Name   string
+	Static bool
+	Value  Expr
+}
+
+// DestructField binds the local Name to a field of the value destructured
+// by a LocalAssignStmt, the value at index Value of the list. Key is a
+// NumberExpr for positional fields and nil for a name outside of braces,
+// which is bound to the value itself.
+type DestructField struct {
+	Node
+
+	Name    string
+	Key     Expr
+	Default Expr
+	Value   int
+}
+
+// MatchArm is a case of a MatchStmt. Pattern is a literal, an IdentExpr that
+// binds the value ('_' matches anything), a TableExpr shape, a RangePattern
+// or a TypePattern. Guard may be nil.
+type MatchArm struct {
+	Node
+
+	Pattern Expr
+	Guard   Expr
+	Stmts   []Stmt
+}
+
+type TypeHint struct {
+	Node
+
+	Name     string
+	Nullable bool
+}
@@ -18,11 +18,21 @@ type AssignStmt struct {
 	Rhs []Expr
 }
 
+type CompoundAssignStmt struct {
+	StmtBase
+
+	Operator string
+	Lhs      Expr
+	Rhs      Expr
+}
+
 type LocalAssignStmt struct {
 	StmtBase
 
-	Names []string
-	Exprs []Expr
+	Names   []string
+	Attribs []string // "const", "close" or "" for each name, may be nil
+	Exprs   []Expr
+	Pattern []*DestructField // local {a, b=}, c = exprs, one per name, nil without braces
 }
 
 type FuncCallStmt struct {
@@ -51,6 +61,14 @@ type RepeatStmt struct {
 	Stmts     []Stmt
 }
 
+// MatchStmt runs the Stmts of the first of Arms whose pattern matches Value.
+type MatchStmt struct {
+	StmtBase
+
+	Value Expr
+	Arms  []*MatchArm
+}
+
 type IfStmt struct {
 	StmtBase
 
@@ -84,6 +102,15 @@ type FuncDefStmt struct {
 	Func *FunctionExpr
 }
 
+type ClassStmt struct {
+	StmtBase
+
+	Name    string
+	Local   bool
+	Base    Expr
+	Members []*ClassMember
+}
+
 type ReturnStmt struct {
 	StmtBase
 
@@ -94,6 +121,10 @@ type BreakStmt struct {
 	StmtBase
 }
 
+type ContinueStmt struct {
+	StmtBase
+}
+
 type LabelStmt struct {
 	StmtBase
 
@@ -15,6 +15,17 @@ type Token struct {
 	Name string
 	Str  string
 	Pos  Position
+	// trivia kept for source tools such as formatters
+	Comments []*Comment // comments between the previous token and this one
+	Newlines int        // line breaks between the last comment or token and this one
+}
+
+// Comment is a line or long comment. Text is the comment as written,
+// including the leading '--'.
+type Comment struct {
+	Pos      Position
+	Text     string
+	Newlines int // line breaks between the previous token or comment and this one
 }
 
 func (self *Token) String() string {
@@ -22,6 +22,9 @@ func (ls *LState) CheckInt(n int) int {
 	if intv, ok := v.(LNumber); ok {
 		return int(intv)
 	}
+	if intv, ok := v.(LInteger); ok {
+		return int(intv)
+	}
 	ls.TypeError(n, LTNumber)
 	return 0
 }
@@ -31,6 +34,9 @@ func (ls *LState) CheckInt64(n int) int64 {
 	if intv, ok := v.(LNumber); ok {
 		return int64(intv)
 	}
+	if intv, ok := v.(LInteger); ok {
+		return int64(intv)
+	}
 	ls.TypeError(n, LTNumber)
 	return 0
 }
@@ -40,6 +46,9 @@ func (ls *LState) CheckNumber(n int) LNumber {
 	if lv, ok := v.(LNumber); ok {
 		return lv
 	}
+	if lv, ok := v.(LInteger); ok {
+		return LNumber(lv)
+	}
 	if lv, ok := v.(LString); ok {
 		if num, err := parseNumber(string(lv)); err == nil {
 			return num
@@ -49,6 +58,20 @@ func (ls *LState) CheckNumber(n int) LNumber {
 	return 0
 }
 
+// CheckInteger checks whether or not the n-th argument is a number with an
+// exact integer representation and returns it as an integer.
+func (ls *LState) CheckInteger(n int) LInteger {
+	v := ls.Get(n)
+	if iv, ok := lvToInteger(v); ok {
+		return iv
+	}
+	if _, ok := lvToNumber(v); ok {
+		ls.ArgError(n, "number has no integer representation")
+	}
+	ls.TypeError(n, LTNumber)
+	return 0
+}
+
 func (ls *LState) CheckString(n int) string {
 	v := ls.Get(n)
 	if lv, ok := v.(LString); ok {
@@ -149,6 +172,9 @@ func (ls *LState) OptInt(n int, d int) int {
 	if intv, ok := v.(LNumber); ok {
 		return int(intv)
 	}
+	if intv, ok := v.(LInteger); ok {
+		return int(intv)
+	}
 	ls.TypeError(n, LTNumber)
 	return 0
 }
@@ -161,6 +187,9 @@ func (ls *LState) OptInt64(n int, d int64) int64 {
 	if intv, ok := v.(LNumber); ok {
 		return int64(intv)
 	}
+	if intv, ok := v.(LInteger); ok {
+		return int64(intv)
+	}
 	ls.TypeError(n, LTNumber)
 	return 0
 }
@@ -173,6 +202,9 @@ func (ls *LState) OptNumber(n int, d LNumber) LNumber {
 	if lv, ok := v.(LNumber); ok {
 		return lv
 	}
+	if lv, ok := v.(LInteger); ok {
+		return LNumber(lv)
+	}
 	ls.TypeError(n, LTNumber)
 	return 0
 }
@@ -423,6 +455,8 @@ func (ls *LState) ToStringMeta(lv LValue) LValue {
 		ls.Push(lv)
 		ls.Call(1, 1)
 		return ls.reg.Pop()
+	} else if name, ok := ls.metaOp1(lv, "__name").(LString); ok {
+		return LString(fmt.Sprintf("%s: %p", name, lv))
 	} else {
 		return LString(lv.String())
 	}
@@ -31,6 +31,8 @@ var baseFuncs = map[string]LGFunction{
 	"error":          baseError,
 	"getfenv":        baseGetFEnv,
 	"getmetatable":   baseGetMetatable,
+	"getprivate":     baseGetPrivate,
+	"instanceof":     baseInstanceOf,
 	"load":           baseLoad,
 	"loadfile":       baseLoadFile,
 	"loadstring":     baseLoadString,
@@ -45,6 +47,7 @@ var baseFuncs = map[string]LGFunction{
 	"_printregs":     base_PrintRegs,
 	"setfenv":        baseSetFEnv,
 	"setmetatable":   baseSetMetatable,
+	"setprivate":     baseSetPrivate,
 	"tonumber":       baseToNumber,
 	"tostring":       baseToString,
 	"type":           baseType,
@@ -107,8 +110,8 @@ func baseGetFEnv(L *LState) int {
 		return 1
 	}
 
-	if number, ok := value.(LNumber); ok {
-		level := int(float64(number))
+	if value.Type() == LTNumber {
+		level := int(LVAsNumber(value))
 		if level <= 0 {
 			L.Push(L.Env)
 		} else {
@@ -134,6 +137,87 @@ func baseGetMetatable(L *LState) int {
 	return 1
 }
 
+const maxClassDepth = 100
+
+// newClass creates the table of a class declared with the class statement.
+// The class table is the metatable of its instances. Its own metatable makes
+// it callable and lets it inherit the fields of the base class.
+func newClass(L *LState, name string, base LValue) *LTable {
+	class := L.NewTable()
+	meta := L.NewTable()
+	switch bc := base.(type) {
+	case *LNilType:
+	case *LTable:
+		// metamethods are not looked up through __index, so they are copied
+		bc.ForEach(func(key, value LValue) {
+			if k, ok := key.(LString); ok && strings.HasPrefix(string(k), "__") && k != "__index" && k != "__name" {
+				class.RawSetH(key, value)
+			}
+		})
+		meta.RawSetString("__index", bc)
+	default:
+		L.RaiseError("cannot extend a %v value", base.Type().String())
+	}
+	class.RawSetString("__index", class)
+	class.RawSetString("__name", LString(name))
+	meta.RawSetString("__call", L.NewFunction(classCall))
+	meta.RawSetString("__name", LString("class "+name))
+	L.SetMetatable(class, meta)
+	return class
+}
+
+// classCall creates an instance of the class given as the first argument and
+// passes the remaining arguments to its init method.
+func classCall(L *LState) int {
+	class := L.CheckTable(1)
+	obj := L.NewTable()
+	L.SetMetatable(obj, class)
+	if init := L.GetField(class, "init"); init != LNil {
+		top := L.GetTop()
+		L.Push(init)
+		L.Push(obj)
+		for i := 2; i <= top; i++ {
+			L.Push(L.Get(i))
+		}
+		L.Call(top, 0)
+	}
+	L.Push(obj)
+	return 1
+}
+
+func baseGetPrivate(L *LState) int {
+	L.Push(L.CheckTable(1).GetPrivate())
+	return 1
+}
+
+func baseSetPrivate(L *LState) int {
+	tb := L.CheckTable(1)
+	tb.SetPrivate(L.CheckAny(2))
+	L.SetTop(1)
+	return 1
+}
+
+func baseInstanceOf(L *LState) int {
+	obj := L.CheckAny(1)
+	class := L.CheckTable(2)
+	// follows the chain of classes through the __index field of the
+	// metatable of each class, as set up by the class statement.
+	mt := L.metatable(obj, true)
+	for i := 0; i < maxClassDepth && mt != LNil; i++ {
+		if mt == class {
+			L.Push(LTrue)
+			return 1
+		}
+		meta, ok := L.metatable(mt, true).(*LTable)
+		if !ok {
+			break
+		}
+		mt = meta.RawGetString("__index")
+	}
+	L.Push(LFalse)
+	return 1
+}
+
 func ipairsaux(L *LState) int {
 	tb := L.CheckTable(1)
 	i := L.CheckInt(2)
@@ -143,8 +227,8 @@ func ipairsaux(L *LState) int {
 		return 0
 	} else {
 		L.Pop(1)
-		L.Push(LNumber(i))
-		L.Push(LNumber(i))
+		L.Push(LInteger(i))
+		L.Push(LInteger(i))
 		L.Push(v)
 		return 2
 	}
@@ -154,7 +238,7 @@ func baseIpairs(L *LState) int {
 	tb := L.CheckTable(1)
 	L.Push(L.Get(UpvalueIndex(1)))
 	L.Push(tb)
-	L.Push(LNumber(0))
+	L.Push(LInteger(0))
 	return 3
 }
 
@@ -310,6 +394,8 @@ func baseShow(L *LState) int {
 			}
 		} else if num, ok := toshow.(LNumber); ok {
 			fmt.Print(fmt.Sprintf("number: %f", num))
+		} else if num, ok := toshow.(LInteger); ok {
+			fmt.Print(fmt.Sprintf("number: %d", num))
 		} else if str, ok := toshow.(LString); ok {
 			fmt.Print(fmt.Sprintf("string: %s", str))
 		} else if boolean, ok := toshow.(LBool); ok {
@@ -360,8 +446,8 @@ func baseRawSet(L *LState) int {
 func baseSelect(L *LState) int {
 	L.CheckTypes(1, LTNumber, LTString)
 	switch lv := L.Get(1).(type) {
-	case LNumber:
-		idx := int(lv)
+	case LNumber, LInteger:
+		idx := L.CheckInt(1)
 		num := L.GetTop()
 		if idx < 0 {
 			idx = num + idx
@@ -376,7 +462,7 @@ func baseSelect(L *LState) int {
 		if string(lv) != "#" {
 			L.ArgError(1, "invalid string '"+string(lv)+"'")
 		}
-		L.Push(LNumber(L.GetTop() - 1))
+		L.Push(LInteger(L.GetTop() - 1))
 		return 1
 	}
 	return 0
@@ -401,8 +487,8 @@ func baseSetFEnv(L *LState) int {
 		}
 	}
 
-	if number, ok := value.(LNumber); ok {
-		level := int(float64(number))
+	if value.Type() == LTNumber {
+		level := int(LVAsNumber(value))
 		if level <= 0 {
 			L.Env = env
 			return 0
@@ -447,7 +533,7 @@ func baseToNumber(L *LState) int {
 	noBase := L.Get(2) == LNil
 
 	switch lv := L.CheckAny(1).(type) {
-	case LNumber:
+	case LNumber, LInteger:
 		L.Push(lv)
 	case LString:
 		var str string
@@ -458,33 +544,41 @@ func baseToNumber(L *LState) int {
 		} else {
 			str = strings.TrimSpace(strsli[0])
 		}
-		if strings.IndexAny(str, ".eE") > -1 {
+		if digits := strings.TrimLeft(str, "+-"); noBase && (strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X")) {
+			// hexadecimal integers wrap around like the literals
+			if v, err := parseNumberValue(str); err != nil {
+				L.Push(LNil)
+			} else {
+				L.Push(v)
+			}
+		} else if strings.IndexAny(str, ".eE") > -1 {
 			if v, err := strconv.ParseFloat(str, LNumberBit); err != nil {
 				L.Push(LNil)
 			} else {
 				L.Push(LNumber(v))
 			}
 		} else {
-			if noBase && strings.HasPrefix(strings.ToLower(str), "0x") {
-				base, str = 16, str[2:] // Hex number
-			} else if noBase && (strings.HasPrefix(strings.ToLower(str), "0b") || strings.HasPrefix(strings.ToLower(str), "0B")) {
+			if noBase && (strings.HasPrefix(strings.ToLower(str), "0b") || strings.HasPrefix(strings.ToLower(str), "0B")) {
 				base, str = 2, str[2:] // Binary number
 			} else if noBase && (strings.HasPrefix(strings.ToLower(str), "0o") || strings.HasPrefix(strings.ToLower(str), "0O")) {
 				base, str = 8, str[2:] // Octal number
 			} else if noBase && (strings.HasPrefix(str, "0t") || strings.HasPrefix(str, "0T")) {
 				base, str = 10, str[2:] // Decimal number
 			}
-			if v, err := strconv.ParseInt(str, base, LNumberBit); err != nil {
-				L.Push(LNil)
-			} else {
+			if v, err := strconv.ParseInt(str, base, LNumberBit); err == nil {
+				L.Push(LInteger(v))
+			} else if v, err := strconv.ParseFloat(str, LNumberBit); err == nil && base == 10 {
+				// decimal integers that overflow become floats
 				L.Push(LNumber(v))
+			} else {
+				L.Push(LNil)
 			}
 		}
 	case *LBool:
 		if *lv {
-			L.Push(LNumber(1))
+			L.Push(LInteger(1))
 		} else {
-			L.Push(LNumber(0))
+			L.Push(LInteger(0))
 		}
 	default:
 		L.Push(LNil)
@@ -0,0 +1,1201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zmsvDreamLang/Milk/ast"
+	"github.com/zmsvDreamLang/Milk/parse"
+)
+
+/* milk check: static type checker {{{ */
+
+func checkMain(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Println(`Usage: milk check file [file ...]
+Checks type hints(inline annotations and '--- @param'/'--- @return' doc
+comments) without running the scripts. Exits with status 1 if any problem
+is found.`)
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	status := 0
+	for _, path := range fs.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Println(err.Error())
+			status = 1
+			continue
+		}
+		diags := checkSource(bytes.NewReader(src), path)
+		for _, diag := range diags {
+			fmt.Println(diag.String())
+		}
+		if len(diags) > 0 {
+			status = 1
+		}
+	}
+	return status
+}
+
+type diagnostic struct {
+	Pos     ast.Position
+	Message string
+}
+
+func (d *diagnostic) String() string {
+	return fmt.Sprintf("%v:%v:%v: %v", d.Pos.Source, d.Pos.Line, d.Pos.Column, d.Message)
+}
+
+// checkSource parses and checks a single chunk. A chunk with syntax errors
+// is not checked, the syntax errors are reported instead.
+func checkSource(reader io.Reader, name string) []*diagnostic {
+	src, err := io.ReadAll(reader)
+	if err != nil {
+		return []*diagnostic{{ast.Position{Source: name}, err.Error()}}
+	}
+	src = blankShebang(src)
+	chunk, _, err := parse.ParseRecover(bytes.NewReader(src), name)
+	if err != nil {
+		return syntaxDiagnostics(err, name, src)
+	}
+	ck := newChecker(name, src)
+	ck.checkChunk(chunk)
+	sortDiagnostics(ck.diags)
+	return ck.diags
+}
+
+func sortDiagnostics(diags []*diagnostic) {
+	sort.SliceStable(diags, func(i, j int) bool {
+		pi, pj := diags[i].Pos, diags[j].Pos
+		if pi.Line != pj.Line {
+			return pi.Line < pj.Line
+		}
+		return pi.Column < pj.Column
+	})
+}
+
+// syntaxDiagnostics reports the errors of parse.ParseRecover on src as
+// diagnostics. An error at EOF is put at the end of the last line, and an
+// error at the position of the previous one, which follows from it, is left
+// out.
+func syntaxDiagnostics(err error, name string, src []byte) []*diagnostic {
+	var errs parse.ErrorList
+	switch err := err.(type) {
+	case parse.ErrorList:
+		errs = err
+	case *parse.Error:
+		errs = parse.ErrorList{err}
+	default:
+		return []*diagnostic{{ast.Position{Source: name}, strings.TrimSpace(err.Error())}}
+	}
+	diags := []*diagnostic{}
+	for _, perr := range errs {
+		pos := perr.Pos
+		if pos.Line == parse.EOF || pos.Line < 1 {
+			lines := strings.Split(string(src), "\n")
+			pos.Line = len(lines)
+			pos.Column = len(strings.TrimSuffix(lines[len(lines)-1], "\r")) + 1
+		}
+		if n := len(diags); n > 0 && diags[n-1].Pos == pos {
+			continue
+		}
+		diags = append(diags, &diagnostic{pos, strings.TrimSpace(perr.Message)})
+	}
+	return diags
+}
+
+/* types {{{ */
+
+// checkType is a statically inferred type. An empty name means the type is
+// unknown, such values are never reported.
+type checkType struct {
+	Name     string
+	Nullable bool
+}
+
+var (
+	typeUnknown = checkType{}
+	typeNil     = checkType{Name: "nil"}
+	typeBool    = checkType{Name: "boolean"}
+	typeNumber  = checkType{Name: "number"}
+	typeString  = checkType{Name: "string"}
+	typeTable   = checkType{Name: "table"}
+	typeFunc    = checkType{Name: "function"}
+)
+
+var checkTypeNames = map[string]bool{
+	"nil": true, "boolean": true, "number": true, "string": true, "function": true,
+	"userdata": true, "thread": true, "table": true, "channel": true,
+}
+
+func (t checkType) String() string {
+	if t.Name == "" {
+		return "any"
+	}
+	if t.Nullable {
+		return t.Name + "?"
+	}
+	return t.Name
+}
+
+func (t checkType) Known() bool { return t.Name != "" }
+
+func (t checkType) MaybeNil() bool { return t.Name == "nil" || t.Nullable }
+
+func (t checkType) NonNil() checkType {
+	if t.Name == "nil" {
+		return typeUnknown
+	}
+	return checkType{Name: t.Name}
+}
+
+func typeFromHint(hint *ast.TypeHint) checkType {
+	if hint == nil {
+		return typeUnknown
+	}
+	return parseCheckType(hint.Name, hint.Nullable)
+}
+
+// parseCheckType accepts the type names used by type hints, optionally
+// followed by '?' or written as a 'T|nil' union.
+func parseCheckType(name string, nullable bool) checkType {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if strings.HasSuffix(name, "?") {
+		name = strings.TrimSuffix(name, "?")
+		nullable = true
+	}
+	parts := []string{}
+	for _, part := range strings.Split(name, "|") {
+		part = strings.TrimSpace(part)
+		if part == "nil" && strings.Contains(name, "|") {
+			nullable = true
+			continue
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) != 1 || !checkTypeNames[parts[0]] {
+		return typeUnknown
+	}
+	return checkType{Name: parts[0], Nullable: nullable && parts[0] != "nil"}
+}
+
+// assignable reports whether a value of type 'from' can be passed where
+// 'to' is expected.
+func assignable(from, to checkType) bool {
+	if !from.Known() || !to.Known() {
+		return true
+	}
+	if from.Name == "nil" {
+		return to.Nullable || to.Name == "nil"
+	}
+	return from.Name == to.Name
+}
+
+/* }}} */
+
+/* symbols {{{ */
+
+type funcParam struct {
+	Name       string
+	Type       checkType
+	HasDefault bool
+}
+
+// argType is the type accepted for the argument, nil selecting the default
+// value of the parameter if it has one.
+func (param funcParam) argType() checkType {
+	if param.HasDefault && param.Type.Known() {
+		return checkType{Name: param.Type.Name, Nullable: true}
+	}
+	return param.Type
+}
+
+type funcSig struct {
+	Name      string
+	Params    []funcParam
+	HasVargs  bool
+	IsMethod  bool
+	Returns   []checkType
+	Annotated bool
+}
+
+func (sig *funcSig) hasDefaults() bool {
+	for _, param := range sig.Params {
+		if param.HasDefault {
+			return true
+		}
+	}
+	return false
+}
+
+// paramIndex returns the index of the parameter called name, -1 if there is
+// none.
+func (sig *funcSig) paramIndex(name string) int {
+	for i, param := range sig.Params {
+		if param.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+type symbol struct {
+	Type   checkType
+	Sig    *funcSig
+	Fields map[string]*symbol
+}
+
+func (sym *symbol) field(name string) *symbol {
+	if sym == nil || sym.Fields == nil {
+		return nil
+	}
+	return sym.Fields[name]
+}
+
+type checkScope struct {
+	parent *checkScope
+	vars   map[string]*symbol
+	fn     *funcSig
+}
+
+func newCheckScope(parent *checkScope, fn *funcSig) *checkScope {
+	if fn == nil && parent != nil {
+		fn = parent.fn
+	}
+	return &checkScope{parent, map[string]*symbol{}, fn}
+}
+
+func (sc *checkScope) lookup(name string) *symbol {
+	for s := sc; s != nil; s = s.parent {
+		if sym, ok := s.vars[name]; ok {
+			return sym
+		}
+	}
+	return nil
+}
+
+/* }}} */
+
+type checker struct {
+	source  string
+	lines   []string
+	globals map[string]*symbol
+	diags   []*diagnostic
+}
+
+func newChecker(source string, src []byte) *checker {
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(src)+1)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return &checker{source: source, lines: lines, globals: map[string]*symbol{}}
+}
+
+func (ck *checker) report(node ast.PositionHolder, format string, args ...interface{}) {
+	ck.diags = append(ck.diags, &diagnostic{
+		Pos:     ast.Position{Source: ck.source, Line: node.Line(), Column: node.Column()},
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (ck *checker) checkChunk(chunk []ast.Stmt) {
+	scope := newCheckScope(nil, nil)
+	// global functions may be called from function bodies before they are defined.
+	for _, stmt := range chunk {
+		if st, ok := stmt.(*ast.FuncDefStmt); ok {
+			if ident, ok := st.Name.Func.(*ast.IdentExpr); ok {
+				ck.globals[ident.Value] = &symbol{Type: typeFunc, Sig: ck.funcSig(st.Func, false)}
+			}
+		}
+	}
+	ck.checkBlock(chunk, scope)
+}
+
+/* doc comments {{{ */
+
+// docComments returns the '---' comment lines that immediately precede the
+// given line.
+func (ck *checker) docComments(line int) []string {
+	result := []string{}
+	for i := line - 2; i >= 0 && i < len(ck.lines); i-- {
+		text := strings.TrimSpace(ck.lines[i])
+		if !strings.HasPrefix(text, "---") {
+			break
+		}
+		result = append([]string{strings.TrimSpace(strings.TrimPrefix(text, "---"))}, result...)
+	}
+	return result
+}
+
+func (ck *checker) funcSig(fn *ast.FunctionExpr, isMethod bool) *funcSig {
+	sig := &funcSig{Name: fn.Name, HasVargs: fn.ParList.HasVargs, IsMethod: isMethod}
+	if sig.Name == "" {
+		sig.Name = fmt.Sprintf("<%v:%v>", ck.source, fn.Line())
+	}
+	for i, name := range fn.ParList.Names {
+		param := funcParam{Name: name}
+		param.HasDefault = i < len(fn.ParList.Defaults) && fn.ParList.Defaults[i] != nil
+		if i < len(fn.ParList.Types) {
+			param.Type = typeFromHint(fn.ParList.Types[i])
+			sig.Annotated = sig.Annotated || param.Type.Known()
+		}
+		sig.Params = append(sig.Params, param)
+	}
+	for _, hint := range fn.ReturnTypes {
+		sig.Returns = append(sig.Returns, typeFromHint(hint))
+		sig.Annotated = true
+	}
+
+	docReturns := []checkType{}
+	for _, doc := range ck.docComments(fn.Line()) {
+		fields := strings.Fields(doc)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "@param":
+			if len(fields) < 3 {
+				continue
+			}
+			for i := range sig.Params {
+				if sig.Params[i].Name == fields[1] && !sig.Params[i].Type.Known() {
+					sig.Params[i].Type = parseCheckType(fields[2], false)
+					sig.Annotated = true
+				}
+			}
+		case "@return":
+			for _, name := range strings.Split(strings.Join(fields[1:], " "), ",") {
+				if words := strings.Fields(name); len(words) > 0 {
+					docReturns = append(docReturns, parseCheckType(words[0], false))
+				}
+			}
+		}
+	}
+	if len(fn.ReturnTypes) == 0 && len(docReturns) > 0 {
+		sig.Returns = docReturns
+		sig.Annotated = true
+	}
+	return sig
+}
+
+/* }}} */
+
+/* statements {{{ */
+
+func (ck *checker) checkBlock(stmts []ast.Stmt, scope *checkScope) {
+	for _, stmt := range stmts {
+		ck.checkStmt(stmt, scope)
+	}
+}
+
+func (ck *checker) checkStmt(stmt ast.Stmt, scope *checkScope) {
+	switch st := stmt.(type) {
+	case *ast.AssignStmt:
+		types := ck.exprListTypes(st.Rhs, len(st.Lhs), scope)
+		for i, lhs := range st.Lhs {
+			ck.assign(lhs, types[i], st.Rhs, i, scope)
+		}
+	case *ast.LocalAssignStmt:
+		if st.Pattern != nil {
+			ck.checkDestruct(st, scope)
+			return
+		}
+		// 'local function f' can refer to itself
+		if len(st.Names) == 1 && len(st.Exprs) == 1 {
+			if fn, ok := st.Exprs[0].(*ast.FunctionExpr); ok && fn.Name == st.Names[0] {
+				sym := &symbol{Type: typeFunc, Sig: ck.funcSig(fn, false)}
+				scope.vars[st.Names[0]] = sym
+				ck.checkFunction(fn, nil, sym.Sig, scope)
+				return
+			}
+		}
+		types := ck.exprListTypes(st.Exprs, len(st.Names), scope)
+		for i, name := range st.Names {
+			sym := &symbol{Type: types[i]}
+			if i < len(st.Exprs) {
+				ck.describe(sym, st.Exprs[i], scope)
+			} else {
+				sym.Type = typeNil
+			}
+			scope.vars[name] = sym
+		}
+	case *ast.CompoundAssignStmt:
+		ck.exprType(st.Lhs, scope)
+		ck.exprType(st.Rhs, scope)
+	case *ast.FuncCallStmt:
+		ck.exprType(st.Expr, scope)
+	case *ast.DoBlockStmt:
+		ck.checkBlock(st.Stmts, newCheckScope(scope, nil))
+	case *ast.WhileStmt:
+		ck.exprType(st.Condition, scope)
+		body := newCheckScope(scope, nil)
+		ck.narrow(st.Condition, body, true)
+		ck.checkBlock(st.Stmts, body)
+	case *ast.RepeatStmt:
+		body := newCheckScope(scope, nil)
+		ck.checkBlock(st.Stmts, body)
+		ck.exprType(st.Condition, body)
+	case *ast.IfStmt:
+		ck.exprType(st.Condition, scope)
+		then := newCheckScope(scope, nil)
+		ck.narrow(st.Condition, then, true)
+		ck.checkBlock(st.Then, then)
+		els := newCheckScope(scope, nil)
+		ck.narrow(st.Condition, els, false)
+		ck.checkBlock(st.Else, els)
+		// 'if not x then return end' narrows x for the rest of the block
+		if blockTerminates(st.Then) && len(st.Else) == 0 {
+			ck.narrow(st.Condition, scope, false)
+		}
+	case *ast.MatchStmt:
+		ck.checkMatchStmt(st, scope)
+	case *ast.NumberForStmt:
+		ck.expectType(st.Init, typeNumber, "'for' initial value", scope)
+		ck.expectType(st.Limit, typeNumber, "'for' limit", scope)
+		if st.Step != nil {
+			ck.expectType(st.Step, typeNumber, "'for' step", scope)
+		}
+		body := newCheckScope(scope, nil)
+		body.vars[st.Name] = &symbol{Type: typeNumber}
+		ck.checkBlock(st.Stmts, body)
+	case *ast.GenericForStmt:
+		for _, expr := range st.Exprs {
+			ck.exprType(expr, scope)
+		}
+		body := newCheckScope(scope, nil)
+		for _, name := range st.Names {
+			body.vars[name] = &symbol{}
+		}
+		ck.checkBlock(st.Stmts, body)
+	case *ast.FuncDefStmt:
+		ck.checkFuncDefStmt(st, scope)
+	case *ast.ClassStmt:
+		ck.checkClassStmt(st, scope)
+	case *ast.ReturnStmt:
+		ck.checkReturnStmt(st, scope)
+	}
+}
+
+func (ck *checker) checkFuncDefStmt(st *ast.FuncDefStmt, scope *checkScope) {
+	if st.Name.Func == nil {
+		recv := ck.exprSymbol(st.Name.Receiver, scope)
+		sig := ck.funcSig(st.Func, true)
+		if recv != nil {
+			if recv.Fields == nil {
+				recv.Fields = map[string]*symbol{}
+			}
+			recv.Fields[st.Name.Method] = &symbol{Type: typeFunc, Sig: sig}
+		}
+		ck.checkFunction(st.Func, st.Name.Receiver, sig, scope)
+		return
+	}
+	var sig *funcSig
+	switch fn := st.Name.Func.(type) {
+	case *ast.IdentExpr:
+		if sym := scope.lookup(fn.Value); sym != nil {
+			sym.Type, sym.Sig = typeFunc, ck.funcSig(st.Func, false)
+			sig = sym.Sig
+		} else if sym, ok := ck.globals[fn.Value]; ok && sym.Sig != nil && sym.Sig.Name == st.Func.Name {
+			sig = sym.Sig
+		} else {
+			sig = ck.funcSig(st.Func, false)
+			ck.globals[fn.Value] = &symbol{Type: typeFunc, Sig: sig}
+		}
+	case *ast.AttrGetExpr:
+		sig = ck.funcSig(st.Func, false)
+		if key, ok := fn.Key.(*ast.StringExpr); ok {
+			if obj := ck.exprSymbol(fn.Object, scope); obj != nil {
+				if obj.Fields == nil {
+					obj.Fields = map[string]*symbol{}
+				}
+				obj.Fields[key.Value] = &symbol{Type: typeFunc, Sig: sig}
+			}
+		}
+	}
+	ck.checkFunction(st.Func, nil, sig, scope)
+}
+
+func (ck *checker) checkClassStmt(st *ast.ClassStmt, scope *checkScope) {
+	if st.Base != nil {
+		ck.exprType(st.Base, scope)
+	}
+	class := &symbol{Type: typeTable, Fields: map[string]*symbol{}}
+	if st.Local {
+		scope.vars[st.Name] = class
+	} else if sym := scope.lookup(st.Name); sym != nil {
+		sym.Type, sym.Sig, sym.Fields = class.Type, nil, class.Fields
+	} else {
+		ck.globals[st.Name] = class
+	}
+	// members are declared first, so that methods can call each other
+	sigs := make([]*funcSig, len(st.Members))
+	for i, member := range st.Members {
+		if fn, ok := member.Value.(*ast.FunctionExpr); ok {
+			sigs[i] = ck.funcSig(fn, !member.Static)
+			class.Fields[member.Name] = &symbol{Type: typeFunc, Sig: sigs[i]}
+		}
+	}
+	receiver := &ast.IdentExpr{Value: st.Name}
+	receiver.SetLine(st.Line())
+	for i, member := range st.Members {
+		fn, ok := member.Value.(*ast.FunctionExpr)
+		switch {
+		case !ok:
+			sym := &symbol{Type: ck.exprType(member.Value, scope)}
+			ck.describe(sym, member.Value, scope)
+			class.Fields[member.Name] = sym
+		case member.Static:
+			ck.checkFunction(fn, nil, sigs[i], scope)
+		default:
+			ck.checkFunction(fn, receiver, sigs[i], scope)
+		}
+	}
+}
+
+func (ck *checker) checkFunction(fn *ast.FunctionExpr, receiver ast.Expr, sig *funcSig, scope *checkScope) {
+	if sig == nil {
+		sig = ck.funcSig(fn, receiver != nil)
+	}
+	body := newCheckScope(scope, sig)
+	if receiver != nil {
+		self := &symbol{Type: typeTable}
+		if recv := ck.exprSymbol(receiver, scope); recv != nil {
+			self.Fields = recv.Fields
+		}
+		body.vars["self"] = self
+	}
+	for _, param := range sig.Params {
+		body.vars[param.Name] = &symbol{Type: param.Type}
+	}
+	for i, def := range fn.ParList.Defaults {
+		if def != nil && i < len(sig.Params) {
+			ck.expectType(def, sig.Params[i].Type, fmt.Sprintf("default value of argument #%v '%v' to '%v'", i+1, sig.Params[i].Name, sig.Name), body)
+		}
+	}
+	ck.checkBlock(fn.Stmts, body)
+	if !blockTerminates(fn.Stmts) {
+		for i, ret := range sig.Returns {
+			if ret.Known() && !ret.MaybeNil() {
+				pos := &ast.Node{}
+				pos.SetLine(eline(fn))
+				pos.SetColumn(1)
+				ck.report(pos, "'%v' may end without returning a value (return value #%v must be %v)", sig.Name, i+1, ret)
+				break
+			}
+		}
+	}
+}
+
+func (ck *checker) checkReturnStmt(st *ast.ReturnStmt, scope *checkScope) {
+	sig := scope.fn
+	if sig == nil || len(sig.Returns) == 0 {
+		for _, expr := range st.Exprs {
+			ck.exprType(expr, scope)
+		}
+		return
+	}
+	types := ck.exprListTypes(st.Exprs, len(sig.Returns), scope)
+	open := len(st.Exprs) > 0 && isMultiValue(st.Exprs[len(st.Exprs)-1])
+	for i, want := range sig.Returns {
+		if i >= len(st.Exprs) && !open {
+			if want.Known() && !want.MaybeNil() {
+				ck.report(st, "missing return value #%v from '%v' (%v expected)", i+1, sig.Name, want)
+			}
+			continue
+		}
+		var node ast.PositionHolder = st
+		if i < len(st.Exprs) {
+			node = st.Exprs[i]
+		}
+		ck.checkValue(node, types[i], want, fmt.Sprintf("return value #%v from '%v'", i+1, sig.Name))
+	}
+}
+
+func (ck *checker) assign(lhs ast.Expr, typ checkType, rhs []ast.Expr, i int, scope *checkScope) {
+	switch ex := lhs.(type) {
+	case *ast.IdentExpr:
+		sym := scope.lookup(ex.Value)
+		if sym == nil {
+			sym = &symbol{}
+			ck.globals[ex.Value] = sym
+		}
+		sym.Type = typ
+		sym.Sig, sym.Fields = nil, nil
+		if i < len(rhs) {
+			ck.describe(sym, rhs[i], scope)
+		}
+	case *ast.AttrGetExpr:
+		ck.exprType(ex, scope)
+		if key, ok := ex.Key.(*ast.StringExpr); ok {
+			if obj := ck.exprSymbol(ex.Object, scope); obj != nil {
+				if obj.Fields == nil {
+					obj.Fields = map[string]*symbol{}
+				}
+				sym := &symbol{Type: typ}
+				if i < len(rhs) {
+					ck.describe(sym, rhs[i], scope)
+				}
+				obj.Fields[key.Value] = sym
+			}
+		}
+	}
+}
+
+// describe records function signatures and table shapes of an assigned
+// value on its symbol.
+func (ck *checker) describe(sym *symbol, expr ast.Expr, scope *checkScope) {
+	switch ex := expr.(type) {
+	case *ast.FunctionExpr:
+		sym.Sig = ck.funcSig(ex, false)
+	case *ast.TableExpr:
+		sym.Fields = map[string]*symbol{}
+		for _, field := range ex.Fields {
+			if key, ok := field.Key.(*ast.StringExpr); ok {
+				fsym := &symbol{Type: ck.peekType(field.Value, scope)}
+				ck.describe(fsym, field.Value, scope)
+				sym.Fields[key.Value] = fsym
+			}
+		}
+	case *ast.IdentExpr:
+		if src := scope.lookup(ex.Value); src != nil {
+			sym.Sig, sym.Fields = src.Sig, src.Fields
+		} else if src, ok := ck.globals[ex.Value]; ok {
+			sym.Sig, sym.Fields = src.Sig, src.Fields
+		}
+	}
+}
+
+/* }}} */
+
+/* expressions {{{ */
+
+func isMultiValue(expr ast.Expr) bool {
+	switch ex := expr.(type) {
+	case *ast.FuncCallExpr:
+		return !ex.AdjustRet
+	case *ast.Comma3Expr:
+		return !ex.AdjustRet
+	}
+	return false
+}
+
+// exprListTypes infers the types of n values produced by an expression list
+// as in an assignment.
+func (ck *checker) exprListTypes(exprs []ast.Expr, n int, scope *checkScope) []checkType {
+	types := make([]checkType, 0, n)
+	for i, expr := range exprs {
+		if i == len(exprs)-1 && isMultiValue(expr) {
+			if call, ok := expr.(*ast.FuncCallExpr); ok {
+				typ, sig := ck.callType(call, scope)
+				types = append(types, typ)
+				for j := 1; len(types) < n; j++ {
+					if sig != nil && len(sig.Returns) > 0 {
+						if j < len(sig.Returns) {
+							types = append(types, sig.Returns[j])
+						} else {
+							types = append(types, typeNil)
+						}
+					} else {
+						types = append(types, typeUnknown)
+					}
+				}
+				continue
+			}
+		}
+		types = append(types, ck.exprType(expr, scope))
+	}
+	for len(types) < n {
+		if len(exprs) > 0 && isMultiValue(exprs[len(exprs)-1]) {
+			types = append(types, typeUnknown)
+		} else {
+			types = append(types, typeNil)
+		}
+	}
+	return types
+}
+
+// peekType infers a type without reporting anything, it is used for values
+// that are checked separately.
+func (ck *checker) peekType(expr ast.Expr, scope *checkScope) checkType {
+	ndiags := len(ck.diags)
+	typ := ck.exprType(expr, scope)
+	ck.diags = ck.diags[:ndiags]
+	return typ
+}
+
+func (ck *checker) exprType(expr ast.Expr, scope *checkScope) checkType {
+	switch ex := expr.(type) {
+	case *ast.NilExpr:
+		return typeNil
+	case *ast.TrueExpr, *ast.FalseExpr:
+		return typeBool
+	case *ast.NumberExpr:
+		return typeNumber
+	case *ast.StringExpr:
+		return typeString
+	case *ast.TableExpr:
+		for _, field := range ex.Fields {
+			if field.Key != nil {
+				ck.exprType(field.Key, scope)
+			}
+			ck.exprType(field.Value, scope)
+		}
+		return typeTable
+	case *ast.FunctionExpr:
+		ck.checkFunction(ex, nil, nil, scope)
+		return typeFunc
+	case *ast.IdentExpr:
+		if sym := scope.lookup(ex.Value); sym != nil {
+			return sym.Type
+		}
+		if sym, ok := ck.globals[ex.Value]; ok {
+			return sym.Type
+		}
+		return typeUnknown
+	case *ast.AttrGetExpr:
+		obj := ck.exprType(ex.Object, scope)
+		ck.exprType(ex.Key, scope)
+		typ := typeUnknown
+		if sym := ck.exprSymbol(ex, scope); sym != nil {
+			typ = sym.Type
+		}
+		if ex.Optional {
+			return optionalType(typ, obj)
+		}
+		ck.checkIndexable(ex.Object, ex.Key, obj)
+		return typ
+	case *ast.FuncCallExpr:
+		typ, _ := ck.callType(ex, scope)
+		return typ
+	case *ast.LogicalOpExpr:
+		lhs := ck.exprType(ex.Lhs, scope)
+		rhsScope := newCheckScope(scope, nil)
+		ck.narrow(ex.Lhs, rhsScope, ex.Operator == "and")
+		rhs := ck.exprType(ex.Rhs, rhsScope)
+		if ex.Operator == "or" {
+			name := ""
+			if lhs.NonNil().Name == rhs.Name || lhs.Name == "nil" {
+				name = rhs.Name
+			}
+			return checkType{Name: name, Nullable: rhs.MaybeNil() && name != "nil"}
+		}
+		return typeUnknown
+	case *ast.NilCoalesceOpExpr:
+		lhs := ck.exprType(ex.Lhs, scope)
+		rhs := ck.exprType(ex.Rhs, scope)
+		name := ""
+		if lhs.NonNil().Name == rhs.Name || lhs.Name == "nil" {
+			name = rhs.Name
+		}
+		return checkType{Name: name, Nullable: rhs.MaybeNil() && name != "nil"}
+	case *ast.RelationalOpExpr:
+		ck.exprType(ex.Lhs, scope)
+		ck.exprType(ex.Rhs, scope)
+		return typeBool
+	case *ast.StringConcatOpExpr:
+		ck.exprType(ex.Lhs, scope)
+		ck.exprType(ex.Rhs, scope)
+		return typeString
+	case *ast.InterpolatedStringExpr:
+		for _, part := range ex.Parts {
+			ck.exprType(part, scope)
+		}
+		return typeString
+	case *ast.ArithmeticOpExpr:
+		ck.exprType(ex.Lhs, scope)
+		ck.exprType(ex.Rhs, scope)
+		return typeNumber
+	case *ast.UnaryMinusOpExpr:
+		ck.exprType(ex.Expr, scope)
+		return typeNumber
+	case *ast.UnaryNotOpExpr:
+		ck.exprType(ex.Expr, scope)
+		return typeBool
+	case *ast.UnaryLenOpExpr:
+		ck.exprType(ex.Expr, scope)
+		return typeNumber
+	case *ast.UnaryBNotOpExpr:
+		ck.exprType(ex.Expr, scope)
+		return typeNumber
+	}
+	return typeUnknown
+}
+
+func (ck *checker) checkDestruct(st *ast.LocalAssignStmt, scope *checkScope) {
+	types := ck.exprListTypes(st.Exprs, st.Pattern[len(st.Pattern)-1].Value+1, scope)
+	// defaults can not see the new locals, so they are declared last
+	syms := make([]*symbol, len(st.Pattern))
+	for i, field := range st.Pattern {
+		// the expression of the value, nil for the extra results of a call
+		var src ast.Expr
+		if field.Value < len(st.Exprs) {
+			src = st.Exprs[field.Value]
+		}
+		if field.Key == nil {
+			syms[i] = &symbol{Type: types[field.Value]}
+			if src != nil {
+				ck.describe(syms[i], src, scope)
+			}
+			continue
+		}
+		if src != nil && (i == 0 || st.Pattern[i-1].Value != field.Value) {
+			ck.checkIndexable(src, field.Key, types[field.Value])
+		}
+		syms[i] = &symbol{Type: typeUnknown}
+		if key, ok := field.Key.(*ast.StringExpr); ok && src != nil {
+			if sym := ck.exprSymbol(src, scope).field(key.Value); sym != nil {
+				syms[i] = &symbol{Type: sym.Type, Sig: sym.Sig, Fields: sym.Fields}
+			}
+		}
+		if field.Default != nil {
+			if def := ck.exprType(field.Default, scope); !def.MaybeNil() {
+				syms[i].Type = syms[i].Type.NonNil()
+			}
+		}
+	}
+	for i, field := range st.Pattern {
+		scope.vars[field.Name] = syms[i]
+	}
+}
+
+func (ck *checker) checkMatchStmt(st *ast.MatchStmt, scope *checkScope) {
+	typ := ck.exprType(st.Value, scope)
+	for _, arm := range st.Arms {
+		body := newCheckScope(scope, nil)
+		declarePattern(arm.Pattern, typ, body)
+		if arm.Guard != nil {
+			ck.exprType(arm.Guard, body)
+			ck.narrow(arm.Guard, body, true)
+		}
+		ck.checkBlock(arm.Stmts, body)
+	}
+}
+
+// declarePattern declares the names bound by a match pattern against a value
+// of type typ. Names only match non-nil values.
+func declarePattern(pattern ast.Expr, typ checkType, scope *checkScope) {
+	switch pat := pattern.(type) {
+	case *ast.IdentExpr:
+		if pat.Value != "_" {
+			scope.vars[pat.Value] = &symbol{Type: typ.NonNil()}
+		}
+	case *ast.TypePattern:
+		if pat.Name != "_" {
+			scope.vars[pat.Name] = &symbol{Type: typeFromHint(pat.Type)}
+		}
+	case *ast.TableExpr:
+		for _, field := range pat.Fields {
+			declarePattern(field.Value, typeUnknown, scope)
+		}
+	}
+}
+
+func (ck *checker) expectType(expr ast.Expr, want checkType, what string, scope *checkScope) {
+	ck.checkValue(expr, ck.exprType(expr, scope), want, what)
+}
+
+func (ck *checker) checkValue(node ast.PositionHolder, got, want checkType, what string) {
+	if assignable(got, want) {
+		if got.Nullable && want.Known() && !want.MaybeNil() {
+			ck.report(node, "%v may be nil (%v expected)", what, want)
+		}
+		return
+	}
+	ck.report(node, "%v: %v expected, got %v", what, want, got)
+}
+
+func (ck *checker) checkIndexable(object, key ast.Expr, typ checkType) {
+	if !typ.MaybeNil() {
+		return
+	}
+	field := "?"
+	if str, ok := key.(*ast.StringExpr); ok {
+		field = str.Value
+	}
+	qualifier := "possibly-nil"
+	if typ.Name == "nil" {
+		qualifier = "nil"
+	}
+	ck.report(key, "field '%v' accessed on %v value '%v'", field, qualifier, exprString(object))
+}
+
+// exprSymbol resolves identifiers and constant field chains(a.b.c) to a
+// symbol, or returns nil.
+func (ck *checker) exprSymbol(expr ast.Expr, scope *checkScope) *symbol {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		if sym := scope.lookup(ex.Value); sym != nil {
+			return sym
+		}
+		return ck.globals[ex.Value]
+	case *ast.AttrGetExpr:
+		if key, ok := ex.Key.(*ast.StringExpr); ok {
+			return ck.exprSymbol(ex.Object, scope).field(key.Value)
+		}
+	}
+	return nil
+}
+
+func (ck *checker) callType(call *ast.FuncCallExpr, scope *checkScope) (checkType, *funcSig) {
+	var sig *funcSig
+	recv := typeUnknown
+	args := call.Args
+	if call.Func != nil {
+		ck.exprType(call.Func, scope)
+		if sym := ck.exprSymbol(call.Func, scope); sym != nil {
+			sig = sym.Sig
+		}
+		if sig != nil && sig.IsMethod {
+			// obj.method(obj, ...)
+			if len(args) == 0 {
+				ck.report(call, "missing 'self' argument to '%v'", sig.Name)
+				sig = nil
+			} else {
+				args = args[1:]
+			}
+		}
+	} else {
+		recv = ck.exprType(call.Receiver, scope)
+		if !call.Optional {
+			method := &ast.StringExpr{Value: call.Method}
+			method.SetLine(call.Line())
+			method.SetColumn(call.Column())
+			ck.checkIndexable(call.Receiver, method, recv)
+		}
+		if sym := ck.exprSymbol(call.Receiver, scope).field(call.Method); sym != nil {
+			sig = sym.Sig
+		}
+		if sig != nil && !sig.IsMethod {
+			// obj:func(...) passes obj as the first parameter
+			sig = &funcSig{Name: sig.Name, Params: sig.Params, HasVargs: sig.HasVargs, Returns: sig.Returns, Annotated: sig.Annotated}
+			if len(sig.Params) > 0 {
+				sig.Params = sig.Params[1:]
+			} else if !sig.HasVargs {
+				sig = nil
+			}
+		}
+	}
+
+	// the fields of f{...} are checked against the parameters they name
+	var named *ast.TableExpr
+	if call.NamedArgs && sig != nil && sig.hasDefaults() && len(args) == 1 {
+		if tbl, ok := args[0].(*ast.TableExpr); ok && namesParams(tbl, sig) {
+			named = tbl
+		}
+	}
+	types := make([]checkType, len(args))
+	for i, arg := range args {
+		if named == nil {
+			types[i] = ck.exprType(arg, scope)
+		}
+	}
+	if sig == nil {
+		if call.Optional {
+			return optionalType(typeUnknown, recv), nil
+		}
+		return typeUnknown, nil
+	}
+
+	if named != nil {
+		ck.checkNamedArgs(call, named, sig, scope)
+	} else {
+		ck.checkArgs(call, args, types, sig)
+	}
+	typ := typeUnknown
+	if len(sig.Returns) > 0 {
+		typ = sig.Returns[0]
+	}
+	if call.Optional {
+		return optionalType(typ, recv), sig
+	}
+	return typ, sig
+}
+
+func (ck *checker) checkArgs(call *ast.FuncCallExpr, args []ast.Expr, types []checkType, sig *funcSig) {
+	open := len(args) > 0 && isMultiValue(args[len(args)-1])
+	if len(args) > len(sig.Params) && !sig.HasVargs && sig.Annotated {
+		ck.report(args[len(sig.Params)], "too many arguments to '%v' (expected %v, got %v)", sig.Name, len(sig.Params), len(args))
+	}
+	for i, param := range sig.Params {
+		what := fmt.Sprintf("argument #%v '%v' to '%v'", i+1, param.Name, sig.Name)
+		switch {
+		case i < len(args):
+			// only the first value of a trailing call is known
+			ck.checkValue(args[i], types[i], param.argType(), what)
+		case i >= len(args) && !open:
+			if param.Type.Known() && !param.Type.MaybeNil() && !param.HasDefault {
+				ck.report(call, "missing %v (%v expected)", what, param.Type)
+			}
+		}
+	}
+}
+
+// namesParams reports whether a f{...} call passes the fields of tbl as the
+// arguments: some keys name parameters of sig and every other field is
+// positional. Otherwise the table itself is the first argument.
+func namesParams(tbl *ast.TableExpr, sig *funcSig) bool {
+	named := false
+	for _, field := range tbl.Fields {
+		switch key := field.Key.(type) {
+		case nil:
+		case *ast.StringExpr:
+			if sig.paramIndex(key.Value) < 0 {
+				return false
+			}
+			named = true
+		default:
+			return false
+		}
+	}
+	return named
+}
+
+// checkNamedArgs checks the fields of a f{...} call to a function with
+// default parameter values, positional fields standing for the parameters at
+// their index.
+func (ck *checker) checkNamedArgs(call *ast.FuncCallExpr, tbl *ast.TableExpr, sig *funcSig, scope *checkScope) {
+	given := map[int]bool{}
+	for _, field := range tbl.Fields {
+		if key, ok := field.Key.(*ast.StringExpr); ok {
+			given[sig.paramIndex(key.Value)] = true
+		}
+	}
+	index := 0
+	for _, field := range tbl.Fields {
+		pos := -1
+		if key, ok := field.Key.(*ast.StringExpr); ok {
+			pos = sig.paramIndex(key.Value)
+		} else {
+			switch {
+			case index >= len(sig.Params):
+				ck.report(field.Value, "too many positional arguments to '%v' (expected at most %v)", sig.Name, len(sig.Params))
+			case given[index]:
+				ck.report(field.Value, "named argument '%v' to '%v' is also given as positional argument #%v", sig.Params[index].Name, sig.Name, index+1)
+			default:
+				pos = index
+			}
+			index++
+		}
+		typ := ck.exprType(field.Value, scope)
+		if pos > -1 {
+			given[pos] = true
+			param := sig.Params[pos]
+			ck.checkValue(field.Value, typ, param.argType(), fmt.Sprintf("argument #%v '%v' to '%v'", pos+1, param.Name, sig.Name))
+		}
+	}
+	for i, param := range sig.Params {
+		if !given[i] && param.Type.Known() && !param.Type.MaybeNil() && !param.HasDefault {
+			ck.report(call, "missing argument #%v '%v' to '%v' (%v expected)", i+1, param.Name, sig.Name, param.Type)
+		}
+	}
+}
+
+// optionalType is the type of an optional access(a?.b, a?:m()) resulting in
+// typ on an object of type obj.
+func optionalType(typ, obj checkType) checkType {
+	if obj.MaybeNil() && typ.Name != "nil" {
+		typ.Nullable = true
+	}
+	return typ
+}
+
+// narrow marks identifiers that cannot be nil when cond evaluates to
+// 'truth' by shadowing them in scope.
+func (ck *checker) narrow(cond ast.Expr, scope *checkScope, truth bool) {
+	nonnil := func(expr ast.Expr) {
+		if ident, ok := expr.(*ast.IdentExpr); ok {
+			if sym := scope.lookup(ident.Value); sym != nil && sym.Type.MaybeNil() {
+				scope.vars[ident.Value] = &symbol{Type: sym.Type.NonNil(), Sig: sym.Sig, Fields: sym.Fields}
+			}
+		}
+	}
+	switch ex := cond.(type) {
+	case *ast.IdentExpr:
+		if truth {
+			nonnil(ex)
+		}
+	case *ast.UnaryNotOpExpr:
+		ck.narrow(ex.Expr, scope, !truth)
+	case *ast.LogicalOpExpr:
+		if ex.Operator == "and" && truth || ex.Operator == "or" && !truth {
+			ck.narrow(ex.Lhs, scope, truth)
+			ck.narrow(ex.Rhs, scope, truth)
+		}
+	case *ast.RelationalOpExpr:
+		_, lnil := ex.Lhs.(*ast.NilExpr)
+		_, rnil := ex.Rhs.(*ast.NilExpr)
+		target := ex.Lhs
+		if lnil {
+			target = ex.Rhs
+		}
+		if (lnil || rnil) && (ex.Operator == "~=" && truth || ex.Operator == "==" && !truth) {
+			nonnil(target)
+		}
+	}
+}
+
+// blockTerminates reports whether control never reaches the end of stmts.
+func blockTerminates(stmts []ast.Stmt) bool {
+	if len(stmts) == 0 {
+		return false
+	}
+	switch st := stmts[len(stmts)-1].(type) {
+	case *ast.ReturnStmt, *ast.BreakStmt, *ast.ContinueStmt, *ast.GotoStmt:
+		return true
+	case *ast.DoBlockStmt:
+		return blockTerminates(st.Stmts)
+	case *ast.IfStmt:
+		return len(st.Else) > 0 && blockTerminates(st.Then) && blockTerminates(st.Else)
+	case *ast.FuncCallStmt:
+		if call, ok := st.Expr.(*ast.FuncCallExpr); ok {
+			if ident, ok := call.Func.(*ast.IdentExpr); ok {
+				return ident.Value == "error"
+			}
+		}
+	}
+	return false
+}
+
+func exprString(expr ast.Expr) string {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		return ex.Value
+	case *ast.AttrGetExpr:
+		if key, ok := ex.Key.(*ast.StringExpr); ok {
+			return exprString(ex.Object) + "." + key.Value
+		}
+		return exprString(ex.Object) + "[...]"
+	case *ast.FuncCallExpr:
+		if ex.Func != nil {
+			return exprString(ex.Func) + "(...)"
+		}
+		return exprString(ex.Receiver) + ":" + ex.Method + "(...)"
+	}
+	return "?"
+}
+
+func eline(pos ast.PositionHolder) int {
+	if line := pos.LastLine(); line != 0 {
+		return line
+	}
+	return pos.Line()
+}
+
+/* }}} */
+
+/* }}} */
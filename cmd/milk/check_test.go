@@ -0,0 +1,220 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func checkMessages(src string) []string {
+	messages := []string{}
+	for _, diag := range checkSource(strings.NewReader(src), "test.milk") {
+		messages = append(messages, diag.String())
+	}
+	return messages
+}
+
+func TestCheckCallSites(t *testing.T) {
+	src := `--- @param name string
+--- @return string
+function greet(name)
+    return "Hello, " .. name
+end
+local function add(a: number, b: number?): number
+    return a + (b or 0)
+end
+greet(1)
+add(1, 2, 3)
+add()
+add(greet("x"))
+add(1, nil)
+`
+	expected := []string{
+		"test.milk:9:7: argument #1 'name' to 'greet': string expected, got number",
+		"test.milk:10:11: too many arguments to 'add' (expected 2, got 3)",
+		"test.milk:11:1: missing argument #1 'a' to 'add' (number expected)",
+		"test.milk:12:5: argument #1 'a' to 'add': number expected, got string",
+	}
+	messages := checkMessages(src)
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
+
+func TestCheckPossiblyNil(t *testing.T) {
+	src := `local M = {}
+function M.find(id: number): table?
+    return nil
+end
+local r = M.find(1)
+print(r.id)
+if r then print(r.id) end
+local q = M.find(2)
+if not q then return end
+print(q.name)
+`
+	expected := []string{
+		"test.milk:6:9: field 'id' accessed on possibly-nil value 'r'",
+	}
+	messages := checkMessages(src)
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
+
+func TestCheckOptionalChaining(t *testing.T) {
+	src := `local M = {}
+function M.find(id: number): table?
+    return nil
+end
+function M.count(n: number) end
+local r = M.find(1)
+print(r?.id, r?:get())
+M.count(r?.id)
+M.count(r?.id ?? 0)
+`
+	expected := []string{
+		"test.milk:8:9: argument #1 'n' to 'M.count' may be nil (number expected)",
+	}
+	messages := checkMessages(src)
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
+
+func TestCheckDestructuring(t *testing.T) {
+	src := `local M = {}
+function M.find(id: number): table?
+    return nil
+end
+function M.add(a: number, b: number): number
+    return a + b
+end
+local {add=} = M
+add(1, "2")
+local {id=} = M.find(1)
+local n, {x=}, {add=} = 1, M.find(2), M
+add(n, "3")
+local {y=}, z = M.find(3), add
+z(1, false)
+`
+	expected := []string{
+		"test.milk:9:8: argument #2 'b' to 'M.add': number expected, got string",
+		"test.milk:10:8: field 'id' accessed on possibly-nil value 'M.find(...)'",
+		"test.milk:11:11: field 'x' accessed on possibly-nil value 'M.find(...)'",
+		"test.milk:12:8: argument #2 'b' to 'M.add': number expected, got string",
+		"test.milk:13:8: field 'y' accessed on possibly-nil value 'M.find(...)'",
+		"test.milk:14:6: argument #2 'b' to 'M.add': number expected, got boolean",
+	}
+	messages := checkMessages(src)
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
+
+func TestCheckMatch(t *testing.T) {
+	src := `function add(a: number, b: number): number
+    return a + b
+end
+local function f(v: number?)
+    match v with
+    case s: string then add(1, s)
+    case {x} if add(x, "1") > 0 then print(x)
+    case n then return add(n, 1)
+    end
+end
+`
+	expected := []string{
+		"test.milk:6:32: argument #2 'b' to 'add': number expected, got string",
+		"test.milk:7:24: argument #2 'b' to 'add': number expected, got string",
+	}
+	messages := checkMessages(src)
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
+
+func TestCheckDefaultParams(t *testing.T) {
+	src := `function connect(host: string = "localhost", port: number = 3306, user: string)
+    return host
+end
+connect(nil, nil, "root")
+connect("db")
+connect{port = "3307", user = "root"}
+connect{"db", prot = 1}
+connect{user = "root", "db", 1, 2, 3}
+function retry(n: number = "3")
+end
+`
+	expected := []string{
+		"test.milk:5:1: missing argument #3 'user' to 'connect' (string expected)",
+		"test.milk:6:16: argument #2 'port' to 'connect': number? expected, got string",
+		"test.milk:7:1: missing argument #3 'user' to 'connect' (string expected)",
+		"test.milk:7:8: argument #1 'host' to 'connect': string? expected, got table",
+		"test.milk:8:33: named argument 'user' to 'connect' is also given as positional argument #3",
+		"test.milk:8:36: too many positional arguments to 'connect' (expected at most 3)",
+		"test.milk:9:28: default value of argument #1 'n' to 'retry': number expected, got string",
+	}
+	messages := checkMessages(src)
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
+
+func TestCheckSyntaxError(t *testing.T) {
+	messages := checkMessages("local x = = 1\nprint(x)\nif x print(x) end\n")
+	expected := []string{
+		"test.milk:1:11: syntax error, expression expected",
+		"test.milk:3:6: syntax error, 'then' expected",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+
+	// an error at EOF is at the end of the source, and an error following
+	// from the previous one is left out
+	messages = checkMessages("local t = {1 2}\nif t then\n  print(t)")
+	expected = []string{
+		"test.milk:1:14: syntax error, '}' expected",
+		"test.milk:3:11: syntax error, 'end' expected to close 'if' at line 2",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
+
+func TestCheckShebang(t *testing.T) {
+	messages := checkMessages("#!/usr/bin/env milk\nlocal function f(n: number) end\nf(\"x\")\n")
+	expected := []string{
+		"test.milk:3:3: argument #1 'n' to 'f': number expected, got string",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
+
+func TestCheckClassMembers(t *testing.T) {
+	src := `class Point
+    function init(x: number, y: number)
+        self.x, self.y = x, y
+    end
+    function move(dx: number)
+        self.x = self.x + dx
+    end
+    static function origin(): table
+        return Point(0, 0)
+    end
+end
+local p = Point.origin()
+Point.move(p, "1")
+Point.move(p, 1)
+Point.move()
+`
+	expected := []string{
+		"test.milk:13:15: argument #1 'dx' to 'Point:move': number expected, got string",
+		"test.milk:15:1: missing 'self' argument to 'Point:move'",
+	}
+	messages := checkMessages(src)
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
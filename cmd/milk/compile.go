@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	lua "github.com/zmsvDreamLang/Milk"
+	"github.com/zmsvDreamLang/Milk/parse"
+)
+
+// compileMain writes the scripts precompiled into output. The first script is
+// the main chunk, the others are bundled as modules registered in
+// package.preload under their path from the directory of the main chunk.
+func compileMain(output string, strip bool, scripts []string) int {
+	if len(scripts) == 0 {
+		fmt.Println("no script to compile")
+		return 1
+	}
+	protos := make([]*lua.FunctionProto, len(scripts))
+	for i, script := range scripts {
+		proto, err := compileFile(script)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		protos[i] = proto
+	}
+	proto := protos[0]
+	if len(protos) > 1 {
+		modules := make([]lua.BundleModule, 0, len(protos)-1)
+		for i, script := range scripts[1:] {
+			name, err := moduleName(filepath.Dir(scripts[0]), script)
+			if err != nil {
+				fmt.Println(err.Error())
+				return 1
+			}
+			modules = append(modules, lua.BundleModule{Name: name, Proto: protos[i+1]})
+		}
+		var err error
+		if proto, err = lua.Bundle(scripts[0], modules, protos[0]); err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+	}
+	if strip {
+		proto.StripDebugInfo()
+	}
+	if err := os.WriteFile(output, proto.RawBytecode(), 0644); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	return 0
+}
+
+func compileFile(script string) (*lua.FunctionProto, error) {
+	src, err := os.ReadFile(script)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := parse.Parse(bytes.NewReader(blankShebang(src)), script)
+	if err != nil {
+		return nil, err
+	}
+	chunk = lua.Optimize(chunk, lua.OptimizationLevel)
+	return lua.Compile(chunk, script)
+}
+
+// moduleName returns the name require uses to find the module at path from
+// dir, for example "lib.json" for lib/json.milk and "lib" for lib/init.milk.
+// The module must be in dir or below it.
+func moduleName(dir, path string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("module %v is not in the directory of the main script", path)
+	}
+	name := filepath.ToSlash(rel)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = strings.TrimSuffix(name, "/init")
+	return strings.ReplaceAll(name, "/", "."), nil
+}
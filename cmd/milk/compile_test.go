@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	lua "github.com/zmsvDreamLang/Milk"
+)
+
+func TestModuleName(t *testing.T) {
+	for path, expected := range map[string]string{
+		"json.milk":          "json",
+		"./lib/json.lua":     "lib.json",
+		"lib/init.milk":      "lib",
+		"lib/sub/init.milk":  "lib.sub",
+		"lib/../util.milk":   "util",
+		"lib/version.1.milk": "lib.version.1",
+	} {
+		if name, err := moduleName(".", path); err != nil || name != expected {
+			t.Errorf("moduleName(%q) = %q, %v, expected %q", path, name, err, expected)
+		}
+	}
+	// the names are relative to the directory of the main script
+	app := filepath.Join(t.TempDir(), "app")
+	for _, tc := range []struct{ dir, path, expected string }{
+		{app, filepath.Join(app, "lib", "greet.milk"), "lib.greet"},
+		{app, filepath.Join(app, "lib", "init.milk"), "lib"},
+		{"src", "src/lib/json.milk", "lib.json"},
+		{"src/", "./src/util.milk", "util"},
+	} {
+		if name, err := moduleName(tc.dir, tc.path); err != nil || name != tc.expected {
+			t.Errorf("moduleName(%q, %q) = %q, %v, expected %q", tc.dir, tc.path, name, err, tc.expected)
+		}
+	}
+	for _, tc := range []struct{ dir, path string }{
+		{app, filepath.Join(filepath.Dir(app), "lib", "greet.milk")},
+		{".", "../lib/x.milk"},
+		{"src", "lib/x.milk"},
+	} {
+		if name, err := moduleName(tc.dir, tc.path); err == nil {
+			t.Errorf("moduleName(%q, %q) = %q, expected an error", tc.dir, tc.path, name)
+		}
+	}
+}
+
+func TestCompileMain(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.milk":      `result = require("lib.greet")("milk") .. require("lib").suffix`,
+		"lib/greet.milk": `return function(name) return "hello " .. name end`,
+		"lib/init.milk":  `return {suffix = "!"}`,
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	if status := compileMain("app.milkc", true, []string{"main.milk", "lib/greet.milk", "lib/init.milk"}); status != 0 {
+		t.Fatalf("compileMain failed with status %v", status)
+	}
+	// the sources must not be needed anymore
+	os.RemoveAll("lib")
+	L := lua.NewState()
+	defer L.Close()
+	if err := L.DoFile("app.milkc"); err != nil {
+		t.Fatal(err)
+	}
+	if result := L.GetGlobal("result"); result.String() != "hello milk!" {
+		t.Errorf("unexpected result %v", result)
+	}
+}
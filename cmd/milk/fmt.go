@@ -0,0 +1,998 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zmsvDreamLang/Milk/ast"
+	"github.com/zmsvDreamLang/Milk/parse"
+)
+
+/* milk fmt: source formatter {{{ */
+
+func fmtMain(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	check := fs.Bool("check", false, "")
+	write := fs.Bool("write", false, "")
+	fs.Usage = func() {
+		fmt.Println(`Usage: milk fmt [--check | --write] file [file ...]
+Reformats the scripts with consistent indentation, spacing and quoting and
+prints the result. Comments and blank lines are kept.
+  --check  list the files whose formatting differs and exit with status 1
+  --write  rewrite the files in place`)
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || *check && *write {
+		fs.Usage()
+		return 2
+	}
+	status := 0
+	for _, path := range fs.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Println(err.Error())
+			status = 1
+			continue
+		}
+		out, err := formatSource(src, path)
+		if err != nil {
+			fmt.Println(strings.TrimSpace(err.Error()))
+			status = 1
+			continue
+		}
+		switch {
+		case *check:
+			if !bytes.Equal(src, out) {
+				fmt.Println(path)
+				status = 1
+			}
+		case *write:
+			if bytes.Equal(src, out) {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err == nil {
+				err = os.WriteFile(path, out, info.Mode())
+			}
+			if err != nil {
+				fmt.Println(err.Error())
+				status = 1
+			}
+		default:
+			os.Stdout.Write(out)
+		}
+	}
+	return status
+}
+
+// formatSource returns the chunk in src re-emitted from its AST. Comments and
+// blank lines between statements are taken from the trivia of the tokens. A
+// first line starting with '#' is kept as it is.
+func formatSource(src []byte, name string) ([]byte, error) {
+	body := blankShebang(src)
+	chunk, tokens, err := parse.ParseTokens(bytes.NewReader(body), name)
+	if err != nil {
+		return nil, err
+	}
+	f := newFormatter(body, tokens)
+	if shebang := src[:len(src)-len(body)]; len(shebang) > 0 {
+		f.out = append(f.out, strings.TrimRight(string(shebang), " \t"))
+	} else {
+		f.blockStart = true
+	}
+	f.stmts(chunk)
+	f.continued = 0
+	f.flush(ast.Position{Line: len(f.lineStarts) + 1})
+	return []byte(strings.Join(f.out, "\n") + "\n"), nil
+}
+
+type formatter struct {
+	src        []byte
+	lineStarts []int
+	tokens     []ast.Token
+	tokenIndex map[[2]int]int
+	comments   []*ast.Comment
+	next       int // the first comment not printed yet
+	out        []string
+	line       strings.Builder
+	indent     int
+	blockStart bool // nothing has been printed in the current block yet
+	interp     int  // inside the ${...} parts of an interpolated string
+	continued  int  // indent+1 while the lines at indent continue an expression
+}
+
+func newFormatter(src []byte, tokens []ast.Token) *formatter {
+	f := &formatter{src: src, tokens: tokens, tokenIndex: map[[2]int]int{}}
+	for i, tok := range tokens {
+		f.tokenIndex[[2]int{tok.Pos.Line, tok.Pos.Column}] = i
+		f.comments = append(f.comments, tok.Comments...)
+	}
+	// lines break the way the scanner counts them: \n, \r, \r\n and \n\r
+	f.lineStarts = []int{0}
+	for i := 0; i < len(src); i++ {
+		if ch := src[i]; ch == '\n' || ch == '\r' {
+			if i+1 < len(src) && src[i+1] != ch && (src[i+1] == '\n' || src[i+1] == '\r') {
+				i++
+			}
+			f.lineStarts = append(f.lineStarts, i+1)
+		}
+	}
+	return f
+}
+
+/* output {{{ */
+
+func (f *formatter) write(s string) {
+	if f.line.Len() == 0 {
+		f.line.WriteString(strings.Repeat("    ", f.indent))
+		if f.continued == f.indent+1 {
+			f.line.WriteString("    ")
+		}
+	}
+	f.line.WriteString(s)
+}
+
+func (f *formatter) newline() {
+	f.out = append(f.out, strings.TrimRight(f.line.String(), " \t"))
+	f.line.Reset()
+	f.blockStart = false
+}
+
+// blank separates what follows from the previous line, except at the start
+// of a block.
+func (f *formatter) blank() {
+	if !f.blockStart && len(f.out) > 0 && f.out[len(f.out)-1] != "" {
+		f.out = append(f.out, "")
+	}
+}
+
+func before(pos ast.Position, line, column int) bool {
+	return pos.Line < line || pos.Line == line && pos.Column < column
+}
+
+// flush prints the comments that come before pos. A comment that follows
+// code on the same line stays at the end of the line that was printed last.
+func (f *formatter) flush(pos ast.Position) {
+	if f.line.Len() > 0 {
+		return
+	}
+	for ; f.next < len(f.comments) && before(f.comments[f.next].Pos, pos.Line, pos.Column); f.next++ {
+		comment := f.comments[f.next]
+		text := strings.TrimRight(comment.Text, " \t")
+		if comment.Newlines == 0 && len(f.out) > 0 && f.out[len(f.out)-1] != "" {
+			f.out[len(f.out)-1] += " " + text
+			continue
+		}
+		if comment.Newlines > 1 {
+			f.blank()
+		}
+		f.write(text)
+		f.newline()
+	}
+}
+
+// inner prints the comments that come before pos inside an expression. A
+// comment that follows code stays on its line, which is broken after it, and
+// the rest of the expression is indented one more level.
+func (f *formatter) inner(pos ast.Position) {
+	if f.interp > 0 || !f.commentsBefore(pos) {
+		return
+	}
+	if f.line.Len() > 0 {
+		f.newline()
+		f.continued = f.indent + 1
+	}
+	f.flush(pos)
+}
+
+// token returns the index of the token at line and column, or -1.
+func (f *formatter) token(line, column int) int {
+	if i, ok := f.tokenIndex[[2]int{line, column}]; ok {
+		return i
+	}
+	return -1
+}
+
+// leading prints the comments before the construct starting at node and
+// keeps a single blank line if there was one before it.
+func (f *formatter) leading(node ast.PositionHolder) {
+	f.flush(ast.Position{Line: node.Line(), Column: node.Column()})
+	if i := f.token(node.Line(), node.Column()); i >= 0 && f.tokens[i].Newlines > 1 {
+		f.blank()
+	}
+}
+
+// commentsUntil reports whether a comment not printed yet comes before the
+// end of line.
+func (f *formatter) commentsUntil(line int) bool {
+	return f.next < len(f.comments) && f.comments[f.next].Pos.Line <= line
+}
+
+// commentsBefore reports whether a comment not printed yet comes before pos.
+func (f *formatter) commentsBefore(pos ast.Position) bool {
+	return f.next < len(f.comments) && before(f.comments[f.next].Pos, pos.Line, pos.Column)
+}
+
+/* }}} */
+
+/* statements {{{ */
+
+func (f *formatter) stmts(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		f.continued = 0
+		f.leading(stmt)
+		f.stmt(stmt)
+		f.newline()
+	}
+}
+
+// block prints stmts one level deeper and the comments up to end, the
+// position of the keyword that closes the block.
+func (f *formatter) block(stmts []ast.Stmt, end ast.Position) {
+	f.newline()
+	f.continued = 0
+	f.indent++
+	f.blockStart = true
+	f.stmts(stmts)
+	f.flush(end)
+	f.indent--
+}
+
+func endOf(node ast.PositionHolder) ast.Position {
+	return ast.Position{Line: node.LastLine()}
+}
+
+func (f *formatter) stmt(stmt ast.Stmt) {
+	switch st := stmt.(type) {
+	case *ast.AssignStmt:
+		f.statementStart(st.Lhs[0])
+		f.exprList(st.Lhs)
+		f.write(" = ")
+		f.exprList(st.Rhs)
+	case *ast.CompoundAssignStmt:
+		f.statementStart(st.Lhs)
+		f.expr(st.Lhs)
+		f.write(" " + st.Operator + "= ")
+		f.expr(st.Rhs)
+	case *ast.LocalAssignStmt:
+		f.localAssign(st)
+	case *ast.FuncCallStmt:
+		f.statementStart(st.Expr)
+		f.expr(st.Expr)
+	case *ast.DoBlockStmt:
+		f.write("do")
+		f.block(st.Stmts, endOf(st))
+		f.write("end")
+	case *ast.WhileStmt:
+		f.write("while ")
+		f.expr(st.Condition)
+		f.write(" do")
+		f.block(st.Stmts, endOf(st))
+		f.write("end")
+	case *ast.RepeatStmt:
+		f.write("repeat")
+		f.block(st.Stmts, ast.Position{Line: st.Condition.Line()})
+		f.write("until ")
+		f.expr(st.Condition)
+	case *ast.MatchStmt:
+		f.write("match ")
+		f.expr(st.Value)
+		f.write(" with")
+		f.newline()
+		f.indent++
+		f.blockStart = true
+		for _, arm := range st.Arms {
+			f.leading(arm)
+			f.write("case ")
+			f.expr(arm.Pattern)
+			if arm.Guard != nil {
+				f.write(" if ")
+				f.expr(arm.Guard)
+			}
+			f.write(" then")
+			f.block(arm.Stmts, endOf(arm))
+		}
+		f.indent--
+		f.flush(endOf(st))
+		f.write("end")
+	case *ast.IfStmt:
+		f.ifStmt(st)
+	case *ast.NumberForStmt:
+		f.write("for " + st.Name + " = ")
+		f.expr(st.Init)
+		f.write(", ")
+		f.expr(st.Limit)
+		if st.Step != nil {
+			f.write(", ")
+			f.expr(st.Step)
+		}
+		f.write(" do")
+		f.block(st.Stmts, endOf(st))
+		f.write("end")
+	case *ast.GenericForStmt:
+		f.write("for " + strings.Join(st.Names, ", ") + " in ")
+		f.exprList(st.Exprs)
+		f.write(" do")
+		f.block(st.Stmts, endOf(st))
+		f.write("end")
+	case *ast.FuncDefStmt:
+		f.write("function ")
+		if st.Name.Func != nil {
+			f.expr(st.Name.Func)
+		} else {
+			f.expr(st.Name.Receiver)
+			f.write(":" + st.Name.Method)
+		}
+		f.funcBody(st.Func)
+	case *ast.ClassStmt:
+		if st.Local {
+			f.write("local ")
+		}
+		f.write("class " + st.Name)
+		if st.Base != nil {
+			f.write(" extends ")
+			f.expr(st.Base)
+		}
+		f.newline()
+		f.indent++
+		f.blockStart = true
+		for _, member := range st.Members {
+			f.leading(member)
+			if member.Static {
+				f.write("static ")
+			}
+			if fn, ok := member.Value.(*ast.FunctionExpr); ok {
+				f.write("function " + member.Name)
+				f.funcBody(fn)
+			} else {
+				f.write(member.Name + " = ")
+				f.expr(member.Value)
+			}
+			f.newline()
+		}
+		f.flush(endOf(st))
+		f.indent--
+		f.write("end")
+	case *ast.ReturnStmt:
+		f.write("return")
+		if len(st.Exprs) > 0 {
+			f.write(" ")
+			f.exprList(st.Exprs)
+		}
+	case *ast.BreakStmt:
+		f.write("break")
+	case *ast.ContinueStmt:
+		f.write("continue")
+	case *ast.LabelStmt:
+		f.write("::" + st.Name + "::")
+	case *ast.GotoStmt:
+		f.write("goto " + st.Label)
+	}
+}
+
+// statementStart separates a statement that starts with a parenthesis from
+// the previous one, which it would be called on otherwise.
+func (f *formatter) statementStart(expr ast.Expr) {
+	if leadingParen(expr) {
+		f.write(";")
+	}
+}
+
+func (f *formatter) localAssign(st *ast.LocalAssignStmt) {
+	f.write("local ")
+	if st.Pattern != nil {
+		for i, field := range st.Pattern {
+			// the fields of a value are together in braces
+			first := i == 0 || field.Value != st.Pattern[i-1].Value
+			if i > 0 && first && st.Pattern[i-1].Key != nil {
+				f.write("}")
+			}
+			if i > 0 {
+				f.write(", ")
+			}
+			if first && field.Key != nil {
+				f.write("{")
+			}
+			f.write(field.Name)
+			switch field.Key.(type) {
+			case nil:
+				if i < len(st.Attribs) && st.Attribs[i] != "" {
+					f.write(" <" + st.Attribs[i] + ">")
+				}
+			case *ast.NumberExpr:
+			default:
+				if field.Default == nil {
+					f.write("=")
+				} else {
+					f.write(" = ")
+					f.expr(field.Default)
+				}
+			}
+		}
+		if st.Pattern[len(st.Pattern)-1].Key != nil {
+			f.write("}")
+		}
+		f.write(" = ")
+		f.exprList(st.Exprs)
+		return
+	}
+	if len(st.Names) == 1 && st.Attribs == nil && len(st.Exprs) == 1 {
+		if fn, ok := st.Exprs[0].(*ast.FunctionExpr); ok && fn.Name == st.Names[0] {
+			f.write("function " + fn.Name)
+			f.funcBody(fn)
+			return
+		}
+	}
+	for i, name := range st.Names {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.write(name)
+		if i < len(st.Attribs) && st.Attribs[i] != "" {
+			f.write(" <" + st.Attribs[i] + ">")
+		}
+	}
+	if len(st.Exprs) > 0 {
+		f.write(" = ")
+		f.exprList(st.Exprs)
+	}
+}
+
+func (f *formatter) ifStmt(st *ast.IfStmt) {
+	if st.Line() == st.LastLine() && len(st.Else) == 0 && f.compact(st.Then, st.LastLine()) {
+		f.write("if ")
+		f.expr(st.Condition)
+		f.write(" then ")
+		if len(st.Then) > 0 {
+			f.stmt(st.Then[0])
+			f.write(" ")
+		}
+		f.write("end")
+		return
+	}
+	end := endOf(st)
+	f.write("if ")
+	for {
+		f.expr(st.Condition)
+		f.write(" then")
+		// elseif clauses are parsed as an if without an end of its own
+		if len(st.Else) == 1 {
+			if elseif, ok := st.Else[0].(*ast.IfStmt); ok && elseif.LastLine() == 0 {
+				f.block(st.Then, ast.Position{Line: elseif.Line(), Column: elseif.Column()})
+				f.write("elseif ")
+				st = elseif
+				continue
+			}
+		}
+		if len(st.Else) == 0 {
+			f.block(st.Then, end)
+			break
+		}
+		f.block(st.Then, f.elsePosition(st.Else))
+		f.write("else")
+		f.block(st.Else, end)
+		break
+	}
+	f.write("end")
+}
+
+// elsePosition returns the position of the else keyword that comes before
+// stmts, the position of a zero value if it is unknown.
+func (f *formatter) elsePosition(stmts []ast.Stmt) ast.Position {
+	if i := f.token(stmts[0].Line(), stmts[0].Column()); i > 0 && f.tokens[i-1].Type == parse.TElse {
+		return f.tokens[i-1].Pos
+	}
+	return ast.Position{}
+}
+
+// compact reports whether a block written on a single line in the source
+// can stay on a single line: it has no comments and at most one simple
+// statement.
+func (f *formatter) compact(stmts []ast.Stmt, line int) bool {
+	if len(stmts) > 1 || f.commentsUntil(line) {
+		return false
+	}
+	if len(stmts) == 0 {
+		return true
+	}
+	switch stmts[0].(type) {
+	case *ast.AssignStmt, *ast.CompoundAssignStmt, *ast.FuncCallStmt, *ast.ReturnStmt,
+		*ast.BreakStmt, *ast.ContinueStmt, *ast.GotoStmt:
+		return true
+	case *ast.LocalAssignStmt:
+		return stmts[0].(*ast.LocalAssignStmt).Pattern == nil
+	}
+	return false
+}
+
+func (f *formatter) funcBody(fn *ast.FunctionExpr) {
+	f.write("(")
+	for i, name := range fn.ParList.Names {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.write(name)
+		if i < len(fn.ParList.Types) && fn.ParList.Types[i] != nil {
+			f.write(": " + typeHintString(fn.ParList.Types[i]))
+		}
+		if i < len(fn.ParList.Defaults) && fn.ParList.Defaults[i] != nil {
+			f.write(" = ")
+			f.expr(fn.ParList.Defaults[i])
+		}
+	}
+	if fn.ParList.HasVargs {
+		if len(fn.ParList.Names) > 0 {
+			f.write(", ")
+		}
+		f.write("...")
+	}
+	f.write(")")
+	switch len(fn.ReturnTypes) {
+	case 0:
+	case 1:
+		f.write(": " + typeHintString(fn.ReturnTypes[0]))
+	default:
+		types := make([]string, len(fn.ReturnTypes))
+		for i, typ := range fn.ReturnTypes {
+			types[i] = typeHintString(typ)
+		}
+		f.write(": (" + strings.Join(types, ", ") + ")")
+	}
+	if fn.Line() == fn.LastLine() && f.compact(fn.Stmts, fn.LastLine()) {
+		if len(fn.Stmts) > 0 {
+			f.write(" ")
+			f.stmt(fn.Stmts[0])
+		}
+		f.write(" end")
+		return
+	}
+	f.block(fn.Stmts, endOf(fn))
+	f.write("end")
+}
+
+func typeHintString(hint *ast.TypeHint) string {
+	if hint.Nullable {
+		return hint.Name + "?"
+	}
+	return hint.Name
+}
+
+/* }}} */
+
+/* expressions {{{ */
+
+// operator precedences from the lowest to the highest
+const (
+	precNilCoalesce = iota + 1
+	precOr
+	precAnd
+	precCompare
+	precBor
+	precBxor
+	precBand
+	precShift
+	precConcat
+	precAdd
+	precMul
+	precUnary
+	precPow
+	precPrimary
+)
+
+var arithPrecedence = map[string]int{
+	"|": precBor, "~": precBxor, "&": precBand, "<<": precShift, ">>": precShift,
+	"+": precAdd, "-": precAdd, "*": precMul, "/": precMul, "//": precMul, "%": precMul,
+	"^": precPow}
+
+func precedence(expr ast.Expr) int {
+	switch ex := expr.(type) {
+	case *ast.NilCoalesceOpExpr:
+		return precNilCoalesce
+	case *ast.LogicalOpExpr:
+		if ex.Operator == "or" {
+			return precOr
+		}
+		return precAnd
+	case *ast.RelationalOpExpr:
+		return precCompare
+	case *ast.StringConcatOpExpr:
+		return precConcat
+	case *ast.ArithmeticOpExpr:
+		return arithPrecedence[ex.Operator]
+	case *ast.UnaryMinusOpExpr, *ast.UnaryNotOpExpr, *ast.UnaryLenOpExpr, *ast.UnaryBNotOpExpr:
+		return precUnary
+	}
+	return precPrimary
+}
+
+// isPrefix reports whether expr can be called or indexed without
+// parentheses.
+func isPrefix(expr ast.Expr) bool {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr, *ast.AttrGetExpr, *ast.FuncCallExpr, *ast.SuperExpr:
+		return true
+	case *ast.Comma3Expr:
+		return ex.AdjustRet
+	}
+	return false
+}
+
+// leadingParen reports whether expr is printed starting with a parenthesis.
+func leadingParen(expr ast.Expr) bool {
+	switch ex := expr.(type) {
+	case *ast.FuncCallExpr:
+		if ex.AdjustRet && !ex.Optional {
+			return true
+		}
+		if ex.Func != nil {
+			return !isPrefix(ex.Func) || leadingParen(ex.Func)
+		}
+		return !isPrefix(ex.Receiver) || leadingParen(ex.Receiver)
+	case *ast.AttrGetExpr:
+		return !isPrefix(ex.Object) || leadingParen(ex.Object)
+	case *ast.Comma3Expr:
+		return ex.AdjustRet
+	}
+	return false
+}
+
+func (f *formatter) exprList(exprs []ast.Expr) {
+	for i, expr := range exprs {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.expr(expr)
+	}
+}
+
+// operand prints expr in parentheses if it binds less tightly than prec.
+func (f *formatter) operand(expr ast.Expr, prec int) {
+	if precedence(expr) < prec {
+		f.write("(")
+		f.expr(expr)
+		f.write(")")
+		return
+	}
+	f.expr(expr)
+}
+
+func (f *formatter) prefix(expr ast.Expr) {
+	if isPrefix(expr) {
+		f.expr(expr)
+		return
+	}
+	f.write("(")
+	f.expr(expr)
+	f.write(")")
+}
+
+func (f *formatter) binary(lhs ast.Expr, operator string, rhs ast.Expr, prec int, right bool) {
+	if right {
+		f.operand(lhs, prec+1)
+	} else {
+		f.operand(lhs, prec)
+	}
+	f.write(" " + operator + " ")
+	switch {
+	case precedence(rhs) == precUnary:
+		// a unary operator on the right needs no parentheses: 2 ^ -x
+		f.expr(rhs)
+	case right:
+		f.operand(rhs, prec)
+	default:
+		f.operand(rhs, prec+1)
+	}
+}
+
+func (f *formatter) unary(operator string, expr ast.Expr) {
+	f.write(operator)
+	// '- -x' must not become a comment
+	switch ex := expr.(type) {
+	case *ast.UnaryMinusOpExpr:
+		if operator == "-" {
+			f.write(" ")
+		}
+	case *ast.NumberExpr:
+		if operator == "-" && strings.HasPrefix(ex.Value, "-") {
+			f.write(" ")
+		}
+	}
+	f.operand(expr, precUnary)
+}
+
+func (f *formatter) expr(expr ast.Expr) {
+	if expr.Line() > 0 {
+		f.inner(ast.Position{Line: expr.Line(), Column: expr.Column()})
+	}
+	switch ex := expr.(type) {
+	case *ast.NilExpr:
+		f.write("nil")
+	case *ast.TrueExpr:
+		f.write("true")
+	case *ast.FalseExpr:
+		f.write("false")
+	case *ast.NumberExpr:
+		f.write(ex.Value)
+	case *ast.StringExpr:
+		f.write(f.quote(ex))
+	case *ast.Comma3Expr:
+		if ex.AdjustRet {
+			f.write("(...)")
+		} else {
+			f.write("...")
+		}
+	case *ast.IdentExpr:
+		f.write(ex.Value)
+	case *ast.SuperExpr:
+		f.write("super")
+	case *ast.AttrGetExpr:
+		f.prefix(ex.Object)
+		if key, ok := ex.Key.(*ast.StringExpr); ok && isName(key.Value) {
+			if ex.Optional {
+				f.write("?." + key.Value)
+			} else {
+				f.write("." + key.Value)
+			}
+			return
+		}
+		if ex.Optional {
+			f.write("?.")
+		}
+		f.write("[")
+		f.expr(ex.Key)
+		f.write("]")
+	case *ast.TableExpr:
+		f.table(ex)
+	case *ast.FuncCallExpr:
+		f.call(ex)
+	case *ast.LogicalOpExpr:
+		f.binary(ex.Lhs, ex.Operator, ex.Rhs, precedence(ex), false)
+	case *ast.NilCoalesceOpExpr:
+		f.binary(ex.Lhs, "??", ex.Rhs, precNilCoalesce, false)
+	case *ast.RelationalOpExpr:
+		f.binary(ex.Lhs, ex.Operator, ex.Rhs, precCompare, false)
+	case *ast.StringConcatOpExpr:
+		f.binary(ex.Lhs, "..", ex.Rhs, precConcat, true)
+	case *ast.ArithmeticOpExpr:
+		f.binary(ex.Lhs, ex.Operator, ex.Rhs, precedence(ex), ex.Operator == "^")
+	case *ast.UnaryMinusOpExpr:
+		f.unary("-", ex.Expr)
+	case *ast.UnaryNotOpExpr:
+		f.unary("not ", ex.Expr)
+	case *ast.UnaryLenOpExpr:
+		f.unary("#", ex.Expr)
+	case *ast.UnaryBNotOpExpr:
+		f.unary("~", ex.Expr)
+	case *ast.FunctionExpr:
+		f.write("function")
+		f.funcBody(ex)
+	case *ast.InterpolatedStringExpr:
+		f.interpolation(ex)
+	case *ast.RangePattern:
+		f.expr(ex.Lo)
+		f.write(" .. ")
+		f.expr(ex.Hi)
+	case *ast.TypePattern:
+		f.write(ex.Name + ": " + typeHintString(ex.Type))
+	}
+}
+
+func (f *formatter) call(ex *ast.FuncCallExpr) {
+	parens := ex.AdjustRet && !ex.Optional
+	if parens {
+		f.write("(")
+	}
+	if ex.Func != nil {
+		f.prefix(ex.Func)
+	} else {
+		f.prefix(ex.Receiver)
+		if ex.Optional {
+			f.write("?:" + ex.Method)
+		} else {
+			f.write(":" + ex.Method)
+		}
+	}
+	if ex.NamedArgs {
+		f.expr(ex.Args[0])
+	} else {
+		f.write("(")
+		f.exprList(ex.Args)
+		f.write(")")
+	}
+	if parens {
+		f.write(")")
+	}
+}
+
+// table prints a table constructor or pattern on a single line, or with a
+// field per line if its first field starts on a new line in the source or a
+// comment comes between its fields.
+func (f *formatter) table(ex *ast.TableExpr) {
+	if len(ex.Fields) == 0 {
+		f.write("{}")
+		return
+	}
+	closing := -1
+	if open := f.token(ex.Line(), ex.Column()); open >= 0 && f.interp == 0 {
+		depth := 0
+		for i := open; i < len(f.tokens); i++ {
+			if f.tokens[i].Type == '{' {
+				depth++
+			} else if f.tokens[i].Type == '}' {
+				if depth--; depth == 0 {
+					closing = i
+					break
+				}
+			}
+		}
+	}
+	if closing < 0 || fieldStart(ex.Fields[0]).Line() == ex.Line() && !f.commentsBefore(f.tokens[closing].Pos) {
+		f.write("{")
+		for i, field := range ex.Fields {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.field(field)
+		}
+		f.write("}")
+		return
+	}
+	f.write("{")
+	f.newline()
+	f.indent++
+	f.blockStart = true
+	continued := f.continued
+	for _, field := range ex.Fields {
+		f.leading(fieldStart(field))
+		f.continued = 0
+		f.field(field)
+		f.write(",")
+		f.newline()
+	}
+	f.flush(f.tokens[closing].Pos)
+	f.indent--
+	f.continued = continued
+	f.write("}")
+}
+
+func fieldStart(field *ast.Field) ast.Expr {
+	if field.Key != nil && field.Key.Line() > 0 {
+		return field.Key
+	}
+	return field.Value
+}
+
+func (f *formatter) field(field *ast.Field) {
+	if field.Key != nil {
+		if key, ok := field.Key.(*ast.StringExpr); ok && isName(key.Value) {
+			f.write(key.Value)
+		} else {
+			f.write("[")
+			f.expr(field.Key)
+			f.write("]")
+		}
+		f.write(" = ")
+	}
+	f.expr(field.Value)
+}
+
+func isName(s string) bool {
+	if len(s) == 0 || parse.IsReservedWord(s) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if !(ch == '_' || 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || i > 0 && '0' <= ch && ch <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *formatter) interpolation(ex *ast.InterpolatedStringExpr) {
+	f.write("`")
+	for _, part := range ex.Parts {
+		// literal parts share the position of the string
+		if str, ok := part.(*ast.StringExpr); ok && str.Line() == ex.Line() && str.Column() == ex.Column() {
+			f.write(escapeString(str.Value, '`'))
+			continue
+		}
+		f.write("${")
+		f.interp++
+		f.expr(part)
+		f.interp--
+		f.write("}")
+	}
+	f.write("`")
+}
+
+// quote returns the string literal for ex. Strings written in long brackets
+// keep them, the others are double quoted unless they contain double quotes
+// but no single quotes.
+func (f *formatter) quote(ex *ast.StringExpr) string {
+	if f.interp == 0 && f.srcByte(ex.Line(), ex.Column()) == '[' {
+		if long, ok := longBracket(ex.Value); ok {
+			return long
+		}
+	}
+	if strings.Contains(ex.Value, `"`) && !strings.Contains(ex.Value, "'") {
+		return "'" + escapeString(ex.Value, '\'') + "'"
+	}
+	return `"` + escapeString(ex.Value, '"') + `"`
+}
+
+func (f *formatter) srcByte(line, column int) byte {
+	if line < 1 || line > len(f.lineStarts) || column < 1 {
+		return 0
+	}
+	if i := f.lineStarts[line-1] + column - 1; i < len(f.src) {
+		return f.src[i]
+	}
+	return 0
+}
+
+// longBracket returns s in long brackets of the lowest level that can hold
+// it. Carriage returns would be read back as newlines.
+func longBracket(s string) (string, bool) {
+	if strings.Contains(s, "\r") {
+		return "", false
+	}
+	level := ""
+	for {
+		closing := "]" + level + "]"
+		if strings.Index(s+closing, closing) == len(s) {
+			break
+		}
+		level += "="
+	}
+	// a newline right after the opening bracket is skipped
+	if strings.HasPrefix(s, "\n") {
+		s = "\n" + s
+	}
+	return "[" + level + "[" + s + "]" + level + "]", true
+}
+
+// escapeString escapes s for a string delimited by quote. Newlines stay as
+// they are in backtick strings.
+func escapeString(s string, quote byte) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == quote || ch == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(ch)
+		case ch == '$' && quote == '`' && i+1 < len(s) && s[i+1] == '{':
+			buf.WriteString(`\$`)
+		case ch == '\n' && quote == '`':
+			buf.WriteByte(ch)
+		case ch == '\a':
+			buf.WriteString(`\a`)
+		case ch == '\b':
+			buf.WriteString(`\b`)
+		case ch == '\f':
+			buf.WriteString(`\f`)
+		case ch == '\n':
+			buf.WriteString(`\n`)
+		case ch == '\r':
+			buf.WriteString(`\r`)
+		case ch == '\t':
+			buf.WriteString(`\t`)
+		case ch == '\v':
+			buf.WriteString(`\v`)
+		case ch < ' ' || ch == 0x7f:
+			fmt.Fprintf(&buf, `\%03d`, ch)
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	return buf.String()
+}
+
+/* }}} */
+
+/* }}} */
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zmsvDreamLang/Milk/parse"
+)
+
+func formatString(t *testing.T, src string) string {
+	t.Helper()
+	out, err := formatSource([]byte(src), "test.milk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestFormat(t *testing.T) {
+	src := `-- leading comment
+local   x=1;local y  =  'a'
+
+
+local t={1,2;three=3,["four four"]=4}
+function t.f(a,b:number?,c=2,...):(string,number?)
+  if a then return b elseif c then    -- trailing
+     return c
+  else
+    -- before the end
+    return ...
+  end
+end
+local function g() return 1 end
+local {p, q=, r = 3} = t
+local   u,{v},{ w = 1 }, z<close> = f()
+local s <const> = x .. y .. (x .. y) .. "it's" .. '"q"'
+print((f()), -(-x), - -x, not not x, (a + b) * c, a - (b - c), 2 ^ -x, (-2) ^ 2, {1, {
+  2}})
+match x with
+case 1 .. 3 if y then print "range"
+case {k = n: number} then
+    print(n)
+  case _ then
+end
+class A extends B
+  static count = 0
+  function init(n) super.init(self, n) end
+end
+local obj = {
+    a = 1, -- the a
+    b = function() return 2 end,
+
+    -- the c
+    c = {},
+}
+print(` + "`${x} \\` ${\"y\"}`" + `, [[
+long]], obj?.a, obj?:f())
+goto done
+::done::
+`
+	expected := `-- leading comment
+local x = 1
+local y = "a"
+
+local t = {1, 2, three = 3, ["four four"] = 4}
+function t.f(a, b: number?, c = 2, ...): (string, number?)
+    if a then
+        return b
+    elseif c then -- trailing
+        return c
+    else
+        -- before the end
+        return ...
+    end
+end
+local function g() return 1 end
+local {p, q=, r = 3} = t
+local u, {v}, {w = 1}, z <close> = f()
+local s <const> = x .. y .. (x .. y) .. "it's" .. '"q"'
+print((f()), - -x, - -x, not not x, (a + b) * c, a - (b - c), 2 ^ -x, (-2) ^ 2, {1, {
+    2,
+}})
+match x with
+    case 1 .. 3 if y then
+        print("range")
+    case {k = n: number} then
+        print(n)
+    case _ then
+end
+class A extends B
+    static count = 0
+    function init(n) super.init(self, n) end
+end
+local obj = {
+    a = 1, -- the a
+    b = function() return 2 end,
+
+    -- the c
+    c = {},
+}
+print(` + "`${x} \\` ${\"y\"}`" + `, [[long]], obj?.a, obj?:f())
+goto done
+::done::
+`
+	if out := formatString(t, src); out != expected {
+		t.Errorf("unexpected output:\n%v", out)
+	}
+}
+
+func TestFormatAmbiguousCall(t *testing.T) {
+	src := "local a = b;\n(f or g)()\n"
+	expected := "local a = b\n;(f or g)()\n"
+	if out := formatString(t, src); out != expected {
+		t.Errorf("unexpected output:\n%v", out)
+	}
+}
+
+func TestFormatShebang(t *testing.T) {
+	src := "#!/usr/bin/env milk\n\nlocal   x=1\n"
+	expected := "#!/usr/bin/env milk\n\nlocal x = 1\n"
+	if out := formatString(t, src); out != expected {
+		t.Errorf("unexpected output:\n%v", out)
+	}
+}
+
+func TestFormatInnerComments(t *testing.T) {
+	src := `local res = {"return",   -- first return
+  "call", "call",
+  "call",    -- last call
+}
+local x = foo(a, -- the a
+  b,
+  -- before c
+  c)
+local y = a + -- plus
+  b * c
+if a and -- first
+   b then
+  print(x)
+end
+`
+	expected := `local res = {
+    "return", -- first return
+    "call",
+    "call",
+    "call", -- last call
+}
+local x = foo(a, -- the a
+    b,
+    -- before c
+    c)
+local y = a + -- plus
+    b * c
+if a and -- first
+    b then
+    print(x)
+end
+`
+	if out := formatString(t, src); out != expected {
+		t.Errorf("unexpected output:\n%v", out)
+	}
+
+	// the comments after a continued expression are not indented with it
+	src = "f(a, -- arg\n  b)\n-- final\nlocal z = 1\nlocal x = a + -- why\n b\n-- end\n"
+	expected = "f(a, -- arg\n    b)\n-- final\nlocal z = 1\nlocal x = a + -- why\n    b\n-- end\n"
+	if out := formatString(t, src); out != expected {
+		t.Errorf("unexpected output:\n%v", out)
+	}
+}
+
+// TestFormatScripts formats the test scripts and checks that the result
+// parses to the same AST with the same comments and is formatted as it is.
+func TestFormatScripts(t *testing.T) {
+	scripts, _ := filepath.Glob("../../_glua-tests/*.lua")
+	more, _ := filepath.Glob("../../_lua5.1-tests/*.lua")
+	for _, script := range append(scripts, more...) {
+		src, err := os.ReadFile(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk, err := parse.Parse(strings.NewReader(string(src)), script)
+		if err != nil {
+			continue
+		}
+		out, err := formatSource(src, script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted, err := parse.Parse(strings.NewReader(string(out)), script)
+		if err != nil {
+			t.Errorf("%v: formatted source does not parse: %v", script, err)
+			continue
+		}
+		if parse.Dump(chunk) != parse.Dump(formatted) {
+			t.Errorf("%v: formatted source has a different AST", script)
+			continue
+		}
+		if commentTexts(src) != commentTexts(out) {
+			t.Errorf("%v: formatted source has different comments", script)
+			continue
+		}
+		again, _ := formatSource(out, script)
+		if string(again) != string(out) {
+			t.Errorf("%v: formatting is not idempotent", script)
+		}
+	}
+}
+
+func commentTexts(src []byte) string {
+	_, tokens, _ := parse.ParseTokens(strings.NewReader(string(src)), "")
+	texts := []string{}
+	for _, tok := range tokens {
+		for _, comment := range tok.Comments {
+			texts = append(texts, strings.TrimRight(comment.Text, " \t"))
+		}
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,886 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+	lua "github.com/zmsvDreamLang/Milk"
+	"github.com/zmsvDreamLang/Milk/ast"
+	"github.com/zmsvDreamLang/Milk/parse"
+)
+
+/* milk lint: static analyzer {{{ */
+
+// lintRules are the rules of the linter, all of them enabled by default.
+var lintRules = []string{
+	"global",          // assignments to undeclared globals in functions
+	"toplevel-global", // assignments to globals not listed in the configuration
+	"unused",          // unused locals, parameters and loop variables
+	"shadowing",       // locals that shadow other locals
+	"unreachable",     // code after return, break, continue, goto and error()
+	"goto-scope",      // goto that jumps into the scope of a local
+	"unknown-member",  // fields missing from the standard libraries
+	"nan-compare",     // == and ~= comparisons with NaN
+}
+
+// lintConfigName is the configuration file looked up in the directory of a
+// script and its parents.
+const lintConfigName = ".milklint.toml"
+
+type lintConfig struct {
+	// Globals lists the globals that the scripts may assign.
+	Globals []string `toml:"globals"`
+	// Rules turns rules on or off by name.
+	Rules map[string]bool `toml:"rules"`
+}
+
+func (config *lintConfig) enabled(rule string) bool {
+	on, ok := config.Rules[rule]
+	return !ok || on
+}
+
+func lintMain(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	configPath := fs.String("config", "", "")
+	fs.Usage = func() {
+		fmt.Printf(`Usage: milk lint [-config file] file [file ...]
+Reports likely mistakes without running the scripts. Exits with status 1 if
+any problem is found. Rules are configured in the nearest %v:
+  globals = ["app"]          # globals that the scripts may assign
+  [rules]
+  shadowing = false          # turn a rule off
+A '-- milk:ignore [rule ...]' comment silences the line it ends, or the next
+line if it stands on its own.
+Rules: %v
+`, lintConfigName, strings.Join(lintRules, ", "))
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	status := 0
+	for _, path := range fs.Args() {
+		var config *lintConfig
+		var err error
+		if *configPath != "" {
+			config, err = loadLintConfig(*configPath)
+		} else {
+			config, err = findLintConfig(filepath.Dir(path))
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			return 2
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Println(err.Error())
+			status = 1
+			continue
+		}
+		diags := lintSource(src, path, config)
+		for _, diag := range diags {
+			fmt.Println(diag.String())
+		}
+		if len(diags) > 0 {
+			status = 1
+		}
+	}
+	return status
+}
+
+// findLintConfig loads the configuration file in dir or the closest of its
+// parents. Without one all the rules are enabled.
+func findLintConfig(dir string) (*lintConfig, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		path := filepath.Join(dir, lintConfigName)
+		if _, err := os.Stat(path); err == nil {
+			return loadLintConfig(path)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return &lintConfig{}, nil
+		}
+		dir = parent
+	}
+}
+
+func loadLintConfig(path string) (*lintConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &lintConfig{}
+	if err := toml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	for rule := range config.Rules {
+		if !containsString(lintRules, rule) {
+			return nil, fmt.Errorf("%v: unknown rule '%v'", path, rule)
+		}
+	}
+	return config, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// lintSource parses and lints a single chunk. A chunk with syntax errors is
+// not linted, the syntax errors are reported instead.
+func lintSource(src []byte, name string, config *lintConfig) []*diagnostic {
+	src = blankShebang(src)
+	chunk, tokens, err := parse.ParseRecover(bytes.NewReader(src), name)
+	if err != nil {
+		return syntaxDiagnostics(err, name, src)
+	}
+	lt := newLinter(name, config)
+	lt.indexTokens(tokens)
+	lt.readIgnores(tokens)
+	lt.lintChunk(chunk)
+	sortDiagnostics(lt.diags)
+	return lt.diags
+}
+
+/* scopes {{{ */
+
+type lintVar struct {
+	Name string
+	Kind string // local, parameter, loop variable, local function or local class
+	Node ast.PositionHolder
+	Used bool
+	NaN  bool // a constant holding NaN
+}
+
+type lintScope struct {
+	parent *lintScope
+	vars   []*lintVar
+}
+
+func newLintScope(parent *lintScope) *lintScope {
+	return &lintScope{parent: parent}
+}
+
+func (sc *lintScope) lookup(name string) *lintVar {
+	for ; sc != nil; sc = sc.parent {
+		for i := len(sc.vars) - 1; i >= 0; i-- {
+			if sc.vars[i].Name == name {
+				return sc.vars[i]
+			}
+		}
+	}
+	return nil
+}
+
+// ignoredName reports whether a local is exempt from the unused and
+// shadowing rules, like '_' and '_index'.
+func ignoredName(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
+/* }}} */
+
+// linter lints a chunk. As in lspIndex, the positions of the names that
+// locals, parameters and loops declare are found in the tokens.
+type linter struct {
+	source     string
+	config     *lintConfig
+	tokens     []ast.Token
+	tokenIndex map[ast.Position]int
+	libraries  map[string][]string
+	declared   map[string]bool // globals listed in the configuration
+	globals    map[string]bool // globals assigned at the top level of the chunk
+	reported   map[string]bool // undeclared globals reported at the top level
+	fields     map[string]bool // library fields the chunk assigns, like "string.foo"
+	members    []*ast.AttrGetExpr
+	ignores    map[int][]string
+	depth      int // nesting of functions
+	diags      []*diagnostic
+}
+
+func newLinter(source string, config *lintConfig) *linter {
+	lt := &linter{
+		source:     source,
+		config:     config,
+		libraries:  lua.Libraries(),
+		declared:   map[string]bool{},
+		globals:    map[string]bool{},
+		reported:   map[string]bool{},
+		fields:     map[string]bool{},
+		ignores:    map[int][]string{},
+		tokenIndex: map[ast.Position]int{},
+	}
+	for _, name := range config.Globals {
+		lt.declared[name] = true
+		lt.globals[name] = true
+	}
+	return lt
+}
+
+func (lt *linter) indexTokens(tokens []ast.Token) {
+	lt.tokens = tokens
+	for i, tok := range tokens {
+		lt.tokenIndex[ast.Position{Line: tok.Pos.Line, Column: tok.Pos.Column}] = i
+	}
+}
+
+// token returns the index of the token at the position of a node, or -1.
+func (lt *linter) token(node ast.PositionHolder) int {
+	if i, ok := lt.tokenIndex[ast.Position{Line: node.Line(), Column: node.Column()}]; ok {
+		return i
+	}
+	return -1
+}
+
+// name returns the index of the first identifier token named name from the
+// token at index from on, or -1.
+func (lt *linter) name(from int, name string) int {
+	if from < 0 {
+		return -1
+	}
+	for i := from; i < len(lt.tokens); i++ {
+		if lt.tokens[i].Type == parse.TIdent && lt.tokens[i].Str == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// at returns a node at the position of the token at index i, or node when
+// the token was not found.
+func (lt *linter) at(i int, node ast.PositionHolder) ast.PositionHolder {
+	if i < 0 {
+		return node
+	}
+	pos := &ast.Node{}
+	pos.SetLine(lt.tokens[i].Pos.Line)
+	pos.SetColumn(lt.tokens[i].Pos.Column)
+	return pos
+}
+
+// readIgnores collects the '-- milk:ignore' comments. An empty list of rules
+// ignores all of them.
+func (lt *linter) readIgnores(tokens []ast.Token) {
+	for i, tok := range tokens {
+		for j, comment := range tok.Comments {
+			fields := strings.Fields(strings.TrimPrefix(comment.Text, "--"))
+			if len(fields) == 0 || fields[0] != "milk:ignore" {
+				continue
+			}
+			line := tok.Pos.Line
+			if comment.Newlines == 0 && (i > 0 || j > 0) {
+				line = comment.Pos.Line
+			}
+			lt.ignores[line] = append(lt.ignores[line], fields[1:]...)
+			if len(fields) == 1 {
+				lt.ignores[line] = append(lt.ignores[line], "*")
+			}
+		}
+	}
+}
+
+func (lt *linter) report(rule string, node ast.PositionHolder, format string, args ...interface{}) {
+	if !lt.config.enabled(rule) {
+		return
+	}
+	if ignores, ok := lt.ignores[node.Line()]; ok && (containsString(ignores, "*") || containsString(ignores, rule)) {
+		return
+	}
+	lt.diags = append(lt.diags, &diagnostic{
+		Pos:     ast.Position{Source: lt.source, Line: node.Line(), Column: node.Column()},
+		Message: fmt.Sprintf(format, args...) + " (" + rule + ")",
+	})
+}
+
+func (lt *linter) lintChunk(chunk []ast.Stmt) {
+	lt.collectGlobals(chunk)
+	lt.block(chunk, nil)
+	for _, ex := range lt.members {
+		library := ex.Object.(*ast.IdentExpr).Value
+		name := ex.Key.(*ast.StringExpr).Value
+		if lt.fields[library+"."+name] {
+			continue
+		}
+		if suggestion := closestName(name, lt.libraries[library]); suggestion != "" {
+			lt.report("unknown-member", ex, "unknown library member '%v.%v' (did you mean '%v'?)", library, name, suggestion)
+		} else {
+			lt.report("unknown-member", ex, "unknown library member '%v.%v'", library, name)
+		}
+	}
+}
+
+// collectGlobals records the globals assigned outside of functions, which
+// functions may assign as well.
+func (lt *linter) collectGlobals(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		switch st := stmt.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range st.Lhs {
+				if ident, ok := lhs.(*ast.IdentExpr); ok {
+					lt.globals[ident.Value] = true
+				}
+			}
+		case *ast.FuncDefStmt:
+			if ident, ok := st.Name.Func.(*ast.IdentExpr); ok {
+				lt.globals[ident.Value] = true
+			}
+		case *ast.ClassStmt:
+			if !st.Local {
+				lt.globals[st.Name] = true
+			}
+		case *ast.DoBlockStmt:
+			lt.collectGlobals(st.Stmts)
+		case *ast.WhileStmt:
+			lt.collectGlobals(st.Stmts)
+		case *ast.RepeatStmt:
+			lt.collectGlobals(st.Stmts)
+		case *ast.IfStmt:
+			lt.collectGlobals(st.Then)
+			lt.collectGlobals(st.Else)
+		case *ast.NumberForStmt:
+			lt.collectGlobals(st.Stmts)
+		case *ast.GenericForStmt:
+			lt.collectGlobals(st.Stmts)
+		case *ast.MatchStmt:
+			for _, arm := range st.Arms {
+				lt.collectGlobals(arm.Stmts)
+			}
+		}
+	}
+}
+
+func (lt *linter) declare(scope *lintScope, name, kind string, node ast.PositionHolder) *lintVar {
+	if prev := scope.lookup(name); prev != nil && !ignoredName(name) && prev.Kind != "self" {
+		lt.report("shadowing", node, "%v '%v' shadows %v '%v' on line %v", kind, name, prev.Kind, name, prev.Node.Line())
+	}
+	v := &lintVar{Name: name, Kind: kind, Node: node}
+	scope.vars = append(scope.vars, v)
+	return v
+}
+
+// close reports the unused variables of a scope that ends.
+func (lt *linter) close(scope *lintScope) {
+	for _, v := range scope.vars {
+		if !v.Used && !ignoredName(v.Name) {
+			lt.report("unused", v.Node, "unused %v '%v'", v.Kind, v.Name)
+		}
+	}
+}
+
+/* statements {{{ */
+
+func (lt *linter) block(stmts []ast.Stmt, parent *lintScope) {
+	scope := newLintScope(parent)
+	lt.stmts(stmts, scope)
+	lt.close(scope)
+}
+
+func (lt *linter) stmts(stmts []ast.Stmt, scope *lintScope) {
+	terminated, reported := false, false
+	for _, stmt := range stmts {
+		if _, ok := stmt.(*ast.LabelStmt); ok {
+			terminated, reported = false, false
+		} else if terminated && !reported {
+			lt.report("unreachable", stmt, "unreachable code")
+			reported = true
+		}
+		lt.stmt(stmt, scope)
+		if blockTerminates([]ast.Stmt{stmt}) {
+			terminated = true
+		}
+	}
+	lt.checkGotos(stmts)
+}
+
+func (lt *linter) stmt(stmt ast.Stmt, scope *lintScope) {
+	switch st := stmt.(type) {
+	case *ast.AssignStmt:
+		lt.exprs(st.Rhs, scope)
+		for _, lhs := range st.Lhs {
+			lt.assign(lhs, scope)
+		}
+	case *ast.CompoundAssignStmt:
+		lt.expr(st.Rhs, scope)
+		lt.expr(st.Lhs, scope)
+		lt.assign(st.Lhs, scope)
+	case *ast.LocalAssignStmt:
+		lt.localAssign(st, scope)
+	case *ast.FuncCallStmt:
+		lt.expr(st.Expr, scope)
+	case *ast.DoBlockStmt:
+		lt.block(st.Stmts, scope)
+	case *ast.WhileStmt:
+		lt.expr(st.Condition, scope)
+		lt.block(st.Stmts, scope)
+	case *ast.RepeatStmt:
+		// the condition sees the locals of the body
+		body := newLintScope(scope)
+		lt.stmts(st.Stmts, body)
+		lt.expr(st.Condition, body)
+		lt.close(body)
+	case *ast.MatchStmt:
+		lt.expr(st.Value, scope)
+		for _, arm := range st.Arms {
+			armScope := newLintScope(scope)
+			lt.pattern(arm.Pattern, armScope)
+			if arm.Guard != nil {
+				lt.expr(arm.Guard, armScope)
+			}
+			lt.block(arm.Stmts, armScope)
+		}
+	case *ast.IfStmt:
+		lt.expr(st.Condition, scope)
+		lt.block(st.Then, scope)
+		lt.block(st.Else, scope)
+	case *ast.NumberForStmt:
+		lt.expr(st.Init, scope)
+		lt.expr(st.Limit, scope)
+		if st.Step != nil {
+			lt.expr(st.Step, scope)
+		}
+		loop := newLintScope(scope)
+		lt.declare(loop, st.Name, "loop variable", lt.at(lt.name(lt.token(st), st.Name), st))
+		lt.block(st.Stmts, loop)
+		lt.close(loop)
+	case *ast.GenericForStmt:
+		lt.exprs(st.Exprs, scope)
+		loop := newLintScope(scope)
+		i := lt.token(st)
+		for _, name := range st.Names {
+			i = lt.name(i, name)
+			lt.declare(loop, name, "loop variable", lt.at(i, st))
+			if i >= 0 {
+				i++
+			}
+		}
+		lt.block(st.Stmts, loop)
+		lt.close(loop)
+	case *ast.FuncDefStmt:
+		if st.Name.Func != nil {
+			lt.assign(st.Name.Func, scope)
+		} else {
+			lt.expr(st.Name.Receiver, scope)
+		}
+		lt.function(st.Func, scope, st.Name.Func == nil)
+	case *ast.ClassStmt:
+		if st.Base != nil {
+			lt.expr(st.Base, scope)
+		}
+		if st.Local {
+			lt.declare(scope, st.Name, "local class", lt.at(lt.name(lt.token(st), st.Name), st))
+		} else {
+			lt.assignGlobal(st.Name, st)
+		}
+		for _, member := range st.Members {
+			if fn, ok := member.Value.(*ast.FunctionExpr); ok {
+				lt.function(fn, scope, !member.Static)
+			} else {
+				lt.expr(member.Value, scope)
+			}
+		}
+	case *ast.ReturnStmt:
+		lt.exprs(st.Exprs, scope)
+	}
+}
+
+func (lt *linter) localAssign(st *ast.LocalAssignStmt, scope *lintScope) {
+	if st.Pattern != nil {
+		lt.exprs(st.Exprs, scope)
+		for i, field := range st.Pattern {
+			if field.Default != nil {
+				lt.expr(field.Default, scope)
+			}
+			v := lt.declare(scope, field.Name, "local", field)
+			if i < len(st.Attribs) && st.Attribs[i] == "close" {
+				v.Used = true
+			}
+		}
+		return
+	}
+	i := lt.token(st)
+	// 'local function f' sees itself
+	if len(st.Names) == 1 && st.Attribs == nil && len(st.Exprs) == 1 {
+		if fn, ok := st.Exprs[0].(*ast.FunctionExpr); ok && fn.Name == st.Names[0] {
+			lt.declare(scope, fn.Name, "local function", lt.at(lt.name(i, fn.Name), st))
+			lt.function(fn, scope, false)
+			return
+		}
+	}
+	lt.exprs(st.Exprs, scope)
+	for n, name := range st.Names {
+		i = lt.name(i, name)
+		v := lt.declare(scope, name, "local", lt.at(i, st))
+		if i >= 0 {
+			i++
+		}
+		attrib := ""
+		if n < len(st.Attribs) {
+			attrib = st.Attribs[n]
+		}
+		switch attrib {
+		case "close":
+			v.Used = true
+		case "const":
+			v.NaN = n < len(st.Exprs) && lt.isNaN(st.Exprs[n], scope)
+		}
+	}
+}
+
+// assign checks an assignment target.
+func (lt *linter) assign(lhs ast.Expr, scope *lintScope) {
+	switch ex := lhs.(type) {
+	case *ast.IdentExpr:
+		if scope.lookup(ex.Value) == nil {
+			lt.assignGlobal(ex.Value, ex)
+		}
+	case *ast.AttrGetExpr:
+		if obj, ok := ex.Object.(*ast.IdentExpr); ok && scope.lookup(obj.Value) == nil {
+			if key, ok := ex.Key.(*ast.StringExpr); ok {
+				lt.fields[obj.Value+"."+key.Value] = true
+			}
+		}
+		lt.expr(ex.Object, scope)
+		lt.expr(ex.Key, scope)
+	default:
+		lt.expr(lhs, scope)
+	}
+}
+
+// assignGlobal checks an assignment to a global. Functions may assign the
+// globals of the configuration and those that the chunk assigns at the top
+// level, where the first assignment to any other is reported.
+func (lt *linter) assignGlobal(name string, node ast.PositionHolder) {
+	if _, ok := lt.libraries[name]; ok {
+		return
+	}
+	if lt.depth == 0 {
+		if !lt.declared[name] && !lt.reported[name] {
+			lt.reported[name] = true
+			lt.report("toplevel-global", node, "assignment to undeclared global '%v'", name)
+		}
+		return
+	}
+	if !lt.globals[name] {
+		lt.report("global", node, "assignment to undeclared global '%v' in a function", name)
+	}
+}
+
+func (lt *linter) function(fn *ast.FunctionExpr, parent *lintScope, method bool) {
+	lt.depth++
+	scope := newLintScope(parent)
+	if method {
+		scope.vars = append(scope.vars, &lintVar{Name: "self", Kind: "self", Used: true})
+	}
+	i := lt.token(fn)
+	for n, name := range fn.ParList.Names {
+		if n < len(fn.ParList.Defaults) && fn.ParList.Defaults[n] != nil {
+			lt.expr(fn.ParList.Defaults[n], scope)
+		}
+		i = lt.name(i, name)
+		lt.declare(scope, name, "parameter", lt.at(i, fn))
+		if i >= 0 {
+			i++
+		}
+	}
+	lt.block(fn.Stmts, scope)
+	lt.close(scope)
+	lt.depth--
+}
+
+// checkGotos reports the gotos of a block that jump forward past a local
+// declaration to a label of the block. A label at the end of the block is
+// outside the scope of the locals.
+func (lt *linter) checkGotos(stmts []ast.Stmt) {
+	for j, stmt := range stmts {
+		label, ok := stmt.(*ast.LabelStmt)
+		if !ok || onlyLabels(stmts[j+1:]) {
+			continue
+		}
+		local := ""
+		for i := j - 1; i >= 0; i-- {
+			if name := declaredLocal(stmts[i]); name != "" && local == "" {
+				local = name
+			}
+			if local == "" {
+				continue
+			}
+			for _, jump := range gotosTo(stmts[i], label.Name) {
+				lt.report("goto-scope", jump, "goto '%v' jumps into the scope of local '%v'", label.Name, local)
+			}
+		}
+	}
+}
+
+func onlyLabels(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		if _, ok := stmt.(*ast.LabelStmt); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// declaredLocal returns the last local declared by stmt, if any.
+func declaredLocal(stmt ast.Stmt) string {
+	switch st := stmt.(type) {
+	case *ast.LocalAssignStmt:
+		return st.Names[len(st.Names)-1]
+	case *ast.ClassStmt:
+		if st.Local {
+			return st.Name
+		}
+	}
+	return ""
+}
+
+// gotosTo returns the gotos to label in stmt that are not bound to a label
+// of the same name in a nested block.
+func gotosTo(stmt ast.Stmt, label string) []*ast.GotoStmt {
+	inBlock := func(stmts []ast.Stmt) []*ast.GotoStmt {
+		jumps := []*ast.GotoStmt{}
+		for _, st := range stmts {
+			if l, ok := st.(*ast.LabelStmt); ok && l.Name == label {
+				return nil
+			}
+		}
+		for _, st := range stmts {
+			jumps = append(jumps, gotosTo(st, label)...)
+		}
+		return jumps
+	}
+	switch st := stmt.(type) {
+	case *ast.GotoStmt:
+		if st.Label == label {
+			return []*ast.GotoStmt{st}
+		}
+	case *ast.DoBlockStmt:
+		return inBlock(st.Stmts)
+	case *ast.WhileStmt:
+		return inBlock(st.Stmts)
+	case *ast.RepeatStmt:
+		return inBlock(st.Stmts)
+	case *ast.IfStmt:
+		return append(inBlock(st.Then), inBlock(st.Else)...)
+	case *ast.NumberForStmt:
+		return inBlock(st.Stmts)
+	case *ast.GenericForStmt:
+		return inBlock(st.Stmts)
+	case *ast.MatchStmt:
+		jumps := []*ast.GotoStmt{}
+		for _, arm := range st.Arms {
+			jumps = append(jumps, inBlock(arm.Stmts)...)
+		}
+		return jumps
+	}
+	return nil
+}
+
+/* }}} */
+
+/* expressions {{{ */
+
+func (lt *linter) exprs(exprs []ast.Expr, scope *lintScope) {
+	for _, expr := range exprs {
+		lt.expr(expr, scope)
+	}
+}
+
+func (lt *linter) expr(expr ast.Expr, scope *lintScope) {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		if v := scope.lookup(ex.Value); v != nil {
+			v.Used = true
+		}
+	case *ast.AttrGetExpr:
+		lt.member(ex, scope)
+		lt.expr(ex.Object, scope)
+		lt.expr(ex.Key, scope)
+	case *ast.TableExpr:
+		for _, field := range ex.Fields {
+			if field.Key != nil {
+				lt.expr(field.Key, scope)
+			}
+			lt.expr(field.Value, scope)
+		}
+	case *ast.FuncCallExpr:
+		if ex.Func != nil {
+			lt.expr(ex.Func, scope)
+		} else {
+			lt.expr(ex.Receiver, scope)
+		}
+		lt.exprs(ex.Args, scope)
+	case *ast.LogicalOpExpr:
+		lt.expr(ex.Lhs, scope)
+		lt.expr(ex.Rhs, scope)
+	case *ast.NilCoalesceOpExpr:
+		lt.expr(ex.Lhs, scope)
+		lt.expr(ex.Rhs, scope)
+	case *ast.RelationalOpExpr:
+		if ex.Operator == "==" || ex.Operator == "~=" {
+			if lt.isNaN(ex.Lhs, scope) || lt.isNaN(ex.Rhs, scope) {
+				result := "false"
+				if ex.Operator == "~=" {
+					result = "true"
+				}
+				lt.report("nan-compare", ex, "comparison with NaN is always %v, use 'x ~= x' to test for NaN", result)
+			}
+		}
+		lt.expr(ex.Lhs, scope)
+		lt.expr(ex.Rhs, scope)
+	case *ast.StringConcatOpExpr:
+		lt.expr(ex.Lhs, scope)
+		lt.expr(ex.Rhs, scope)
+	case *ast.ArithmeticOpExpr:
+		lt.expr(ex.Lhs, scope)
+		lt.expr(ex.Rhs, scope)
+	case *ast.UnaryMinusOpExpr:
+		lt.expr(ex.Expr, scope)
+	case *ast.UnaryNotOpExpr:
+		lt.expr(ex.Expr, scope)
+	case *ast.UnaryLenOpExpr:
+		lt.expr(ex.Expr, scope)
+	case *ast.UnaryBNotOpExpr:
+		lt.expr(ex.Expr, scope)
+	case *ast.FunctionExpr:
+		lt.function(ex, scope, false)
+	case *ast.InterpolatedStringExpr:
+		lt.exprs(ex.Parts, scope)
+	}
+}
+
+// pattern declares the names a match arm binds. They are not reported when
+// unused, since binding a name is also how a pattern matches anything.
+func (lt *linter) pattern(pattern ast.Expr, scope *lintScope) {
+	switch pt := pattern.(type) {
+	case *ast.IdentExpr:
+		if pt.Value != "_" {
+			lt.declare(scope, pt.Value, "local", pt).Used = true
+		}
+	case *ast.TypePattern:
+		lt.declare(scope, pt.Name, "local", pt).Used = true
+	case *ast.TableExpr:
+		for _, field := range pt.Fields {
+			if field.Key != nil {
+				lt.expr(field.Key, scope)
+			}
+			lt.pattern(field.Value, scope)
+		}
+	}
+}
+
+// member records the fields read from the standard libraries that they do
+// not define. They are reported at the end unless the chunk assigns them.
+func (lt *linter) member(ex *ast.AttrGetExpr, scope *lintScope) {
+	obj, ok := ex.Object.(*ast.IdentExpr)
+	if !ok || scope.lookup(obj.Value) != nil {
+		return
+	}
+	key, ok := ex.Key.(*ast.StringExpr)
+	if !ok {
+		return
+	}
+	fields := lt.libraries[obj.Value]
+	if len(fields) == 0 {
+		return
+	}
+	if i := sort.SearchStrings(fields, key.Value); i < len(fields) && fields[i] == key.Value {
+		return
+	}
+	lt.members = append(lt.members, ex)
+}
+
+// isNaN reports whether expr always evaluates to NaN, like 0/0.
+func (lt *linter) isNaN(expr ast.Expr, scope *lintScope) bool {
+	switch ex := expr.(type) {
+	case *ast.ArithmeticOpExpr:
+		return ex.Operator == "/" && isZero(ex.Lhs) && isZero(ex.Rhs)
+	case *ast.UnaryMinusOpExpr:
+		return lt.isNaN(ex.Expr, scope)
+	case *ast.IdentExpr:
+		v := scope.lookup(ex.Value)
+		return v != nil && v.NaN
+	}
+	return false
+}
+
+func isZero(expr ast.Expr) bool {
+	if ex, ok := expr.(*ast.UnaryMinusOpExpr); ok {
+		return isZero(ex.Expr)
+	}
+	num, ok := expr.(*ast.NumberExpr)
+	if !ok {
+		return false
+	}
+	value, err := strconv.ParseFloat(num.Value, 64)
+	return err == nil && value == 0
+}
+
+// closestName returns the name in names closest to name, if it is a likely
+// typo of it.
+func closestName(name string, names []string) string {
+	limit := len(name) / 3
+	if limit < 1 {
+		limit = 1
+	} else if limit > 2 {
+		limit = 2
+	}
+	best, bestDistance := "", limit+1
+	for _, candidate := range names {
+		if distance := editDistance(strings.ToLower(name), strings.ToLower(candidate)); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+/* }}} */
+
+/* }}} */
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func lintMessages(src string, config *lintConfig) []string {
+	messages := []string{}
+	for _, diag := range lintSource([]byte(src), "test.milk", config) {
+		messages = append(messages, diag.String())
+	}
+	return messages
+}
+
+func TestLint(t *testing.T) {
+	src := `count = 0
+local function inc(step, unused)
+    count = count + step
+    total = count
+end
+local x = 1
+do
+    local x = 2
+    print(x)
+end
+for i, v in ipairs({}) do
+    print(v)
+end
+local function f(a)
+    if a then
+        return 1
+    else
+        error("no")
+    end
+    print("never")
+end
+do
+    goto skip
+    local y = 1
+    print(y)
+    ::skip::
+    print("after")
+end
+print(string.endwith("ab", "b"), string.end_with("ab", "b"), math.foo)
+function string.foo() end
+local NaN <const> = 0/0
+print(x == 0/0, x ~= NaN, x ~= x)
+local _ignored = 1
+local z = 1 -- milk:ignore unused
+-- milk:ignore
+local w = 1
+`
+	expected := []string{
+		"test.milk:1:1: assignment to undeclared global 'count' (toplevel-global)",
+		"test.milk:2:16: unused local function 'inc' (unused)",
+		"test.milk:2:26: unused parameter 'unused' (unused)",
+		"test.milk:4:5: assignment to undeclared global 'total' in a function (global)",
+		"test.milk:8:11: local 'x' shadows local 'x' on line 6 (shadowing)",
+		"test.milk:11:5: unused loop variable 'i' (unused)",
+		"test.milk:14:16: unused local function 'f' (unused)",
+		"test.milk:20:5: unreachable code (unreachable)",
+		"test.milk:23:5: goto 'skip' jumps into the scope of local 'y' (goto-scope)",
+		"test.milk:24:5: unreachable code (unreachable)",
+		"test.milk:29:7: unknown library member 'string.endwith' (did you mean 'end_with'?) (unknown-member)",
+		"test.milk:29:62: unknown library member 'math.foo' (unknown-member)",
+		"test.milk:32:7: comparison with NaN is always false, use 'x ~= x' to test for NaN (nan-compare)",
+		"test.milk:32:17: comparison with NaN is always true, use 'x ~= x' to test for NaN (nan-compare)",
+	}
+	messages := lintMessages(src, &lintConfig{})
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
+
+func TestLintToplevelGlobals(t *testing.T) {
+	src := `app = {}
+count = 0
+count = count + 1
+function helper() end
+if app then
+    config = {}
+end
+string.helper = helper
+`
+	expected := []string{
+		"test.milk:2:1: assignment to undeclared global 'count' (toplevel-global)",
+		"test.milk:4:10: assignment to undeclared global 'helper' (toplevel-global)",
+		"test.milk:6:5: assignment to undeclared global 'config' (toplevel-global)",
+	}
+	messages := lintMessages(src, &lintConfig{Globals: []string{"app"}})
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+	messages = lintMessages(src, &lintConfig{Rules: map[string]bool{"toplevel-global": false}})
+	if len(messages) > 0 {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
+
+func TestLintNamePositions(t *testing.T) {
+	src := `local a, b = 1
+print(a)
+for k = 1, 2 do end
+local function f(x,
+                 y)
+    return x
+end
+f()
+`
+	expected := []string{
+		"test.milk:1:10: unused local 'b' (unused)",
+		"test.milk:3:5: unused loop variable 'k' (unused)",
+		"test.milk:5:18: unused parameter 'y' (unused)",
+	}
+	messages := lintMessages(src, &lintConfig{})
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
+
+func TestLintShebang(t *testing.T) {
+	messages := lintMessages("#!/usr/bin/env milk\nlocal x = 1\n", &lintConfig{})
+	expected := []string{"test.milk:2:7: unused local 'x' (unused)"}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	dir := t.TempDir()
+	config := "globals = [\"total\"]\n[rules]\nunused = false\n"
+	if err := os.WriteFile(filepath.Join(dir, lintConfigName), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := findLintConfig(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := `local function set(unused)
+    total = 1
+    other = 2
+end
+`
+	expected := "test.milk:3:5: assignment to undeclared global 'other' in a function (global)"
+	if messages := lintMessages(src, cfg); strings.Join(messages, "\n") != expected {
+		t.Errorf("unexpected diagnostics:\n%v", strings.Join(messages, "\n"))
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, lintConfigName), []byte("[rules]\nunknown = true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findLintConfig(sub); err == nil || !strings.Contains(err.Error(), "unknown rule 'unknown'") {
+		t.Errorf("expected an unknown rule error, got %v", err)
+	}
+}
@@ -0,0 +1,1107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	lua "github.com/zmsvDreamLang/Milk"
+	"github.com/zmsvDreamLang/Milk/ast"
+	"github.com/zmsvDreamLang/Milk/parse"
+)
+
+/* milk lsp: language server {{{ */
+
+func lspMain(args []string) int {
+	fs := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Println(`Usage: milk lsp
+Runs a Language Server Protocol server on stdin and stdout for editors. It
+reports syntax errors and provides go to definition and find references for
+locals, upvalues and module fields, hover docs for the builtin libraries and
+completion.`)
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	server := newLspServer(os.Stdin, os.Stdout)
+	if err := server.serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	// an exit without a shutdown request is an error
+	if !server.shutdown {
+		return 1
+	}
+	return 0
+}
+
+/* protocol {{{ */
+
+const (
+	lspParseError     = -32700
+	lspInvalidRequest = -32600
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+)
+
+// completion item kinds
+const (
+	lspFunctionItem = 3
+	lspFieldItem    = 5
+	lspVariableItem = 6
+	lspClassItem    = 7
+	lspModuleItem   = 9
+	lspKeywordItem  = 14
+)
+
+type lspRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// lspPosition counts lines and UTF-16 code units from 0.
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+// lspParams holds the parameters of all the textDocument methods.
+type lspParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	Position       lspPosition `json:"position"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Context struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type lspCompletionItem struct {
+	Label         string `json:"label"`
+	Kind          int    `json:"kind"`
+	Detail        string `json:"detail,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// readLspMessage reads the body of a message framed by a Content-Length
+// header.
+func readLspMessage(reader *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("lsp: invalid Content-Length '%v'", strings.TrimSpace(value))
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("lsp: missing Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+/* }}} */
+
+/* server {{{ */
+
+type lspServer struct {
+	reader    *bufio.Reader
+	writer    io.Writer
+	docs      map[string]*lspDocument
+	libraries map[string][]string
+	types     map[string]string // the types of the library globals and fields, like "string.format"
+	shutdown  bool
+}
+
+// lspDocument is an open script. Its index is the one of the last version
+// the parser could recover from, so that completion keeps working while a
+// line is typed.
+type lspDocument struct {
+	lines []string
+	index *lspIndex
+}
+
+func newLspServer(reader io.Reader, writer io.Writer) *lspServer {
+	s := &lspServer{
+		reader:    bufio.NewReader(reader),
+		writer:    writer,
+		docs:      map[string]*lspDocument{},
+		libraries: lua.Libraries(),
+		types:     map[string]string{},
+	}
+	ls := lua.NewState()
+	defer ls.Close()
+	for name, fields := range s.libraries {
+		value := ls.GetGlobal(name)
+		s.types[name] = value.Type().String()
+		for _, field := range fields {
+			s.types[name+"."+field] = ls.GetField(value, field).Type().String()
+		}
+	}
+	return s
+}
+
+// serve handles messages until the exit notification or the end of the
+// input.
+func (s *lspServer) serve() error {
+	for {
+		body, err := readLspMessage(s.reader)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var req lspRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.send(map[string]interface{}{"id": nil, "error": &lspError{lspParseError, err.Error()}}); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+		if req.Method == "" {
+			// a response to a request of the server
+			continue
+		}
+		result, rerr := s.handle(req.Method, req.Params)
+		if len(req.ID) == 0 || string(req.ID) == "null" {
+			continue
+		}
+		response := map[string]interface{}{"id": req.ID}
+		if rerr != nil {
+			response["error"] = rerr
+		} else {
+			response["result"] = result
+		}
+		if err := s.send(response); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *lspServer) send(message map[string]interface{}) error {
+	message["jsonrpc"] = "2.0"
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.writer, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = s.writer.Write(body)
+	return err
+}
+
+func (s *lspServer) notify(method string, params interface{}) error {
+	return s.send(map[string]interface{}{"method": method, "params": params})
+}
+
+// handle runs a request or a notification. The result of a notification is
+// thrown away.
+func (s *lspServer) handle(method string, raw json.RawMessage) (interface{}, *lspError) {
+	if s.shutdown {
+		return nil, &lspError{lspInvalidRequest, "the server is shut down"}
+	}
+	var params lspParams
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+	}
+	uri := params.TextDocument.URI
+	switch method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // full
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{".", ":"}},
+			},
+			"serverInfo": map[string]string{"name": "milk", "version": lua.PackageVersion},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		s.update(uri, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.update(uri, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		s.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": []interface{}{}})
+		return nil, nil
+	case "textDocument/definition":
+		return s.definition(uri, params.Position), nil
+	case "textDocument/references":
+		return s.references(uri, params.Position, params.Context.IncludeDeclaration), nil
+	case "textDocument/hover":
+		return s.hover(uri, params.Position), nil
+	case "textDocument/completion":
+		return s.completion(uri, params.Position), nil
+	}
+	return nil, &lspError{lspMethodNotFound, "method not found: " + method}
+}
+
+// update parses a new version of a document and publishes its syntax
+// errors.
+func (s *lspServer) update(uri, text string) {
+	doc := s.docs[uri]
+	if doc == nil {
+		doc = &lspDocument{}
+		s.docs[uri] = doc
+	}
+	doc.lines = strings.Split(text, "\n")
+	for i, line := range doc.lines {
+		doc.lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	diagnostics := []interface{}{}
+	chunk, tokens, err := parse.ParseRecover(bytes.NewReader(blankShebang([]byte(text))), uri)
+	// reading a string cannot fail, the errors are syntax errors
+	errs, _ := err.(parse.ErrorList)
+	for _, perr := range errs {
+		line, column := perr.Pos.Line, perr.Pos.Column
+		if line == parse.EOF || line < 1 {
+			line = len(doc.lines)
+			column = len(doc.lines[line-1]) + 1
+		}
+		diagnostics = append(diagnostics, map[string]interface{}{
+			"range":    doc.rangeOf(line, column, len(perr.Token)),
+			"severity": 1, // error
+			"source":   "milk",
+			"message":  strings.TrimSpace(perr.Message),
+		})
+	}
+	if chunk != nil {
+		doc.index = newLspIndex(chunk, tokens)
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": diagnostics})
+}
+
+// occurrence returns the document and the occurrence of a name at pos.
+func (s *lspServer) occurrence(uri string, pos lspPosition) (*lspDocument, *lspOccurrence) {
+	doc := s.docs[uri]
+	if doc == nil || doc.index == nil {
+		return doc, nil
+	}
+	line, column := doc.position(pos)
+	return doc, doc.index.at(line, column)
+}
+
+func (s *lspServer) definition(uri string, pos lspPosition) interface{} {
+	doc, occ := s.occurrence(uri, pos)
+	if occ == nil || occ.Symbol.Decl == nil {
+		return nil
+	}
+	decl := occ.Symbol.Decl
+	return lspLocation{uri, doc.rangeOf(decl.Line, decl.Column, len(occ.Symbol.Name))}
+}
+
+func (s *lspServer) references(uri string, pos lspPosition, includeDeclaration bool) interface{} {
+	doc, occ := s.occurrence(uri, pos)
+	if occ == nil {
+		return nil
+	}
+	locations := []lspLocation{}
+	for _, ref := range doc.index.occurrences {
+		if ref.Symbol != occ.Symbol || (ref == occ.Symbol.Decl && !includeDeclaration) {
+			continue
+		}
+		locations = append(locations, lspLocation{uri, doc.rangeOf(ref.Line, ref.Column, len(ref.Symbol.Name))})
+	}
+	return locations
+}
+
+func (s *lspServer) hover(uri string, pos lspPosition) interface{} {
+	doc, occ := s.occurrence(uri, pos)
+	if occ == nil {
+		return nil
+	}
+	sym := occ.Symbol
+	var text string
+	if name := s.builtin(sym); name != "" {
+		text = s.builtinDoc(name)
+	} else {
+		text = "```lua\n(" + sym.Kind + ") " + sym.qualifiedName() + "\n```"
+		if sym.Decl != nil {
+			text += fmt.Sprintf("\nDefined on line %v.", sym.Decl.Line)
+		}
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "markdown", "value": text},
+		"range":    doc.rangeOf(occ.Line, occ.Column, len(sym.Name)),
+	}
+}
+
+// builtin returns the name of the library global or field, like
+// "string.format", a symbol stands for. It returns an empty string if the
+// chunk defines the symbol itself.
+func (s *lspServer) builtin(sym *lspSymbol) string {
+	switch {
+	case sym.Kind == "global" && sym.Decl == nil:
+		if _, ok := s.libraries[sym.Name]; ok {
+			return sym.Name
+		}
+	case sym.Kind == "field" && sym.Decl == nil && sym.Owner.Kind == "global" && sym.Owner.Decl == nil:
+		name := sym.Owner.Name + "." + sym.Name
+		if _, ok := s.types[name]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// builtinDoc documents a library global or field in markdown.
+func (s *lspServer) builtinDoc(name string) string {
+	if doc, ok := lspDocs[name]; ok {
+		signature, text, _ := strings.Cut(doc, "\n")
+		return "```lua\n" + signature + "\n```\n" + text
+	}
+	if fields := s.libraries[name]; len(fields) > 0 {
+		return "```lua\n(library) " + name + "\n```\nFields: " + strings.Join(fields, ", ")
+	}
+	return "```lua\n(builtin " + s.types[name] + ") " + name + "\n```"
+}
+
+// lspMemberPattern matches the text before the cursor after a field or
+// method access like 'string.fo' or 'a.b:'.
+var lspMemberPattern = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*(?:\s*\.\s*[A-Za-z_][A-Za-z0-9_]*)*)\s*[.:]\s*[A-Za-z0-9_]*$`)
+
+var lspKeywords = []string{
+	"and", "break", "case", "class", "continue", "do", "else", "elseif", "end", "extends", "false",
+	"for", "function", "goto", "if", "in", "local", "match", "nil", "not", "or", "repeat", "return",
+	"static", "super", "then", "true", "until", "while", "with",
+}
+
+func (s *lspServer) completion(uri string, pos lspPosition) interface{} {
+	doc := s.docs[uri]
+	if doc == nil {
+		return []lspCompletionItem{}
+	}
+	line, column := doc.position(pos)
+	prefix := ""
+	if line >= 1 && line <= len(doc.lines) {
+		prefix = doc.lines[line-1][:column-1]
+	}
+	if m := lspMemberPattern.FindStringSubmatch(prefix); m != nil {
+		path := strings.Split(m[1], ".")
+		for i := range path {
+			path[i] = strings.TrimSpace(path[i])
+		}
+		return s.memberItems(doc, path, line, column)
+	}
+	return s.nameItems(doc, line, column)
+}
+
+// memberItems completes the fields of the table the path of names refers
+// to: the fields the chunk defines and those of a library.
+func (s *lspServer) memberItems(doc *lspDocument, path []string, line, column int) []lspCompletionItem {
+	items := []lspCompletionItem{}
+	seen := map[string]bool{}
+	var sym *lspSymbol
+	if doc.index != nil {
+		sym = doc.index.resolve(path[0], line, column)
+		for _, name := range path[1:] {
+			if sym == nil {
+				break
+			}
+			sym = sym.Fields[name]
+		}
+	}
+	if sym != nil {
+		for _, name := range sortedKeys(sym.Fields) {
+			seen[name] = true
+			items = append(items, lspCompletionItem{Label: name, Kind: lspFieldItem, Detail: "(field) " + sym.Fields[name].qualifiedName()})
+		}
+	}
+	if len(path) == 1 && (sym == nil || s.builtin(sym) != "") {
+		for _, field := range s.libraries[path[0]] {
+			if !seen[field] && !strings.HasPrefix(field, "__") {
+				items = append(items, s.builtinItem(path[0]+"."+field, field))
+			}
+		}
+	}
+	return items
+}
+
+// nameItems completes the keywords, the locals in scope and the globals.
+func (s *lspServer) nameItems(doc *lspDocument, line, column int) []lspCompletionItem {
+	items := []lspCompletionItem{}
+	seen := map[string]bool{}
+	for _, keyword := range lspKeywords {
+		seen[keyword] = true
+		items = append(items, lspCompletionItem{Label: keyword, Kind: lspKeywordItem})
+	}
+	if doc.index != nil {
+		for _, sym := range doc.index.visible(line, column) {
+			if seen[sym.Name] {
+				continue
+			}
+			seen[sym.Name] = true
+			kind := lspVariableItem
+			switch sym.Kind {
+			case "local function":
+				kind = lspFunctionItem
+			case "local class":
+				kind = lspClassItem
+			}
+			items = append(items, lspCompletionItem{Label: sym.Name, Kind: kind, Detail: "(" + sym.Kind + ") " + sym.Name})
+		}
+		for _, name := range sortedKeys(doc.index.globals) {
+			if sym := doc.index.globals[name]; sym.Decl != nil && !seen[name] {
+				seen[name] = true
+				items = append(items, lspCompletionItem{Label: name, Kind: lspVariableItem, Detail: "(global) " + name})
+			}
+		}
+	}
+	for _, name := range sortedKeys(s.libraries) {
+		if !seen[name] && !strings.HasPrefix(name, "_") {
+			items = append(items, s.builtinItem(name, name))
+		}
+	}
+	return items
+}
+
+func (s *lspServer) builtinItem(name, label string) lspCompletionItem {
+	item := lspCompletionItem{Label: label, Kind: lspFieldItem, Detail: "(builtin " + s.types[name] + ") " + name}
+	switch {
+	case s.types[name] == "function":
+		item.Kind = lspFunctionItem
+	case len(s.libraries[name]) > 0:
+		item.Kind = lspModuleItem
+		item.Detail = "(library) " + name
+	}
+	if doc, ok := lspDocs[name]; ok {
+		signature, text, _ := strings.Cut(doc, "\n")
+		item.Detail, item.Documentation = signature, text
+	}
+	return item
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// position converts an LSP position to a line and a byte column counted
+// from 1 as in the AST.
+func (doc *lspDocument) position(pos lspPosition) (int, int) {
+	if pos.Line < 0 || pos.Line >= len(doc.lines) {
+		return pos.Line + 1, pos.Character + 1
+	}
+	units := 0
+	for i, r := range doc.lines[pos.Line] {
+		if units >= pos.Character {
+			return pos.Line + 1, i + 1
+		}
+		units += utf16Len(r)
+	}
+	return pos.Line + 1, len(doc.lines[pos.Line]) + 1
+}
+
+// lspPosition converts a line and a byte column counted from 1 to an LSP
+// position.
+func (doc *lspDocument) lspPosition(line, column int) lspPosition {
+	pos := lspPosition{Line: line - 1}
+	if line < 1 || line > len(doc.lines) {
+		return pos
+	}
+	text := doc.lines[line-1]
+	if column-1 < len(text) {
+		text = text[:column-1]
+	}
+	for _, r := range text {
+		pos.Character += utf16Len(r)
+	}
+	return pos
+}
+
+// rangeOf returns the range of length bytes from a line and a byte column.
+func (doc *lspDocument) rangeOf(line, column, length int) lspRange {
+	return lspRange{doc.lspPosition(line, column), doc.lspPosition(line, column+length)}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+/* }}} */
+
+/* symbols {{{ */
+
+// lspSymbol is a local, a global or a field of one of them that names in
+// the chunk refer to. Fields are known by the table they are read from, so
+// that 'M.f' is the same symbol wherever the local or global M is in scope.
+type lspSymbol struct {
+	Name   string
+	Kind   string     // local, parameter, loop variable, local function, local class, self, global or field
+	Owner  *lspSymbol // the symbol of the table holding a field
+	Decl   *lspOccurrence
+	Fields map[string]*lspSymbol
+}
+
+func (sym *lspSymbol) qualifiedName() string {
+	if sym.Owner != nil {
+		return sym.Owner.qualifiedName() + "." + sym.Name
+	}
+	return sym.Name
+}
+
+// lspOccurrence is a name in the source, at a byte column counted from 1.
+type lspOccurrence struct {
+	Line   int
+	Column int
+	Symbol *lspSymbol
+}
+
+type lspScope struct {
+	parent  *lspScope
+	symbols []*lspSymbol
+	depth   int
+	first   int // the lines of the scope
+	last    int
+}
+
+// lspIndex resolves the names of a chunk. The AST does not keep the
+// positions of the names declared by local statements, parameters and
+// loops, so they are found in the tokens from the position of the node.
+type lspIndex struct {
+	tokens      []ast.Token
+	tokenIndex  map[ast.Position]int
+	occurrences []*lspOccurrence
+	globals     map[string]*lspSymbol
+	scopes      []*lspScope
+}
+
+func newLspIndex(chunk []ast.Stmt, tokens []ast.Token) *lspIndex {
+	idx := &lspIndex{
+		tokens:     tokens,
+		tokenIndex: map[ast.Position]int{},
+		globals:    map[string]*lspSymbol{},
+	}
+	for i, tok := range tokens {
+		idx.tokenIndex[ast.Position{Line: tok.Pos.Line, Column: tok.Pos.Column}] = i
+	}
+	idx.block(chunk, nil, 1, math.MaxInt32)
+	sort.SliceStable(idx.occurrences, func(i, j int) bool {
+		oi, oj := idx.occurrences[i], idx.occurrences[j]
+		if oi.Line != oj.Line {
+			return oi.Line < oj.Line
+		}
+		return oi.Column < oj.Column
+	})
+	return idx
+}
+
+// at returns the occurrence of a name under the cursor, or just before it.
+func (idx *lspIndex) at(line, column int) *lspOccurrence {
+	var before *lspOccurrence
+	for _, occ := range idx.occurrences {
+		if occ.Line != line || column < occ.Column {
+			continue
+		}
+		if end := occ.Column + len(occ.Symbol.Name); column < end {
+			return occ
+		} else if column == end {
+			before = occ
+		}
+	}
+	return before
+}
+
+// visible returns the locals in scope at a position, the innermost first.
+func (idx *lspIndex) visible(line, column int) []*lspSymbol {
+	var inner *lspScope
+	for _, sc := range idx.scopes {
+		if sc.first <= line && line <= sc.last && (inner == nil || sc.depth >= inner.depth) {
+			inner = sc
+		}
+	}
+	symbols := []*lspSymbol{}
+	for sc := inner; sc != nil; sc = sc.parent {
+		for i := len(sc.symbols) - 1; i >= 0; i-- {
+			sym := sc.symbols[i]
+			if decl := sym.Decl; decl == nil || decl.Line < line || decl.Line == line && decl.Column+len(sym.Name) <= column {
+				symbols = append(symbols, sym)
+			}
+		}
+	}
+	return symbols
+}
+
+// resolve returns the symbol a name refers to at a position.
+func (idx *lspIndex) resolve(name string, line, column int) *lspSymbol {
+	for _, sym := range idx.visible(line, column) {
+		if sym.Name == name {
+			return sym
+		}
+	}
+	return idx.globals[name]
+}
+
+func (idx *lspIndex) newScope(parent *lspScope, first, last int) *lspScope {
+	sc := &lspScope{parent: parent, first: first, last: last}
+	if parent != nil {
+		sc.depth = parent.depth + 1
+	}
+	idx.scopes = append(idx.scopes, sc)
+	return sc
+}
+
+func (idx *lspIndex) lookup(scope *lspScope, name string) *lspSymbol {
+	for sc := scope; sc != nil; sc = sc.parent {
+		for i := len(sc.symbols) - 1; i >= 0; i-- {
+			if sc.symbols[i].Name == name {
+				return sc.symbols[i]
+			}
+		}
+	}
+	return idx.global(name)
+}
+
+func (idx *lspIndex) global(name string) *lspSymbol {
+	sym := idx.globals[name]
+	if sym == nil {
+		sym = &lspSymbol{Name: name, Kind: "global"}
+		idx.globals[name] = sym
+	}
+	return sym
+}
+
+func (idx *lspIndex) field(owner *lspSymbol, name string) *lspSymbol {
+	if owner.Fields == nil {
+		owner.Fields = map[string]*lspSymbol{}
+	}
+	sym := owner.Fields[name]
+	if sym == nil {
+		sym = &lspSymbol{Name: name, Kind: "field", Owner: owner}
+		owner.Fields[name] = sym
+	}
+	return sym
+}
+
+// token returns the index of the token at the position of a node, or -1.
+func (idx *lspIndex) token(node ast.PositionHolder) int {
+	if i, ok := idx.tokenIndex[ast.Position{Line: node.Line(), Column: node.Column()}]; ok {
+		return i
+	}
+	return -1
+}
+
+// name returns the index of the first identifier token named name from the
+// token at index from on, or -1.
+func (idx *lspIndex) name(from int, name string) int {
+	if from < 0 {
+		return -1
+	}
+	for i := from; i < len(idx.tokens); i++ {
+		if idx.tokens[i].Type == parse.TIdent && idx.tokens[i].Str == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (idx *lspIndex) after(i int) int {
+	if i < 0 {
+		return -1
+	}
+	return i + 1
+}
+
+// occur records the token at index i as an occurrence of sym.
+func (idx *lspIndex) occur(sym *lspSymbol, i int) *lspOccurrence {
+	if sym == nil || i < 0 {
+		return nil
+	}
+	occ := &lspOccurrence{Line: idx.tokens[i].Pos.Line, Column: idx.tokens[i].Pos.Column, Symbol: sym}
+	idx.occurrences = append(idx.occurrences, occ)
+	return occ
+}
+
+// define records an assignment to a global or a field. The first one is
+// its definition.
+func (idx *lspIndex) define(sym *lspSymbol, i int) {
+	if occ := idx.occur(sym, i); occ != nil && sym.Decl == nil {
+		sym.Decl = occ
+	}
+}
+
+func (idx *lspIndex) declare(scope *lspScope, name, kind string, i int) *lspSymbol {
+	sym := &lspSymbol{Name: name, Kind: kind}
+	scope.symbols = append(scope.symbols, sym)
+	sym.Decl = idx.occur(sym, i)
+	return sym
+}
+
+/* }}} */
+
+/* resolver {{{ */
+
+func (idx *lspIndex) block(stmts []ast.Stmt, parent *lspScope, first, last int) {
+	scope := idx.newScope(parent, first, last)
+	for _, stmt := range stmts {
+		idx.stmt(stmt, scope)
+	}
+}
+
+func (idx *lspIndex) stmt(stmt ast.Stmt, scope *lspScope) {
+	// an if statement of an elseif has no last line
+	last := stmt.LastLine()
+	if last < stmt.Line() {
+		last = scope.last
+	}
+	switch st := stmt.(type) {
+	case *ast.AssignStmt:
+		idx.exprs(st.Rhs, scope)
+		for i, lhs := range st.Lhs {
+			if sym := idx.assign(lhs, scope); sym != nil && i < len(st.Rhs) {
+				idx.constructor(sym, st.Rhs[i])
+			}
+		}
+	case *ast.CompoundAssignStmt:
+		idx.expr(st.Lhs, scope)
+		idx.expr(st.Rhs, scope)
+	case *ast.LocalAssignStmt:
+		idx.localAssign(st, scope)
+	case *ast.FuncCallStmt:
+		idx.expr(st.Expr, scope)
+	case *ast.DoBlockStmt:
+		idx.block(st.Stmts, scope, st.Line(), last)
+	case *ast.WhileStmt:
+		idx.expr(st.Condition, scope)
+		idx.block(st.Stmts, scope, st.Line(), last)
+	case *ast.RepeatStmt:
+		// the condition sees the locals of the body
+		body := idx.newScope(scope, st.Line(), last)
+		for _, stmt := range st.Stmts {
+			idx.stmt(stmt, body)
+		}
+		idx.expr(st.Condition, body)
+	case *ast.MatchStmt:
+		idx.expr(st.Value, scope)
+		for _, arm := range st.Arms {
+			armLast := arm.LastLine()
+			if armLast < arm.Line() {
+				armLast = last
+			}
+			armScope := idx.newScope(scope, arm.Line(), armLast)
+			idx.pattern(arm.Pattern, armScope)
+			if arm.Guard != nil {
+				idx.expr(arm.Guard, armScope)
+			}
+			idx.block(arm.Stmts, armScope, arm.Line(), armLast)
+		}
+	case *ast.IfStmt:
+		idx.expr(st.Condition, scope)
+		idx.block(st.Then, scope, st.Line(), last)
+		idx.block(st.Else, scope, st.Line(), last)
+	case *ast.NumberForStmt:
+		idx.expr(st.Init, scope)
+		idx.expr(st.Limit, scope)
+		if st.Step != nil {
+			idx.expr(st.Step, scope)
+		}
+		loop := idx.newScope(scope, st.Line(), last)
+		idx.declare(loop, st.Name, "loop variable", idx.name(idx.token(st), st.Name))
+		idx.block(st.Stmts, loop, st.Line(), last)
+	case *ast.GenericForStmt:
+		idx.exprs(st.Exprs, scope)
+		loop := idx.newScope(scope, st.Line(), last)
+		i := idx.token(st)
+		for _, name := range st.Names {
+			i = idx.name(i, name)
+			idx.declare(loop, name, "loop variable", i)
+			i = idx.after(i)
+		}
+		idx.block(st.Stmts, loop, st.Line(), last)
+	case *ast.FuncDefStmt:
+		if st.Name.Func != nil {
+			idx.assign(st.Name.Func, scope)
+		} else if owner := idx.expr(st.Name.Receiver, scope); owner != nil {
+			idx.define(idx.field(owner, st.Name.Method), idx.name(idx.after(idx.token(st.Name.Receiver)), st.Name.Method))
+		}
+		idx.function(st.Func, scope, st.Name.Func == nil)
+	case *ast.ClassStmt:
+		if st.Base != nil {
+			idx.expr(st.Base, scope)
+		}
+		var class *lspSymbol
+		if st.Local {
+			class = idx.declare(scope, st.Name, "local class", idx.name(idx.token(st), st.Name))
+		} else {
+			class = idx.global(st.Name)
+			idx.define(class, idx.name(idx.token(st), st.Name))
+		}
+		for _, member := range st.Members {
+			idx.define(idx.field(class, member.Name), idx.name(idx.token(member), member.Name))
+			if fn, ok := member.Value.(*ast.FunctionExpr); ok {
+				idx.function(fn, scope, !member.Static)
+			} else {
+				idx.expr(member.Value, scope)
+			}
+		}
+	case *ast.ReturnStmt:
+		idx.exprs(st.Exprs, scope)
+	}
+}
+
+func (idx *lspIndex) localAssign(st *ast.LocalAssignStmt, scope *lspScope) {
+	if st.Pattern != nil {
+		idx.exprs(st.Exprs, scope)
+		for _, field := range st.Pattern {
+			if field.Default != nil {
+				idx.expr(field.Default, scope)
+			}
+			idx.declare(scope, field.Name, "local", idx.name(idx.token(field), field.Name))
+		}
+		return
+	}
+	i := idx.token(st)
+	// 'local function f' sees itself
+	if len(st.Names) == 1 && st.Attribs == nil && len(st.Exprs) == 1 {
+		if fn, ok := st.Exprs[0].(*ast.FunctionExpr); ok && fn.Name == st.Names[0] {
+			idx.declare(scope, fn.Name, "local function", idx.name(i, fn.Name))
+			idx.function(fn, scope, false)
+			return
+		}
+	}
+	idx.exprs(st.Exprs, scope)
+	for n, name := range st.Names {
+		i = idx.name(i, name)
+		sym := idx.declare(scope, name, "local", i)
+		i = idx.after(i)
+		if n < len(st.Exprs) {
+			idx.constructor(sym, st.Exprs[n])
+		}
+	}
+}
+
+// assign resolves an assignment target and returns its symbol, if it has
+// one.
+func (idx *lspIndex) assign(lhs ast.Expr, scope *lspScope) *lspSymbol {
+	switch ex := lhs.(type) {
+	case *ast.IdentExpr:
+		sym := idx.lookup(scope, ex.Value)
+		if sym.Kind == "global" {
+			idx.define(sym, idx.token(ex))
+		} else {
+			idx.occur(sym, idx.token(ex))
+		}
+		return sym
+	case *ast.AttrGetExpr:
+		return idx.member(ex, scope, true)
+	}
+	idx.expr(lhs, scope)
+	return nil
+}
+
+// constructor defines the fields of a table constructor assigned to sym.
+func (idx *lspIndex) constructor(sym *lspSymbol, value ast.Expr) {
+	table, ok := value.(*ast.TableExpr)
+	if !ok {
+		return
+	}
+	for _, field := range table.Fields {
+		key, ok := field.Key.(*ast.StringExpr)
+		if !ok {
+			continue
+		}
+		if i := idx.token(key); i >= 0 && idx.tokens[i].Type == parse.TIdent {
+			member := idx.field(sym, key.Value)
+			idx.define(member, i)
+			idx.constructor(member, field.Value)
+		}
+	}
+}
+
+func (idx *lspIndex) function(fn *ast.FunctionExpr, parent *lspScope, method bool) {
+	scope := idx.newScope(parent, fn.Line(), fn.LastLine())
+	if method {
+		scope.symbols = append(scope.symbols, &lspSymbol{Name: "self", Kind: "self"})
+	}
+	i := idx.token(fn)
+	for n, name := range fn.ParList.Names {
+		if n < len(fn.ParList.Defaults) && fn.ParList.Defaults[n] != nil {
+			idx.expr(fn.ParList.Defaults[n], scope)
+		}
+		i = idx.name(i, name)
+		idx.declare(scope, name, "parameter", i)
+		i = idx.after(i)
+	}
+	for _, stmt := range fn.Stmts {
+		idx.stmt(stmt, scope)
+	}
+}
+
+func (idx *lspIndex) exprs(exprs []ast.Expr, scope *lspScope) {
+	for _, expr := range exprs {
+		idx.expr(expr, scope)
+	}
+}
+
+// expr resolves the names of an expression and returns the symbol of the
+// expression itself, if it is a name or a field of one.
+func (idx *lspIndex) expr(expr ast.Expr, scope *lspScope) *lspSymbol {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		sym := idx.lookup(scope, ex.Value)
+		idx.occur(sym, idx.token(ex))
+		return sym
+	case *ast.AttrGetExpr:
+		return idx.member(ex, scope, false)
+	case *ast.TableExpr:
+		for _, field := range ex.Fields {
+			if field.Key != nil {
+				idx.expr(field.Key, scope)
+			}
+			idx.expr(field.Value, scope)
+		}
+	case *ast.FuncCallExpr:
+		if ex.Func != nil {
+			idx.expr(ex.Func, scope)
+		} else if owner := idx.expr(ex.Receiver, scope); owner != nil {
+			idx.occur(idx.field(owner, ex.Method), idx.name(idx.after(idx.token(ex.Receiver)), ex.Method))
+		}
+		idx.exprs(ex.Args, scope)
+	case *ast.LogicalOpExpr:
+		idx.expr(ex.Lhs, scope)
+		idx.expr(ex.Rhs, scope)
+	case *ast.NilCoalesceOpExpr:
+		idx.expr(ex.Lhs, scope)
+		idx.expr(ex.Rhs, scope)
+	case *ast.RelationalOpExpr:
+		idx.expr(ex.Lhs, scope)
+		idx.expr(ex.Rhs, scope)
+	case *ast.StringConcatOpExpr:
+		idx.expr(ex.Lhs, scope)
+		idx.expr(ex.Rhs, scope)
+	case *ast.ArithmeticOpExpr:
+		idx.expr(ex.Lhs, scope)
+		idx.expr(ex.Rhs, scope)
+	case *ast.UnaryMinusOpExpr:
+		idx.expr(ex.Expr, scope)
+	case *ast.UnaryNotOpExpr:
+		idx.expr(ex.Expr, scope)
+	case *ast.UnaryLenOpExpr:
+		idx.expr(ex.Expr, scope)
+	case *ast.UnaryBNotOpExpr:
+		idx.expr(ex.Expr, scope)
+	case *ast.FunctionExpr:
+		idx.function(ex, scope, false)
+	case *ast.InterpolatedStringExpr:
+		idx.exprs(ex.Parts, scope)
+	}
+	return nil
+}
+
+// member resolves a field access. Only the fields written 'obj.name' of a
+// name or a field of one have a symbol.
+func (idx *lspIndex) member(ex *ast.AttrGetExpr, scope *lspScope, assign bool) *lspSymbol {
+	owner := idx.expr(ex.Object, scope)
+	key, ok := ex.Key.(*ast.StringExpr)
+	if !ok {
+		idx.expr(ex.Key, scope)
+		return nil
+	}
+	i := idx.token(key)
+	if owner == nil || i < 0 || idx.tokens[i].Type != parse.TIdent {
+		return nil
+	}
+	sym := idx.field(owner, key.Value)
+	if assign {
+		idx.define(sym, i)
+	} else {
+		idx.occur(sym, i)
+	}
+	return sym
+}
+
+// pattern declares the names a match arm binds.
+func (idx *lspIndex) pattern(pattern ast.Expr, scope *lspScope) {
+	switch pt := pattern.(type) {
+	case *ast.IdentExpr:
+		if pt.Value != "_" {
+			idx.declare(scope, pt.Value, "local", idx.token(pt))
+		}
+	case *ast.TypePattern:
+		idx.declare(scope, pt.Name, "local", idx.token(pt))
+	case *ast.TableExpr:
+		for _, field := range pt.Fields {
+			if field.Key != nil {
+				idx.expr(field.Key, scope)
+			}
+			idx.pattern(field.Value, scope)
+		}
+	}
+}
+
+/* }}} */
+
+/* }}} */
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// lspSession runs the server on the requests and returns the responses by
+// id and the notifications in order.
+func lspSession(t *testing.T, requests ...map[string]interface{}) (map[int]json.RawMessage, []map[string]interface{}) {
+	t.Helper()
+	var input bytes.Buffer
+	for _, request := range requests {
+		request["jsonrpc"] = "2.0"
+		body, err := json.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&input, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	var output bytes.Buffer
+	if err := newLspServer(&input, &output).serve(); err != nil {
+		t.Fatal(err)
+	}
+	responses := map[int]json.RawMessage{}
+	notifications := []map[string]interface{}{}
+	reader := bufio.NewReader(&output)
+	for reader.Buffered() > 0 || output.Len() > 0 {
+		body, err := readLspMessage(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var message struct {
+			ID     *int                   `json:"id"`
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+			Result json.RawMessage        `json:"result"`
+			Error  *lspError              `json:"error"`
+		}
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case message.Error != nil:
+			t.Fatalf("request %v failed: %v", *message.ID, message.Error.Message)
+		case message.ID != nil:
+			responses[*message.ID] = message.Result
+		default:
+			notifications = append(notifications, map[string]interface{}{"method": message.Method, "params": message.Params})
+		}
+	}
+	return responses, notifications
+}
+
+func lspPositionRequest(id int, method string, line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"id":     id,
+		"method": method,
+		"params": map[string]interface{}{
+			"textDocument": map[string]string{"uri": "file:///test.milk"},
+			"position":     lspPosition{line, character},
+			"context":      map[string]bool{"includeDeclaration": true},
+		},
+	}
+}
+
+func lspOpen(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"method": "textDocument/didOpen",
+		"params": map[string]interface{}{
+			"textDocument": map[string]string{"uri": "file:///test.milk", "languageId": "milk", "text": text},
+		},
+	}
+}
+
+// lspRanges renders the ranges of the locations as "line:start-end".
+func lspRanges(t *testing.T, result json.RawMessage) string {
+	t.Helper()
+	var locations []lspLocation
+	if !bytes.HasPrefix(result, []byte("[")) {
+		var location *lspLocation
+		if err := json.Unmarshal(result, &location); err != nil {
+			t.Fatal(err)
+		}
+		if location != nil {
+			locations = append(locations, *location)
+		}
+	} else if err := json.Unmarshal(result, &locations); err != nil {
+		t.Fatal(err)
+	}
+	ranges := []string{}
+	for _, location := range locations {
+		r := location.Range
+		ranges = append(ranges, fmt.Sprintf("%v:%v-%v", r.Start.Line, r.Start.Character, r.End.Character))
+	}
+	return strings.Join(ranges, " ")
+}
+
+func TestLspNavigation(t *testing.T) {
+	src := `local M = {name = "m"}
+function M.greet(who, ...)
+    local msg = "hi " .. who
+    return function() return msg .. M.name end
+end
+local count <const>, ok = 0, M.greet("x")
+for i, v in ipairs({}) do print(i, v, count) end
+class Point
+    function init(x) self.x = x end
+end
+print(Point.init, "é" .. ok)
+`
+	responses, _ := lspSession(t,
+		map[string]interface{}{"id": 0, "method": "initialize", "params": map[string]interface{}{}},
+		lspOpen(src),
+		// the upvalue msg
+		lspPositionRequest(1, "textDocument/definition", 3, 29),
+		lspPositionRequest(2, "textDocument/references", 2, 11),
+		// the module field M.greet
+		lspPositionRequest(3, "textDocument/definition", 5, 31),
+		lspPositionRequest(4, "textDocument/references", 1, 11),
+		// names found in the tokens
+		lspPositionRequest(5, "textDocument/references", 1, 18),
+		lspPositionRequest(6, "textDocument/definition", 6, 35),
+		lspPositionRequest(7, "textDocument/definition", 6, 40),
+		lspPositionRequest(8, "textDocument/definition", 10, 12),
+		// the column after a multi-byte character counts UTF-16 units
+		lspPositionRequest(9, "textDocument/definition", 10, 26),
+		// builtins have no definition
+		lspPositionRequest(10, "textDocument/definition", 6, 14),
+		map[string]interface{}{"id": 11, "method": "shutdown"},
+		map[string]interface{}{"method": "exit"},
+	)
+	expected := map[int]string{
+		1:  "2:10-13",
+		2:  "2:10-13 3:29-32",
+		3:  "1:11-16",
+		4:  "1:11-16 5:31-36",
+		5:  "1:17-20 2:25-28",
+		6:  "6:7-8",
+		7:  "5:6-11",
+		8:  "8:13-17",
+		9:  "5:21-23",
+		10: "",
+	}
+	for id, want := range expected {
+		if got := lspRanges(t, responses[id]); got != want {
+			t.Errorf("request %v: expected %v, got %v", id, want, got)
+		}
+	}
+	var capabilities struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}
+	if err := json.Unmarshal(responses[0], &capabilities); err != nil || capabilities.Capabilities["definitionProvider"] != true {
+		t.Errorf("unexpected initialize result %s", responses[0])
+	}
+}
+
+func TestLspDiagnostics(t *testing.T) {
+	_, notifications := lspSession(t,
+		lspOpen("local x = = 1\nprint(x"),
+		map[string]interface{}{
+			"method": "textDocument/didChange",
+			"params": map[string]interface{}{
+				"textDocument":   map[string]interface{}{"uri": "file:///test.milk", "version": 2},
+				"contentChanges": []map[string]string{{"text": "local x = 1\n"}},
+			},
+		},
+	)
+	if len(notifications) != 2 {
+		t.Fatalf("expected 2 notifications, got %v", notifications)
+	}
+	diagnostics := notifications[0]["params"].(map[string]interface{})["diagnostics"].([]interface{})
+	messages := []string{}
+	for _, diag := range diagnostics {
+		diag := diag.(map[string]interface{})
+		start := diag["range"].(map[string]interface{})["start"].(map[string]interface{})
+		messages = append(messages, fmt.Sprintf("%v:%v: %v", start["line"], start["character"], diag["message"]))
+	}
+	expected := "0:10: syntax error, expression expected\n1:7: syntax error, ')' expected to close '(' at line 2"
+	if strings.Join(messages, "\n") != expected {
+		t.Errorf("unexpected diagnostics %v", messages)
+	}
+	if diagnostics := notifications[1]["params"].(map[string]interface{})["diagnostics"].([]interface{}); len(diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, got %v", diagnostics)
+	}
+}
+
+func TestLspHoverAndCompletion(t *testing.T) {
+	src := "local t = {size = 1}\nprint(string.format(\"%d\", t.size), matrix.det)\n"
+	responses, _ := lspSession(t,
+		lspOpen(src),
+		map[string]interface{}{
+			"method": "textDocument/didChange",
+			"params": map[string]interface{}{
+				"textDocument":   map[string]string{"uri": "file:///test.milk"},
+				"contentChanges": []map[string]string{{"text": src + "local s = string.\nt.\n"}},
+			},
+		},
+		lspPositionRequest(1, "textDocument/hover", 1, 15),
+		lspPositionRequest(2, "textDocument/hover", 1, 43),
+		lspPositionRequest(3, "textDocument/hover", 1, 29),
+		lspPositionRequest(4, "textDocument/completion", 2, 17),
+		lspPositionRequest(5, "textDocument/completion", 3, 2),
+		lspPositionRequest(6, "textDocument/completion", 3, 0),
+	)
+	hover := func(id int) string {
+		var result struct {
+			Contents struct {
+				Value string `json:"value"`
+			} `json:"contents"`
+		}
+		if err := json.Unmarshal(responses[id], &result); err != nil {
+			t.Fatal(err)
+		}
+		return result.Contents.Value
+	}
+	if value := hover(1); !strings.HasPrefix(value, "```lua\nstring.format(formatstring, ···)\n```\n") {
+		t.Errorf("unexpected hover for string.format: %q", value)
+	}
+	if value := hover(2); value != "```lua\n(builtin function) matrix.det\n```" {
+		t.Errorf("unexpected hover for matrix.det: %q", value)
+	}
+	if value := hover(3); value != "```lua\n(field) t.size\n```\nDefined on line 1." {
+		t.Errorf("unexpected hover for t.size: %q", value)
+	}
+
+	labels := func(id int) map[string]bool {
+		var items []lspCompletionItem
+		if err := json.Unmarshal(responses[id], &items); err != nil {
+			t.Fatal(err)
+		}
+		found := map[string]bool{}
+		for _, item := range items {
+			found[item.Label] = true
+		}
+		return found
+	}
+	// the source does not parse, completion uses the names of the
+	// statements that did
+	if items := labels(4); !items["format"] || !items["trim_start"] || items["print"] {
+		t.Errorf("unexpected string completion %v", items)
+	}
+	if items := labels(5); !items["size"] || len(items) != 1 {
+		t.Errorf("unexpected table completion %v", items)
+	}
+	if items := labels(6); !items["local"] || !items["print"] || !items["matrix"] {
+		t.Errorf("unexpected completion %v", items)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+/* milk lsp: builtin documentation {{{ */
+
+// lspDocs documents the builtin functions for hover. The first line of an
+// entry is the signature. Builtins without an entry are still completed and
+// described by their type.
+var lspDocs = map[string]string{
+	// base
+	"assert":         "assert(v [, message])\nRaises an error with message(default \"assertion failed!\") if v is false or nil, otherwise returns all its arguments.",
+	"collectgarbage": "collectgarbage([opt [, arg]])\nControls the garbage collector. opt is \"collect\", \"stop\", \"restart\", \"count\", \"step\", \"setpause\" or \"setstepmul\".",
+	"dofile":         "dofile([filename])\nRuns the file(stdin by default) and returns the values it returns.",
+	"error":          "error(message [, level])\nRaises an error with message. level 1(the default) adds the position of the function calling error, 2 that of its caller and 0 none.",
+	"getfenv":        "getfenv([f])\nReturns the environment of the function f, or of the function at stack level f(1 by default).",
+	"getmetatable":   "getmetatable(object)\nReturns the __metatable field of the metatable of object if there is one, else its metatable or nil.",
+	"ipairs":         "ipairs(t)\nReturns an iterator over the pairs (1, t[1]), (2, t[2]), ... up to the first nil value.",
+	"load":           "load(func [, chunkname])\nLoads a chunk by calling func for its pieces until it returns an empty string or nil. Returns the compiled function, or nil and an error message.",
+	"loadfile":       "loadfile([filename])\nLoads the file(stdin by default) as a chunk without running it. Returns the compiled function, or nil and an error message.",
+	"loadstring":     "loadstring(string [, chunkname])\nLoads string as a chunk without running it. Returns the compiled function, or nil and an error message.",
+	"next":           "next(table [, index])\nReturns the key after index in table and its value, or the first pair if index is nil. Returns nil after the last pair.",
+	"pairs":          "pairs(t)\nReturns next, t and nil, to iterate over all the pairs of t. A __pairs metamethod is called instead if t has one.",
+	"pcall":          "pcall(f, ···)\nCalls f with the arguments in protected mode. Returns true and the results of f, or false and the error.",
+	"print":          "print(···)\nWrites its arguments to stdout separated by tabs, converting them with tostring.",
+	"rawequal":       "rawequal(v1, v2)\nReports whether v1 is equal to v2 without calling any metamethod.",
+	"rawget":         "rawget(table, index)\nReturns table[index] without calling any metamethod.",
+	"rawset":         "rawset(table, index, value)\nSets table[index] to value without calling any metamethod and returns table.",
+	"require":        "require(modname)\nLoads the module modname once, searching package.path, and returns the value it returns. Later calls return the same value from package.loaded.",
+	"select":         "select(index, ···)\nReturns the arguments after index, or their count if index is \"#\". A negative index counts from the end.",
+	"setfenv":        "setfenv(f, table)\nSets the environment of the function f, or of the function at stack level f, and returns it.",
+	"setmetatable":   "setmetatable(table, metatable)\nSets the metatable of table(nil removes it) and returns table.",
+	"tonumber":       "tonumber(e [, base])\nConverts e to a number, reading strings in base(10 by default). Returns nil if e cannot be converted.",
+	"tostring":       "tostring(e)\nConverts e to a string, calling the __tostring metamethod if it has one.",
+	"type":           "type(v)\nReturns the type of v as a string: \"nil\", \"boolean\", \"number\", \"string\", \"table\", \"function\", \"thread\", \"userdata\" or \"channel\".",
+	"unpack":         "unpack(list [, i [, j]])\nReturns list[i], ..., list[j]. i defaults to 1 and j to #list.",
+	"xpcall":         "xpcall(f, err)\nCalls f in protected mode with err as the message handler. Returns true and the results of f, or false and the result of err.",
+
+	// string
+	"string.byte":       "string.byte(s [, i [, j]])\nReturns the codes of the characters s[i], ..., s[j]. i defaults to 1 and j to i.",
+	"string.char":       "string.char(···)\nReturns the string made of the characters with the given codes.",
+	"string.count":      "string.count(s, pattern [, init [, plain]])\nReturns the number of non-overlapping matches of pattern in s from init. plain turns off the pattern matching.",
+	"string.dump":       "string.dump(function)\nReturns the binary representation of function, which load turns back into a copy of it.",
+	"string.end_with":   "string.end_with(s, suffix)\nReports whether s ends with suffix.",
+	"string.find":       "string.find(s, pattern [, init [, plain]])\nReturns the start and end of the first match of pattern in s from init and its captures, or nil. plain turns off the pattern matching.",
+	"string.format":     "string.format(formatstring, ···)\nReturns its arguments formatted as described by formatstring, in the style of printf.",
+	"string.gmatch":     "string.gmatch(s, pattern)\nReturns an iterator over the matches of pattern in s, giving the captures of each match or the whole match.",
+	"string.gsub":       "string.gsub(s, pattern, repl [, n])\nReturns a copy of s with the first n(all by default) matches of pattern replaced by repl, a string, a table or a function, and the number of matches.",
+	"string.is_blank":   "string.is_blank(s)\nReports whether s only holds spaces, tabs and line breaks.",
+	"string.len":        "string.len(s)\nReturns the length of s in bytes.",
+	"string.lower":      "string.lower(s)\nReturns a copy of s with the uppercase letters changed to lowercase.",
+	"string.match":      "string.match(s, pattern [, init])\nReturns the captures of the first match of pattern in s from init, or the whole match, or nil.",
+	"string.pad_end":    "string.pad_end(s, n [, pad])\nReturns s padded at the end with copies of pad(a space by default) up to the length n.",
+	"string.pad_start":  "string.pad_start(s, n [, pad])\nReturns s padded at the start with copies of pad(a space by default) up to the length n.",
+	"string.rep":        "string.rep(s, n)\nReturns n copies of s concatenated.",
+	"string.reverse":    "string.reverse(s)\nReturns s reversed.",
+	"string.split":      "string.split(s [, sep ··· [, n]])\nSplits s at each of the separators(\",\" by default) into a table, at most n times.",
+	"string.start_with": "string.start_with(s, prefix)\nReports whether s starts with prefix.",
+	"string.sub":        "string.sub(s, i [, j])\nReturns the substring of s from i to j(the end by default). Negative indices count from the end.",
+	"string.trim":       "string.trim(s [, chars])\nReturns s without the leading and trailing characters in chars(a space by default).",
+	"string.trim_end":   "string.trim_end(s [, chars])\nReturns s without the trailing characters in chars(a space by default).",
+	"string.trim_start": "string.trim_start(s [, chars])\nReturns s without the leading characters in chars(a space by default).",
+	"string.truncate":   "string.truncate(s, n [, suffix])\nReturns s cut to n bytes, ending with suffix if it is given and shorter than n.",
+	"string.upper":      "string.upper(s)\nReturns a copy of s with the lowercase letters changed to uppercase.",
+
+	// table
+	"table.concat":   "table.concat(list [, sep [, i [, j]]])\nReturns list[i]..sep..list[i+1]..sep..list[j]. sep defaults to \"\", i to 1 and j to #list.",
+	"table.equals":   "table.equals(t1, t2)\nReports whether t1 and t2 hold equal keys and values, comparing nested tables deeply.",
+	"table.getn":     "table.getn(list)\nReturns the length of list.",
+	"table.insert":   "table.insert(list, [pos,] value)\nInserts value at pos(the end by default), shifting up the elements after it.",
+	"table.maxn":     "table.maxn(table)\nReturns the largest positive numerical key of table, or 0.",
+	"table.remove":   "table.remove(list [, pos])\nRemoves and returns the element at pos(the last one by default), shifting down the elements after it.",
+	"table.sort":     "table.sort(list [, comp])\nSorts list in place with comp(a, b), or the < operator.",
+	"table.tostring": "table.tostring(t)\nReturns a readable representation of t and its nested tables, with the keys sorted. Raises an error on circular references.",
+	"table.unpack":   "table.unpack(list [, i [, j]])\nReturns list[i], ..., list[j]. i defaults to 1 and j to #list.",
+
+	// math
+	"math.abs":        "math.abs(x)\nReturns the absolute value of x.",
+	"math.acos":       "math.acos(x)\nReturns the arc cosine of x in radians.",
+	"math.asin":       "math.asin(x)\nReturns the arc sine of x in radians.",
+	"math.atan":       "math.atan(x)\nReturns the arc tangent of x in radians.",
+	"math.atan2":      "math.atan2(y, x)\nReturns the arc tangent of y/x in radians, using the signs of both to find the quadrant.",
+	"math.ceil":       "math.ceil(x)\nReturns the smallest integer larger than or equal to x.",
+	"math.cos":        "math.cos(x)\nReturns the cosine of x, given in radians.",
+	"math.cosh":       "math.cosh(x)\nReturns the hyperbolic cosine of x.",
+	"math.deg":        "math.deg(x)\nReturns the angle x, given in radians, in degrees.",
+	"math.exp":        "math.exp(x)\nReturns e raised to the power x.",
+	"math.floor":      "math.floor(x)\nReturns the largest integer smaller than or equal to x.",
+	"math.fmod":       "math.fmod(x, y)\nReturns the remainder of the division of x by y, rounding the quotient towards zero.",
+	"math.frexp":      "math.frexp(x)\nReturns m and e such that x = m * 2^e, with the absolute value of m in [0.5, 1).",
+	"math.huge":       "math.huge\nThe value HUGE_VAL, larger than or equal to any other number.",
+	"math.ldexp":      "math.ldexp(m, e)\nReturns m * 2^e.",
+	"math.log":        "math.log(x)\nReturns the natural logarithm of x.",
+	"math.log10":      "math.log10(x)\nReturns the base-10 logarithm of x.",
+	"math.max":        "math.max(x, ···)\nReturns the largest of its arguments.",
+	"math.maxinteger": "math.maxinteger\nThe largest integer.",
+	"math.min":        "math.min(x, ···)\nReturns the smallest of its arguments.",
+	"math.mininteger": "math.mininteger\nThe smallest integer.",
+	"math.mod":        "math.mod(x, y)\nReturns the remainder of the division of x by y, rounding the quotient towards zero.",
+	"math.modf":       "math.modf(x)\nReturns the integral part and the fractional part of x.",
+	"math.pi":         "math.pi\nThe value of pi.",
+	"math.pow":        "math.pow(x, y)\nReturns x raised to the power y.",
+	"math.rad":        "math.rad(x)\nReturns the angle x, given in degrees, in radians.",
+	"math.random":     "math.random([m [, n]])\nReturns a pseudo-random number in [0, 1), or an integer in [1, m] or [m, n].",
+	"math.randomseed": "math.randomseed(x)\nSets x as the seed of the pseudo-random generator.",
+	"math.sin":        "math.sin(x)\nReturns the sine of x, given in radians.",
+	"math.sinh":       "math.sinh(x)\nReturns the hyperbolic sine of x.",
+	"math.sqrt":       "math.sqrt(x)\nReturns the square root of x.",
+	"math.tan":        "math.tan(x)\nReturns the tangent of x, given in radians.",
+	"math.tanh":       "math.tanh(x)\nReturns the hyperbolic tangent of x.",
+	"math.tointeger":  "math.tointeger(x)\nReturns x as an integer if it has an integral value, otherwise nil.",
+	"math.type":       "math.type(x)\nReturns \"integer\" or \"float\" for a number x, otherwise nil.",
+
+	// os
+	"os.clock":        "os.clock()\nReturns the CPU time used by the program in seconds.",
+	"os.date":         "os.date([format [, time]])\nReturns time(now by default) formatted with format in the style of strftime, or as a table for \"*t\". A leading \"!\" formats in UTC.",
+	"os.difftime":     "os.difftime(t2, t1)\nReturns the number of seconds from t1 to t2.",
+	"os.execute":      "os.execute([command])\nRuns command in the shell and returns its exit status.",
+	"os.exit":         "os.exit([code])\nExits the program with code(0 by default).",
+	"os.get_home_dir": "os.get_home_dir()\nReturns the home directory of the user, or nil and an error message.",
+	"os.getenv":       "os.getenv(varname)\nReturns the value of the environment variable varname, or nil if it is not set.",
+	"os.remove":       "os.remove(filename)\nDeletes the file or empty directory. Returns true, or nil and an error message.",
+	"os.rename":       "os.rename(oldname, newname)\nRenames a file or directory. Returns true, or nil and an error message.",
+	"os.setenv":       "os.setenv(varname, value)\nSets the environment variable varname to value.",
+	"os.time":         "os.time([table])\nReturns the current time, or the time described by table, as a number of seconds.",
+	"os.tmpname":      "os.tmpname()\nReturns a name that can be used for a temporary file.",
+
+	// io
+	"io.close":       "io.close([file])\nCloses file, or the default output file.",
+	"io.create_dir":  "io.create_dir(path)\nCreates the directory path. Returns true, or nil and an error message.",
+	"io.create_file": "io.create_file(path [, data])\nCreates the file path holding data. Returns nil and an error message if it cannot be created.",
+	"io.file_size":   "io.file_size(path)\nReturns the size of the file in bytes, or nil and an error message.",
+	"io.flush":       "io.flush()\nFlushes the default output file.",
+	"io.input":       "io.input([file])\nSets the default input file to file, a file name or handle, and returns the default input file.",
+	"io.is_dir":      "io.is_dir(path)\nReports whether path is a directory.",
+	"io.is_file":     "io.is_file(path)\nReports whether path is a regular file.",
+	"io.lines":       "io.lines([filename])\nReturns an iterator over the lines of the file, or of the default input file.",
+	"io.open":        "io.open(filename [, mode])\nOpens the file in mode(\"r\" by default) and returns its handle, or nil and an error message.",
+	"io.output":      "io.output([file])\nSets the default output file to file, a file name or handle, and returns the default output file.",
+	"io.popen":       "io.popen(prog [, mode])\nRuns prog and returns a handle to read its output, or to write its input in mode \"w\".",
+	"io.read":        "io.read(···)\nReads the default input file in the given formats: \"*n\", \"*a\", \"*l\" or a number of bytes.",
+	"io.stderr":      "io.stderr\nThe standard error file.",
+	"io.stdin":       "io.stdin\nThe standard input file.",
+	"io.stdout":      "io.stdout\nThe standard output file.",
+	"io.tmpfile":     "io.tmpfile()\nReturns the handle of a temporary file opened in update mode.",
+	"io.type":        "io.type(obj)\nReturns \"file\" for an open file handle, \"closed file\" for a closed one, otherwise nil.",
+	"io.write":       "io.write(···)\nWrites its arguments, strings or numbers, to the default output file.",
+
+	// coroutine
+	"coroutine.create":  "coroutine.create(f)\nReturns a new coroutine running f.",
+	"coroutine.resume":  "coroutine.resume(co [, val1, ···])\nStarts or continues co with the arguments. Returns true and the values passed to yield or returned, or false and the error.",
+	"coroutine.running": "coroutine.running()\nReturns the running coroutine, or nil in the main thread.",
+	"coroutine.status":  "coroutine.status(co)\nReturns the status of co: \"running\", \"suspended\", \"normal\" or \"dead\".",
+	"coroutine.wrap":    "coroutine.wrap(f)\nReturns a function that resumes a new coroutine running f, raising its errors.",
+	"coroutine.yield":   "coroutine.yield(···)\nSuspends the running coroutine. Its arguments are the results of the resume call.",
+}
+
+/* }}} */
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"os"
@@ -17,30 +18,64 @@ func main() {
 }
 
 func mainAux() int {
-	var opt_e, opt_l, opt_p string
-	var opt_i, opt_v, opt_dt, opt_dc bool
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			return checkMain(os.Args[2:])
+		case "fmt":
+			return fmtMain(os.Args[2:])
+		case "lint":
+			return lintMain(os.Args[2:])
+		case "lsp":
+			return lspMain(os.Args[2:])
+		}
+	}
+
+	var opt_e, opt_l, opt_p, opt_o string
+	var opt_i, opt_v, opt_dt, opt_dc, opt_O0, opt_O1, opt_s bool
 	var opt_m int
 	flag.StringVar(&opt_e, "e", "", "")
 	flag.StringVar(&opt_l, "l", "", "")
 	flag.StringVar(&opt_p, "p", "", "")
+	flag.StringVar(&opt_o, "o", "", "")
 	flag.IntVar(&opt_m, "mx", 0, "")
 	flag.BoolVar(&opt_i, "i", false, "")
 	flag.BoolVar(&opt_v, "v", false, "")
 	flag.BoolVar(&opt_dt, "dt", false, "")
 	flag.BoolVar(&opt_dc, "dc", false, "")
+	flag.BoolVar(&opt_O0, "O0", false, "")
+	flag.BoolVar(&opt_O1, "O1", false, "")
+	flag.BoolVar(&opt_s, "s", false, "")
 	flag.Usage = func() {
 		fmt.Println(`Usage: milk [options] [script [args]].
+       milk check file [file ...]
+       milk fmt [--check | --write] file [file ...]
+       milk lint [-config file] file [file ...]
+       milk lsp
+       milk -o out.milkc [-s] script [module ...]
 Available options are:
   -e stat  execute string 'stat'
   -l name  require library 'name'
   -mx MB   memory limit(default: unlimited)
   -dt      dump AST trees
-  -dc      dump VM codes
+  -dc      list VM codes
+  -O0      disable optimizations
+  -O1      fold constants and remove dead branches(default)
+  -o file  precompile 'script' into 'file', bundling the modules
+  -s       strip debug information from precompiled chunks
   -i       enter interactive mode after executing 'script'
   -p file  write cpu profiles to the file
   -v       show version information`)
 	}
 	flag.Parse()
+	if opt_O0 {
+		lua.OptimizationLevel = 0
+	} else if opt_O1 {
+		lua.OptimizationLevel = 1
+	}
+	if len(opt_o) != 0 {
+		return compileMain(opt_o, opt_s, flag.Args())
+	}
 	if len(opt_p) != 0 {
 		f, err := os.Create(opt_p)
 		if err != nil {
@@ -80,12 +115,12 @@ Available options are:
 		}
 		L.SetGlobal("arg", argtb)
 		if opt_dt || opt_dc {
-			file, err := os.Open(script)
+			src, err := os.ReadFile(script)
 			if err != nil {
 				fmt.Println(err.Error())
 				return 1
 			}
-			chunk, err2 := parse.Parse(file, script)
+			chunk, err2 := parse.Parse(bytes.NewReader(blankShebang(src)), script)
 			if err2 != nil {
 				fmt.Println(err2.Error())
 				return 1
@@ -95,13 +130,13 @@ Available options are:
 				return 0
 			}
 			if opt_dc {
+				chunk = lua.Optimize(chunk, lua.OptimizationLevel)
 				proto, err3 := lua.Compile(chunk, script)
 				if err3 != nil {
 					fmt.Println(err3.Error())
 					return 1
 				}
-				fmt.Println(proto.RawBytecodeString())
-				// fmt.Println(proto.String())
+				fmt.Print(proto.Disassemble())
 				return 0
 			}
 		}
@@ -165,6 +200,19 @@ func loadline(rl *readline.Instance, L *lua.LState) (string, error) {
 	}
 }
 
+// blankShebang returns src with a first line starting with '#', like
+// '#!/usr/bin/env milk', blanked out the way the interpreter skips it. The
+// line break stays so that the positions in the rest of src do not move.
+func blankShebang(src []byte) []byte {
+	if len(src) == 0 || src[0] != '#' {
+		return src
+	}
+	if end := bytes.IndexAny(src, "\r\n"); end >= 0 {
+		return src[end:]
+	}
+	return nil
+}
+
 func multiline(ml string, rl *readline.Instance, L *lua.LState) (string, error) {
 	for {
 		if _, err := L.LoadString(ml); err == nil { // try compile
@@ -51,6 +51,11 @@ type lblabels struct {
 	b bool
 }
 
+type typeCheck struct {
+	mask  int
+	kname int
+}
+
 type constLValueExpr struct {
 	ast.ExprBase
 
@@ -102,6 +107,22 @@ func eline(pos ast.PositionHolder) int {
 	return line
 }
 
+// scol returns the column that the errors of an expression point at: the key
+// of a field, the function of a call and the start of other expressions.
+func scol(expr ast.Expr) int {
+	switch ex := expr.(type) {
+	case *ast.AttrGetExpr:
+		if ex.Key.Line() == ex.Line() {
+			return ex.Key.Column()
+		}
+	case *ast.FuncCallExpr:
+		if ex.Func != nil && ex.Func.Line() == ex.Line() {
+			return scol(ex.Func)
+		}
+	}
+	return expr.Column()
+}
+
 func savereg(ec *expcontext, reg int) int {
 	if ec.ctype != ecLocal || ec.reg == regNotDefined {
 		return reg
@@ -124,17 +145,17 @@ func isVarArgReturnExpr(expr ast.Expr) bool {
 	return false
 }
 
-func lnumberValue(expr ast.Expr) (LNumber, bool) {
+func lnumberValue(expr ast.Expr) (LValue, bool) {
 	if ex, ok := expr.(*ast.NumberExpr); ok {
-		lv, err := parseNumber(ex.Value)
+		lv, err := parseNumberValue(ex.Value)
 		if err != nil {
 			lv = LNumber(math.NaN())
 		}
 		return lv, true
 	} else if ex, ok := expr.(*constLValueExpr); ok {
-		return ex.Value.(LNumber), true
+		return ex.Value, true
 	}
-	return 0, false
+	return LNil, false
 }
 
 /* utilities }}} */
@@ -172,22 +193,32 @@ func (e *CompileError) Error() string {
 } // }}}
 
 type codeStore struct { // {{{
-	codes []uint32
-	lines []int
-	pc    int
+	codes   []uint32
+	lines   []int
+	columns []int
+	column  int
+	pc      int
 }
 
 func (cd *codeStore) Add(inst uint32, line int) {
 	if l := len(cd.codes); l <= 0 || cd.pc == l {
 		cd.codes = append(cd.codes, inst)
 		cd.lines = append(cd.lines, line)
+		cd.columns = append(cd.columns, cd.column)
 	} else {
 		cd.codes[cd.pc] = inst
 		cd.lines[cd.pc] = line
+		cd.columns[cd.pc] = cd.column
 	}
 	cd.pc++
 }
 
+// SetColumn sets the source column of the instructions added next. It is
+// set at each statement and before the instructions that may raise errors.
+func (cd *codeStore) SetColumn(column int) {
+	cd.column = column
+}
+
 func (cd *codeStore) AddABC(op int, a int, b int, c int, line int) {
 	cd.Add(opCreateABC(op, a, b, c), line)
 }
@@ -280,6 +311,10 @@ func (cd *codeStore) PosList() []int {
 	return cd.lines[:cd.pc]
 }
 
+func (cd *codeStore) ColumnList() []int {
+	return cd.columns[:cd.pc]
+}
+
 func (cd *codeStore) LastPC() int {
 	return cd.pc - 1
 }
@@ -357,16 +392,23 @@ func (vp *varNamePool) Register(name string) int {
 type codeBlock struct {
 	LocalVars      *varNamePool
 	BreakLabel     int
+	ContinueLabel  int
 	Parent         *codeBlock
 	RefUpvalue     bool
 	LineStart      int
 	LastLine       int
 	labels         map[string]*gotoLabelDesc
 	firstGotoIndex int
+	index          int
+	continues      []*ast.ContinueStmt
+	continuePcs    []int
+	continueVars   int
+	untilScope     bool
+	attribs        map[int]string
 }
 
 func newCodeBlock(localvars *varNamePool, blabel int, parent *codeBlock, pos ast.PositionHolder, firstGotoIndex int) *codeBlock {
-	bl := &codeBlock{localvars, blabel, parent, false, 0, 0, map[string]*gotoLabelDesc{}, firstGotoIndex}
+	bl := &codeBlock{localvars, blabel, labelNoJump, parent, false, 0, 0, map[string]*gotoLabelDesc{}, firstGotoIndex, 0, nil, nil, 0, false, nil}
 	if pos != nil {
 		bl.LineStart = pos.Line()
 		bl.LastLine = pos.LastLine()
@@ -389,6 +431,13 @@ func (b *codeBlock) GetLabel(label string) *gotoLabelDesc {
 	return nil
 }
 
+func (b *codeBlock) SetAttrib(reg int, attrib string) {
+	if b.attribs == nil {
+		b.attribs = map[int]string{}
+	}
+	b.attribs[reg] = attrib
+}
+
 func (b *codeBlock) LocalVarsCount() int {
 	count := 0
 	for block := b; block != nil; block = block.Parent {
@@ -409,12 +458,13 @@ type funcContext struct {
 	labelPc         map[int]int
 	gotosCount      int
 	unresolvedGotos map[int]*gotoLabelDesc
+	returnChecks    []typeCheck
 }
 
 func newFuncContext(sourcename string, parent *funcContext) *funcContext {
 	fc := &funcContext{
 		Proto:           newFunctionProto(sourcename),
-		Code:            &codeStore{make([]uint32, 0, 1024), make([]int, 0, 1024), 0},
+		Code:            &codeStore{codes: make([]uint32, 0, 1024), lines: make([]int, 0, 1024), columns: make([]int, 0, 1024)},
 		Parent:          parent,
 		Upvalues:        newVarNamePool(0),
 		Block:           newCodeBlock(newVarNamePool(0), labelNoJump, nil, nil, 0),
@@ -556,6 +606,19 @@ func (fc *funcContext) FindLocalVarAndBlock(name string) (int, *codeBlock) {
 	return -1, nil
 }
 
+// HasCloseVars reports whether a <close> local is in scope, in which case
+// returns can not be compiled as tail calls.
+func (fc *funcContext) HasCloseVars() bool {
+	for block := fc.Block; block != nil; block = block.Parent {
+		for _, attrib := range block.attribs {
+			if attrib == "close" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (fc *funcContext) FindLocalVar(name string) int {
 	idx, _ := fc.FindLocalVarAndBlock(name)
 	return idx
@@ -571,9 +634,29 @@ func (fc *funcContext) LocalVars() []varNamePoolValue {
 
 func (fc *funcContext) EnterBlock(blabel int, pos ast.PositionHolder) {
 	fc.Block = newCodeBlock(newVarNamePool(fc.RegTop()), blabel, fc.Block, pos, fc.gotosCount)
+	fc.Block.index = len(fc.Blocks)
 	fc.Blocks = append(fc.Blocks, fc.Block)
 }
 
+func (fc *funcContext) EnterLoopBlock(blabel, clabel int, pos ast.PositionHolder) {
+	fc.EnterBlock(blabel, pos)
+	fc.Block.ContinueLabel = clabel
+}
+
+// OptimizeContinues turns the OP_CLOSE emitted by continue statements of the
+// current loop block into OP_NOP when no local inside the loop is captured
+// by a closure.
+func (fc *funcContext) OptimizeContinues() {
+	for _, block := range fc.Blocks[fc.Block.index:] {
+		if block.RefUpvalue {
+			return
+		}
+	}
+	for _, pc := range fc.Block.continuePcs {
+		fc.Code.SetOpCode(pc, OP_NOP)
+	}
+}
+
 func (fc *funcContext) CloseUpvalues() int {
 	n := -1
 	if fc.Block.RefUpvalue {
@@ -584,6 +667,9 @@ func (fc *funcContext) CloseUpvalues() int {
 }
 
 func (fc *funcContext) LeaveBlock() int {
+	if len(fc.Block.continuePcs) > 0 {
+		fc.OptimizeContinues()
+	}
 	closed := fc.CloseUpvalues()
 	fc.EndScope()
 
@@ -651,9 +737,12 @@ func compileBlock(context *funcContext, chunk []ast.Stmt) { // {{{
 } // }}}
 
 func compileStmt(context *funcContext, stmt ast.Stmt, isLastStmt bool) { // {{{
+	context.Code.SetColumn(stmt.Column())
 	switch st := stmt.(type) {
 	case *ast.AssignStmt:
 		compileAssignStmt(context, st)
+	case *ast.CompoundAssignStmt:
+		compileCompoundAssignStmt(context, st)
 	case *ast.LocalAssignStmt:
 		compileLocalAssignStmt(context, st)
 	case *ast.FuncCallStmt:
@@ -668,12 +757,18 @@ func compileStmt(context *funcContext, stmt ast.Stmt, isLastStmt bool) { // {{{
 		compileRepeatStmt(context, st)
 	case *ast.FuncDefStmt:
 		compileFuncDefStmt(context, st)
+	case *ast.ClassStmt:
+		compileClassStmt(context, st)
 	case *ast.ReturnStmt:
 		compileReturnStmt(context, st)
 	case *ast.IfStmt:
 		compileIfStmt(context, st)
+	case *ast.MatchStmt:
+		compileMatchStmt(context, st)
 	case *ast.BreakStmt:
 		compileBreakStmt(context, st)
+	case *ast.ContinueStmt:
+		compileContinueStmt(context, st)
 	case *ast.NumberForStmt:
 		compileNumberForStmt(context, st)
 	case *ast.GenericForStmt:
@@ -691,6 +786,7 @@ func compileAssignStmtLeft(context *funcContext, stmt *ast.AssignStmt) (int, []*
 	for _, lhs := range stmt.Lhs {
 		switch st := lhs.(type) {
 		case *ast.IdentExpr:
+			checkConstAssign(context, st)
 			identtype := getIdentRefType(context, context, st)
 			ec := &expcontext{identtype, regNotDefined, 0}
 			switch identtype {
@@ -753,11 +849,12 @@ func compileAssignStmtRight(context *funcContext, stmt *ast.AssignStmt, reg int,
 		idx := reg
 		reginc := compileExpr(context, reg, expr, ec)
 		if ec.ctype == ecTable {
-			if _, ok := expr.(*ast.LogicalOpExpr); !ok {
-				context.Code.PropagateKMV(context.RegTop(), &ac.valuerk, &reg, reginc)
-			} else {
+			switch expr.(type) {
+			case *ast.LogicalOpExpr, *ast.NilCoalesceOpExpr:
 				ac.valuerk = idx
 				reg += reginc
+			default:
+				context.Code.PropagateKMV(context.RegTop(), &ac.valuerk, &reg, reginc)
 			}
 		} else {
 			ac.needmove = reginc != 0
@@ -804,6 +901,7 @@ func compileAssignStmt(context *funcContext, stmt *ast.AssignStmt) { // {{{
 			if acs[i].keyks {
 				opcode = OP_SETTABLEKS
 			}
+			code.SetColumn(scol(ex))
 			code.AddABC(opcode, acs[i].ec.reg, acs[i].keyrk, acs[i].valuerk, sline(ex))
 			if !opIsK(acs[i].valuerk) {
 				reg -= 1
@@ -812,6 +910,70 @@ func compileAssignStmt(context *funcContext, stmt *ast.AssignStmt) { // {{{
 	}
 } // }}}
 
+func compileCompoundAssignStmt(context *funcContext, stmt *ast.CompoundAssignStmt) { // {{{
+	// the target is evaluated only once, so that 't[f()] += 1' calls f,
+	// __index and __newindex exactly one time each.
+	code := context.Code
+	reg := context.RegTop()
+	line := sline(stmt)
+	switch lhs := stmt.Lhs.(type) {
+	case *ast.IdentExpr:
+		checkConstAssign(context, lhs)
+		switch getIdentRefType(context, context, lhs) {
+		case ecLocal:
+			a := context.FindLocalVar(lhs.Value)
+			compileCompoundOp(context, reg, stmt, a, a)
+		case ecUpvalue:
+			index := context.Upvalues.RegisterUnique(lhs.Value)
+			code.AddABC(OP_GETUPVAL, reg, index, 0, line)
+			compileCompoundOp(context, reg+1, stmt, reg, reg)
+			code.AddABC(OP_SETUPVAL, reg, index, 0, line)
+		case ecGlobal:
+			index := context.ConstIndex(LString(lhs.Value))
+			code.AddABx(OP_GETGLOBAL, reg, index, line)
+			compileCompoundOp(context, reg+1, stmt, reg, reg)
+			code.AddABx(OP_SETGLOBAL, reg, index, line)
+		}
+	case *ast.AttrGetExpr:
+		obj := reg
+		compileExprWithMVPropagation(context, lhs.Object, &reg, &obj)
+		key := reg
+		compileExprWithKMVPropagation(context, lhs.Key, &reg, &key)
+		getop, setop := OP_GETTABLE, OP_SETTABLE
+		if _, ok := lhs.Key.(*ast.StringExpr); ok {
+			getop, setop = OP_GETTABLEKS, OP_SETTABLEKS
+		}
+		code.SetColumn(scol(lhs))
+		code.AddABC(getop, reg, obj, key, line)
+		compileCompoundOp(context, reg+1, stmt, reg, reg)
+		code.SetColumn(scol(lhs))
+		code.AddABC(setop, obj, key, reg, line)
+	default:
+		panic("invalid left expression.")
+	}
+} // }}}
+
+func compileCompoundOp(context *funcContext, reg int, stmt *ast.CompoundAssignStmt, a, b int) { // {{{
+	// R(a) := R(b) <op> rhs, registers from reg are free to use
+	code := context.Code
+	line := sline(stmt)
+	if stmt.Operator == ".." {
+		code.AddABC(OP_MOVE, reg, b, 0, line)
+		compileExpr(context, reg+1, stmt.Rhs, ecnone(0))
+		code.SetColumn(stmt.Column())
+		code.AddABC(OP_CONCAT, a, reg, reg+1, line)
+		return
+	}
+	c := reg
+	compileExprWithKMVPropagation(context, stmt.Rhs, &reg, &c)
+	op, ok := arithOpcodes[stmt.Operator]
+	if !ok {
+		raiseCompileError(context, line, "unknown compound assignment operator '%v='", stmt.Operator)
+	}
+	code.SetColumn(stmt.Column())
+	code.AddABC(op, a, b, c, line)
+} // }}}
+
 func compileRegAssignment(context *funcContext, names []string, exprs []ast.Expr, reg int, nvars int, line int) { // {{{
 	lennames := len(names)
 	lenexprs := len(exprs)
@@ -854,10 +1016,16 @@ func compileRegAssignment(context *funcContext, names []string, exprs []ast.Expr
 
 func compileLocalAssignStmt(context *funcContext, stmt *ast.LocalAssignStmt) { // {{{
 	reg := context.RegTop()
+	if stmt.Pattern != nil {
+		compileLocalDestructStmt(context, stmt)
+		return
+	}
+	checkLocalAttribs(context, stmt)
 	if len(stmt.Names) == 1 && len(stmt.Exprs) == 1 {
 		if _, ok := stmt.Exprs[0].(*ast.FunctionExpr); ok {
 			context.RegisterLocalVar(stmt.Names[0])
 			compileRegAssignment(context, stmt.Names, stmt.Exprs, reg, len(stmt.Names), sline(stmt))
+			compileLocalAttribs(context, stmt, reg)
 			return
 		}
 	}
@@ -866,9 +1034,109 @@ func compileLocalAssignStmt(context *funcContext, stmt *ast.LocalAssignStmt) { /
 	for _, name := range stmt.Names {
 		context.RegisterLocalVar(name)
 	}
+	compileLocalAttribs(context, stmt, reg)
+} // }}}
+
+func compileLocalDestructStmt(context *funcContext, stmt *ast.LocalAssignStmt) { // {{{
+	// local {a, b=, c=1}, d = expr1, expr2 is lowered to
+	//   local t1, t2 = expr1, expr2
+	//   local a, b, c, d = t1[1], t1.b, t1.c, t2; if c == nil then c = 1 end
+	// where the defaults are evaluated after all fields have been read. As in
+	// a plain local statement, the new locals are not visible to defaults.
+	code := context.Code
+	reg := context.RegTop()
+	checkLocalAttribs(context, stmt)
+	nfields := len(stmt.Pattern)
+	nvalues := stmt.Pattern[nfields-1].Value + 1
+	src := reg + nfields
+	tmp := src + nvalues
+	if nvalues == 1 && len(stmt.Exprs) == 1 {
+		compileExprWithMVPropagation(context, stmt.Exprs[0], &tmp, &src)
+	} else {
+		compileRegAssignment(context, make([]string, nvalues), stmt.Exprs, src, nvalues, sline(stmt))
+	}
+	for i, field := range stmt.Pattern {
+		code.SetColumn(field.Column())
+		if field.Key == nil {
+			code.AddABC(OP_MOVE, reg+i, src+field.Value, 0, sline(field))
+			continue
+		}
+		keyreg, key := tmp, tmp
+		compileExprWithKMVPropagation(context, field.Key, &keyreg, &key)
+		opcode := OP_GETTABLE
+		if _, ok := field.Key.(*ast.StringExpr); ok {
+			opcode = OP_GETTABLEKS
+		}
+		code.AddABC(opcode, reg+i, src+field.Value, key, sline(field))
+	}
+	ec := &expcontext{}
+	for i, field := range stmt.Pattern {
+		if _, ok := field.Default.(*ast.NilExpr); ok || field.Default == nil {
+			continue
+		}
+		skiplabel := context.NewLabel()
+		k := reg + nfields
+		compileNilJump(context, &k, field.Default, reg+i, 0, skiplabel)
+		ecupdate(ec, ecLocal, reg+i, 0)
+		if compileExpr(context, reg+nfields, field.Default, ec) != 0 {
+			code.AddABC(OP_MOVE, reg+i, reg+nfields, 0, sline(field))
+		}
+		context.SetLabelPc(skiplabel, code.LastPC())
+	}
+	for _, name := range stmt.Names {
+		context.RegisterLocalVar(name)
+	}
+	compileLocalAttribs(context, stmt, reg)
+} // }}}
+
+func checkLocalAttribs(context *funcContext, stmt *ast.LocalAssignStmt) { // {{{
+	closes := 0
+	for _, attrib := range stmt.Attribs {
+		switch attrib {
+		case "", "const":
+		case "close":
+			closes++
+		default:
+			raiseCompileError(context, sline(stmt), "unknown attribute '%s'", attrib)
+		}
+	}
+	if closes > 1 {
+		raiseCompileError(context, sline(stmt), "multiple to-be-closed variables in local list")
+	}
+} // }}}
+
+func compileLocalAttribs(context *funcContext, stmt *ast.LocalAssignStmt, reg int) { // {{{
+	for i, attrib := range stmt.Attribs {
+		switch attrib {
+		case "":
+			continue
+		case "close":
+			// exits of the block close R(reg) the same way as they close
+			// upvalues. The name is for the error about a non-closable
+			// value, the local is not active yet at OP_TBC.
+			context.Block.RefUpvalue = true
+			context.Code.AddABx(OP_TBC, reg+i, context.ConstIndex(LString(stmt.Names[i])), sline(stmt))
+		}
+		context.Block.SetAttrib(reg+i, attrib)
+	}
+} // }}}
+
+func checkConstAssign(context *funcContext, expr *ast.IdentExpr) { // {{{
+	for fc := context; fc != nil; fc = fc.Parent {
+		if idx, block := fc.FindLocalVarAndBlock(expr.Value); idx > -1 {
+			if block.attribs[idx] != "" {
+				raiseCompileError(context, sline(expr), "attempt to assign to const variable '%s'", expr.Value)
+			}
+			return
+		}
+	}
 } // }}}
 
 func compileReturnStmt(context *funcContext, stmt *ast.ReturnStmt) { // {{{
+	if len(context.returnChecks) > 0 {
+		compileTypedReturnStmt(context, stmt)
+		return
+	}
 	lenexprs := len(stmt.Exprs)
 	code := context.Code
 	reg := context.RegTop()
@@ -887,7 +1155,9 @@ func compileReturnStmt(context *funcContext, stmt *ast.ReturnStmt) { // {{{
 				reg += compileExpr(context, reg, ex, ecnone(0))
 			} else {
 				reg += compileExpr(context, reg, ex, ecnone(-2))
-				code.SetOpCode(code.LastPC(), OP_TAILCALL)
+				if !context.HasCloseVars() {
+					code.SetOpCode(code.LastPC(), OP_TAILCALL)
+				}
 			}
 			code.AddABC(OP_RETURN, a, 0, 0, sline(stmt))
 			return
@@ -909,6 +1179,41 @@ func compileReturnStmt(context *funcContext, stmt *ast.ReturnStmt) { // {{{
 	context.Code.AddABC(OP_RETURN, a, count, 0, sline(stmt))
 } // }}}
 
+func compileTypedReturnStmt(context *funcContext, stmt *ast.ReturnStmt) { // {{{
+	// values are always materialized in registers so that they can be checked,
+	// thus 'return f()' is not compiled as a tail call here.
+	lenexprs := len(stmt.Exprs)
+	nchecks := len(context.returnChecks)
+	code := context.Code
+	reg := context.RegTop()
+	a := reg
+	lastisvaarg := false
+
+	for i, expr := range stmt.Exprs {
+		if i == lenexprs-1 && isVarArgReturnExpr(expr) {
+			if want := nchecks - i; want > 0 {
+				reg += compileExpr(context, reg, expr, ecnone(want-1))
+			} else {
+				compileExpr(context, reg, expr, ecnone(-2))
+				lastisvaarg = true
+			}
+		} else {
+			reg += compileExpr(context, reg, expr, ecnone(0))
+		}
+	}
+	count := reg - a + 1
+	if lastisvaarg {
+		count = 0
+	}
+	if a+nchecks > reg {
+		code.AddLoadNil(reg, a+nchecks-1, sline(stmt))
+	}
+	for i, check := range context.returnChecks {
+		code.AddABC(OP_TYPECHECK, a+i, check.mask, check.kname, sline(stmt))
+	}
+	code.AddABC(OP_RETURN, a, count, 0, sline(stmt))
+} // }}}
+
 func compileIfStmt(context *funcContext, stmt *ast.IfStmt) { // {{{
 	thenlabel := context.NewLabel()
 	elselabel := context.NewLabel()
@@ -928,6 +1233,209 @@ func compileIfStmt(context *funcContext, stmt *ast.IfStmt) { // {{{
 
 } // }}}
 
+func compileMatchStmt(context *funcContext, stmt *ast.MatchStmt) { // {{{
+	code := context.Code
+	endlabel := context.NewLabel()
+	context.EnterBlock(labelNoJump, stmt)
+	value := context.RegTop()
+	compileRegAssignment(context, []string{"(match)"}, []ast.Expr{stmt.Value}, value, 1, sline(stmt))
+	context.RegisterLocalVar("(match)")
+
+	if keys := matchJumpTableKeys(stmt); keys != nil {
+		compileMatchJumpTable(context, stmt, value, keys, endlabel)
+	} else {
+		for _, arm := range stmt.Arms {
+			nextlabel := context.NewLabel()
+			context.EnterBlock(labelNoJump, arm)
+			names := patternBindings(arm.Pattern)
+			slot := context.RegTop()
+			compilePattern(context, arm.Pattern, value, &slot, slot+len(names), nextlabel)
+			for _, name := range names {
+				context.RegisterLocalVar(name)
+			}
+			if arm.Guard != nil {
+				thenlabel := context.NewLabel()
+				compileBranchCondition(context, context.RegTop(), arm.Guard, thenlabel, nextlabel, false)
+				context.SetLabelPc(thenlabel, code.LastPC())
+			}
+			compileChunk(context, arm.Stmts, false)
+			n := context.LeaveBlock()
+			code.AddASbx(OP_JMP, 0, endlabel, eline(arm))
+			context.SetLabelPc(nextlabel, code.LastPC())
+			if n > -1 {
+				code.AddABC(OP_CLOSE, n, 0, 0, eline(arm))
+			}
+		}
+	}
+	context.SetLabelPc(endlabel, code.LastPC())
+	context.LeaveBlock()
+} // }}}
+
+// matchJumpTableKeys returns the values matched by the arms of stmt when it
+// can be compiled to an OP_JMPTABLE: at least two arms match a string, number
+// or boolean literal without a guard, optionally followed by a single '_' arm.
+// It returns nil otherwise.
+func matchJumpTableKeys(stmt *ast.MatchStmt) []LValue { // {{{
+	keys := []LValue{}
+	for i, arm := range stmt.Arms {
+		if arm.Guard != nil {
+			return nil
+		}
+		if ident, ok := arm.Pattern.(*ast.IdentExpr); ok && ident.Value == "_" && i == len(stmt.Arms)-1 {
+			break
+		}
+		switch arm.Pattern.(type) {
+		case *ast.StringExpr, *ast.NumberExpr, *ast.TrueExpr, *ast.FalseExpr:
+			key, _ := literalValue(arm.Pattern)
+			keys = append(keys, key)
+		default:
+			return nil
+		}
+	}
+	if len(keys) < 2 {
+		return nil
+	}
+	return keys
+} // }}}
+
+func compileMatchJumpTable(context *funcContext, stmt *ast.MatchStmt, value int, keys []LValue, endlabel int) { // {{{
+	// the table holds labels until patchCode resolves them to pcs
+	code := context.Code
+	table := make(map[LValue]int, len(keys))
+	code.AddABx(OP_JMPTABLE, value, len(context.Proto.JumpTables), sline(stmt))
+	context.Proto.JumpTables = append(context.Proto.JumpTables, table)
+	defaultlabel := endlabel
+	if len(stmt.Arms) > len(keys) {
+		defaultlabel = context.NewLabel()
+	}
+	code.AddASbx(OP_JMP, 0, defaultlabel, sline(stmt))
+	for i, arm := range stmt.Arms {
+		if i < len(keys) {
+			label := context.NewLabel()
+			context.SetLabelPc(label, code.LastPC())
+			// the first of duplicate arms wins
+			key := normalizeKey(keys[i])
+			if _, dup := table[key]; !dup {
+				table[key] = label
+			}
+		} else {
+			context.SetLabelPc(defaultlabel, code.LastPC())
+		}
+		compileBlock(context, arm.Stmts)
+		code.AddASbx(OP_JMP, 0, endlabel, eline(arm))
+	}
+} // }}}
+
+// literalValue returns the value of a literal expression.
+func literalValue(pattern ast.Expr) (LValue, bool) { // {{{
+	switch pat := pattern.(type) {
+	case *ast.NilExpr:
+		return LNil, true
+	case *ast.TrueExpr:
+		return LTrue, true
+	case *ast.FalseExpr:
+		return LFalse, true
+	case *ast.StringExpr:
+		return LString(pat.Value), true
+	case *ast.NumberExpr:
+		num, err := parseNumberValue(pat.Value)
+		if err != nil {
+			num = LNumber(math.NaN())
+		}
+		return num, true
+	case *constLValueExpr:
+		return pat.Value, true
+	}
+	return LNil, false
+} // }}}
+
+// patternBindings returns the names bound by pattern in the order
+// compilePattern stores them.
+func patternBindings(pattern ast.Expr) []string { // {{{
+	switch pat := pattern.(type) {
+	case *ast.IdentExpr:
+		if pat.Value != "_" {
+			return []string{pat.Value}
+		}
+	case *ast.TypePattern:
+		if pat.Name != "_" {
+			return []string{pat.Name}
+		}
+	case *ast.TableExpr:
+		names := []string{}
+		for _, field := range pat.Fields {
+			names = append(names, patternBindings(field.Value)...)
+		}
+		return names
+	}
+	return nil
+} // }}}
+
+// compilePattern emits the tests of pattern against R(v) that jump to
+// faillabel when it does not match. Bound values are moved to the registers
+// from *slot on and temporaries are allocated from tmp.
+func compilePattern(context *funcContext, pattern ast.Expr, v int, slot *int, tmp int, faillabel int) { // {{{
+	code := context.Code
+	line := sline(pattern)
+	bind := func() {
+		code.AddABC(OP_MOVE, *slot, v, 0, line)
+		*slot++
+	}
+	switch pat := pattern.(type) {
+	case *ast.NilExpr:
+		compileNilJump(context, &tmp, pat, v, 0, faillabel)
+	case *ast.IdentExpr:
+		// a name matches any non-nil value, '_' matches anything
+		if pat.Value != "_" {
+			compileNilJump(context, &tmp, pat, v, 1, faillabel)
+			bind()
+		}
+	case *ast.TypePattern:
+		mask := typeHintMask(context, pat.Type, fmt.Sprintf("pattern '%v'", pat.Name))
+		code.AddABC(OP_TESTTYPE, v, mask, 0, line)
+		code.AddASbx(OP_JMP, 0, faillabel, line)
+		if pat.Name != "_" {
+			bind()
+		}
+	case *ast.RangePattern:
+		code.AddABC(OP_TESTTYPE, v, 1<<uint(LTNumber), 0, line)
+		code.AddASbx(OP_JMP, 0, faillabel, line)
+		lo, _ := literalValue(pat.Lo)
+		code.AddABC(OP_LE, 0, loadRk(context, &tmp, pat.Lo, lo), v, line)
+		code.AddASbx(OP_JMP, 0, faillabel, line)
+		hi, _ := literalValue(pat.Hi)
+		code.AddABC(OP_LE, 0, v, loadRk(context, &tmp, pat.Hi, hi), line)
+		code.AddASbx(OP_JMP, 0, faillabel, line)
+	case *ast.TableExpr:
+		code.AddABC(OP_TESTTYPE, v, 1<<uint(LTTable), 0, line)
+		code.AddASbx(OP_JMP, 0, faillabel, line)
+		index := 0
+		for _, field := range pat.Fields {
+			key := field.Key
+			if key == nil {
+				index++
+				key = &ast.NumberExpr{Value: fmt.Sprint(index)}
+				key.SetLine(sline(field.Value))
+			}
+			keyreg, k := tmp, tmp
+			compileExprWithKMVPropagation(context, key, &keyreg, &k)
+			opcode := OP_GETTABLE
+			if _, ok := key.(*ast.StringExpr); ok {
+				opcode = OP_GETTABLEKS
+			}
+			code.AddABC(opcode, tmp, v, k, sline(field.Value))
+			compilePattern(context, field.Value, tmp, slot, tmp+1, faillabel)
+		}
+	default:
+		cnst, ok := literalValue(pattern)
+		if !ok {
+			raiseCompileError(context, line, "invalid pattern")
+		}
+		code.AddABC(OP_EQ, 0, v, loadRk(context, &tmp, pattern, cnst), line)
+		code.AddASbx(OP_JMP, 0, faillabel, line)
+	}
+} // }}}
+
 func compileBranchCondition(context *funcContext, reg int, expr ast.Expr, thenlabel, elselabel int, hasnextcond bool) { // {{{
 	// TODO folding constants?
 	code := context.Code
@@ -984,7 +1492,7 @@ func compileWhileStmt(context *funcContext, stmt *ast.WhileStmt) { // {{{
 	context.SetLabelPc(condlabel, context.Code.LastPC())
 	compileBranchCondition(context, context.RegTop(), stmt.Condition, thenlabel, elselabel, false)
 	context.SetLabelPc(thenlabel, context.Code.LastPC())
-	context.EnterBlock(elselabel, stmt)
+	context.EnterLoopBlock(elselabel, condlabel, stmt)
 	compileChunk(context, stmt.Stmts, false)
 	context.CloseUpvalues()
 	context.Code.AddASbx(OP_JMP, 0, condlabel, eline(stmt))
@@ -996,11 +1504,17 @@ func compileRepeatStmt(context *funcContext, stmt *ast.RepeatStmt) { // {{{
 	initlabel := context.NewLabel()
 	thenlabel := context.NewLabel()
 	elselabel := context.NewLabel()
+	condlabel := context.NewLabel()
 
 	context.SetLabelPc(initlabel, context.Code.LastPC())
 	context.SetLabelPc(elselabel, context.Code.LastPC())
-	context.EnterBlock(thenlabel, stmt)
+	context.EnterLoopBlock(thenlabel, condlabel, stmt)
+	context.Block.untilScope = true
 	compileChunk(context, stmt.Stmts, true)
+	if len(context.Block.continues) > 0 {
+		checkUntilScope(context, stmt)
+	}
+	context.SetLabelPc(condlabel, context.Code.LastPC())
 	compileBranchCondition(context, context.RegTop(), stmt.Condition, thenlabel, elselabel, false)
 
 	context.SetLabelPc(thenlabel, context.Code.LastPC())
@@ -1018,9 +1532,11 @@ func compileRepeatStmt(context *funcContext, stmt *ast.RepeatStmt) { // {{{
 } // }}}
 
 func compileBreakStmt(context *funcContext, stmt *ast.BreakStmt) { // {{{
+	refupvalue := false
 	for block := context.Block; block != nil; block = block.Parent {
+		refupvalue = refupvalue || block.RefUpvalue
 		if label := block.BreakLabel; label != labelNoJump {
-			if block.RefUpvalue {
+			if refupvalue {
 				context.Code.AddABC(OP_CLOSE, block.Parent.LocalVars.LastIndex(), 0, 0, sline(stmt))
 			}
 			context.Code.AddASbx(OP_JMP, 0, label, sline(stmt))
@@ -1030,6 +1546,104 @@ func compileBreakStmt(context *funcContext, stmt *ast.BreakStmt) { // {{{
 	raiseCompileError(context, sline(stmt), "no loop to break")
 } // }}}
 
+func compileContinueStmt(context *funcContext, stmt *ast.ContinueStmt) { // {{{
+	for block := context.Block; block != nil; block = block.Parent {
+		if label := block.ContinueLabel; label != labelNoJump {
+			n := block.Parent.LocalVars.LastIndex()
+			if block.untilScope {
+				// locals of a repeat body stay alive for the until condition
+				n = block.LocalVars.LastIndex()
+			}
+			if len(block.continues) == 0 {
+				block.continueVars = len(block.LocalVars.Names())
+			}
+			block.continues = append(block.continues, stmt)
+			context.Code.AddABC(OP_CLOSE, n, 0, 0, sline(stmt))
+			block.continuePcs = append(block.continuePcs, context.Code.LastPC())
+			context.Code.AddASbx(OP_JMP, 0, label, sline(stmt))
+			return
+		}
+	}
+	raiseCompileError(context, sline(stmt), "no loop to continue")
+} // }}}
+
+func checkUntilScope(context *funcContext, stmt *ast.RepeatStmt) { // {{{
+	block := context.Block
+	names := block.LocalVars.Names()[block.continueVars:]
+	if len(names) == 0 {
+		return
+	}
+	ident := findIdentExpr(stmt.Condition, func(ident *ast.IdentExpr) bool {
+		for _, name := range names {
+			if name == ident.Value {
+				return true
+			}
+		}
+		return false
+	})
+	if ident != nil {
+		raiseCompileError(context, sline(ident), "local '%v' used in the 'until' condition is declared after 'continue' at line %v",
+			ident.Value, sline(block.continues[0]))
+	}
+} // }}}
+
+// findIdentExpr returns the first identifier in expr that satisfies fn.
+// Bodies of nested functions are not searched.
+func findIdentExpr(expr ast.Expr, fn func(*ast.IdentExpr) bool) *ast.IdentExpr { // {{{
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		if fn(ex) {
+			return ex
+		}
+	case *ast.AttrGetExpr:
+		return findIdentExprs(fn, ex.Object, ex.Key)
+	case *ast.TableExpr:
+		for _, field := range ex.Fields {
+			if ident := findIdentExprs(fn, field.Key, field.Value); ident != nil {
+				return ident
+			}
+		}
+	case *ast.FuncCallExpr:
+		if ident := findIdentExprs(fn, ex.Func, ex.Receiver); ident != nil {
+			return ident
+		}
+		return findIdentExprs(fn, ex.Args...)
+	case *ast.LogicalOpExpr:
+		return findIdentExprs(fn, ex.Lhs, ex.Rhs)
+	case *ast.NilCoalesceOpExpr:
+		return findIdentExprs(fn, ex.Lhs, ex.Rhs)
+	case *ast.RelationalOpExpr:
+		return findIdentExprs(fn, ex.Lhs, ex.Rhs)
+	case *ast.StringConcatOpExpr:
+		return findIdentExprs(fn, ex.Lhs, ex.Rhs)
+	case *ast.InterpolatedStringExpr:
+		return findIdentExprs(fn, ex.Parts...)
+	case *ast.ArithmeticOpExpr:
+		return findIdentExprs(fn, ex.Lhs, ex.Rhs)
+	case *ast.UnaryMinusOpExpr:
+		return findIdentExpr(ex.Expr, fn)
+	case *ast.UnaryNotOpExpr:
+		return findIdentExpr(ex.Expr, fn)
+	case *ast.UnaryLenOpExpr:
+		return findIdentExpr(ex.Expr, fn)
+	case *ast.UnaryBNotOpExpr:
+		return findIdentExpr(ex.Expr, fn)
+	}
+	return nil
+} // }}}
+
+func findIdentExprs(fn func(*ast.IdentExpr) bool, exprs ...ast.Expr) *ast.IdentExpr {
+	for _, expr := range exprs {
+		if expr == nil {
+			continue
+		}
+		if ident := findIdentExpr(expr, fn); ident != nil {
+			return ident
+		}
+	}
+	return nil
+}
+
 func compileFuncDefStmt(context *funcContext, stmt *ast.FuncDefStmt) { // {{{
 	if stmt.Name.Func == nil {
 		reg := context.RegTop()
@@ -1046,12 +1660,53 @@ func compileFuncDefStmt(context *funcContext, stmt *ast.FuncDefStmt) { // {{{
 	}
 } // }}}
 
+func compileClassStmt(context *funcContext, stmt *ast.ClassStmt) { // {{{
+	// the base class is kept in a hidden local, so that methods can refer to
+	// it as an upvalue through 'super'.
+	code := context.Code
+	line := sline(stmt)
+	if stmt.Local {
+		context.RegisterLocalVar(stmt.Name)
+	}
+	context.EnterBlock(labelNoJump, stmt)
+	reg := context.RegTop()
+	if stmt.Base != nil {
+		compileExpr(context, reg, stmt.Base, ecnone(0))
+	} else {
+		code.AddLoadNil(reg, reg, line)
+	}
+	base := context.RegisterLocalVar("(super)")
+	class := context.RegisterLocalVar("(class)")
+	code.SetColumn(stmt.Column())
+	code.AddABC(OP_MOVE, class, base, 0, line)
+	code.AddABx(OP_CLASS, class, context.ConstIndex(LString(stmt.Name)), line)
+	for _, member := range stmt.Members {
+		reg := context.RegTop()
+		kreg := loadRk(context, &reg, member.Value, LString(member.Name))
+		ec := ecnone(0)
+		if _, ok := member.Value.(*ast.FunctionExpr); ok && !member.Static {
+			ec = ecfuncdef
+		}
+		compileExpr(context, reg, member.Value, ec)
+		code.AddABC(OP_SETTABLE, class, kreg, reg, sline(member))
+	}
+	name := &ast.IdentExpr{Value: stmt.Name}
+	name.SetLine(line)
+	value := &ast.IdentExpr{Value: "(class)"}
+	value.SetLine(line)
+	astmt := &ast.AssignStmt{Lhs: []ast.Expr{name}, Rhs: []ast.Expr{value}}
+	astmt.SetLine(line)
+	compileAssignStmt(context, astmt)
+	context.LeaveBlock()
+} // }}}
+
 func compileNumberForStmt(context *funcContext, stmt *ast.NumberForStmt) { // {{{
 	code := context.Code
 	endlabel := context.NewLabel()
+	contlabel := context.NewLabel()
 	ec := &expcontext{}
 
-	context.EnterBlock(endlabel, stmt)
+	context.EnterLoopBlock(endlabel, contlabel, stmt)
 	reg := context.RegTop()
 	rindex := context.RegisterLocalVar("(for index)")
 	ecupdate(ec, ecLocal, rindex, 0)
@@ -1071,6 +1726,7 @@ func compileNumberForStmt(context *funcContext, stmt *ast.NumberForStmt) { // {{
 	ecupdate(ec, ecLocal, rstep, 0)
 	compileExpr(context, reg, stmt.Step, ec)
 
+	code.SetColumn(stmt.Column())
 	code.AddASbx(OP_FORPREP, rindex, 0, sline(stmt))
 
 	context.RegisterLocalVar(stmt.Name)
@@ -1081,6 +1737,7 @@ func compileNumberForStmt(context *funcContext, stmt *ast.NumberForStmt) { // {{
 	context.LeaveBlock()
 
 	flpc := code.LastPC()
+	context.SetLabelPc(contlabel, flpc)
 	code.AddASbx(OP_FORLOOP, rindex, bodypc-(flpc+1), sline(stmt))
 
 	context.SetLabelPc(endlabel, code.LastPC())
@@ -1095,7 +1752,7 @@ func compileGenericForStmt(context *funcContext, stmt *ast.GenericForStmt) { //
 	fllabel := context.NewLabel()
 	nnames := len(stmt.Names)
 
-	context.EnterBlock(endlabel, stmt)
+	context.EnterLoopBlock(endlabel, fllabel, stmt)
 	rgen := context.RegisterLocalVar("(for generator)")
 	context.RegisterLocalVar("(for state)")
 	context.RegisterLocalVar("(for control)")
@@ -1114,6 +1771,7 @@ func compileGenericForStmt(context *funcContext, stmt *ast.GenericForStmt) { //
 	context.LeaveBlock()
 
 	context.SetLabelPc(fllabel, code.LastPC())
+	code.SetColumn(stmt.Column())
 	code.AddABC(OP_TFORLOOP, rgen, 0, nnames, sline(stmt))
 	code.AddASbx(OP_JMP, 0, bodylabel, sline(stmt))
 
@@ -1131,7 +1789,8 @@ func compileLabelStmt(context *funcContext, stmt *ast.LabelStmt, isLastStmt bool
 } // }}}
 
 func compileGotoStmt(context *funcContext, stmt *ast.GotoStmt) { // {{{
-	context.Code.AddABC(OP_CLOSE, 0, 0, 0, sline(stmt))
+	// closes nothing unless the jump leaves the scope of a local
+	context.Code.AddABC(OP_CLOSE, context.BlockLocalVarsCount(), 0, 0, sline(stmt))
 	context.Code.AddASbx(OP_JMP, 0, labelNoJump, sline(stmt))
 	label := newLabelDesc(-1, stmt.Label, context.Code.LastPC(), sline(stmt), context.BlockLocalVarsCount())
 	context.AddUnresolvedGoto(label)
@@ -1151,7 +1810,7 @@ func compileExpr(context *funcContext, reg int, expr ast.Expr, ec *expcontext) i
 		code.AddABx(OP_LOADK, sreg, context.ConstIndex(LString(ex.Value)), sline(ex))
 		return sused
 	case *ast.NumberExpr:
-		num, err := parseNumber(ex.Value)
+		num, err := parseNumberValue(ex.Value)
 		if err != nil {
 			num = LNumber(math.NaN())
 		}
@@ -1160,6 +1819,13 @@ func compileExpr(context *funcContext, reg int, expr ast.Expr, ec *expcontext) i
 	case *constLValueExpr:
 		code.AddABx(OP_LOADK, sreg, context.ConstIndex(ex.Value), sline(ex))
 		return sused
+	case *ast.SuperExpr:
+		super := &ast.IdentExpr{Value: "(super)"}
+		super.SetLine(sline(ex))
+		if getIdentRefType(context, context, super) == ecGlobal {
+			raiseCompileError(context, sline(ex), "'super' outside of a class method")
+		}
+		return compileExpr(context, reg, super, ec)
 	case *ast.NilExpr:
 		code.AddLoadNil(sreg, sreg, sline(ex))
 		return sused
@@ -1194,13 +1860,21 @@ func compileExpr(context *funcContext, reg int, expr ast.Expr, ec *expcontext) i
 		a := sreg
 		b := reg
 		compileExprWithMVPropagation(context, ex.Object, &reg, &b)
+		endlabel := -1
+		if ex.Optional { // hoge?.key
+			endlabel = compileOptionalJump(context, &reg, ex, b, a, a, false)
+		}
 		c := reg
 		compileExprWithKMVPropagation(context, ex.Key, &reg, &c)
 		opcode := OP_GETTABLE
 		if _, ok := ex.Key.(*ast.StringExpr); ok {
 			opcode = OP_GETTABLEKS
 		}
+		code.SetColumn(scol(ex))
 		code.AddABC(opcode, a, b, c, sline(ex))
+		if endlabel > -1 {
+			context.SetLabelPc(endlabel, code.LastPC())
+		}
 		return sused
 	case *ast.TableExpr:
 		compileTableExpr(context, reg, ex, ec)
@@ -1211,7 +1885,10 @@ func compileExpr(context *funcContext, reg int, expr ast.Expr, ec *expcontext) i
 	case *ast.StringConcatOpExpr:
 		compileStringConcatOpExpr(context, reg, ex, ec)
 		return sused
-	case *ast.UnaryMinusOpExpr, *ast.UnaryNotOpExpr, *ast.UnaryLenOpExpr:
+	case *ast.InterpolatedStringExpr:
+		compileInterpolatedStringExpr(context, reg, ex, ec)
+		return sused
+	case *ast.UnaryMinusOpExpr, *ast.UnaryNotOpExpr, *ast.UnaryLenOpExpr, *ast.UnaryBNotOpExpr:
 		compileUnaryOpExpr(context, reg, ex, ec)
 		return sused
 	case *ast.RelationalOpExpr:
@@ -1220,6 +1897,9 @@ func compileExpr(context *funcContext, reg int, expr ast.Expr, ec *expcontext) i
 	case *ast.LogicalOpExpr:
 		compileLogicalOpExpr(context, reg, ex, ec)
 		return sused
+	case *ast.NilCoalesceOpExpr:
+		compileNilCoalesceOpExpr(context, reg, ex, ec)
+		return sused
 	case *ast.FuncCallExpr:
 		return compileFuncCallExpr(context, reg, ex, ec)
 	case *ast.FunctionExpr:
@@ -1250,10 +1930,11 @@ func compileExpr(context *funcContext, reg int, expr ast.Expr, ec *expcontext) i
 
 func compileExprWithPropagation(context *funcContext, expr ast.Expr, reg *int, save *int, propergator func(int, *int, *int, int)) { // {{{
 	reginc := compileExpr(context, *reg, expr, ecnone(0))
-	if _, ok := expr.(*ast.LogicalOpExpr); ok {
+	switch expr.(type) {
+	case *ast.LogicalOpExpr, *ast.NilCoalesceOpExpr:
 		*save = *reg
 		*reg = *reg + reginc
-	} else {
+	default:
 		propergator(context.RegTop(), save, reg, reginc)
 	}
 } // }}}
@@ -1272,29 +1953,41 @@ func constFold(exp ast.Expr) ast.Expr { // {{{
 		lvalue, lisconst := lnumberValue(constFold(expr.Lhs))
 		rvalue, risconst := lnumberValue(constFold(expr.Rhs))
 		if lisconst && risconst {
-			switch expr.Operator {
-			case "+":
-				return &constLValueExpr{Value: lvalue + rvalue}
-			case "-":
-				return &constLValueExpr{Value: lvalue - rvalue}
-			case "*":
-				return &constLValueExpr{Value: lvalue * rvalue}
-			case "/":
-				return &constLValueExpr{Value: lvalue / rvalue}
-			case "%":
-				return &constLValueExpr{Value: luaModulo(lvalue, rvalue)}
-			case "^":
-				return &constLValueExpr{Value: LNumber(math.Pow(float64(lvalue), float64(rvalue)))}
-			default:
+			opcode, ok := arithOpcodes[expr.Operator]
+			if !ok {
 				panic(fmt.Sprintf("unknown binop: %v", expr.Operator))
 			}
+			if rvalue == LInteger(0) && LVIsInteger(lvalue) && isIntegerOp(opcode) {
+				// leave the error to the runtime
+				return expr
+			}
+			if isBitwiseOp(opcode) {
+				_, lok := lvToInteger(lvalue)
+				_, rok := lvToInteger(rvalue)
+				if !lok || !rok {
+					return expr
+				}
+			}
+			value, _ := arithNumbers(nil, opcode, lvalue, rvalue)
+			return &constLValueExpr{Value: value}
 		} else {
 			return expr
 		}
 	case *ast.UnaryMinusOpExpr:
 		expr.Expr = constFold(expr.Expr)
 		if value, ok := lnumberValue(expr.Expr); ok {
-			return &constLValueExpr{Value: LNumber(-value)}
+			if iv, ok := value.(LInteger); ok {
+				return &constLValueExpr{Value: -iv}
+			}
+			return &constLValueExpr{Value: -value.(LNumber)}
+		}
+		return expr
+	case *ast.UnaryBNotOpExpr:
+		expr.Expr = constFold(expr.Expr)
+		if value, ok := lnumberValue(expr.Expr); ok {
+			if iv, ok := lvToInteger(value); ok {
+				return &constLValueExpr{Value: ^iv}
+			}
 		}
 		return expr
 	default:
@@ -1314,8 +2007,20 @@ func compileFunctionExpr(context *funcContext, funcexpr *ast.FunctionExpr, ec *e
 		context.Proto.NumParameters += 1
 		context.RegisterLocalVar("self")
 	}
-	for _, name := range funcexpr.ParList.Names {
-		context.RegisterLocalVar(name)
+	funcname := funcexpr.Name
+	if len(funcname) == 0 {
+		funcname = fmt.Sprintf("<%v:%v>", context.Proto.SourceName, context.Proto.LineDefined)
+	}
+	if ec.ctype == ecMethod {
+		context.Proto.Params = append(context.Proto.Params, ParamInfo{Name: "self"})
+	}
+	regs := make([]int, len(funcexpr.ParList.Names))
+	for i, name := range funcexpr.ParList.Names {
+		regs[i] = context.RegisterLocalVar(name)
+		context.Proto.Params = append(context.Proto.Params, ParamInfo{Name: name})
+	}
+	for i, hint := range funcexpr.ReturnTypes {
+		context.returnChecks = append(context.returnChecks, newTypeCheck(context, hint, fmt.Sprintf("return value #%v from '%v'", i+1, funcname)))
 	}
 	if funcexpr.ParList.HasVargs {
 		if CompatVarArg {
@@ -1326,14 +2031,33 @@ func compileFunctionExpr(context *funcContext, funcexpr *ast.FunctionExpr, ec *e
 		}
 		context.Proto.IsVarArg |= VarArgIsVarArg
 	}
+	// defaults can refer to all parameters and are applied before the
+	// parameters are type checked
+	params := context.Proto.Params[len(context.Proto.Params)-len(regs):]
+	for i, name := range funcexpr.ParList.Names {
+		if i < len(funcexpr.ParList.Defaults) && funcexpr.ParList.Defaults[i] != nil {
+			compileParamDefault(context, funcexpr.ParList.Defaults[i], regs[i], &params[i])
+		}
+		if i < len(funcexpr.ParList.Types) && funcexpr.ParList.Types[i] != nil {
+			check := newTypeCheck(context, funcexpr.ParList.Types[i], fmt.Sprintf("argument #%v '%v' to '%v'", i+1, name, funcname))
+			context.Code.AddABC(OP_TYPECHECK, regs[i], check.mask, check.kname, sline(funcexpr))
+		}
+	}
 
 	compileChunk(context, funcexpr.Stmts, false)
 
-	context.Code.AddABC(OP_RETURN, 0, 1, 0, eline(funcexpr))
+	if len(context.returnChecks) > 0 {
+		ret := &ast.ReturnStmt{}
+		ret.SetLine(eline(funcexpr))
+		compileTypedReturnStmt(context, ret)
+	} else {
+		context.Code.AddABC(OP_RETURN, 0, 1, 0, eline(funcexpr))
+	}
 	context.EndScope()
 	context.CheckUnresolvedGoto()
 	context.Proto.Code = context.Code.List()
 	context.Proto.DbgSourcePositions = context.Code.PosList()
+	context.Proto.DbgSourceColumns = context.Code.ColumnList()
 	context.Proto.DbgUpvalues = context.Upvalues.Names()
 	context.Proto.NumUpvalues = uint8(len(context.Proto.DbgUpvalues))
 	for _, clv := range context.Proto.Constants {
@@ -1346,6 +2070,46 @@ func compileFunctionExpr(context *funcContext, funcexpr *ast.FunctionExpr, ec *e
 	patchCode(context)
 } // }}}
 
+func newTypeCheck(context *funcContext, hint *ast.TypeHint, what string) typeCheck { // {{{
+	mask := typeHintMask(context, hint, what)
+	kname := context.ConstIndex(LString(what))
+	if kname > opMaxArgsC {
+		raiseCompileError(context, sline(hint), "too many constants")
+	}
+	return typeCheck{mask, kname}
+} // }}}
+
+// compileParamDefault assigns the default value expr to the parameter in R(reg)
+// when the argument is nil and records it in param.
+func compileParamDefault(context *funcContext, expr ast.Expr, reg int, param *ParamInfo) { // {{{
+	code := context.Code
+	param.HasDefault = true
+	if value, ok := literalValue(constFold(expr)); ok {
+		param.Default = value
+	}
+	skiplabel := context.NewLabel()
+	tmp := context.RegTop()
+	compileNilJump(context, &tmp, expr, reg, 0, skiplabel)
+	// a call in R(reg) could clobber the following parameters
+	value := tmp
+	compileExprWithMVPropagation(context, expr, &tmp, &value)
+	code.AddABC(OP_MOVE, reg, value, 0, sline(expr))
+	context.SetLabelPc(skiplabel, code.LastPC())
+} // }}}
+
+// typeHintMask returns the set of value types accepted by hint as a bit mask.
+func typeHintMask(context *funcContext, hint *ast.TypeHint, what string) int { // {{{
+	typ, ok := lValueTypeByName(hint.Name)
+	if !ok {
+		raiseCompileError(context, sline(hint), "unknown type '%v' for %v", hint.Name, what)
+	}
+	mask := 1 << uint(typ)
+	if hint.Nullable {
+		mask |= 1 << uint(LTNil)
+	}
+	return mask
+} // }}}
+
 func compileTableExpr(context *funcContext, reg int, ex *ast.TableExpr, ec *expcontext) { // {{{
 	code := context.Code
 	/*
@@ -1382,6 +2146,7 @@ func compileTableExpr(context *funcContext, reg int, ex *ast.TableExpr, ec *expc
 			if _, ok := field.Key.(*ast.StringExpr); ok {
 				opcode = OP_SETTABLEKS
 			}
+			code.SetColumn(field.Key.Column())
 			code.AddABC(opcode, tablereg, b, c, sline(ex))
 			reg = regorg
 		}
@@ -1417,6 +2182,22 @@ func compileTableExpr(context *funcContext, reg int, ex *ast.TableExpr, ec *expc
 	}
 } // }}}
 
+// arithOpcodes maps binary arithmetic and bitwise operators to their opcodes.
+var arithOpcodes = map[string]int{
+	"+":  OP_ADD,
+	"-":  OP_SUB,
+	"*":  OP_MUL,
+	"/":  OP_DIV,
+	"%":  OP_MOD,
+	"^":  OP_POW,
+	"//": OP_IDIV,
+	"&":  OP_BAND,
+	"|":  OP_BOR,
+	"~":  OP_BXOR,
+	"<<": OP_SHL,
+	">>": OP_SHR,
+}
+
 func compileArithmeticOpExpr(context *funcContext, reg int, expr *ast.ArithmeticOpExpr, ec *expcontext) { // {{{
 	exp := constFold(expr)
 	if ex, ok := exp.(*constLValueExpr); ok {
@@ -1431,22 +2212,8 @@ func compileArithmeticOpExpr(context *funcContext, reg int, expr *ast.Arithmetic
 	c := reg
 	compileExprWithKMVPropagation(context, expr.Rhs, &reg, &c)
 
-	op := 0
-	switch expr.Operator {
-	case "+":
-		op = OP_ADD
-	case "-":
-		op = OP_SUB
-	case "*":
-		op = OP_MUL
-	case "/":
-		op = OP_DIV
-	case "%":
-		op = OP_MOD
-	case "^":
-		op = OP_POW
-	}
-	context.Code.AddABC(op, a, b, c, sline(expr))
+	context.Code.SetColumn(scol(expr))
+	context.Code.AddABC(arithOpcodes[expr.Operator], a, b, c, sline(expr))
 } // }}}
 
 func compileStringConcatOpExpr(context *funcContext, reg int, expr *ast.StringConcatOpExpr, ec *expcontext) { // {{{
@@ -1467,9 +2234,29 @@ func compileStringConcatOpExpr(context *funcContext, reg int, expr *ast.StringCo
 	for pc := code.LastPC(); pc != 0 && opGetOpCode(code.At(pc)) == OP_CONCAT; pc-- {
 		code.Pop()
 	}
+	code.SetColumn(scol(expr))
 	code.AddABC(OP_CONCAT, a, basereg, basereg+crange, sline(expr))
 } // }}}
 
+func compileInterpolatedStringExpr(context *funcContext, reg int, expr *ast.InterpolatedStringExpr, ec *expcontext) { // {{{
+	code := context.Code
+	a := savereg(ec, reg)
+	basereg := reg
+	for _, part := range expr.Parts {
+		compileExpr(context, reg, part, ecnone(0))
+		if _, ok := part.(*ast.StringExpr); !ok {
+			code.SetColumn(scol(part))
+			code.AddABC(OP_TOSTRING, reg, reg, 0, sline(part))
+		}
+		reg++
+	}
+	if len(expr.Parts) == 1 {
+		code.AddABC(OP_MOVE, a, basereg, 0, sline(expr))
+		return
+	}
+	code.AddABC(OP_CONCAT, a, basereg, reg-1, sline(expr))
+} // }}}
+
 func compileUnaryOpExpr(context *funcContext, reg int, expr ast.Expr, ec *expcontext) { // {{{
 	opcode := 0
 	code := context.Code
@@ -1500,11 +2287,22 @@ func compileUnaryOpExpr(context *funcContext, reg int, expr ast.Expr, ec *expcon
 	case *ast.UnaryLenOpExpr:
 		opcode = OP_LEN
 		operandexpr = ex.Expr
+	case *ast.UnaryBNotOpExpr:
+		exp := constFold(ex)
+		if lvexpr, ok := exp.(*constLValueExpr); ok {
+			exp.SetLine(sline(expr))
+			compileExpr(context, reg, lvexpr, ec)
+			return
+		}
+		ex, _ = exp.(*ast.UnaryBNotOpExpr)
+		operandexpr = ex.Expr
+		opcode = OP_BNOT
 	}
 
 	a := savereg(ec, reg)
 	b := reg
 	compileExprWithMVPropagation(context, operandexpr, &reg, &b)
+	code.SetColumn(scol(expr))
 	code.AddABC(opcode, a, b, 0, sline(expr))
 } // }}}
 
@@ -1514,6 +2312,7 @@ func compileRelationalOpExprAux(context *funcContext, reg int, expr *ast.Relatio
 	compileExprWithKMVPropagation(context, expr.Lhs, &reg, &b)
 	c := reg
 	compileExprWithKMVPropagation(context, expr.Rhs, &reg, &c)
+	code.SetColumn(scol(expr))
 	switch expr.Operator {
 	case "<":
 		code.AddABC(OP_LT, 0^flip, b, c, sline(expr))
@@ -1572,6 +2371,52 @@ func compileLogicalOpExpr(context *funcContext, reg int, expr *ast.LogicalOpExpr
 	context.SetLabelPc(endlabel, code.LastPC())
 } // }}}
 
+func compileNilCoalesceOpExpr(context *funcContext, reg int, expr *ast.NilCoalesceOpExpr, ec *expcontext) { // {{{
+	a := savereg(ec, reg)
+	code := context.Code
+	endlabel := context.NewLabel()
+	compileExpr(context, reg, expr.Lhs, ecnone(0))
+	tmp := reg + 1
+	compileNilJump(context, &tmp, expr, reg, 0, endlabel)
+	compileExpr(context, reg, expr.Rhs, ecnone(0))
+	context.SetLabelPc(endlabel, code.LastPC())
+	if a != reg {
+		code.AddABC(OP_MOVE, a, reg, 0, sline(expr))
+	}
+} // }}}
+
+// compileOptionalJump emits the nil check of an optional access on R(r).
+// When R(r) is nil, R(from) to R(to) are set to nil and the returned label,
+// which must be placed right after the access, is jumped to. Nothing is
+// set when to < from or R(r) is the only register to set. With multret the
+// top is also set after R(to), as a call returning all its values does.
+func compileOptionalJump(context *funcContext, reg *int, expr ast.Expr, r int, from int, to int, multret bool) int { // {{{
+	code := context.Code
+	endlabel := context.NewLabel()
+	if !multret && (to < from || (from == r && to == r)) {
+		compileNilJump(context, reg, expr, r, 1, endlabel)
+		return endlabel
+	}
+	accesslabel := context.NewLabel()
+	compileNilJump(context, reg, expr, r, 0, accesslabel)
+	settop := 0
+	if multret {
+		settop = 1
+	}
+	code.AddABC(OP_LOADNIL, from, to, settop, sline(expr))
+	code.AddASbx(OP_JMP, 0, endlabel, sline(expr))
+	context.SetLabelPc(accesslabel, code.LastPC())
+	return endlabel
+} // }}}
+
+// compileNilJump emits a jump to label taken when R(r) is nil (cond 1) or
+// not nil (cond 0).
+func compileNilJump(context *funcContext, reg *int, expr ast.Expr, r int, cond int, label int) { // {{{
+	k := loadRk(context, reg, expr, LNil)
+	context.Code.AddABC(OP_EQ, cond, r, k, sline(expr))
+	context.Code.AddASbx(OP_JMP, 0, label, sline(expr))
+} // }}}
+
 func compileLogicalOpExprAux(context *funcContext, reg int, expr ast.Expr, ec *expcontext, thenlabel, elselabel int, hasnextcond bool, lb *lblabels) { // {{{
 	// TODO folding constants?
 	code := context.Code
@@ -1677,6 +2522,20 @@ func compileLogicalOpExprAux(context *funcContext, reg int, expr ast.Expr, ec *e
 } // }}}
 
 func compileFuncCallExpr(context *funcContext, reg int, expr *ast.FuncCallExpr, ec *expcontext) int { // {{{
+	if _, ok := expr.Receiver.(*ast.SuperExpr); ok {
+		// super:method(...) calls the method of the base class with the
+		// current self
+		key := &ast.StringExpr{Value: expr.Method}
+		key.SetLine(sline(expr))
+		fn := &ast.AttrGetExpr{Object: expr.Receiver, Key: key}
+		fn.SetLine(sline(expr))
+		self := &ast.IdentExpr{Value: "self"}
+		self.SetLine(sline(expr))
+		call := *expr
+		call.Func, call.Receiver, call.Method = fn, nil, ""
+		call.Args = append([]ast.Expr{self}, expr.Args...)
+		expr = &call
+	}
 	funcreg := reg
 	if ec.ctype == ecLocal && ec.reg == (int(context.Proto.NumParameters)-1) {
 		funcreg = ec.reg
@@ -1685,6 +2544,7 @@ func compileFuncCallExpr(context *funcContext, reg int, expr *ast.FuncCallExpr,
 	argc := len(expr.Args)
 	islastvararg := false
 	name := "(anonymous)"
+	endlabel := -1
 
 	if expr.Func != nil { // hoge.func()
 		reg += compileExpr(context, reg, expr.Func, ecnone(0))
@@ -1692,7 +2552,19 @@ func compileFuncCallExpr(context *funcContext, reg int, expr *ast.FuncCallExpr,
 	} else { // hoge:method()
 		b := reg
 		compileExprWithMVPropagation(context, expr.Receiver, &reg, &b)
+		if expr.Optional { // hoge?:method()
+			last := funcreg + ec.varargopt
+			switch {
+			case ec.varargopt == -1:
+				last = -1
+			case ec.varargopt < -1:
+				// all the results are passed on, a single nil when hoge is nil
+				last = funcreg
+			}
+			endlabel = compileOptionalJump(context, &reg, expr, b, funcreg, last, ec.varargopt < -1)
+		}
 		c := loadRk(context, &reg, expr, LString(expr.Method))
+		context.Code.SetColumn(scol(expr))
 		context.Code.AddABC(OP_SELF, funcreg, b, c, sline(expr))
 		// increments a register for an implicit "self"
 		reg = b + 1
@@ -1716,8 +2588,16 @@ func compileFuncCallExpr(context *funcContext, reg int, expr *ast.FuncCallExpr,
 	if islastvararg {
 		b = 0
 	}
+	context.Code.SetColumn(scol(expr))
+	if expr.NamedArgs {
+		context.Code.AddABC(OP_NAMEDARGS, funcreg, argc, 0, sline(expr))
+		b = 0
+	}
 	context.Code.AddABC(OP_CALL, funcreg, b, ec.varargopt+2, sline(expr))
 	context.Proto.DbgCalls = append(context.Proto.DbgCalls, DbgCall{Pc: context.Code.LastPC(), Name: name})
+	if endlabel > -1 {
+		context.SetLabelPc(endlabel, context.Code.LastPC())
+	}
 
 	if ec.varargopt == 0 && shouldmove(ec, funcreg) {
 		context.Code.AddABC(OP_MOVE, ec.reg, funcreg, 0, sline(expr))
@@ -1774,24 +2654,45 @@ func patchCode(context *funcContext) { // {{{
 	}
 	moven := 0
 	code := context.Code.List()
+	// absolute targets of the jumps, before any of them is patched
+	targets := map[int]int{}
+	for pc := 0; pc < len(code); pc++ {
+		switch opGetOpCode(code[pc]) {
+		case OP_CLOSURE:
+			pc += int(context.Proto.FunctionPrototypes[opGetArgBx(code[pc])].NumUpvalues)
+		case OP_JMP:
+			targets[pc] = context.GetLabelPc(opGetArgSbx(code[pc])) + 1
+		}
+	}
 	for pc := 0; pc < len(code); pc++ {
 		inst := code[pc]
 		curop := opGetOpCode(inst)
 		switch curop {
 		case OP_CLOSURE:
+			if reg := opGetArgA(inst); reg > maxreg {
+				maxreg = reg
+			}
 			pc += int(context.Proto.FunctionPrototypes[opGetArgBx(inst)].NumUpvalues)
 			moven = 0
 			continue
 		case OP_SETGLOBAL, OP_SETUPVAL, OP_EQ, OP_LT, OP_LE, OP_TEST,
-			OP_TAILCALL, OP_RETURN, OP_FORPREP, OP_FORLOOP, OP_TFORLOOP,
-			OP_SETLIST, OP_CLOSE:
+			OP_TAILCALL, OP_RETURN, OP_SETLIST, OP_CLOSE:
 			/* nothing to do */
+		case OP_FORPREP, OP_FORLOOP:
+			// the loop sets the control variable R(A+3)
+			if reg := opGetArgA(inst) + 3; reg > maxreg {
+				maxreg = reg
+			}
+		case OP_TFORLOOP:
+			if reg := opGetArgA(inst) + 2 + opGetArgC(inst); reg > maxreg {
+				maxreg = reg
+			}
 		case OP_CALL:
-			if reg := opGetArgA(inst) + opGetArgC(inst) - 2; reg > maxreg {
+			if reg := intMax(opGetArgA(inst), opGetArgA(inst)+opGetArgC(inst)-2); reg > maxreg {
 				maxreg = reg
 			}
 		case OP_VARARG:
-			if reg := opGetArgA(inst) + opGetArgB(inst) - 1; reg > maxreg {
+			if reg := intMax(opGetArgA(inst), opGetArgA(inst)+opGetArgB(inst)-1); reg > maxreg {
 				maxreg = reg
 			}
 		case OP_SELF:
@@ -1802,21 +2703,29 @@ func patchCode(context *funcContext) { // {{{
 			if reg := opGetArgB(inst); reg > maxreg {
 				maxreg = reg
 			}
+		case OP_JMPTABLE:
+			table := context.Proto.JumpTables[opGetArgBx(inst)]
+			for key, label := range table {
+				table[key] = context.GetLabelPc(label) + 1
+			}
+			if reg := opGetArgA(inst); reg > maxreg {
+				maxreg = reg
+			}
 		case OP_JMP: // jump to jump optimization
-			distance := 0
-			count := 0 // avoiding infinite loops
-			for jmp := inst; opGetOpCode(jmp) == OP_JMP && count < 5; jmp = context.Code.At(pc + distance + 1) {
-				d := context.GetLabelPc(opGetArgSbx(jmp)) - pc
-				if d > opMaxArgSbx {
-					if distance == 0 {
-						raiseCompileError(context, context.Proto.LineDefined, "too long to jump.")
+			target := targets[pc]
+			if target-pc-1 > opMaxArgSbx {
+				raiseCompileError(context, context.Proto.LineDefined, "too long to jump.")
+			}
+			if OptimizationLevel > 0 {
+				for count := 0; count < len(code); count++ { // avoiding infinite loops
+					next, ok := targets[target]
+					if !ok || next == target || next-pc-1 > opMaxArgSbx || pc+1-next > opMaxArgSbx {
+						break
 					}
-					break
+					target = next
 				}
-				distance = d
-				count++
 			}
-			if distance == 0 {
+			if distance := target - pc - 1; distance == 0 && OptimizationLevel > 0 {
 				context.Code.SetOpCode(pc, OP_NOP)
 			} else {
 				context.Code.SetSbx(pc, distance)
@@ -1867,3 +2776,45 @@ func Compile(chunk []ast.Stmt, name string) (proto *FunctionProto, err error) {
 	proto = context.Proto
 	return
 } // }}}
+
+// BundleModule is a module registered by a chunk built with Bundle.
+type BundleModule struct {
+	Name  string
+	Proto *FunctionProto
+}
+
+// Bundle returns a chunk that registers the modules in package.preload and
+// then runs main, if any, with the arguments of the chunk. The modules and main
+// are main chunks returned by Compile or Undump.
+func Bundle(name string, modules []BundleModule, main *FunctionProto) (*FunctionProto, error) { // {{{
+	// the chunk is compiled with empty functions that are then replaced
+	stub := func() ast.Expr {
+		return &ast.FunctionExpr{ParList: &ast.ParList{HasVargs: true, Names: []string{}}}
+	}
+	preload := &ast.AttrGetExpr{Object: &ast.IdentExpr{Value: "package"}, Key: &ast.StringExpr{Value: "preload"}}
+	chunk := []ast.Stmt{&ast.LocalAssignStmt{Names: []string{"preload"}, Exprs: []ast.Expr{preload}}}
+	protos := []*FunctionProto{}
+	for _, module := range modules {
+		chunk = append(chunk, &ast.AssignStmt{
+			Lhs: []ast.Expr{&ast.AttrGetExpr{Object: &ast.IdentExpr{Value: "preload"}, Key: &ast.StringExpr{Value: module.Name}}},
+			Rhs: []ast.Expr{stub()},
+		})
+		protos = append(protos, module.Proto)
+	}
+	if main != nil {
+		call := &ast.FuncCallExpr{Func: stub(), Args: []ast.Expr{&ast.Comma3Expr{}}}
+		chunk = append(chunk, &ast.ReturnStmt{Exprs: []ast.Expr{call}})
+		protos = append(protos, main)
+	}
+	proto, err := Compile(chunk, name)
+	if err != nil {
+		return nil, err
+	}
+	for i, fp := range protos {
+		if fp.NumUpvalues != 0 {
+			return nil, fmt.Errorf("%v is not a main chunk", fp.SourceName)
+		}
+		proto.FunctionPrototypes[i] = fp
+	}
+	return proto, nil
+} // }}}
@@ -13,6 +13,10 @@ var CallStackSize = 256
 var MaxTableGetLoop = 100
 var MaxArrayIndex = 67108864
 
+// OptimizationLevel is the level passed to Optimize by LState.Load, 0 disables
+// the optimizations.
+var OptimizationLevel = 1
+
 type LNumber float64
 
 const LNumberBit = 64
@@ -13,6 +13,7 @@ func OpenDatabase(L *LState) int {
 
 	mt := L.NewTypeMetatable("database")
 	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), databaseMethods))
+	L.SetField(mt, "__close", L.NewFunction(dbCloseMeta))
 
 	L.Push(mod)
 	return 1
@@ -80,6 +81,15 @@ func dbClose(L *LState) int {
 	return 1
 }
 
+// dbCloseMeta closes a connection going out of scope as a <close>
+// variable. Closing a closed connection is a no-op.
+func dbCloseMeta(L *LState) int {
+	if err := checkDatabase(L, 1).Close(); err != nil {
+		L.RaiseError("failed to close database: %v", err)
+	}
+	return 0
+}
+
 func dbQuery(L *LState) int {
 	db := getDatabase(L, 1)
 	query := L.CheckString(L.GetTop())
@@ -141,7 +151,7 @@ func dbExec(L *LState) int {
 
 	rowsAffected, _ := res.RowsAffected()
 	L.Push(LBool(true))
-	L.Push(LNumber(rowsAffected))
+	L.Push(LInteger(rowsAffected))
 	return 2
 }
 
@@ -198,7 +208,7 @@ func dbInsert(L *LState) int {
 
 	lastInsertId, _ := result.LastInsertId()
 	L.Push(LBool(true))
-	L.Push(LNumber(lastInsertId))
+	L.Push(LInteger(lastInsertId))
 	return 2
 }
 
@@ -228,7 +238,7 @@ func dbUpdate(L *LState) int {
 
 	rowsAffected, _ := result.RowsAffected()
 	L.Push(LBool(true))
-	L.Push(LNumber(rowsAffected))
+	L.Push(LInteger(rowsAffected))
 	return 2
 }
 
@@ -247,7 +257,7 @@ func dbDelete(L *LState) int {
 
 	rowsAffected, _ := result.RowsAffected()
 	L.Push(LBool(true))
-	L.Push(LNumber(rowsAffected))
+	L.Push(LInteger(rowsAffected))
 	return 2
 }
 
@@ -277,7 +287,10 @@ func luaValueToInterface(v LValue) interface{} {
 	case LTBool:
 		return bool(v.(LBool))
 	case LTNumber:
-		return float64(v.(LNumber))
+		if iv, ok := v.(LInteger); ok {
+			return int64(iv)
+		}
+		return float64(LVAsNumber(v))
 	case LTString:
 		return string(v.(LString))
 	default:
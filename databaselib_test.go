@@ -0,0 +1,29 @@
+package lua
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestDatabaseCloseVariable(t *testing.T) {
+	// sql.Open does not connect, so no server is needed to close the handle
+	db, err := sql.Open("mysql", "user:pass@tcp(localhost:1)/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	L := NewState()
+	defer L.Close()
+	ud := L.NewUserData()
+	ud.Value = db
+	L.SetMetatable(ud, L.GetTypeMetatable("database"))
+	L.SetGlobal("conn", ud)
+	errorIfScriptFail(t, L, `
+	pcall(function()
+		local db <close> = conn
+		error("query failed")
+	end)
+	`)
+	if err := db.Ping(); err == nil || err.Error() != "sql: database is closed" {
+		t.Errorf("database was not closed: %v", err)
+	}
+}
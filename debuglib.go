@@ -13,11 +13,13 @@ func OpenDebug(L *LState) int {
 
 var debugFuncs = map[string]LGFunction{
 	"getfenv":      debugGetFEnv,
+	"gethook":      debugGetHook,
 	"getinfo":      debugGetInfo,
 	"getlocal":     debugGetLocal,
 	"getmetatable": debugGetMetatable,
 	"getupvalue":   debugGetUpvalue,
 	"setfenv":      debugSetFEnv,
+	"sethook":      debugSetHook,
 	"setlocal":     debugSetLocal,
 	"setmetatable": debugSetMetatable,
 	"setupvalue":   debugSetUpvalue,
@@ -29,6 +31,67 @@ func debugGetFEnv(L *LState) int {
 	return 1
 }
 
+// debugThread returns the thread given as first argument of some debug
+// functions, or L, and the index of the next argument.
+func debugThread(L *LState) (*LState, int) {
+	if th, ok := L.Get(1).(*LState); ok {
+		return th, 2
+	}
+	return L, 1
+}
+
+func debugGetHook(L *LState) int {
+	th, _ := debugThread(L)
+	hook := th.hook
+	if hook == nil {
+		L.Push(LNil)
+		return 1
+	}
+	if hook.value != nil {
+		L.Push(hook.value)
+	} else {
+		L.Push(LString("external hook"))
+	}
+	mask := ""
+	for _, c := range []struct {
+		bit  int
+		name string
+	}{{HookMaskCall, "c"}, {HookMaskReturn, "r"}, {HookMaskLine, "l"}} {
+		if hook.mask&c.bit != 0 {
+			mask += c.name
+		}
+	}
+	L.Push(LString(mask))
+	L.Push(LInteger(hook.count))
+	return 3
+}
+
+func debugSetHook(L *LState) int {
+	th, arg := debugThread(L)
+	if L.Get(arg) == LNil {
+		th.hook = nil
+		return 0
+	}
+	fn := L.CheckFunction(arg)
+	mask := 0
+	for _, c := range L.OptString(arg+1, "") {
+		switch c {
+		case 'c':
+			mask |= HookMaskCall
+		case 'r':
+			mask |= HookMaskReturn
+		case 'l':
+			mask |= HookMaskLine
+		}
+	}
+	th.SetHook(luaHook(fn))
+	th.SetHookMask(mask, L.OptInt(arg+2, 0))
+	if th.hook != nil {
+		th.hook.value = fn
+	}
+	return 0
+}
+
 func debugGetInfo(L *LState) int {
 	L.CheckTypes(1, LTFunction, LTNumber)
 	arg1 := L.Get(1)
@@ -41,8 +104,8 @@ func debugGetInfo(L *LState) int {
 	case *LFunction:
 		dbg = &Debug{}
 		fn, err = L.GetInfo(">"+what, dbg, lv)
-	case LNumber:
-		dbg, ok = L.GetStack(int(lv))
+	case LNumber, LInteger:
+		dbg, ok = L.GetStack(L.CheckInt(1))
 		if !ok {
 			L.Push(LNil)
 			return 1
@@ -67,10 +130,29 @@ func debugGetInfo(L *LState) int {
 	tbl.RawSetString("linedefined", LNumber(dbg.LineDefined))
 	tbl.RawSetString("lastlinedefined", LNumber(dbg.LastLineDefined))
 	tbl.RawSetString("func", fn)
+	if lf, ok := fn.(*LFunction); ok && !lf.IsG {
+		tbl.RawSetString("params", debugParams(L, lf.Proto))
+	}
 	L.Push(tbl)
 	return 1
 }
 
+// debugParams lists the parameters of a function as {name=, hasdefault=,
+// default=} tables. default is only set for constant default values.
+func debugParams(L *LState, proto *FunctionProto) *LTable {
+	params := L.CreateTable(len(proto.Params), 0)
+	for _, param := range proto.Params {
+		info := L.CreateTable(0, 3)
+		info.RawSetString("name", LString(param.Name))
+		info.RawSetString("hasdefault", LBool(param.HasDefault))
+		if param.Default != nil {
+			info.RawSetString("default", param.Default)
+		}
+		params.Append(info)
+	}
+	return params
+}
+
 func debugGetLocal(L *LState) int {
 	level := L.CheckInt(1)
 	idx := L.CheckInt(2)
@@ -164,7 +246,7 @@ func debugTraceback(L *LState) int {
 		}
 	}
 
-	traceback := strings.TrimSpace(ls.stackTrace(level))
+	traceback := strings.TrimSpace(ls.stackTrace(level, false))
 	if len(msg) > 0 {
 		traceback = fmt.Sprintf("%s\n%s", msg, traceback)
 	}
@@ -0,0 +1,259 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+/* disassembler {{{ */
+
+// Disassemble returns a listing of the function and its nested functions in
+// the style of 'luac -l -l': every instruction with its source line, operands
+// and the constants, upvalues and jump targets it refers to, followed by the
+// constants, locals and upvalues of the function. Constant operands are
+// printed as -1-index as luac does.
+func (fp *FunctionProto) Disassemble() string {
+	var buf strings.Builder
+	fp.disassemble(&buf)
+	return buf.String()
+}
+
+func (fp *FunctionProto) disassemble(buf *strings.Builder) {
+	kind := "function"
+	if fp.LineDefined == 0 {
+		kind = "main"
+	}
+	plural := func(n int) string {
+		if n == 1 {
+			return ""
+		}
+		return "s"
+	}
+	vararg := ""
+	if fp.IsVarArg != 0 {
+		vararg = "+"
+	}
+	fmt.Fprintf(buf, "%v <%v:%v,%v> (%v instruction%v, %v bytes)\n", kind, fp.SourceName,
+		fp.LineDefined, fp.LastLineDefined, len(fp.Code), plural(len(fp.Code)), len(fp.Code)*4)
+	fmt.Fprintf(buf, "%v%v param%v, %v slot%v, %v upvalue%v, %v local%v, %v constant%v, %v function%v\n",
+		fp.NumParameters, vararg, plural(int(fp.NumParameters)), fp.NumUsedRegisters, plural(int(fp.NumUsedRegisters)),
+		fp.NumUpvalues, plural(int(fp.NumUpvalues)), len(fp.DbgLocals), plural(len(fp.DbgLocals)),
+		len(fp.Constants), plural(len(fp.Constants)), len(fp.FunctionPrototypes), plural(len(fp.FunctionPrototypes)))
+
+	for pc, inst := range fp.Code {
+		line := "-"
+		if l := fp.lineAt(pc); l >= 0 {
+			line = fmt.Sprint(l)
+		}
+		operands, comment := fp.disassembleInst(pc, inst)
+		text := fmt.Sprintf("\t%v\t[%v]\t%-9v\t%v", pc+1, line, opProps[opGetOpCode(inst)].Name, operands)
+		if len(comment) > 0 {
+			text += "\t; " + comment
+		}
+		buf.WriteString(strings.TrimRight(text, " \t"))
+		buf.WriteString("\n")
+	}
+
+	fmt.Fprintf(buf, "constants (%v):\n", len(fp.Constants))
+	for i, constant := range fp.Constants {
+		fmt.Fprintf(buf, "\t%v\t%v\n", i+1, constantString(constant))
+	}
+	fmt.Fprintf(buf, "locals (%v):\n", len(fp.DbgLocals))
+	for i, local := range fp.DbgLocals {
+		fmt.Fprintf(buf, "\t%v\t%v\t%v\t%v\n", i, local.Name, local.StartPc+1, local.EndPc+1)
+	}
+	fmt.Fprintf(buf, "upvalues (%v):\n", len(fp.DbgUpvalues))
+	for i, name := range fp.DbgUpvalues {
+		fmt.Fprintf(buf, "\t%v\t%v\n", i, name)
+	}
+	if len(fp.JumpTables) > 0 {
+		fmt.Fprintf(buf, "jump tables (%v):\n", len(fp.JumpTables))
+		for i, table := range fp.JumpTables {
+			entries := make([]string, 0, len(table))
+			for key, pc := range table {
+				entries = append(entries, fmt.Sprintf("%v => %v", constantString(key), pc+1))
+			}
+			sort.Strings(entries)
+			fmt.Fprintf(buf, "\t%v\t%v\n", i, strings.Join(entries, ", "))
+		}
+	}
+
+	for _, proto := range fp.FunctionPrototypes {
+		buf.WriteString("\n")
+		proto.disassemble(buf)
+	}
+}
+
+// disassembleInst returns the operands of the instruction at pc and a comment
+// describing them.
+func (fp *FunctionProto) disassembleInst(pc int, inst uint32) (string, string) {
+	op := opGetOpCode(inst)
+	props := opProps[op]
+	a, b, c := opGetArgA(inst), opGetArgB(inst), opGetArgC(inst)
+	bx, sbx := opGetArgBx(inst), opGetArgSbx(inst)
+
+	// constant operands are shown as -1-index
+	rk := func(mode opArgMode, v int) int {
+		if mode == opArgModeK && opIsK(v) {
+			return -1 - opIndexK(v)
+		}
+		return v
+	}
+	var operands string
+	switch props.Type {
+	case opTypeABC:
+		operands = fmt.Sprint(a)
+		if props.ModeArgB != opArgModeN {
+			operands += fmt.Sprintf(" %v", rk(props.ModeArgB, b))
+		}
+		switch {
+		case op == OP_TYPECHECK:
+			operands += fmt.Sprintf(" %v", -1-c)
+		case op == OP_MOVEN:
+			// the number of MOVEs that follow
+			operands += fmt.Sprintf(" %v", c)
+		case op == OP_LOADNIL && c != 0:
+			// also sets the top
+			operands += fmt.Sprintf(" %v", c)
+		case props.ModeArgC != opArgModeN:
+			operands += fmt.Sprintf(" %v", rk(props.ModeArgC, c))
+		}
+	case opTypeABx:
+		if props.ModeArgB == opArgModeK {
+			operands = fmt.Sprintf("%v %v", a, -1-bx)
+		} else {
+			operands = fmt.Sprintf("%v %v", a, bx)
+		}
+	case opTypeASbx:
+		switch op {
+		case OP_NOP:
+		case OP_JMP:
+			operands = fmt.Sprint(sbx)
+		default:
+			operands = fmt.Sprintf("%v %v", a, sbx)
+		}
+	}
+
+	constant := func(index int) string {
+		if index < 0 || index >= len(fp.Constants) {
+			return "?"
+		}
+		return constantString(fp.Constants[index])
+	}
+	rkConstant := func(v int) string {
+		if opIsK(v) {
+			return constant(opIndexK(v))
+		}
+		return "-"
+	}
+	var comment string
+	switch op {
+	case OP_LOADK:
+		comment = constant(bx)
+	case OP_GETUPVAL, OP_SETUPVAL:
+		comment = "-"
+		if b < len(fp.DbgUpvalues) {
+			comment = fp.DbgUpvalues[b]
+		}
+	case OP_GETGLOBAL, OP_SETGLOBAL, OP_CLASS, OP_TBC:
+		comment = fp.stringConstant(bx)
+	case OP_GETTABLE, OP_GETTABLEKS, OP_SELF:
+		if opIsK(c) {
+			comment = rkConstant(c)
+		}
+	case OP_SETTABLE, OP_SETTABLEKS, OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW,
+		OP_IDIV, OP_BAND, OP_BOR, OP_BXOR, OP_SHL, OP_SHR, OP_EQ, OP_LT, OP_LE:
+		if opIsK(b) || opIsK(c) {
+			comment = rkConstant(b) + " " + rkConstant(c)
+		}
+	case OP_JMP, OP_FORLOOP, OP_FORPREP:
+		comment = fmt.Sprintf("to %v", pc+sbx+2)
+	case OP_CLOSURE:
+		if bx < len(fp.FunctionPrototypes) {
+			proto := fp.FunctionPrototypes[bx]
+			comment = fmt.Sprintf("function <%v:%v,%v>", proto.SourceName, proto.LineDefined, proto.LastLineDefined)
+		}
+	case OP_SETLIST:
+		comment = fmt.Sprint(c)
+	case OP_TYPECHECK:
+		comment = typeMaskString(b) + " " + constant(c)
+	case OP_TESTTYPE:
+		comment = typeMaskString(b)
+	case OP_JMPTABLE:
+		if bx < len(fp.JumpTables) {
+			comment = fmt.Sprintf("%v entries", len(fp.JumpTables[bx]))
+		}
+	}
+	return operands, comment
+}
+
+// stringConstant returns the string constant at index unquoted, as luac
+// prints the names of the globals.
+func (fp *FunctionProto) stringConstant(index int) string {
+	if index < len(fp.Constants) {
+		if s, ok := fp.Constants[index].(LString); ok {
+			return string(s)
+		}
+	}
+	return "?"
+}
+
+// constantString returns a constant as it would be written in a script.
+func constantString(value LValue) string {
+	switch v := value.(type) {
+	case LString:
+		return quoteString(string(v))
+	case LNumber:
+		f := float64(v)
+		switch {
+		case math.IsNaN(f):
+			return "nan"
+		case math.IsInf(f, 1):
+			return "inf"
+		case math.IsInf(f, -1):
+			return "-inf"
+		}
+		return floatNumeral(f)
+	}
+	return value.String()
+}
+
+// quoteString quotes s with the escapes of Lua strings.
+func quoteString(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\a':
+			buf.WriteString(`\a`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\v':
+			buf.WriteString(`\v`)
+		default:
+			if ch < ' ' || ch == 0x7f {
+				fmt.Fprintf(&buf, `\%03d`, ch)
+			} else {
+				buf.WriteByte(ch)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+/* }}} */
@@ -0,0 +1,551 @@
+package lua
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+/* binary chunks {{{ */
+
+// Precompiled chunks use the layout of Lua 5.1 binary chunks. The format byte
+// of the header marks the chunks written by Milk, since the opcodes differ from
+// the ones of the reference implementation, and is raised with each change to
+// the Milk format. Each function is followed by the Milk specific data: call
+// names, parameters, jump tables and the source columns of the instructions.
+
+// LuaSignature starts every precompiled chunk.
+const LuaSignature = "\x1bLua"
+
+const (
+	bytecodeVersion     = 0x51
+	bytecodeFirstFormat = 0x4d // 'M', the reference implementation uses 0
+	bytecodeFormat      = 0x4e // with the source columns
+)
+
+var bytecodeHeader = []byte{
+	0x1b, 0x4c, 0x75, 0x61, // Lua signature
+	bytecodeVersion, // Version
+	bytecodeFormat,  // Format
+	0x01,            // Endianness
+	0x04,            // Size of int
+	0x04,            // Size of size_t
+	0x04,            // Size of Instruction
+	0x08,            // Size of lua_Number
+	0x00,            // Integral flag
+}
+
+// Types of the constants. Integers are an extension to Lua 5.1, their tag is
+// the one used by later versions.
+const (
+	bytecodeNil     = 0x00
+	bytecodeBoolean = 0x01
+	bytecodeNumber  = 0x03
+	bytecodeString  = 0x04
+	bytecodeInteger = 0x13
+)
+
+/* }}} */
+
+/* dump {{{ */
+
+// RawBytecode returns the precompiled chunk of the function, which can be
+// loaded back with Undump or LState.Load.
+func (fp *FunctionProto) RawBytecode() []byte {
+	ds := &dumpState{buf: make([]byte, 0, len(fp.Code)*4+64)}
+	ds.buf = append(ds.buf, bytecodeHeader...)
+	ds.writeFunction(fp, "=?")
+	return ds.buf
+}
+
+func (fp *FunctionProto) RawBytecodeString() string {
+	return string(fp.RawBytecode())
+}
+
+type dumpState struct {
+	buf []byte
+}
+
+func (ds *dumpState) writeByte(b byte) {
+	ds.buf = append(ds.buf, b)
+}
+
+func (ds *dumpState) writeInt(i int) {
+	ds.buf = binary.LittleEndian.AppendUint32(ds.buf, uint32(int32(i)))
+}
+
+// writeString writes s with a terminating zero as Lua does, an absent string
+// is written as size 0.
+func (ds *dumpState) writeString(s string, present bool) {
+	if !present {
+		ds.writeInt(0)
+		return
+	}
+	ds.writeInt(len(s) + 1)
+	ds.buf = append(ds.buf, s...)
+	ds.buf = append(ds.buf, 0)
+}
+
+func (ds *dumpState) writeConstant(value LValue) {
+	switch v := value.(type) {
+	case *LNilType:
+		ds.writeByte(bytecodeNil)
+	case LBool:
+		ds.writeByte(bytecodeBoolean)
+		if v {
+			ds.writeByte(1)
+		} else {
+			ds.writeByte(0)
+		}
+	case LNumber:
+		ds.writeByte(bytecodeNumber)
+		ds.buf = binary.LittleEndian.AppendUint64(ds.buf, math.Float64bits(float64(v)))
+	case LInteger:
+		ds.writeByte(bytecodeInteger)
+		ds.buf = binary.LittleEndian.AppendUint64(ds.buf, uint64(v))
+	case LString:
+		ds.writeByte(bytecodeString)
+		ds.writeString(string(v), true)
+	default:
+		panic(fmt.Sprintf("can not dump a constant of type %v", value.Type()))
+	}
+}
+
+func (ds *dumpState) writeFunction(fp *FunctionProto, parent string) {
+	// nested functions inherit the source name of their parent
+	ds.writeString(fp.SourceName, fp.SourceName != parent)
+	ds.writeInt(fp.LineDefined)
+	ds.writeInt(fp.LastLineDefined)
+	ds.writeByte(fp.NumUpvalues)
+	ds.writeByte(fp.NumParameters)
+	ds.writeByte(fp.IsVarArg)
+	ds.writeByte(fp.NumUsedRegisters)
+
+	ds.writeInt(len(fp.Code))
+	for _, inst := range fp.Code {
+		ds.buf = binary.LittleEndian.AppendUint32(ds.buf, inst)
+	}
+
+	ds.writeInt(len(fp.Constants))
+	for _, constant := range fp.Constants {
+		ds.writeConstant(constant)
+	}
+	ds.writeInt(len(fp.FunctionPrototypes))
+	for _, proto := range fp.FunctionPrototypes {
+		ds.writeFunction(proto, fp.SourceName)
+	}
+
+	ds.writeInt(len(fp.DbgSourcePositions))
+	for _, line := range fp.DbgSourcePositions {
+		ds.writeInt(line)
+	}
+	ds.writeInt(len(fp.DbgLocals))
+	for _, local := range fp.DbgLocals {
+		ds.writeString(local.Name, true)
+		ds.writeInt(local.StartPc)
+		ds.writeInt(local.EndPc)
+	}
+	ds.writeInt(len(fp.DbgUpvalues))
+	for _, name := range fp.DbgUpvalues {
+		ds.writeString(name, true)
+	}
+
+	ds.writeInt(len(fp.DbgCalls))
+	for _, call := range fp.DbgCalls {
+		ds.writeString(call.Name, true)
+		ds.writeInt(call.Pc)
+	}
+	ds.writeInt(len(fp.Params))
+	for _, param := range fp.Params {
+		ds.writeString(param.Name, true)
+		if !param.HasDefault {
+			ds.writeByte(0)
+		} else if param.Default == nil {
+			ds.writeByte(1)
+		} else {
+			ds.writeByte(2)
+			ds.writeConstant(param.Default)
+		}
+	}
+	ds.writeInt(len(fp.JumpTables))
+	for _, table := range fp.JumpTables {
+		// sorted to make the output reproducible
+		keys := make([]LValue, 0, len(table))
+		for key := range table {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if ki, kj := keys[i].Type(), keys[j].Type(); ki != kj {
+				return ki < kj
+			}
+			return keys[i].String() < keys[j].String()
+		})
+		ds.writeInt(len(keys))
+		for _, key := range keys {
+			ds.writeConstant(key)
+			ds.writeInt(table[key])
+		}
+	}
+	ds.writeInt(len(fp.DbgSourceColumns))
+	for _, column := range fp.DbgSourceColumns {
+		ds.writeInt(column)
+	}
+}
+
+/* }}} */
+
+/* undump {{{ */
+
+type undumpError struct {
+	msg string
+}
+
+type undumpState struct {
+	name string
+	data []byte
+	pos  int
+}
+
+func (us *undumpState) error(why string) {
+	panic(&undumpError{fmt.Sprintf("%v: %v in precompiled chunk", us.name, why)})
+}
+
+func (us *undumpState) read(n int) []byte {
+	if n < 0 || len(us.data)-us.pos < n {
+		us.error("unexpected end")
+	}
+	b := us.data[us.pos : us.pos+n]
+	us.pos += n
+	return b
+}
+
+func (us *undumpState) readByte() byte {
+	return us.read(1)[0]
+}
+
+func (us *undumpState) readInt() int {
+	return int(int32(binary.LittleEndian.Uint32(us.read(4))))
+}
+
+// readCount reads the number of the elements that follow, each of them taking
+// at least size bytes.
+func (us *undumpState) readCount(size int) int {
+	n := us.readInt()
+	if n < 0 {
+		us.error("bad integer")
+	} else if n > (len(us.data)-us.pos)/size {
+		us.error("unexpected end")
+	}
+	return n
+}
+
+func (us *undumpState) readString() (string, bool) {
+	n := us.readCount(1)
+	if n == 0 {
+		return "", false
+	}
+	b := us.read(n)
+	if b[n-1] != 0 {
+		us.error("bad string")
+	}
+	return string(b[:n-1]), true
+}
+
+func (us *undumpState) readConstant() LValue {
+	switch us.readByte() {
+	case bytecodeNil:
+		return LNil
+	case bytecodeBoolean:
+		return LBool(us.readByte() != 0)
+	case bytecodeNumber:
+		return LNumber(math.Float64frombits(binary.LittleEndian.Uint64(us.read(8))))
+	case bytecodeInteger:
+		return LInteger(int64(binary.LittleEndian.Uint64(us.read(8))))
+	case bytecodeString:
+		s, _ := us.readString()
+		return LString(s)
+	}
+	us.error("bad constant")
+	return nil
+}
+
+func (us *undumpState) readHeader() {
+	header := us.read(len(bytecodeHeader))
+	switch {
+	case string(header[:4]) != LuaSignature:
+		us.error("bad signature")
+	case header[4] != bytecodeVersion:
+		us.error(fmt.Sprintf("version mismatch (%x.%x expected)", bytecodeVersion>>4, bytecodeVersion&0xf))
+	case header[5] < bytecodeFirstFormat:
+		us.error("format mismatch (not compiled by Milk)")
+	case header[5] != bytecodeFormat:
+		us.error(fmt.Sprintf("format version mismatch (%v expected, got %v)",
+			bytecodeFormat-bytecodeFirstFormat+1, int(header[5])-bytecodeFirstFormat+1))
+	case string(header[6:]) != string(bytecodeHeader[6:]):
+		us.error("bad header")
+	}
+}
+
+func (us *undumpState) readFunction(parent string) *FunctionProto {
+	source, ok := us.readString()
+	if !ok {
+		source = parent
+	}
+	fp := newFunctionProto(source)
+	fp.LineDefined = us.readInt()
+	fp.LastLineDefined = us.readInt()
+	fp.NumUpvalues = us.readByte()
+	fp.NumParameters = us.readByte()
+	fp.IsVarArg = us.readByte()
+	fp.NumUsedRegisters = us.readByte()
+
+	fp.Code = make([]uint32, us.readCount(4))
+	for i := range fp.Code {
+		fp.Code[i] = binary.LittleEndian.Uint32(us.read(4))
+	}
+
+	fp.Constants = make([]LValue, us.readCount(1))
+	for i := range fp.Constants {
+		fp.Constants[i] = us.readConstant()
+		sv := ""
+		if slv, ok := fp.Constants[i].(LString); ok {
+			sv = string(slv)
+		}
+		fp.stringConstants = append(fp.stringConstants, sv)
+	}
+	fp.FunctionPrototypes = make([]*FunctionProto, us.readCount(1))
+	for i := range fp.FunctionPrototypes {
+		fp.FunctionPrototypes[i] = us.readFunction(source)
+	}
+
+	fp.DbgSourcePositions = make([]int, us.readCount(4))
+	for i := range fp.DbgSourcePositions {
+		fp.DbgSourcePositions[i] = us.readInt()
+	}
+	fp.DbgLocals = make([]*DbgLocalInfo, us.readCount(9))
+	for i := range fp.DbgLocals {
+		name, _ := us.readString()
+		fp.DbgLocals[i] = &DbgLocalInfo{Name: name, StartPc: us.readInt(), EndPc: us.readInt()}
+	}
+	fp.DbgUpvalues = make([]string, us.readCount(4))
+	for i := range fp.DbgUpvalues {
+		fp.DbgUpvalues[i], _ = us.readString()
+	}
+
+	fp.DbgCalls = make([]DbgCall, us.readCount(8))
+	for i := range fp.DbgCalls {
+		name, _ := us.readString()
+		fp.DbgCalls[i] = DbgCall{Name: name, Pc: us.readInt()}
+	}
+	if n := us.readCount(5); n > 0 {
+		fp.Params = make([]ParamInfo, n)
+		for i := range fp.Params {
+			fp.Params[i].Name, _ = us.readString()
+			switch us.readByte() {
+			case 0:
+			case 1:
+				fp.Params[i].HasDefault = true
+			case 2:
+				fp.Params[i].HasDefault = true
+				fp.Params[i].Default = us.readConstant()
+			default:
+				us.error("bad parameter")
+			}
+		}
+	}
+	if n := us.readCount(4); n > 0 {
+		fp.JumpTables = make([]map[LValue]int, n)
+		for i := range fp.JumpTables {
+			size := us.readCount(5)
+			table := make(map[LValue]int, size)
+			for j := 0; j < size; j++ {
+				key := us.readConstant()
+				table[key] = us.readInt()
+			}
+			fp.JumpTables[i] = table
+		}
+	}
+	fp.DbgSourceColumns = make([]int, us.readCount(4))
+	for i := range fp.DbgSourceColumns {
+		fp.DbgSourceColumns[i] = us.readInt()
+	}
+	us.checkFunction(fp)
+	return fp
+}
+
+// checkFunction verifies that the operands referring to registers,
+// constants, upvalues, nested functions, jump tables and jump targets are in
+// range, so that a damaged chunk can not make the VM index out of bounds.
+func (us *undumpState) checkFunction(fp *FunctionProto) {
+	ncode := len(fp.Code)
+	if ncode == 0 || opGetOpCode(fp.Code[ncode-1]) != OP_RETURN {
+		us.error("bad code")
+	}
+	if n := len(fp.DbgSourcePositions); n != ncode && n != 0 {
+		us.error("bad line information")
+	}
+	if n := len(fp.DbgSourceColumns); n != ncode && n != 0 {
+		us.error("bad column information")
+	}
+	if len(fp.DbgUpvalues) != int(fp.NumUpvalues) {
+		us.error("bad upvalues")
+	}
+	if int(fp.NumParameters) > int(fp.NumUsedRegisters) {
+		us.error("bad number of registers")
+	}
+	inRange := func(index, size int) {
+		if index < 0 || index >= size {
+			us.error("bad code")
+		}
+	}
+	nregs := int(fp.NumUsedRegisters)
+	pc := 0
+	register := func(reg int) {
+		if reg < 0 || reg >= nregs {
+			us.error(fmt.Sprintf("bad register %v in %v at instruction %v (%v registers)",
+				reg, opProps[opGetOpCode(fp.Code[pc])].Name, pc+1, nregs))
+		}
+	}
+	upvalue := func(index int) {
+		if index < 0 || index >= int(fp.NumUpvalues) {
+			us.error(fmt.Sprintf("bad upvalue %v in %v at instruction %v (%v upvalues)",
+				index, opProps[opGetOpCode(fp.Code[pc])].Name, pc+1, fp.NumUpvalues))
+		}
+	}
+	for ; pc < ncode; pc++ {
+		inst := fp.Code[pc]
+		op := opGetOpCode(inst)
+		if op > opCodeMax {
+			us.error("bad code")
+		}
+		props := opProps[op]
+		a, b, c := opGetArgA(inst), opGetArgB(inst), opGetArgC(inst)
+		if props.Type == opTypeABx && props.ModeArgB == opArgModeK {
+			inRange(opGetArgBx(inst), len(fp.Constants))
+		} else if props.Type == opTypeABC {
+			modes := []opArgMode{props.ModeArgB, props.ModeArgC}
+			if op == OP_TYPECHECK {
+				// C is the constant index of the message, checked below
+				modes[1] = opArgModeU
+			}
+			for i, mode := range modes {
+				arg := b
+				if i == 1 {
+					arg = c
+				}
+				switch {
+				case mode == opArgModeK && opIsK(arg):
+					inRange(opIndexK(arg), len(fp.Constants))
+				case mode == opArgModeK, mode == opArgModeR:
+					register(arg)
+				}
+			}
+		}
+		switch op {
+		case OP_EQ, OP_LT, OP_LE, OP_JMP, OP_NOP:
+			// A is not a register
+		case OP_CLOSE:
+			// A is the level of the registers to close
+			if a > nregs {
+				register(a)
+			}
+		case OP_RETURN:
+			if b != 1 {
+				register(a)
+			}
+			if b > 1 {
+				register(a + b - 2)
+			}
+		default:
+			register(a)
+		}
+		switch op {
+		case OP_JMP, OP_FORLOOP, OP_FORPREP:
+			inRange(pc+1+opGetArgSbx(inst), ncode)
+			if op != OP_JMP {
+				register(a + 3)
+			}
+		case OP_MOVEN:
+			inRange(pc+c, ncode)
+			for i := 1; i <= c; i++ {
+				if opGetOpCode(fp.Code[pc+i]) != OP_MOVE {
+					us.error("bad code")
+				}
+			}
+		case OP_GETUPVAL, OP_SETUPVAL:
+			upvalue(b)
+		case OP_SELF:
+			register(a + 1)
+		case OP_CALL, OP_TAILCALL:
+			if b > 0 {
+				register(a + b - 1)
+			}
+			if op == OP_CALL && c > 1 {
+				register(a + c - 2)
+			}
+		case OP_TFORLOOP:
+			register(a + 2 + c)
+		case OP_SETLIST:
+			register(a + b)
+		case OP_VARARG:
+			if b > 1 {
+				register(a + b - 2)
+			}
+		case OP_NAMEDARGS:
+			register(a + b)
+		case OP_TYPECHECK:
+			inRange(c, len(fp.Constants))
+		case OP_JMPTABLE:
+			inRange(opGetArgBx(inst), len(fp.JumpTables))
+		case OP_CLOSURE:
+			inRange(opGetArgBx(inst), len(fp.FunctionPrototypes))
+			nups := int(fp.FunctionPrototypes[opGetArgBx(inst)].NumUpvalues)
+			inRange(pc+nups, ncode)
+			for i := 1; i <= nups; i++ {
+				switch capture := fp.Code[pc+i]; opGetOpCode(capture) {
+				case OP_MOVE:
+					register(opGetArgB(capture))
+				case OP_GETUPVAL:
+					upvalue(opGetArgB(capture))
+				default:
+					us.error("bad code")
+				}
+			}
+			pc += nups
+		}
+	}
+	for _, table := range fp.JumpTables {
+		for _, pc := range table {
+			inRange(pc, ncode)
+		}
+	}
+}
+
+// Undump loads a precompiled chunk written by FunctionProto.RawBytecode.
+func Undump(reader io.Reader, name string) (proto *FunctionProto, err error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			if uerr, ok := rcv.(*undumpError); ok {
+				proto, err = nil, errors.New(uerr.msg)
+			} else {
+				panic(rcv)
+			}
+		}
+	}()
+	us := &undumpState{name: name, data: data}
+	us.readHeader()
+	proto = us.readFunction("=?")
+	if us.pos != len(data) {
+		us.error("trailing garbage")
+	}
+	return proto, nil
+}
+
+/* }}} */
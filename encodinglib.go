@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"strings"
 
 	toml "github.com/pelletier/go-toml"
 )
@@ -158,7 +159,9 @@ func decodingJson(LuaVM *LState) int {
 	jsonStr := LuaVM.CheckString(1)
 
 	var goMap map[string]interface{}
-	err := json.Unmarshal([]byte(jsonStr), &goMap)
+	decoder := json.NewDecoder(strings.NewReader(jsonStr))
+	decoder.UseNumber()
+	err := decoder.Decode(&goMap)
 	if err != nil {
 		LuaVM.Push(LNil)
 		return 1
@@ -191,6 +194,16 @@ func toLuaValue(LuaVM *LState, value interface{}) LValue {
 		return LString(v)
 	case float64:
 		return LNumber(v)
+	case int64:
+		return LInteger(v)
+	case json.Number:
+		if iv, err := v.Int64(); err == nil {
+			return LInteger(iv)
+		}
+		if fv, err := v.Float64(); err == nil {
+			return LNumber(fv)
+		}
+		return LString(v.String())
 	case bool:
 		return LBool(v)
 	case map[string]interface{}:
@@ -205,6 +218,9 @@ func toGoValue(lv LValue) interface{} {
 	case LTString:
 		return lv.String()
 	case LTNumber:
+		if iv, ok := lv.(LInteger); ok {
+			return int64(iv)
+		}
 		return float64(LVAsNumber(lv))
 	case LTBool:
 		return LVAsBool(lv)
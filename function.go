@@ -1,6 +1,7 @@
 package lua
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 )
@@ -17,6 +18,14 @@ type DbgLocalInfo struct {
 	EndPc   int
 }
 
+// ParamInfo describes a declared parameter. Default holds the default value
+// when HasDefault is set and the default is a constant, nil otherwise.
+type ParamInfo struct {
+	Name       string
+	HasDefault bool
+	Default    LValue
+}
+
 type DbgCall struct {
 	Name string
 	Pc   int
@@ -33,13 +42,22 @@ type FunctionProto struct {
 	Code               []uint32
 	Constants          []LValue
 	FunctionPrototypes []*FunctionProto
+	Params             []ParamInfo
 
 	DbgSourcePositions []int
+	DbgSourceColumns   []int
 	DbgLocals          []*DbgLocalInfo
 	DbgCalls           []DbgCall
 	DbgUpvalues        []string
 
+	// JumpTables map the values of OP_JMPTABLE to absolute pcs.
+	JumpTables []map[LValue]int
+
 	stringConstants []string
+	// source is the text of the chunk the function was compiled from, kept
+	// to quote the lines of errors in tracebacks. It is nil for compiled
+	// chunks.
+	source []byte
 }
 
 /* Upvalue {{{ */
@@ -101,6 +119,7 @@ func newFunctionProto(name string) *FunctionProto {
 		FunctionPrototypes: make([]*FunctionProto, 0, 16),
 
 		DbgSourcePositions: make([]int, 0, 128),
+		DbgSourceColumns:   make([]int, 0, 128),
 		DbgLocals:          make([]*DbgLocalInfo, 0, 16),
 		DbgCalls:           make([]DbgCall, 0, 128),
 		DbgUpvalues:        make([]string, 0, 16),
@@ -109,83 +128,76 @@ func newFunctionProto(name string) *FunctionProto {
 	}
 }
 
-func (fp *FunctionProto) RawBytecodeString() string {
-	return string(fp.RawBytecode())
+// acceptsNamedArgs reports whether f{...} calls to the function may be mapped
+// onto its parameters, which is the case when any parameter has a default
+// value. See namedArgs for the tables that are.
+func (fp *FunctionProto) acceptsNamedArgs() bool {
+	for _, param := range fp.Params {
+		if param.HasDefault {
+			return true
+		}
+	}
+	return false
 }
 
-func (fp *FunctionProto) RawBytecode() []byte {
-	buf := make([]byte, 0, len(fp.Code)*4+12)
-	buf = append(buf, 0x1b, 0x4c, 0x75, 0x61) // Lua signature
-	buf = append(buf, 0x51)                   // Version
-	buf = append(buf, 0x00)                   // Format
-	buf = append(buf, 0x01)                   // Endianness
-	buf = append(buf, 0x04)                   // Size of int
-	buf = append(buf, 0x04)                   // Size of size_t
-	buf = append(buf, 0x04)                   // Size of Instruction
-	buf = append(buf, 0x08)                   // Size of lua_Number
-	buf = append(buf, 0x00)                   // Integral flag
-
-	// Function header
-	buf = append(buf, encodeString(fp.SourceName)...)
-	buf = append(buf, encodeInt(fp.LineDefined)...)
-	buf = append(buf, encodeInt(fp.LastLineDefined)...)
-	buf = append(buf, fp.NumUpvalues)
-	buf = append(buf, fp.NumParameters)
-	buf = append(buf, fp.IsVarArg)
-	buf = append(buf, fp.NumUsedRegisters)
-
-	// Code
-	buf = append(buf, encodeInt(len(fp.Code))...)
-	for _, code := range fp.Code {
-		buf = append(buf, byte(code), byte(code>>8), byte(code>>16), byte(code>>24))
+// lineAt returns the source line of the instruction at pc, -1 when the line
+// information has been stripped.
+func (fp *FunctionProto) lineAt(pc int) int {
+	if pc < 0 || pc >= len(fp.DbgSourcePositions) {
+		return -1
 	}
+	return fp.DbgSourcePositions[pc]
+}
 
-	// Constants
-	buf = append(buf, encodeInt(len(fp.Constants))...)
-	for _, constant := range fp.Constants {
-		buf = append(buf, encodeConstant(constant)...)
+// columnAt returns the source column of the instruction at pc, 0 when it is
+// unknown.
+func (fp *FunctionProto) columnAt(pc int) int {
+	if pc < 0 || pc >= len(fp.DbgSourceColumns) {
+		return 0
 	}
+	return fp.DbgSourceColumns[pc]
+}
 
-	// Function prototypes
-	buf = append(buf, encodeInt(len(fp.FunctionPrototypes))...)
+// setSource keeps the text of the chunk in the function and its nested
+// functions.
+func (fp *FunctionProto) setSource(source []byte) {
+	fp.source = source
 	for _, proto := range fp.FunctionPrototypes {
-		buf = append(buf, proto.RawBytecode()...)
+		proto.setSource(source)
 	}
+}
 
-	// Debug information
-	buf = append(buf, encodeInt(len(fp.DbgSourcePositions))...)
-	for _, pos := range fp.DbgSourcePositions {
-		buf = append(buf, encodeInt(pos)...)
+// sourceLine returns the text of a line of the chunk, false when the source
+// is not known.
+func (fp *FunctionProto) sourceLine(line int) (string, bool) {
+	if fp.source == nil || line < 1 {
+		return "", false
 	}
-
-	buf = append(buf, encodeInt(len(fp.DbgLocals))...)
-	for _, local := range fp.DbgLocals {
-		buf = append(buf, encodeString(local.Name)...)
-		buf = append(buf, encodeInt(local.StartPc)...)
-		buf = append(buf, encodeInt(local.EndPc)...)
+	text := fp.source
+	for ; line > 1; line-- {
+		i := bytes.IndexByte(text, '\n')
+		if i < 0 {
+			return "", false
+		}
+		text = text[i+1:]
 	}
-
-	buf = append(buf, encodeInt(len(fp.DbgUpvalues))...)
-	for _, upvalue := range fp.DbgUpvalues {
-		buf = append(buf, encodeString(upvalue)...)
+	if i := bytes.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
 	}
-
-	return buf
-}
-
-func encodeString(s string) []byte {
-	b := []byte(s)
-	return append(encodeInt(len(b)), b...)
+	return strings.TrimRight(string(text), "\r"), true
 }
 
-func encodeInt(i int) []byte {
-	return []byte{byte(i), byte(i >> 8), byte(i >> 16), byte(i >> 24)}
-}
-
-func encodeConstant(c LValue) []byte {
-	// This function should encode the constant based on its type
-	// For simplicity, let's assume all constants are strings
-	return append([]byte{0x04}, encodeString(c.String())...)
+// StripDebugInfo removes the line and local variable information from the
+// function and its nested functions. Errors raised by a stripped function
+// report '?' as line.
+func (fp *FunctionProto) StripDebugInfo() {
+	fp.DbgSourcePositions = nil
+	fp.DbgSourceColumns = nil
+	fp.source = nil
+	fp.DbgLocals = nil
+	for _, proto := range fp.FunctionPrototypes {
+		proto.StripDebugInfo()
+	}
 }
 
 func (fp *FunctionProto) String() string {
@@ -220,7 +232,7 @@ func (fp *FunctionProto) str(level int, count int) string {
 			protono++
 		}
 		buf = append(buf, fmt.Sprintf("%v[%03d] %v (line:%v)\n",
-			indent, no+1, opToString(code), fp.DbgSourcePositions[no]))
+			indent, no+1, opToString(code), fp.lineAt(no)))
 
 	}
 	buf = append(buf, fmt.Sprintf("%v; end of function\n", indent))
@@ -0,0 +1,177 @@
+package lua
+
+/* hooks {{{ */
+
+// HookEvent is the kind of event a hook is called for.
+type HookEvent int
+
+const (
+	// HookCall is sent when a function is called, before its first
+	// instruction.
+	HookCall HookEvent = iota
+	// HookReturn is sent when a function is about to return.
+	HookReturn
+	// HookLine is sent when the thread starts a new line of code, or jumps
+	// back in the code, even to the same line.
+	HookLine
+	// HookCount is sent after every count instructions, see debug.sethook.
+	HookCount
+	// HookTailCall is sent instead of HookCall for a tail call. The function
+	// that made it returns without HookReturn.
+	HookTailCall
+)
+
+var hookEventNames = [...]string{"call", "return", "line", "count", "tail call"}
+
+func (ev HookEvent) String() string {
+	if ev < 0 || int(ev) >= len(hookEventNames) {
+		return "?"
+	}
+	return hookEventNames[ev]
+}
+
+// The masks of SetHookMask select the events a hook is called for. HookCount
+// events are selected by a count instead.
+const (
+	// HookMaskCall selects HookCall and HookTailCall.
+	HookMaskCall = 1 << HookCall
+	// HookMaskReturn selects HookReturn.
+	HookMaskReturn = 1 << HookReturn
+	// HookMaskLine selects HookLine.
+	HookMaskLine = 1 << HookLine
+)
+
+// hookState is the hook of a thread, with what it has seen of the running
+// code to tell the calls and the new lines.
+type hookState struct {
+	fn      func(*LState, HookEvent, *Debug)
+	value   LValue // the function given to debug.sethook, nil for a Go hook
+	mask    int
+	count   int
+	counter int
+	running bool
+
+	frame    *callFrame // the frame of the last instruction
+	tailcall int        // and its count of tail calls
+	pc       int
+	line     int
+}
+
+// SetHook sets a hook that the thread calls on the calls and returns of
+// functions and on each new line of Lua code it runs. The Debug refers to the
+// function that raised the event and can be passed to GetInfo. Hooks are not
+// called while the hook runs. A nil hook removes the hook, and so does
+// debug.sethook.
+func (ls *LState) SetHook(hook func(*LState, HookEvent, *Debug)) {
+	if hook == nil {
+		ls.hook = nil
+		return
+	}
+	ls.hook = &hookState{fn: hook, mask: HookMaskCall | HookMaskReturn | HookMaskLine}
+}
+
+// SetHookMask selects the events the hook of the thread is called for: those
+// of mask, a combination of HookMaskCall, HookMaskReturn and HookMaskLine, and
+// HookCount after every count instructions if count is positive. It does
+// nothing without a hook, and a mask and count for no event remove the hook.
+func (ls *LState) SetHookMask(mask int, count int) {
+	if ls.hook == nil {
+		return
+	}
+	if mask == 0 && count <= 0 {
+		ls.hook = nil
+		return
+	}
+	if count < 0 {
+		count = 0
+	}
+	ls.hook.mask, ls.hook.count, ls.hook.counter = mask, count, 0
+}
+
+// step runs the hooks before the instruction at pc in the frame cf. The
+// mainLoop functions call it only when a hook is set, so threads without one
+// pay a single comparison per instruction.
+func (h *hookState) step(L *LState, cf *callFrame, pc int, op int) {
+	if h.running {
+		return
+	}
+	proto := cf.Fn.Proto
+	newline := false
+	if cf != h.frame || cf.TailCall != h.tailcall {
+		h.frame, h.tailcall = cf, cf.TailCall
+		if pc == 0 {
+			// a new activation
+			newline = true
+			if h.mask&HookMaskCall != 0 {
+				event := HookCall
+				if cf.TailCall > 0 {
+					event = HookTailCall
+				}
+				h.call(L, event, cf, -1)
+			}
+		} else {
+			// back from a call, the line of the call is not new
+			h.pc, h.line = pc-1, proto.lineAt(pc-1)
+		}
+	}
+	if h.count > 0 {
+		h.counter++
+		if h.counter >= h.count {
+			h.counter = 0
+			h.call(L, HookCount, cf, -1)
+		}
+	}
+	if h.mask&HookMaskLine != 0 {
+		line := proto.lineAt(pc)
+		if line >= 0 && (newline || line != h.line || pc <= h.pc) {
+			h.call(L, HookLine, cf, line)
+		}
+		h.pc, h.line = pc, line
+	}
+	if op == OP_RETURN && h.mask&HookMaskReturn != 0 {
+		h.call(L, HookReturn, cf, -1)
+	}
+}
+
+// call calls the hook for an event in the frame cf. The registers of a Lua
+// function above the top may be in use, the hook gets the ones above all of
+// them.
+func (h *hookState) call(L *LState, event HookEvent, cf *callFrame, line int) {
+	if h.running {
+		return
+	}
+	dbg := &Debug{frame: cf, CurrentLine: line}
+	if line < 0 && !cf.Fn.IsG && cf.Pc > 0 {
+		dbg.CurrentLine = cf.Fn.Proto.lineAt(cf.Pc - 1)
+	}
+	top := L.reg.Top()
+	if !cf.Fn.IsG {
+		if used := cf.LocalBase + int(cf.Fn.Proto.NumUsedRegisters); used > top {
+			L.reg.checkSize(used)
+			L.reg.top = used
+		}
+	}
+	h.running = true
+	defer func() {
+		h.running = false
+	}()
+	h.fn(L, event, dbg)
+	L.reg.top = top
+}
+
+// luaHook calls the function given to debug.sethook with the name of the
+// event and, for line events, the line.
+func luaHook(fn LValue) func(*LState, HookEvent, *Debug) {
+	return func(L *LState, event HookEvent, dbg *Debug) {
+		L.Push(fn)
+		L.Push(LString(event.String()))
+		if event == HookLine {
+			L.Push(LInteger(dbg.CurrentLine))
+			L.Call(2, 0)
+		} else {
+			L.Call(1, 0)
+		}
+	}
+}
+
+/* }}} */
@@ -155,7 +155,7 @@ func fileIsWritable(L *LState, file *lFile) int {
 	if file.writer == nil {
 		L.Push(LNil)
 		L.Push(LString(fmt.Sprintf("%s is opened for only reading.", file.Name())))
-		L.Push(LNumber(1)) // C-Lua compatibility: Original Lua pushes errno to the stack
+		L.Push(LInteger(1)) // C-Lua compatibility: Original Lua pushes errno to the stack
 		return 3
 	}
 	return 0
@@ -165,7 +165,7 @@ func fileIsReadable(L *LState, file *lFile) int {
 	if file.reader == nil {
 		L.Push(LNil)
 		L.Push(LString(fmt.Sprintf("%s is opened for only writing.", file.Name())))
-		L.Push(LNumber(1)) // C-Lua compatibility: Original Lua pushes errno to the stack
+		L.Push(LInteger(1)) // C-Lua compatibility: Original Lua pushes errno to the stack
 		return 3
 	}
 	return 0
@@ -207,6 +207,7 @@ func OpenIo(L *LState) int {
 
 var fileMethods = map[string]LGFunction{
 	"__tostring": fileToString,
+	"__close":    fileCloseMeta,
 	"write":      fileWrite,
 	"close":      fileClose,
 	"flush":      fileFlush,
@@ -258,7 +259,7 @@ errreturn:
 	file.AbandonReadBuffer()
 	L.Push(LNil)
 	L.Push(LString(err.Error()))
-	L.Push(LNumber(1)) // C-Lua compatibility: Original Lua pushes errno to the stack
+	L.Push(LInteger(1)) // C-Lua compatibility: Original Lua pushes errno to the stack
 	return 3
 }
 
@@ -298,7 +299,7 @@ func fileCloseAux(L *LState, file *lFile) int {
 		} else {
 			exitStatus = 0
 		}
-		L.Push(LNumber(exitStatus))
+		L.Push(LInteger(exitStatus))
 		return 1
 	}
 
@@ -335,9 +336,9 @@ func fileReadAux(L *LState, file *lFile, idx int) int {
 	var err error
 	top := L.GetTop()
 	for i := idx; i <= top; i++ {
-		switch lv := L.Get(i).(type) {
-		case LNumber:
-			size := int64(lv)
+		switch L.Get(i).(type) {
+		case LNumber, LInteger:
+			size := L.CheckInt64(i)
 			if size == 0 {
 				_, err = file.reader.ReadByte()
 				if err == io.EOF {
@@ -410,7 +411,7 @@ normalreturn:
 errreturn:
 	L.Push(LNil)
 	L.Push(LString(err.Error()))
-	L.Push(LNumber(1)) // C-Lua compatibility: Original Lua pushes errno to the stack
+	L.Push(LInteger(1)) // C-Lua compatibility: Original Lua pushes errno to the stack
 	return 3
 }
 
@@ -427,9 +428,9 @@ func fileSeek(L *LState) int {
 	top := L.GetTop()
 	if top == 1 {
 		L.Push(LString("cur"))
-		L.Push(LNumber(0))
+		L.Push(LInteger(0))
 	} else if top == 2 {
-		L.Push(LNumber(0))
+		L.Push(LInteger(0))
 	}
 
 	var pos int64
@@ -445,7 +446,7 @@ func fileSeek(L *LState) int {
 		goto errreturn
 	}
 
-	L.Push(LNumber(pos))
+	L.Push(LInteger(pos))
 	return 1
 
 errreturn:
@@ -462,6 +463,17 @@ func fileClose(L *LState) int {
 	return fileCloseAux(L, checkFile(L))
 }
 
+// fileCloseMeta closes a file going out of scope as a <close> variable.
+// Files closed already and the standard files are left as is.
+func fileCloseMeta(L *LState) int {
+	file := checkFile(L)
+	if file.closed || file.fp == os.Stdin || file.fp == os.Stdout || file.fp == os.Stderr {
+		return 0
+	}
+	fileCloseAux(L, file)
+	return 0
+}
+
 func fileFlush(L *LState) int {
 	return fileFlushAux(L, checkFile(L))
 }
@@ -631,7 +643,7 @@ func ioFileSize(L *LState) int {
 		L.Push(LString(err.Error()))
 		return 2
 	}
-	L.Push(LNumber(fi.Size()))
+	L.Push(LInteger(fi.Size()))
 	return 1
 }
 
@@ -706,7 +718,7 @@ func ioOpenFile(L *LState) int {
 	if err != nil {
 		L.Push(LNil)
 		L.Push(LString(err.Error()))
-		L.Push(LNumber(1)) // C-Lua compatibility: Original Lua pushes errno to the stack
+		L.Push(LInteger(1)) // C-Lua compatibility: Original Lua pushes errno to the stack
 		return 3
 	}
 	L.Push(file)
@@ -1,5 +1,7 @@
 package lua
 
+import "sort"
+
 const (
 	// BaseLibName is here for consistency; the base functions have no namespace/library.
 	BaseLibName = ""
@@ -94,3 +96,29 @@ func (ls *LState) OpenLibs() {
 		ls.Call(1, 0)
 	}
 }
+
+// Libraries returns the globals defined by OpenLibs. The library tables map
+// to the sorted names of their fields, the other globals map to nil.
+func Libraries() map[string][]string {
+	ls := NewState()
+	defer ls.Close()
+	globals := map[string][]string{}
+	ls.G.Global.ForEach(func(key, value LValue) {
+		name, ok := key.(LString)
+		if !ok {
+			return
+		}
+		globals[string(name)] = nil
+		if tb, ok := value.(*LTable); ok && tb != ls.G.Global {
+			fields := []string{}
+			tb.ForEach(func(key, _ LValue) {
+				if field, ok := key.(LString); ok {
+					fields = append(fields, string(field))
+				}
+			})
+			sort.Strings(fields)
+			globals[string(name)] = fields
+		}
+	})
+	return globals
+}
@@ -9,6 +9,8 @@ func OpenMath(L *LState) int {
 	mod := L.RegisterModule(MathLibName, mathFuncs).(*LTable)
 	mod.RawSetString("pi", LNumber(math.Pi))
 	mod.RawSetString("huge", LNumber(math.MaxFloat64))
+	mod.RawSetString("maxinteger", LInteger(math.MaxInt64))
+	mod.RawSetString("mininteger", LInteger(math.MinInt64))
 	L.Push(mod)
 	return 1
 }
@@ -43,9 +45,18 @@ var mathFuncs = map[string]LGFunction{
 	"sqrt":       mathSqrt,
 	"tan":        mathTan,
 	"tanh":       mathTanh,
+	"tointeger":  mathToInteger,
+	"type":       mathType,
 }
 
 func mathAbs(L *LState) int {
+	if iv, ok := checkNumberValue(L, 1).(LInteger); ok {
+		if iv < 0 {
+			iv = -iv
+		}
+		L.Push(iv)
+		return 1
+	}
 	L.Push(LNumber(math.Abs(float64(L.CheckNumber(1)))))
 	return 1
 }
@@ -71,7 +82,7 @@ func mathAtan2(L *LState) int {
 }
 
 func mathCeil(L *LState) int {
-	L.Push(LNumber(math.Ceil(float64(L.CheckNumber(1)))))
+	L.Push(roundedNumber(L, math.Ceil))
 	return 1
 }
 
@@ -96,10 +107,24 @@ func mathExp(L *LState) int {
 }
 
 func mathFloor(L *LState) int {
-	L.Push(LNumber(math.Floor(float64(L.CheckNumber(1)))))
+	L.Push(roundedNumber(L, math.Floor))
 	return 1
 }
 
+// roundedNumber rounds the first argument with fn. The result is an integer
+// if it fits in one.
+func roundedNumber(L *LState, fn func(float64) float64) LValue {
+	lv := checkNumberValue(L, 1)
+	if iv, ok := lv.(LInteger); ok {
+		return iv
+	}
+	v := LNumber(fn(float64(lv.(LNumber))))
+	if iv, ok := floatToInteger(v); ok {
+		return iv
+	}
+	return v
+}
+
 func mathFmod(L *LState) int {
 	L.Push(LNumber(math.Mod(float64(L.CheckNumber(1)), float64(L.CheckNumber(2)))))
 	return 1
@@ -131,11 +156,11 @@ func mathMax(L *LState) int {
 	if L.GetTop() == 0 {
 		L.RaiseError("wrong number of arguments")
 	}
-	max := L.CheckNumber(1)
+	max := checkNumberValue(L, 1)
 	top := L.GetTop()
 	for i := 2; i <= top; i++ {
-		v := L.CheckNumber(i)
-		if v > max {
+		v := checkNumberValue(L, i)
+		if c, _ := compareNumbers(v, max); c > 0 {
 			max = v
 		}
 	}
@@ -147,11 +172,11 @@ func mathMin(L *LState) int {
 	if L.GetTop() == 0 {
 		L.RaiseError("wrong number of arguments")
 	}
-	min := L.CheckNumber(1)
+	min := checkNumberValue(L, 1)
 	top := L.GetTop()
 	for i := 2; i <= top; i++ {
-		v := L.CheckNumber(i)
-		if v < min {
+		v := checkNumberValue(L, i)
+		if c, _ := compareNumbers(v, min); c < 0 {
 			min = v
 		}
 	}
@@ -189,11 +214,11 @@ func mathRandom(L *LState) int {
 		L.Push(LNumber(rand.Float64()))
 	case 1:
 		n := L.CheckInt(1)
-		L.Push(LNumber(rand.Intn(n) + 1))
+		L.Push(LInteger(rand.Intn(n) + 1))
 	default:
 		min := L.CheckInt(1)
 		max := L.CheckInt(2) + 1
-		L.Push(LNumber(rand.Intn(max-min) + min))
+		L.Push(LInteger(rand.Intn(max-min) + min))
 	}
 	return 1
 }
@@ -229,3 +254,41 @@ func mathTanh(L *LState) int {
 }
 
 //
+
+func mathToInteger(L *LState) int {
+	switch lv := L.CheckAny(1).(type) {
+	case LInteger:
+		L.Push(lv)
+	case LNumber:
+		if iv, ok := floatToInteger(lv); ok {
+			L.Push(iv)
+		} else {
+			L.Push(LNil)
+		}
+	default:
+		L.Push(LNil)
+	}
+	return 1
+}
+
+func mathType(L *LState) int {
+	switch L.CheckAny(1).(type) {
+	case LInteger:
+		L.Push(LString("integer"))
+	case LNumber:
+		L.Push(LString("float"))
+	default:
+		L.Push(LNil)
+	}
+	return 1
+}
+
+// checkNumberValue checks whether or not the n-th argument is a number or a
+// string convertible to a number, and returns it keeping the integer subtype.
+func checkNumberValue(L *LState, n int) LValue {
+	if lv, ok := lvToNumber(L.Get(n)); ok {
+		return lv
+	}
+	L.TypeError(n, LTNumber)
+	return LNil
+}
@@ -90,7 +90,7 @@ func matrixAdd(L *LState) int {
 					L.RaiseError("Invalid type in matrix2 at position [%d]", j)
 					return 0
 				}
-				value2 = value2Raw.(LNumber)
+				value2 = LVAsNumber(value2Raw)
 			} else {
 				row2 := matrix2.RawGetInt((i-1)%matrix2.Len() + 1)
 				if row2.Type() != LTTable {
@@ -103,10 +103,10 @@ func matrixAdd(L *LState) int {
 					L.RaiseError("Invalid type in matrix2 at position [%d][%d]", (i-1)%matrix2.Len()+1, j)
 					return 0
 				}
-				value2 = value2Raw.(LNumber)
+				value2 = LVAsNumber(value2Raw)
 			}
 
-			sum := LNumber(float64(value1.(LNumber)) + float64(value2))
+			sum := LNumber(float64(LVAsNumber(value1)) + float64(value2))
 			resultRow.RawSetInt(j, sum)
 		}
 		result.RawSetInt(i, resultRow)
@@ -155,7 +155,7 @@ func matrixAddBias(L *LState) int {
 				return 0
 			}
 
-			sum := LNumber(float64(matrixValue.(LNumber)) + float64(biasValue.(LNumber)))
+			sum := LNumber(float64(LVAsNumber(matrixValue)) + float64(LVAsNumber(biasValue)))
 			resultRow.RawSetInt(j, sum)
 		}
 		result.RawSetInt(i, resultRow)
@@ -210,9 +210,9 @@ func matrixSub(L *LState) int {
 	b := L.CheckAny(2)
 	name := L.OptString(3, "matrix")
 
-	switch av := a.(type) {
+	switch av := floatValue(a).(type) {
 	case *LTable:
-		switch bv := b.(type) {
+		switch bv := floatValue(b).(type) {
 		case *LTable:
 			if av.Len() != bv.Len() {
 				L.RaiseError("Error updating %s: matrix dimensions do not match for subtraction", name)
@@ -226,7 +226,7 @@ func matrixSub(L *LState) int {
 			return 0
 		}
 	case LNumber:
-		switch bv := b.(type) {
+		switch bv := floatValue(b).(type) {
 		case *LTable:
 			return subtractNumberTable(L, av, bv)
 		case LNumber:
@@ -253,9 +253,9 @@ func subtractTables(L *LState, a, b *LTable) int {
 		aRow := a.RawGetInt(i)
 		bRow := b.RawGetInt(i)
 
-		switch ar := aRow.(type) {
+		switch ar := floatValue(aRow).(type) {
 		case *LTable:
-			switch br := bRow.(type) {
+			switch br := floatValue(bRow).(type) {
 			case *LTable:
 				if ar.Len() != br.Len() {
 					L.RaiseError("Matrix dimensions do not match for subtraction")
@@ -269,7 +269,7 @@ func subtractTables(L *LState, a, b *LTable) int {
 						L.RaiseError("Invalid type in matrix at position [%d][%d]", i, j)
 						return 0
 					}
-					resultRow.RawSetInt(j, LNumber(float64(aVal.(LNumber))-float64(bVal.(LNumber))))
+					resultRow.RawSetInt(j, LNumber(float64(LVAsNumber(aVal))-float64(LVAsNumber(bVal))))
 				}
 				result.RawSetInt(i, resultRow)
 			default:
@@ -277,7 +277,7 @@ func subtractTables(L *LState, a, b *LTable) int {
 				return 0
 			}
 		case LNumber:
-			switch br := bRow.(type) {
+			switch br := floatValue(bRow).(type) {
 			case LNumber:
 				result.RawSetInt(i, LNumber(float64(ar)-float64(br)))
 			default:
@@ -297,11 +297,11 @@ func subtractTableNumber(L *LState, table *LTable, number LNumber) int {
 	result := L.NewTable()
 	for i := 1; i <= table.Len(); i++ {
 		row := table.RawGetInt(i)
-		switch r := row.(type) {
+		switch r := floatValue(row).(type) {
 		case *LTable:
 			resultRow := L.NewTable()
 			for j := 1; j <= r.Len(); j++ {
-				val, ok := r.RawGetInt(j).(LNumber)
+				val, ok := matrixNumber(r.RawGetInt(j))
 				if !ok {
 					L.RaiseError("Invalid type in matrix at position [%d][%d]", i, j)
 					return 0
@@ -324,11 +324,11 @@ func subtractNumberTable(L *LState, number LNumber, table *LTable) int {
 	result := L.NewTable()
 	for i := 1; i <= table.Len(); i++ {
 		row := table.RawGetInt(i)
-		switch r := row.(type) {
+		switch r := floatValue(row).(type) {
 		case *LTable:
 			resultRow := L.NewTable()
 			for j := 1; j <= r.Len(); j++ {
-				val, ok := r.RawGetInt(j).(LNumber)
+				val, ok := matrixNumber(r.RawGetInt(j))
 				if !ok {
 					L.RaiseError("Invalid type in matrix at position [%d][%d]", i, j)
 					return 0
@@ -377,7 +377,7 @@ func matrixSum(L *LState) int {
 		for i := 1; i <= rows; i++ {
 			row := matrix.RawGetInt(i).(*LTable)
 			for j := 1; j <= cols; j++ {
-				val, ok := row.RawGetInt(j).(LNumber)
+				val, ok := matrixNumber(row.RawGetInt(j))
 				if !ok {
 					L.RaiseError("Invalid type in matrix at position [%d][%d]", i, j)
 					return 0
@@ -393,7 +393,7 @@ func matrixSum(L *LState) int {
 			colSum := LNumber(0)
 			for i := 1; i <= rows; i++ {
 				row := matrix.RawGetInt(i).(*LTable)
-				val, ok := row.RawGetInt(j).(LNumber)
+				val, ok := matrixNumber(row.RawGetInt(j))
 				if !ok {
 					L.RaiseError("Invalid type in matrix at position [%d][%d]", i, j)
 					return 0
@@ -433,8 +433,8 @@ func matrixHadamard(L *LState) int {
 		row2 := matrix2.RawGetInt(i).(*LTable)
 		resultRow := L.NewTable()
 		for j := 1; j <= cols1; j++ {
-			val1, ok1 := row1.RawGetInt(j).(LNumber)
-			val2, ok2 := row2.RawGetInt(j).(LNumber)
+			val1, ok1 := matrixNumber(row1.RawGetInt(j))
+			val2, ok2 := matrixNumber(row2.RawGetInt(j))
 			if !ok1 || !ok2 {
 				L.RaiseError("Invalid type in matrix at position [%d][%d]", i, j)
 				return 0
@@ -474,8 +474,8 @@ func matrixMul(L *LState) int {
 		for j := 1; j <= cols2; j++ {
 			sum := LNumber(0)
 			for k := 1; k <= cols1; k++ {
-				val1, ok1 := matrix1.RawGetInt(i).(*LTable).RawGetInt(k).(LNumber)
-				val2, ok2 := matrix2.RawGetInt(k).(*LTable).RawGetInt(j).(LNumber)
+				val1, ok1 := matrixNumber(matrix1.RawGetInt(i).(*LTable).RawGetInt(k))
+				val2, ok2 := matrixNumber(matrix2.RawGetInt(k).(*LTable).RawGetInt(j))
 				if !ok1 || !ok2 {
 					L.RaiseError("Invalid type in matrix at position [%d][%d]", i, j)
 					return 0
@@ -538,13 +538,13 @@ func matrixDiv(L *LState) int {
 				return 0
 			}
 
-			divisor := value2.(LNumber)
+			divisor := LVAsNumber(value2)
 			if divisor == 0 {
 				L.RaiseError("Division by zero at position [%d][%d]", i, j)
 				return 0
 			}
 
-			div := LNumber(float64(value1.(LNumber)) / float64(divisor))
+			div := LNumber(float64(LVAsNumber(value1)) / float64(divisor))
 			resultRow.RawSetInt(j, div)
 		}
 		result.RawSetInt(i, resultRow)
@@ -601,7 +601,7 @@ func matrixDot(L *LState) int {
 				return 0
 			}
 
-			result += value1.(LNumber) * value2.(LNumber)
+			result += LVAsNumber(value1) * LVAsNumber(value2)
 		}
 	}
 
@@ -625,8 +625,8 @@ func matrixCross(L *LState) int {
 
 	result := L.NewTable()
 
-	x1, y1, z1 := vec1.RawGetInt(1).(LNumber), vec1.RawGetInt(2).(LNumber), vec1.RawGetInt(3).(LNumber)
-	x2, y2, z2 := vec2.RawGetInt(1).(LNumber), vec2.RawGetInt(2).(LNumber), vec2.RawGetInt(3).(LNumber)
+	x1, y1, z1 := LVAsNumber(vec1.RawGetInt(1)), LVAsNumber(vec1.RawGetInt(2)), LVAsNumber(vec1.RawGetInt(3))
+	x2, y2, z2 := LVAsNumber(vec2.RawGetInt(1)), LVAsNumber(vec2.RawGetInt(2)), LVAsNumber(vec2.RawGetInt(3))
 
 	result.RawSetInt(1, y1*z2-z1*y2)
 	result.RawSetInt(2, z1*x2-x1*z2)
@@ -683,7 +683,7 @@ func matrixDet(L *LState) int {
 	det := LNumber(1)
 	n := matrix.Len()
 	for i := 1; i <= n; i++ {
-		det *= LU.RawGetInt(i).(*LTable).RawGetInt(i).(LNumber)
+		det *= LVAsNumber(LU.RawGetInt(i).(*LTable).RawGetInt(i))
 	}
 	// 考虑置换矩阵的符号
 	if countSwaps(P)%2 != 0 {
@@ -697,7 +697,7 @@ func countSwaps(P *LTable) int {
 	swaps := 0
 	n := P.Len()
 	for i := 1; i <= n; i++ {
-		if P.RawGetInt(i).(LNumber) != LNumber(i) {
+		if LVAsNumber(P.RawGetInt(i)) != LNumber(i) {
 			swaps++
 		}
 	}
@@ -725,7 +725,7 @@ func matrixInv(L *LState) int {
 
 	// 检查矩阵是否可逆
 	for i := 1; i <= n; i++ {
-		if LU.RawGetInt(i).(*LTable).RawGetInt(i).(LNumber) == 0 {
+		if LVAsNumber(LU.RawGetInt(i).(*LTable).RawGetInt(i)) == 0 {
 			L.RaiseError("Matrix is not invertible")
 			return 0
 		}
@@ -734,7 +734,7 @@ func matrixInv(L *LState) int {
 	for j := 1; j <= n; j++ {
 		b := L.NewTable()
 		for i := 1; i <= n; i++ {
-			if P.RawGetInt(i).(LNumber) == LNumber(j) {
+			if LVAsNumber(P.RawGetInt(i)) == LNumber(j) {
 				b.RawSetInt(i, LNumber(1))
 			} else {
 				b.RawSetInt(i, LNumber(0))
@@ -761,9 +761,9 @@ func luDecomposition(L *LState, matrix *LTable) (*LTable, *LTable) {
 
 	for k := 1; k <= n-1; k++ {
 		for i := k + 1; i <= n; i++ {
-			LU.RawGetInt(i).(*LTable).RawSetInt(k, LU.RawGetInt(i).(*LTable).RawGetInt(k).(LNumber)/LU.RawGetInt(k).(*LTable).RawGetInt(k).(LNumber))
+			LU.RawGetInt(i).(*LTable).RawSetInt(k, LVAsNumber(LU.RawGetInt(i).(*LTable).RawGetInt(k))/LVAsNumber(LU.RawGetInt(k).(*LTable).RawGetInt(k)))
 			for j := k + 1; j <= n; j++ {
-				LU.RawGetInt(i).(*LTable).RawSetInt(j, LU.RawGetInt(i).(*LTable).RawGetInt(j).(LNumber)-LU.RawGetInt(i).(*LTable).RawGetInt(k).(LNumber)*LU.RawGetInt(k).(*LTable).RawGetInt(j).(LNumber))
+				LU.RawGetInt(i).(*LTable).RawSetInt(j, LVAsNumber(LU.RawGetInt(i).(*LTable).RawGetInt(j))-LVAsNumber(LU.RawGetInt(i).(*LTable).RawGetInt(k))*LVAsNumber(LU.RawGetInt(k).(*LTable).RawGetInt(j)))
 			}
 		}
 	}
@@ -779,18 +779,18 @@ func luSolve(L *LState, LU, b *LTable) *LTable {
 	for i := 1; i <= n; i++ {
 		sum := LNumber(0)
 		for j := 1; j < i; j++ {
-			sum += LU.RawGetInt(i).(*LTable).RawGetInt(j).(LNumber) * y.RawGetInt(j).(LNumber)
+			sum += LVAsNumber(LU.RawGetInt(i).(*LTable).RawGetInt(j)) * LVAsNumber(y.RawGetInt(j))
 		}
-		y.RawSetInt(i, (b.RawGetInt(i).(LNumber) - sum))
+		y.RawSetInt(i, (LVAsNumber(b.RawGetInt(i)) - sum))
 	}
 
 	x := L.NewTable()
 	for i := n; i >= 1; i-- {
 		sum := LNumber(0)
 		for j := i + 1; j <= n; j++ {
-			sum += LU.RawGetInt(i).(*LTable).RawGetInt(j).(LNumber) * x.RawGetInt(j).(LNumber)
+			sum += LVAsNumber(LU.RawGetInt(i).(*LTable).RawGetInt(j)) * LVAsNumber(x.RawGetInt(j))
 		}
-		x.RawSetInt(i, (y.RawGetInt(i).(LNumber)-sum)/LU.RawGetInt(i).(*LTable).RawGetInt(i).(LNumber))
+		x.RawSetInt(i, (LVAsNumber(y.RawGetInt(i))-sum)/LVAsNumber(LU.RawGetInt(i).(*LTable).RawGetInt(i)))
 	}
 
 	return x
@@ -811,7 +811,7 @@ func lupDecomposition(L *LState, matrix *LTable) (*LTable, *LTable) {
 		maxVal := LNumber(0)
 		maxRow := 0
 		for i := k; i <= n; i++ {
-			val := LNumber(math.Abs(float64(LU.RawGetInt(i).(*LTable).RawGetInt(k).(LNumber))))
+			val := LNumber(math.Abs(float64(LVAsNumber(LU.RawGetInt(i).(*LTable).RawGetInt(k)))))
 			if val > maxVal {
 				maxVal = val
 				maxRow = i
@@ -835,10 +835,10 @@ func lupDecomposition(L *LState, matrix *LTable) (*LTable, *LTable) {
 
 		// 计算消元因子
 		for i := k + 1; i <= n; i++ {
-			factor := LU.RawGetInt(i).(*LTable).RawGetInt(k).(LNumber) / LU.RawGetInt(k).(*LTable).RawGetInt(k).(LNumber)
+			factor := LVAsNumber(LU.RawGetInt(i).(*LTable).RawGetInt(k)) / LVAsNumber(LU.RawGetInt(k).(*LTable).RawGetInt(k))
 			LU.RawGetInt(i).(*LTable).RawSetInt(k, factor)
 			for j := k + 1; j <= n; j++ {
-				LU.RawGetInt(i).(*LTable).RawSetInt(j, LU.RawGetInt(i).(*LTable).RawGetInt(j).(LNumber)-factor*LU.RawGetInt(k).(*LTable).RawGetInt(j).(LNumber))
+				LU.RawGetInt(i).(*LTable).RawSetInt(j, LVAsNumber(LU.RawGetInt(i).(*LTable).RawGetInt(j))-factor*LVAsNumber(LU.RawGetInt(k).(*LTable).RawGetInt(j)))
 			}
 		}
 	}
@@ -902,14 +902,14 @@ func matrixScale(L *LState) int {
 	input := L.CheckAny(1)
 	scalar := L.OptNumber(2, 1)
 
-	switch v := input.(type) {
+	switch v := floatValue(input).(type) {
 	case LNumber:
 		L.Push(LNumber(float64(v) * float64(scalar)))
 	case *LTable:
 		result := L.NewTable()
 		for i := 1; i <= v.Len(); i++ {
 			row := v.RawGetInt(i)
-			switch r := row.(type) {
+			switch r := floatValue(row).(type) {
 			case LNumber:
 				result.RawSetInt(i, LNumber(float64(r)*float64(scalar)))
 			case *LTable:
@@ -920,7 +920,7 @@ func matrixScale(L *LState) int {
 						L.RaiseError("Unsupported type in matrix at position [%d][%d]", i, j)
 						return 0
 					}
-					resultRow.RawSetInt(j, LNumber(float64(value.(LNumber))*float64(scalar)))
+					resultRow.RawSetInt(j, LNumber(float64(LVAsNumber(value))*float64(scalar)))
 				}
 				result.RawSetInt(i, resultRow)
 			default:
@@ -996,7 +996,7 @@ func matrixTrace(L *LState) int {
 			L.RaiseError("Invalid type in matrix at position [%d][%d]", i, i)
 			return 0
 		}
-		trace += value.(LNumber)
+		trace += LVAsNumber(value)
 	}
 
 	L.Push(trace)
@@ -1116,7 +1116,7 @@ func isConverged(matrix *LTable) bool {
 
 	for i := 1; i <= n; i++ {
 		for j := 1; j <= n; j++ {
-			if i != j && math.Abs(float64(matrix.RawGetInt(i).(*LTable).RawGetInt(j).(LNumber))) > 1e-6 {
+			if i != j && math.Abs(float64(LVAsNumber(matrix.RawGetInt(i).(*LTable).RawGetInt(j)))) > 1e-6 {
 				return false
 			}
 		}
@@ -1131,7 +1131,7 @@ func calculateHouseholderMatrix(L *LState, vector *LTable) *LTable {
 
 	v := L.NewTable()
 	for i := 1; i <= n; i++ {
-		value := vector.RawGetInt(i).(LNumber)
+		value := LVAsNumber(vector.RawGetInt(i))
 		if i == 1 {
 			sign := LNumber(1)
 			if value < 0 {
@@ -1148,9 +1148,9 @@ func calculateHouseholderMatrix(L *LState, vector *LTable) *LTable {
 		H.RawSetInt(i, L.NewTable())
 		for j := 1; j <= n; j++ {
 			if i == j {
-				H.RawGetInt(i).(*LTable).RawSetInt(j, LNumber(1)-2*v.RawGetInt(i).(LNumber)*v.RawGetInt(j).(LNumber)/(vMagnitude*vMagnitude))
+				H.RawGetInt(i).(*LTable).RawSetInt(j, LNumber(1)-2*LVAsNumber(v.RawGetInt(i))*LVAsNumber(v.RawGetInt(j))/(vMagnitude*vMagnitude))
 			} else {
-				H.RawGetInt(i).(*LTable).RawSetInt(j, -2*v.RawGetInt(i).(LNumber)*v.RawGetInt(j).(LNumber)/(vMagnitude*vMagnitude))
+				H.RawGetInt(i).(*LTable).RawSetInt(j, -2*LVAsNumber(v.RawGetInt(i))*LVAsNumber(v.RawGetInt(j))/(vMagnitude*vMagnitude))
 			}
 		}
 	}
@@ -1161,7 +1161,7 @@ func calculateHouseholderMatrix(L *LState, vector *LTable) *LTable {
 func calculateVectorMagnitude(vector *LTable) LNumber {
 	magnitude := LNumber(0)
 	for i := 1; i <= vector.Len(); i++ {
-		value := vector.RawGetInt(i).(LNumber)
+		value := LVAsNumber(vector.RawGetInt(i))
 		magnitude += value * value
 	}
 	return LNumber(math.Sqrt(float64(magnitude)))
@@ -1178,7 +1178,7 @@ func matrixMultiply(L *LState, matrix1, matrix2 *LTable) *LTable {
 			var sum LNumber
 			for k := 1; k <= row1.Len(); k++ {
 				col2 := matrix2.RawGetInt(k).(*LTable)
-				sum += row1.RawGetInt(k).(LNumber) * col2.RawGetInt(j).(LNumber)
+				sum += LVAsNumber(row1.RawGetInt(k)) * LVAsNumber(col2.RawGetInt(j))
 			}
 			resultRow.RawSetInt(j, sum)
 		}
@@ -1199,7 +1199,7 @@ func copyMatrix(L *LState, matrix *LTable) *LTable {
 		row := matrix.RawGetInt(i).(*LTable)
 		resultRow := L.NewTable()
 		for j := 1; j <= row.Len(); j++ {
-			value, ok := row.RawGetInt(j).(LNumber)
+			value, ok := matrixNumber(row.RawGetInt(j))
 			if !ok {
 				L.RaiseError("Invalid type in matrix at position [%d][%d]", i, j)
 				return nil
@@ -1282,13 +1282,13 @@ func gaussJordanElimination(L *LState, matrix *LTable) *LTable {
 		// 选取主元
 		pivotRow := row
 		for i := row; i <= n; i++ {
-			if rref.RawGetInt(i).(*LTable).RawGetInt(col).(LNumber) != 0 {
+			if LVAsNumber(rref.RawGetInt(i).(*LTable).RawGetInt(col)) != 0 {
 				pivotRow = i
 				break
 			}
 		}
 
-		if rref.RawGetInt(pivotRow).(*LTable).RawGetInt(col).(LNumber) == 0 {
+		if LVAsNumber(rref.RawGetInt(pivotRow).(*LTable).RawGetInt(col)) == 0 {
 			continue
 		}
 
@@ -1298,9 +1298,9 @@ func gaussJordanElimination(L *LState, matrix *LTable) *LTable {
 		rref.RawSetInt(pivotRow, tempRow)
 
 		// 主元归一
-		pivot := rref.RawGetInt(row).(*LTable).RawGetInt(col).(LNumber)
+		pivot := LVAsNumber(rref.RawGetInt(row).(*LTable).RawGetInt(col))
 		for j := col; j <= m; j++ {
-			rref.RawGetInt(row).(*LTable).RawSetInt(j, rref.RawGetInt(row).(*LTable).RawGetInt(j).(LNumber)/pivot)
+			rref.RawGetInt(row).(*LTable).RawSetInt(j, LVAsNumber(rref.RawGetInt(row).(*LTable).RawGetInt(j))/pivot)
 		}
 
 		// 消元
@@ -1309,9 +1309,9 @@ func gaussJordanElimination(L *LState, matrix *LTable) *LTable {
 				continue
 			}
 
-			factor := rref.RawGetInt(i).(*LTable).RawGetInt(col).(LNumber)
+			factor := LVAsNumber(rref.RawGetInt(i).(*LTable).RawGetInt(col))
 			for j := col; j <= m; j++ {
-				rref.RawGetInt(i).(*LTable).RawSetInt(j, rref.RawGetInt(i).(*LTable).RawGetInt(j).(LNumber)-factor*rref.RawGetInt(row).(*LTable).RawGetInt(j).(LNumber))
+				rref.RawGetInt(i).(*LTable).RawSetInt(j, LVAsNumber(rref.RawGetInt(i).(*LTable).RawGetInt(j))-factor*LVAsNumber(rref.RawGetInt(row).(*LTable).RawGetInt(j)))
 			}
 		}
 
@@ -1344,7 +1344,7 @@ func findFreeVariables(matrix *LTable) []int {
 	for j := 1; j <= m; j++ {
 		isPivot := false
 		for i := 1; i <= matrix.Len(); i++ {
-			if matrix.RawGetInt(i).(*LTable).RawGetInt(j).(LNumber) != 0 {
+			if LVAsNumber(matrix.RawGetInt(i).(*LTable).RawGetInt(j)) != 0 {
 				isPivot = true
 				break
 			}
@@ -1368,7 +1368,7 @@ func constructNullspaceBasis(L *LState, rref *LTable, freeVariables []int) *LTab
 			if i == j {
 				vector.RawSetInt(i, LNumber(1))
 			} else if i <= n {
-				vector.RawSetInt(i, -rref.RawGetInt(i).(*LTable).RawGetInt(j).(LNumber))
+				vector.RawSetInt(i, -LVAsNumber(rref.RawGetInt(i).(*LTable).RawGetInt(j)))
 			} else {
 				vector.RawSetInt(i, LNumber(0))
 			}
@@ -1407,7 +1407,7 @@ func extractNonZeroRows(L *LState, matrix *LTable) *LTable {
 		row := matrix.RawGetInt(i).(*LTable)
 		nonZero := false
 		for j := 1; j <= row.Len(); j++ {
-			if row.RawGetInt(j).(LNumber) != 0 {
+			if LVAsNumber(row.RawGetInt(j)) != 0 {
 				nonZero = true
 				break
 			}
@@ -1457,7 +1457,7 @@ func normalizeVector(L *LState, vector *LTable) *LTable {
 	magnitude := calculateVectorMagnitude(vector)
 	normalized := L.NewTable()
 	for i := 1; i <= vector.Len(); i++ {
-		normalized.RawSetInt(i, vector.RawGetInt(i).(LNumber)/magnitude)
+		normalized.RawSetInt(i, LVAsNumber(vector.RawGetInt(i))/magnitude)
 	}
 	return normalized
 }
@@ -1474,7 +1474,7 @@ func calculateProjection(L *LState, v, u *LTable) *LTable {
 	projection := copyVector(L, u)
 	dot := calculateDotProduct(v, u)
 	for i := 1; i <= projection.Len(); i++ {
-		projection.RawSetInt(i, projection.RawGetInt(i).(LNumber)*dot)
+		projection.RawSetInt(i, LVAsNumber(projection.RawGetInt(i))*dot)
 	}
 	return projection
 }
@@ -1482,7 +1482,7 @@ func calculateProjection(L *LState, v, u *LTable) *LTable {
 func calculateDotProduct(v, u *LTable) LNumber {
 	dot := LNumber(0)
 	for i := 1; i <= v.Len(); i++ {
-		dot += v.RawGetInt(i).(LNumber) * u.RawGetInt(i).(LNumber)
+		dot += LVAsNumber(v.RawGetInt(i)) * LVAsNumber(u.RawGetInt(i))
 	}
 	return dot
 }
@@ -1490,7 +1490,7 @@ func calculateDotProduct(v, u *LTable) LNumber {
 func subtract(L *LState, v, u *LTable) *LTable {
 	result := L.NewTable()
 	for i := 1; i <= v.Len(); i++ {
-		result.RawSetInt(i, v.RawGetInt(i).(LNumber)-u.RawGetInt(i).(LNumber))
+		result.RawSetInt(i, LVAsNumber(v.RawGetInt(i))-LVAsNumber(u.RawGetInt(i)))
 	}
 	return result
 }
@@ -1587,10 +1587,10 @@ func jacobiIteration(L *LState, A, b *LTable, maxIterations int, tolerance LNumb
 			sum := LNumber(0)
 			for j := 1; j <= n; j++ {
 				if i != j {
-					sum += A.RawGetInt(i).(*LTable).RawGetInt(j).(LNumber) * x.RawGetInt(j).(LNumber)
+					sum += LVAsNumber(A.RawGetInt(i).(*LTable).RawGetInt(j)) * LVAsNumber(x.RawGetInt(j))
 				}
 			}
-			xNew.RawSetInt(i, (b.RawGetInt(i).(LNumber)-sum)/A.RawGetInt(i).(*LTable).RawGetInt(i).(LNumber))
+			xNew.RawSetInt(i, (LVAsNumber(b.RawGetInt(i))-sum)/LVAsNumber(A.RawGetInt(i).(*LTable).RawGetInt(i)))
 		}
 
 		// 检查收敛
@@ -1608,7 +1608,7 @@ func isConvergedJacobi(x, xNew *LTable, tolerance LNumber) bool {
 	n := x.Len()
 
 	for i := 1; i <= n; i++ {
-		if math.Abs(float64(x.RawGetInt(i).(LNumber)-xNew.RawGetInt(i).(LNumber))) > float64(tolerance) {
+		if math.Abs(float64(LVAsNumber(x.RawGetInt(i))-LVAsNumber(xNew.RawGetInt(i)))) > float64(tolerance) {
 			return false
 		}
 	}
@@ -1685,7 +1685,7 @@ func multiplyMatrixVector(L *LState, matrix, vector *LTable) *LTable {
 		row := matrix.RawGetInt(i).(*LTable)
 		sum := LNumber(0)
 		for j := 1; j <= row.Len(); j++ {
-			sum += row.RawGetInt(j).(LNumber) * vector.RawGetInt(j).(LNumber)
+			sum += LVAsNumber(row.RawGetInt(j)) * LVAsNumber(vector.RawGetInt(j))
 		}
 		result.RawSetInt(i, sum)
 	}
@@ -1695,7 +1695,7 @@ func multiplyMatrixVector(L *LState, matrix, vector *LTable) *LTable {
 func multiplyVectorScalar(L *LState, vector *LTable, scalar LNumber) *LTable {
 	result := L.NewTable()
 	for i := 1; i <= vector.Len(); i++ {
-		result.RawSetInt(i, vector.RawGetInt(i).(LNumber)*scalar)
+		result.RawSetInt(i, LVAsNumber(vector.RawGetInt(i))*scalar)
 	}
 	return result
 }
@@ -1741,7 +1741,7 @@ func isConvergedPowerMethod(x, y *LTable, tolerance LNumber) bool {
 	n := x.Len()
 
 	for i := 1; i <= n; i++ {
-		if math.Abs(float64(x.RawGetInt(i).(LNumber)-y.RawGetInt(i).(LNumber))) > float64(tolerance) {
+		if math.Abs(float64(LVAsNumber(x.RawGetInt(i))-LVAsNumber(y.RawGetInt(i)))) > float64(tolerance) {
 			return false
 		}
 	}
@@ -1769,3 +1769,18 @@ func matrixExponentiation(L *LState, matrix *LTable, power int) *LTable {
 
 	return result
 }
+
+// floatValue converts integers to floats, so that matrix elements can be
+// handled as LNumber.
+func floatValue(v LValue) LValue {
+	if iv, ok := v.(LInteger); ok {
+		return LNumber(iv)
+	}
+	return v
+}
+
+// matrixNumber returns a matrix element as a float.
+func matrixNumber(v LValue) (LNumber, bool) {
+	nv, ok := floatValue(v).(LNumber)
+	return nv, ok
+}
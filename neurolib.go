@@ -90,8 +90,8 @@ func mathCrossEntropy(L *LState) int {
 		yHatRow := yHat.RawGetInt(i).(*LTable)
 
 		for j := 1; j <= yRow.Len(); j++ {
-			yVal := float64(yRow.RawGetInt(j).(LNumber))
-			yHatVal := float64(yHatRow.RawGetInt(j).(LNumber))
+			yVal := float64(LVAsNumber(yRow.RawGetInt(j)))
+			yHatVal := float64(LVAsNumber(yHatRow.RawGetInt(j)))
 
 			// 避免 log(0) 的情况
 			yHatVal = math.Max(math.Min(yHatVal, 1-1e-15), 1e-15)
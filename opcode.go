@@ -41,7 +41,7 @@ const (
 	OP_MOVEN               /*      A B     R(A) := R(B); followed by R(C) MOVE ops */
 	OP_LOADK               /*     A Bx    R(A) := Kst(Bx)                          */
 	OP_LOADBOOL            /*  A B C   R(A) := (Bool)B; if (C) pc++                */
-	OP_LOADNIL             /*   A B     R(A) := ... := R(B) := nil                 */
+	OP_LOADNIL             /*   A B C   R(A) := ... := R(B) := nil; if (C) top := B+1 */
 	OP_GETUPVAL            /*  A B     R(A) := UpValue[B]                          */
 
 	OP_GETGLOBAL  /* A Bx    R(A) := Gbl[Kst(Bx)]                            */
@@ -95,6 +95,24 @@ const (
 
 	OP_VARARG /*     A B     R(A) R(A+1) ... R(A+B-1) = vararg            */
 
+	OP_TYPECHECK /*  A B C   if not (type(R(A)) in B) then error(Kst(C)) */
+
+	OP_IDIV /*      A B C   R(A) := RK(B) // RK(C)                          */
+	OP_BAND /*      A B C   R(A) := RK(B) & RK(C)                           */
+	OP_BOR  /*      A B C   R(A) := RK(B) | RK(C)                           */
+	OP_BXOR /*      A B C   R(A) := RK(B) ~ RK(C)                           */
+	OP_SHL  /*      A B C   R(A) := RK(B) << RK(C)                          */
+	OP_SHR  /*      A B C   R(A) := RK(B) >> RK(C)                          */
+	OP_BNOT /*      A B     R(A) := ~R(B)                                   */
+
+	OP_TOSTRING /*  A B     R(A) := tostring(R(B))                          */
+	OP_CLASS    /*  A Bx    R(A) := class Kst(Bx) extending R(A)            */
+	OP_TBC      /*  A Bx    mark R(A), the local Kst(Bx), as to-be-closed   */
+	OP_TESTTYPE /*  A B C   if ((type(R(A)) in B) ~= C) then pc++           */
+	OP_JMPTABLE /*  A Bx    if R(A) in JMPTABLE[Bx] then pc := JMPTABLE[Bx][R(A)] */
+
+	OP_NAMEDARGS /* A B     R(A+B) ... R(top-1) := params of R(A) named in R(A+B) */
+
 	OP_NOP /* NOP */
 )
 const opCodeMax = OP_NOP
@@ -167,6 +185,20 @@ var opProps = []opProp{
 	{"CLOSE", false, false, opArgModeN, opArgModeN, opTypeABC},
 	{"CLOSURE", false, true, opArgModeU, opArgModeN, opTypeABx},
 	{"VARARG", false, true, opArgModeU, opArgModeN, opTypeABC},
+	{"TYPECHECK", false, false, opArgModeU, opArgModeK, opTypeABC},
+	{"IDIV", false, true, opArgModeK, opArgModeK, opTypeABC},
+	{"BAND", false, true, opArgModeK, opArgModeK, opTypeABC},
+	{"BOR", false, true, opArgModeK, opArgModeK, opTypeABC},
+	{"BXOR", false, true, opArgModeK, opArgModeK, opTypeABC},
+	{"SHL", false, true, opArgModeK, opArgModeK, opTypeABC},
+	{"SHR", false, true, opArgModeK, opArgModeK, opTypeABC},
+	{"BNOT", false, true, opArgModeR, opArgModeN, opTypeABC},
+	{"TOSTRING", false, true, opArgModeR, opArgModeN, opTypeABC},
+	{"CLASS", false, true, opArgModeK, opArgModeN, opTypeABx},
+	{"TBC", false, false, opArgModeK, opArgModeN, opTypeABx},
+	{"TESTTYPE", true, false, opArgModeU, opArgModeU, opTypeABC},
+	{"JMPTABLE", false, false, opArgModeU, opArgModeN, opTypeABx},
+	{"NAMEDARGS", false, false, opArgModeU, opArgModeN, opTypeABC},
 	{"NOP", false, false, opArgModeR, opArgModeN, opTypeASbx},
 }
 
@@ -292,6 +324,9 @@ func opToString(inst uint32) string {
 		buf += fmt.Sprintf("; R(%v) := (Bool)%v; if (%v) pc++", arga, argb, argc)
 	case OP_LOADNIL:
 		buf += fmt.Sprintf("; R(%v) := ... := R(%v) := nil", arga, argb)
+		if argc != 0 {
+			buf += fmt.Sprintf("; top := %v", argb+1)
+		}
 	case OP_GETUPVAL:
 		buf += fmt.Sprintf("; R(%v) := UpValue[%v]", arga, argb)
 	case OP_GETGLOBAL:
@@ -364,6 +399,34 @@ func opToString(inst uint32) string {
 		buf += fmt.Sprintf("; R(%v) := closure(KPROTO[%v] R(%v) ... R(%v+n))", arga, argbx, arga, arga)
 	case OP_VARARG:
 		buf += fmt.Sprintf(";  R(%v) R(%v+1) ... R(%v+%v-1) = vararg", arga, arga, arga, argb)
+	case OP_TYPECHECK:
+		buf += fmt.Sprintf("; if not (type(R(%v)) in %v) then error(Kst(%v))", arga, typeMaskString(argb), argc)
+	case OP_IDIV:
+		buf += fmt.Sprintf("; R(%v) := RK(%v) // RK(%v)", arga, argb, argc)
+	case OP_BAND:
+		buf += fmt.Sprintf("; R(%v) := RK(%v) & RK(%v)", arga, argb, argc)
+	case OP_BOR:
+		buf += fmt.Sprintf("; R(%v) := RK(%v) | RK(%v)", arga, argb, argc)
+	case OP_BXOR:
+		buf += fmt.Sprintf("; R(%v) := RK(%v) ~ RK(%v)", arga, argb, argc)
+	case OP_SHL:
+		buf += fmt.Sprintf("; R(%v) := RK(%v) << RK(%v)", arga, argb, argc)
+	case OP_SHR:
+		buf += fmt.Sprintf("; R(%v) := RK(%v) >> RK(%v)", arga, argb, argc)
+	case OP_BNOT:
+		buf += fmt.Sprintf("; R(%v) := ~R(%v)", arga, argb)
+	case OP_TOSTRING:
+		buf += fmt.Sprintf("; R(%v) := tostring(R(%v))", arga, argb)
+	case OP_CLASS:
+		buf += fmt.Sprintf("; R(%v) := class Kst(%v) extending R(%v)", arga, argbx, arga)
+	case OP_TBC:
+		buf += fmt.Sprintf("; mark R(%v), the local Kst(%v), as to-be-closed", arga, argbx)
+	case OP_TESTTYPE:
+		buf += fmt.Sprintf("; if ((type(R(%v)) in %v) ~= %v) then pc++", arga, typeMaskString(argb), argc)
+	case OP_JMPTABLE:
+		buf += fmt.Sprintf("; if R(%v) in JMPTABLE[%v] then pc := JMPTABLE[%v][R(%v)]", arga, argbx, argbx, arga)
+	case OP_NAMEDARGS:
+		buf += fmt.Sprintf("; R(%v+%v) ... R(top-1) := params of R(%v) named in R(%v+%v)", arga, argb, arga, arga, argb)
 	case OP_NOP:
 		/* nothing to do */
 	}
@@ -0,0 +1,627 @@
+package lua
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/zmsvDreamLang/Milk/ast"
+)
+
+/* optimizer {{{ */
+
+// Optimize rewrites a parsed chunk before it is compiled. At level 0 the chunk
+// is returned untouched. At level 1 constant expressions are folded, branches
+// on constant conditions are removed and <const> locals initialized with
+// constants are replaced by their values.
+func Optimize(chunk []ast.Stmt, level int) []ast.Stmt {
+	if level < 1 {
+		return chunk
+	}
+	op := &optimizer{
+		bindings: map[*ast.IdentExpr]*optLocal{},
+		decls:    map[*ast.LocalAssignStmt][]*optLocal{},
+	}
+	op.enterScope()
+	op.resolveBlock(chunk)
+	op.leaveScope()
+	return op.foldBlock(chunk)
+}
+
+// optLocal is a local variable declaration. value is its constant value once
+// known, nil otherwise. Other locals are never inlined: they can be assigned or
+// changed by debug.setlocal.
+type optLocal struct {
+	value    ast.Expr
+	constant bool
+}
+
+type optScope struct {
+	parent *optScope
+	vars   map[string]*optLocal
+}
+
+// optimizer works in two passes: resolveBlock binds identifiers to the local
+// declarations they refer to, then foldBlock folds constants and inlines the
+// constant locals.
+type optimizer struct {
+	scope    *optScope
+	until    *optScope // body scope of the repeat whose condition is resolved
+	bindings map[*ast.IdentExpr]*optLocal
+	decls    map[*ast.LocalAssignStmt][]*optLocal
+}
+
+func (op *optimizer) enterScope() {
+	op.scope = &optScope{parent: op.scope, vars: map[string]*optLocal{}}
+}
+
+func (op *optimizer) leaveScope() {
+	op.scope = op.scope.parent
+}
+
+func (op *optimizer) declare(name string, constant bool) *optLocal {
+	local := &optLocal{constant: constant}
+	op.scope.vars[name] = local
+	return local
+}
+
+func (op *optimizer) lookup(name string) *optLocal {
+	for scope := op.scope; scope != nil; scope = scope.parent {
+		if local, ok := scope.vars[name]; ok {
+			return local
+		}
+	}
+	return nil
+}
+
+/* }}} */
+
+/* resolving {{{ */
+
+func (op *optimizer) resolveBlock(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		op.resolveStmt(stmt)
+	}
+}
+
+func (op *optimizer) resolveScope(stmts []ast.Stmt) {
+	op.enterScope()
+	op.resolveBlock(stmts)
+	op.leaveScope()
+}
+
+// resolveTarget resolves the target of an assignment, assigned identifiers
+// are not inlined.
+func (op *optimizer) resolveTarget(expr ast.Expr) {
+	if _, ok := expr.(*ast.IdentExpr); !ok {
+		op.resolveExpr(expr)
+	}
+}
+
+func (op *optimizer) resolveStmt(stmt ast.Stmt) {
+	switch st := stmt.(type) {
+	case *ast.AssignStmt:
+		op.resolveExprs(st.Rhs)
+		for _, lhs := range st.Lhs {
+			op.resolveTarget(lhs)
+		}
+	case *ast.CompoundAssignStmt:
+		op.resolveExpr(st.Rhs)
+		op.resolveTarget(st.Lhs)
+	case *ast.LocalAssignStmt:
+		op.resolveLocalAssignStmt(st)
+	case *ast.FuncCallStmt:
+		op.resolveExpr(st.Expr)
+	case *ast.DoBlockStmt:
+		op.resolveScope(st.Stmts)
+	case *ast.WhileStmt:
+		op.resolveExpr(st.Condition)
+		op.resolveScope(st.Stmts)
+	case *ast.RepeatStmt:
+		// the condition sees the locals of the body, they are not inlined
+		// there so that the compiler can check them against 'continue'
+		op.enterScope()
+		op.resolveBlock(st.Stmts)
+		until := op.until
+		op.until = op.scope
+		op.resolveExpr(st.Condition)
+		op.until = until
+		op.leaveScope()
+	case *ast.MatchStmt:
+		op.resolveExpr(st.Value)
+		for _, arm := range st.Arms {
+			op.enterScope()
+			op.resolvePattern(arm.Pattern)
+			for _, name := range patternBindings(arm.Pattern) {
+				op.declare(name, false)
+			}
+			if arm.Guard != nil {
+				op.resolveExpr(arm.Guard)
+			}
+			op.resolveBlock(arm.Stmts)
+			op.leaveScope()
+		}
+	case *ast.IfStmt:
+		op.resolveExpr(st.Condition)
+		op.resolveScope(st.Then)
+		op.resolveScope(st.Else)
+	case *ast.NumberForStmt:
+		op.resolveExpr(st.Init)
+		op.resolveExpr(st.Limit)
+		if st.Step != nil {
+			op.resolveExpr(st.Step)
+		}
+		op.enterScope()
+		op.declare(st.Name, false)
+		op.resolveBlock(st.Stmts)
+		op.leaveScope()
+	case *ast.GenericForStmt:
+		op.resolveExprs(st.Exprs)
+		op.enterScope()
+		for _, name := range st.Names {
+			op.declare(name, false)
+		}
+		op.resolveBlock(st.Stmts)
+		op.leaveScope()
+	case *ast.FuncDefStmt:
+		if st.Name.Func == nil {
+			op.resolveExpr(st.Name.Receiver)
+			op.resolveFunction(st.Func, true)
+		} else {
+			op.resolveFunction(st.Func, false)
+			op.resolveTarget(st.Name.Func)
+		}
+	case *ast.ClassStmt:
+		if st.Local {
+			op.declare(st.Name, false)
+		}
+		if st.Base != nil {
+			op.resolveExpr(st.Base)
+		}
+		for _, member := range st.Members {
+			if fn, ok := member.Value.(*ast.FunctionExpr); ok {
+				op.resolveFunction(fn, !member.Static)
+			} else {
+				op.resolveExpr(member.Value)
+			}
+		}
+	case *ast.ReturnStmt:
+		op.resolveExprs(st.Exprs)
+	}
+}
+
+func (op *optimizer) resolveLocalAssignStmt(st *ast.LocalAssignStmt) {
+	// 'local function f' can refer to itself
+	if len(st.Names) == 1 && len(st.Exprs) == 1 {
+		if _, ok := st.Exprs[0].(*ast.FunctionExpr); ok {
+			op.decls[st] = []*optLocal{op.declare(st.Names[0], false)}
+			op.resolveExprs(st.Exprs)
+			return
+		}
+	}
+	op.resolveExprs(st.Exprs)
+	for _, field := range st.Pattern {
+		if field.Default != nil {
+			op.resolveExpr(field.Default)
+		}
+	}
+	locals := make([]*optLocal, len(st.Names))
+	for i, name := range st.Names {
+		// a trailing call or vararg may set several locals
+		constant := st.Pattern == nil && (i < len(st.Exprs) || len(st.Exprs) == 0 || !isVarArgReturnExpr(st.Exprs[len(st.Exprs)-1]))
+		if i >= len(st.Attribs) || st.Attribs[i] != "const" {
+			constant = false
+		}
+		locals[i] = op.declare(name, constant)
+	}
+	op.decls[st] = locals
+}
+
+// resolvePattern resolves the keys of table patterns, the only expressions
+// of a pattern that are evaluated.
+func (op *optimizer) resolvePattern(pattern ast.Expr) {
+	if tbl, ok := pattern.(*ast.TableExpr); ok {
+		for _, field := range tbl.Fields {
+			if field.Key != nil {
+				op.resolveExpr(field.Key)
+			}
+			op.resolvePattern(field.Value)
+		}
+	}
+}
+
+func (op *optimizer) resolveFunction(fn *ast.FunctionExpr, method bool) {
+	op.enterScope()
+	if method {
+		op.declare("self", false)
+	}
+	for _, name := range fn.ParList.Names {
+		op.declare(name, false)
+	}
+	if fn.ParList.HasVargs {
+		op.declare("arg", false)
+	}
+	for _, def := range fn.ParList.Defaults {
+		if def != nil {
+			op.resolveExpr(def)
+		}
+	}
+	op.resolveBlock(fn.Stmts)
+	op.leaveScope()
+}
+
+func (op *optimizer) resolveExprs(exprs []ast.Expr) {
+	for _, expr := range exprs {
+		op.resolveExpr(expr)
+	}
+}
+
+func (op *optimizer) resolveExpr(expr ast.Expr) {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		if op.until != nil && op.until.vars[ex.Value] != nil {
+			break
+		}
+		if local := op.lookup(ex.Value); local != nil {
+			op.bindings[ex] = local
+		}
+	case *ast.AttrGetExpr:
+		op.resolveExpr(ex.Object)
+		op.resolveExpr(ex.Key)
+	case *ast.TableExpr:
+		for _, field := range ex.Fields {
+			if field.Key != nil {
+				op.resolveExpr(field.Key)
+			}
+			op.resolveExpr(field.Value)
+		}
+	case *ast.FuncCallExpr:
+		if ex.Func != nil {
+			op.resolveExpr(ex.Func)
+		} else {
+			op.resolveExpr(ex.Receiver)
+		}
+		op.resolveExprs(ex.Args)
+	case *ast.LogicalOpExpr:
+		op.resolveExpr(ex.Lhs)
+		op.resolveExpr(ex.Rhs)
+	case *ast.NilCoalesceOpExpr:
+		op.resolveExpr(ex.Lhs)
+		op.resolveExpr(ex.Rhs)
+	case *ast.RelationalOpExpr:
+		op.resolveExpr(ex.Lhs)
+		op.resolveExpr(ex.Rhs)
+	case *ast.StringConcatOpExpr:
+		op.resolveExpr(ex.Lhs)
+		op.resolveExpr(ex.Rhs)
+	case *ast.ArithmeticOpExpr:
+		op.resolveExpr(ex.Lhs)
+		op.resolveExpr(ex.Rhs)
+	case *ast.InterpolatedStringExpr:
+		op.resolveExprs(ex.Parts)
+	case *ast.UnaryMinusOpExpr:
+		op.resolveExpr(ex.Expr)
+	case *ast.UnaryNotOpExpr:
+		op.resolveExpr(ex.Expr)
+	case *ast.UnaryLenOpExpr:
+		op.resolveExpr(ex.Expr)
+	case *ast.UnaryBNotOpExpr:
+		op.resolveExpr(ex.Expr)
+	case *ast.FunctionExpr:
+		op.resolveFunction(ex, false)
+	}
+}
+
+/* }}} */
+
+/* folding {{{ */
+
+func (op *optimizer) foldBlock(stmts []ast.Stmt) []ast.Stmt {
+	result := make([]ast.Stmt, 0, len(stmts))
+	for _, stmt := range stmts {
+		result = append(result, op.foldStmt(stmt)...)
+	}
+	return result
+}
+
+// foldStmt returns the statements replacing stmt, none when it is dead code.
+func (op *optimizer) foldStmt(stmt ast.Stmt) []ast.Stmt {
+	switch st := stmt.(type) {
+	case *ast.AssignStmt:
+		st.Rhs = op.foldExprs(st.Rhs)
+		for i, lhs := range st.Lhs {
+			if _, ok := lhs.(*ast.IdentExpr); !ok {
+				st.Lhs[i] = op.foldExpr(lhs)
+			}
+		}
+	case *ast.CompoundAssignStmt:
+		st.Rhs = op.foldExpr(st.Rhs)
+		if attr, ok := st.Lhs.(*ast.AttrGetExpr); ok {
+			attr.Object = op.foldExpr(attr.Object)
+			attr.Key = op.foldExpr(attr.Key)
+		}
+	case *ast.LocalAssignStmt:
+		st.Exprs = op.foldExprs(st.Exprs)
+		for _, field := range st.Pattern {
+			if field.Default != nil {
+				field.Default = op.foldExpr(field.Default)
+			}
+		}
+		for i, local := range op.decls[st] {
+			if !local.constant {
+				continue
+			}
+			if i >= len(st.Exprs) {
+				local.value = &ast.NilExpr{}
+			} else if _, ok := literalValue(st.Exprs[i]); ok {
+				local.value = st.Exprs[i]
+			}
+		}
+	case *ast.FuncCallStmt:
+		st.Expr = op.foldExpr(st.Expr)
+	case *ast.DoBlockStmt:
+		st.Stmts = op.foldBlock(st.Stmts)
+	case *ast.WhileStmt:
+		st.Condition = op.foldExpr(st.Condition)
+		if value, ok := literalValue(st.Condition); ok && !LVAsBool(value) {
+			return nil
+		}
+		st.Stmts = op.foldBlock(st.Stmts)
+	case *ast.RepeatStmt:
+		st.Stmts = op.foldBlock(st.Stmts)
+		st.Condition = op.foldExpr(st.Condition)
+	case *ast.MatchStmt:
+		st.Value = op.foldExpr(st.Value)
+		for _, arm := range st.Arms {
+			if arm.Guard != nil {
+				arm.Guard = op.foldExpr(arm.Guard)
+			}
+			arm.Stmts = op.foldBlock(arm.Stmts)
+		}
+	case *ast.IfStmt:
+		st.Condition = op.foldExpr(st.Condition)
+		if value, ok := literalValue(st.Condition); ok {
+			// the remaining branch keeps its own scope
+			branch := st.Else
+			if LVAsBool(value) {
+				branch = st.Then
+			}
+			if len(branch) == 0 {
+				return nil
+			}
+			block := &ast.DoBlockStmt{Stmts: op.foldBlock(branch)}
+			block.SetLine(sline(branch[0]))
+			block.SetLastLine(eline(branch[len(branch)-1]))
+			return []ast.Stmt{block}
+		}
+		st.Then = op.foldBlock(st.Then)
+		st.Else = op.foldBlock(st.Else)
+	case *ast.NumberForStmt:
+		st.Init = op.foldExpr(st.Init)
+		st.Limit = op.foldExpr(st.Limit)
+		if st.Step != nil {
+			st.Step = op.foldExpr(st.Step)
+		}
+		st.Stmts = op.foldBlock(st.Stmts)
+	case *ast.GenericForStmt:
+		st.Exprs = op.foldExprs(st.Exprs)
+		st.Stmts = op.foldBlock(st.Stmts)
+	case *ast.FuncDefStmt:
+		if st.Name.Func == nil {
+			st.Name.Receiver = op.foldExpr(st.Name.Receiver)
+		}
+		op.foldFunction(st.Func)
+	case *ast.ClassStmt:
+		if st.Base != nil {
+			st.Base = op.foldExpr(st.Base)
+		}
+		for _, member := range st.Members {
+			member.Value = op.foldExpr(member.Value)
+		}
+	case *ast.ReturnStmt:
+		st.Exprs = op.foldExprs(st.Exprs)
+	}
+	return []ast.Stmt{stmt}
+}
+
+func (op *optimizer) foldFunction(fn *ast.FunctionExpr) {
+	for i, def := range fn.ParList.Defaults {
+		if def != nil {
+			fn.ParList.Defaults[i] = op.foldExpr(def)
+		}
+	}
+	fn.Stmts = op.foldBlock(fn.Stmts)
+}
+
+func (op *optimizer) foldExprs(exprs []ast.Expr) []ast.Expr {
+	for i, expr := range exprs {
+		exprs[i] = op.foldExpr(expr)
+	}
+	return exprs
+}
+
+func (op *optimizer) foldExpr(expr ast.Expr) ast.Expr {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		if local := op.bindings[ex]; local != nil && local.value != nil {
+			value, _ := literalValue(local.value)
+			return literalExpr(value, ex)
+		}
+	case *ast.AttrGetExpr:
+		ex.Object = op.foldExpr(ex.Object)
+		ex.Key = op.foldExpr(ex.Key)
+	case *ast.TableExpr:
+		for _, field := range ex.Fields {
+			if field.Key != nil {
+				field.Key = op.foldExpr(field.Key)
+			}
+			field.Value = op.foldExpr(field.Value)
+		}
+	case *ast.FuncCallExpr:
+		if ex.Func != nil {
+			ex.Func = op.foldExpr(ex.Func)
+		} else {
+			ex.Receiver = op.foldExpr(ex.Receiver)
+		}
+		ex.Args = op.foldExprs(ex.Args)
+	case *ast.FunctionExpr:
+		op.foldFunction(ex)
+	case *ast.LogicalOpExpr:
+		ex.Lhs = op.foldExpr(ex.Lhs)
+		ex.Rhs = op.foldExpr(ex.Rhs)
+		if value, ok := literalValue(ex.Lhs); ok {
+			// 'false or f()' is not replaced by f(), which may return several values
+			if LVAsBool(value) == (ex.Operator == "or") {
+				return ex.Lhs
+			} else if !isVarArgReturnExpr(ex.Rhs) {
+				return ex.Rhs
+			}
+		}
+	case *ast.NilCoalesceOpExpr:
+		ex.Lhs = op.foldExpr(ex.Lhs)
+		ex.Rhs = op.foldExpr(ex.Rhs)
+		if value, ok := literalValue(ex.Lhs); ok {
+			if value != LNil {
+				return ex.Lhs
+			} else if !isVarArgReturnExpr(ex.Rhs) {
+				return ex.Rhs
+			}
+		}
+	case *ast.RelationalOpExpr:
+		ex.Lhs = op.foldExpr(ex.Lhs)
+		ex.Rhs = op.foldExpr(ex.Rhs)
+		if result, ok := foldComparison(ex); ok {
+			return literalExpr(LBool(result), ex)
+		}
+	case *ast.StringConcatOpExpr:
+		ex.Lhs = op.foldExpr(ex.Lhs)
+		ex.Rhs = op.foldExpr(ex.Rhs)
+		lhs, lok := literalValue(ex.Lhs)
+		rhs, rok := literalValue(ex.Rhs)
+		if lok && rok && LVCanConvToString(lhs) && LVCanConvToString(rhs) {
+			return literalExpr(LString(LVAsString(lhs)+LVAsString(rhs)), ex)
+		}
+	case *ast.InterpolatedStringExpr:
+		ex.Parts = op.foldExprs(ex.Parts)
+		var buf strings.Builder
+		for _, part := range ex.Parts {
+			value, ok := literalValue(part)
+			if !ok {
+				return ex
+			}
+			buf.WriteString(value.String())
+		}
+		return literalExpr(LString(buf.String()), ex)
+	case *ast.ArithmeticOpExpr:
+		ex.Lhs = op.foldExpr(ex.Lhs)
+		ex.Rhs = op.foldExpr(ex.Rhs)
+		return foldedExpr(constFold(ex), ex)
+	case *ast.UnaryMinusOpExpr:
+		ex.Expr = op.foldExpr(ex.Expr)
+		return foldedExpr(constFold(ex), ex)
+	case *ast.UnaryBNotOpExpr:
+		ex.Expr = op.foldExpr(ex.Expr)
+		return foldedExpr(constFold(ex), ex)
+	case *ast.UnaryNotOpExpr:
+		ex.Expr = op.foldExpr(ex.Expr)
+		if value, ok := literalValue(ex.Expr); ok {
+			return literalExpr(LBool(!LVAsBool(value)), ex)
+		}
+	case *ast.UnaryLenOpExpr:
+		ex.Expr = op.foldExpr(ex.Expr)
+		if str, ok := ex.Expr.(*ast.StringExpr); ok {
+			return literalExpr(LInteger(len(str.Value)), ex)
+		}
+	}
+	return expr
+}
+
+// foldComparison evaluates a comparison of two numbers or two strings, or the
+// equality of any constants.
+func foldComparison(ex *ast.RelationalOpExpr) (bool, bool) {
+	lhs, lok := literalValue(ex.Lhs)
+	rhs, rok := literalValue(ex.Rhs)
+	if !lok || !rok {
+		return false, false
+	}
+	switch ex.Operator {
+	case "==":
+		return equals(nil, lhs, rhs, true), true
+	case "~=":
+		return !equals(nil, lhs, rhs, true), true
+	case ">", ">=":
+		lhs, rhs = rhs, lhs
+	}
+	c, ok := 0, false
+	switch {
+	case lhs.Type() == LTNumber && rhs.Type() == LTNumber:
+		c, ok = compareNumbers(lhs, rhs)
+		if !ok {
+			// comparisons with NaN are false
+			return false, true
+		}
+	case lhs.Type() == LTString && rhs.Type() == LTString:
+		c, ok = strCmp(string(lhs.(LString)), string(rhs.(LString))), true
+	default:
+		// leave the error to the runtime
+		return false, false
+	}
+	if ex.Operator == "<" || ex.Operator == ">" {
+		return c < 0, true
+	}
+	return c <= 0, true
+}
+
+// foldedExpr converts the result of constFold to a literal expression.
+func foldedExpr(folded ast.Expr, pos ast.PositionHolder) ast.Expr {
+	if cnst, ok := folded.(*constLValueExpr); ok {
+		return literalExpr(cnst.Value, pos)
+	}
+	return folded
+}
+
+// literalExpr returns an expression for value with the position of pos.
+// Numbers that have no numeral, such as NaN, are kept as constLValueExpr.
+func literalExpr(value LValue, pos ast.PositionHolder) ast.Expr {
+	var expr ast.Expr
+	switch v := value.(type) {
+	case *LNilType:
+		expr = &ast.NilExpr{}
+	case LBool:
+		if v {
+			expr = &ast.TrueExpr{}
+		} else {
+			expr = &ast.FalseExpr{}
+		}
+	case LString:
+		expr = &ast.StringExpr{Value: string(v)}
+	case LInteger:
+		expr = &ast.NumberExpr{Value: strconv.FormatInt(int64(v), 10)}
+	case LNumber:
+		f := float64(v)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			expr = &constLValueExpr{Value: v}
+			break
+		}
+		expr = &ast.NumberExpr{Value: floatNumeral(f)}
+	default:
+		expr = &constLValueExpr{Value: value}
+	}
+	expr.SetLine(pos.Line())
+	expr.SetLastLine(pos.LastLine())
+	expr.SetColumn(pos.Column())
+	return expr
+}
+
+// floatNumeral returns the shortest numeral of a finite float that is read
+// back as a float.
+func floatNumeral(f float64) string {
+	numeral := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(numeral, ".e") {
+		// keep the float subtype
+		numeral += ".0"
+	}
+	return numeral
+}
+
+/* }}} */
@@ -19,6 +19,8 @@ func getIntField(_ *LState, tb *LTable, key string, v int) int {
 	switch lv := ret.(type) {
 	case LNumber:
 		return int(lv)
+	case LInteger:
+		return int(lv)
 	case LString:
 		slv := string(lv)
 		slv = strings.TrimLeft(slv, " ")
@@ -154,15 +156,15 @@ func osDate(L *LState) int {
 		}
 		if strings.HasPrefix(cfmt, "*t") {
 			ret := L.NewTable()
-			ret.RawSetString("year", LNumber(t.Year()))
-			ret.RawSetString("month", LNumber(t.Month()))
-			ret.RawSetString("day", LNumber(t.Day()))
-			ret.RawSetString("hour", LNumber(t.Hour()))
-			ret.RawSetString("min", LNumber(t.Minute()))
-			ret.RawSetString("sec", LNumber(t.Second()))
-			ret.RawSetString("wday", LNumber(t.Weekday()+1))
+			ret.RawSetString("year", LInteger(t.Year()))
+			ret.RawSetString("month", LInteger(t.Month()))
+			ret.RawSetString("day", LInteger(t.Day()))
+			ret.RawSetString("hour", LInteger(t.Hour()))
+			ret.RawSetString("min", LInteger(t.Minute()))
+			ret.RawSetString("sec", LInteger(t.Second()))
+			ret.RawSetString("wday", LInteger(t.Weekday()+1))
 			// TODO yday & dst
-			ret.RawSetString("yday", LNumber(0))
+			ret.RawSetString("yday", LInteger(0))
 			ret.RawSetString("isdst", LFalse)
 			L.Push(ret)
 			return 1
@@ -253,11 +255,11 @@ func osSetEnv(L *LState) int {
 
 func osTime(L *LState) int {
 	if L.GetTop() == 0 {
-		L.Push(LNumber(time.Now().Unix()))
+		L.Push(LInteger(time.Now().Unix()))
 	} else {
 		lv := L.CheckAny(1)
 		if lv == LNil {
-			L.Push(LNumber(time.Now().Unix()))
+			L.Push(LInteger(time.Now().Unix()))
 		} else {
 			tbl, ok := lv.(*LTable)
 			if !ok {
@@ -275,7 +277,7 @@ func osTime(L *LState) int {
 			if false {
 				print(isdst)
 			}
-			L.Push(LNumber(t.Unix()))
+			L.Push(LInteger(t.Unix()))
 		}
 	}
 	return 1
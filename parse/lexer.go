@@ -31,6 +31,18 @@ func (e *Error) Error() string {
 	}
 }
 
+// ErrorList is the syntax errors of a chunk in source order, as returned by
+// ParseRecover.
+type ErrorList []*Error
+
+func (list ErrorList) Error() string {
+	var buf strings.Builder
+	for _, e := range list {
+		buf.WriteString(e.Error())
+	}
+	return buf.String()
+}
+
 func writeChar(buf *bytes.Buffer, c int) { buf.WriteByte(byte(c)) }
 
 func isDecimal(ch int) bool { return '0' <= ch && ch <= '9' }
@@ -46,6 +58,12 @@ func isDigit(ch int) bool {
 type Scanner struct {
 	Pos    ast.Position
 	reader *bufio.Reader
+	// trivia of the next token
+	raw      *bytes.Buffer
+	comments []*ast.Comment
+	lastLine int
+	// the expression of the last TInterp token
+	interp ast.Expr
 }
 
 func NewScanner(reader io.Reader, source string) *Scanner {
@@ -55,7 +73,8 @@ func NewScanner(reader io.Reader, source string) *Scanner {
 			Line:   1,
 			Column: 0,
 		},
-		reader: bufio.NewReaderSize(reader, 4096),
+		reader:   bufio.NewReaderSize(reader, 4096),
+		lastLine: 1,
 	}
 }
 
@@ -95,6 +114,9 @@ func (sc *Scanner) Next() int {
 	default:
 		sc.Pos.Column++
 	}
+	if sc.raw != nil && ch >= 0 {
+		writeChar(sc.raw, ch)
+	}
 	return ch
 }
 
@@ -106,6 +128,29 @@ func (sc *Scanner) Peek() int {
 	return ch
 }
 
+// peekToken returns the start of the next token on the current line without
+// consuming any input: a whole identifier or a single character. It returns
+// an empty string at EOF.
+func (sc *Scanner) peekToken() string {
+	n := 1
+	for ; ; n++ {
+		buf, _ := sc.reader.Peek(n)
+		if len(buf) < n {
+			return ""
+		}
+		if ch := buf[n-1]; ch != ' ' && ch != '\t' {
+			break
+		}
+	}
+	start := n - 1
+	for ; ; n++ {
+		buf, _ := sc.reader.Peek(n + 1)
+		if !isIdent(int(buf[start]), 0) || len(buf) < n+1 || !isIdent(int(buf[n]), 1) {
+			return string(buf[start:n])
+		}
+	}
+}
+
 func (sc *Scanner) skipWhiteSpace(whitespace int64) int {
 	ch := sc.Next()
 	for ; whitespace&(1<<uint(ch)) != 0; ch = sc.Next() {
@@ -285,6 +330,225 @@ finally:
 	return nil
 }
 
+// scanInterpolation scans the rest of a backtick string. Literal parts are
+// unescaped like quoted strings, and each ${...} part is parsed as a separate
+// expression. A string without any ${...} part is a plain string expression.
+func (sc *Scanner) scanInterpolation(pos ast.Position) (ast.Expr, error) {
+	var buf bytes.Buffer
+	parts := []ast.Expr{}
+	literal := func() {
+		if buf.Len() > 0 {
+			part := &ast.StringExpr{Value: buf.String()}
+			part.SetLine(pos.Line)
+			part.SetColumn(pos.Column)
+			parts = append(parts, part)
+			buf.Reset()
+		}
+	}
+	hasexpr := false
+	for ch := sc.Next(); ch != '`'; ch = sc.Next() {
+		switch {
+		case ch < 0:
+			return nil, sc.Error(buf.String(), "unterminated interpolated string")
+		case ch == '\\':
+			if err := sc.scanEscape(ch, &buf); err != nil {
+				return nil, err
+			}
+		case ch == '$' && sc.Peek() == '{':
+			sc.Next()
+			literal()
+			start := sc.Pos
+			var src bytes.Buffer
+			if err := sc.copyInterpolationExpr(&src); err != nil {
+				return nil, err
+			}
+			expr, err := parseInterpolationExpr(src.String(), start, sc.Pos)
+			if err != nil {
+				// skip the rest of the string for the parser to go on when
+				// recovering
+				for ch := sc.Next(); ch >= 0 && ch != '`'; ch = sc.Next() {
+				}
+				return nil, err
+			}
+			parts = append(parts, expr)
+			hasexpr = true
+		default:
+			writeChar(&buf, ch)
+		}
+	}
+	if !hasexpr {
+		expr := &ast.StringExpr{Value: buf.String()}
+		expr.SetLine(pos.Line)
+		expr.SetColumn(pos.Column)
+		return expr, nil
+	}
+	literal()
+	expr := &ast.InterpolatedStringExpr{Parts: parts}
+	expr.SetLine(pos.Line)
+	expr.SetColumn(pos.Column)
+	return expr, nil
+}
+
+// copyInterpolationExpr copies the source of a ${...} part up to its closing
+// brace, which is consumed but not copied. Braces and strings nested in the
+// expression are copied as they are.
+func (sc *Scanner) copyInterpolationExpr(buf *bytes.Buffer) error {
+	depth := 0
+	for {
+		ch := sc.Next()
+		switch ch {
+		case EOF:
+			return sc.Error(buf.String(), "unterminated interpolation")
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return nil
+			}
+			depth--
+		case '"', '\'', '`':
+			if err := sc.copyQuoted(ch, buf); err != nil {
+				return err
+			}
+			continue
+		}
+		writeChar(buf, ch)
+	}
+}
+
+func (sc *Scanner) copyQuoted(quote int, buf *bytes.Buffer) error {
+	writeChar(buf, quote)
+	for {
+		ch := sc.Next()
+		switch {
+		case ch < 0 || ch == '\n' && quote != '`':
+			return sc.Error(buf.String(), "unterminated string")
+		case ch == quote:
+			writeChar(buf, ch)
+			return nil
+		case ch == '\\':
+			writeChar(buf, ch)
+			if ch = sc.Next(); ch < 0 {
+				return sc.Error(buf.String(), "unterminated string")
+			}
+		case ch == '$' && quote == '`' && sc.Peek() == '{':
+			writeChar(buf, ch)
+			writeChar(buf, sc.Next())
+			if err := sc.copyInterpolationExpr(buf); err != nil {
+				return err
+			}
+			ch = '}'
+		}
+		writeChar(buf, ch)
+	}
+}
+
+// parseInterpolationExpr parses the source of a ${...} part that starts right
+// after start and ends at the closing brace at end.
+func parseInterpolationExpr(src string, start, end ast.Position) (expr ast.Expr, err error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, &Error{end, "empty interpolation", "}"}
+	}
+	// the expression is parsed as a return statement whose keyword is placed
+	// just before the expression, so that positions need no adjustment.
+	const prefix = "return "
+	scanner := NewScanner(strings.NewReader(prefix+src), start.Source)
+	scanner.Pos.Line = start.Line
+	scanner.Pos.Column = start.Column - len(prefix)
+	chunk, err := newParser(scanner).parse()
+	if err != nil {
+		if e, ok := err.(*Error); ok && e.Pos.Line == EOF {
+			e.Pos, e.Token = end, "}"
+		}
+		return nil, err
+	}
+	if len(chunk) == 1 {
+		if ret, ok := chunk[0].(*ast.ReturnStmt); ok && len(ret.Exprs) == 1 {
+			return ret.Exprs[0], nil
+		}
+	}
+	start.Column++
+	return nil, &Error{start, "interpolation must be a single expression", src}
+}
+
+// the token types, besides EOF and the characters that are tokens by
+// themselves. match, with and case are keywords only where the parser
+// expects them, they are scanned as TIdent.
+const (
+	TAnd = iota + 57346
+	TBreak
+	TDo
+	TElse
+	TElseIf
+	TEnd
+	TFalse
+	TFor
+	TFunction
+	TIf
+	TIn
+	TLocal
+	TNil
+	TNot
+	TOr
+	TReturn
+	TRepeat
+	TThen
+	TTrue
+	TUntil
+	TWhile
+	TGoto
+	TContinue
+	TClass
+	TExtends
+	TStatic
+	TSuper
+	TMatch
+	TWith
+	TCase
+	TInterp
+	TEqeq
+	TNeq
+	TLte
+	TGte
+	T2Comma
+	T3Comma
+	T2Colon
+	TIdent
+	TNumber
+	TString
+	TOpAssign
+	T2Slash
+	TShl
+	TShr
+	TQDot
+	TQColon
+	T2Question
+)
+
+var tokenNames = [...]string{
+	"TAnd", "TBreak", "TDo", "TElse", "TElseIf", "TEnd", "TFalse", "TFor", "TFunction", "TIf",
+	"TIn", "TLocal", "TNil", "TNot", "TOr", "TReturn", "TRepeat", "TThen", "TTrue", "TUntil",
+	"TWhile", "TGoto", "TContinue", "TClass", "TExtends", "TStatic", "TSuper", "TMatch", "TWith",
+	"TCase", "TInterp", "TEqeq", "TNeq", "TLte", "TGte", "T2Comma", "T3Comma", "T2Colon",
+	"TIdent", "TNumber", "TString", "TOpAssign", "T2Slash", "TShl", "TShr", "TQDot", "TQColon",
+	"T2Question"}
+
+// the source text of the token types, as error messages show them
+var tokenStrings = [...]string{
+	"and", "break", "do", "else", "elseif", "end", "false", "for", "function", "if",
+	"in", "local", "nil", "not", "or", "return", "repeat", "then", "true", "until",
+	"while", "goto", "continue", "class", "extends", "static", "super", "match", "with",
+	"case", "`", "==", "~=", "<=", ">=", "..", "...", "::",
+	"<name>", "<number>", "<string>", "op=", "//", "<<", ">>", "?.", "?:",
+	"??"}
+
+func TokenName(c int) string {
+	if c >= TAnd && c-TAnd < len(tokenNames) {
+		return tokenNames[c-TAnd]
+	}
+	return string([]byte{byte(c)})
+}
+
 var reservedWords = map[string]int{
 	"and": TAnd, "break": TBreak, "do": TDo, "else": TElse, "elseif": TElseIf,
 	"end": TEnd, "false": TFalse, "for": TFor, "function": TFunction,
@@ -292,24 +556,16 @@ var reservedWords = map[string]int{
 	"return": TReturn, "repeat": TRepeat, "then": TThen, "true": TTrue,
 	"until": TUntil, "while": TWhile, "goto": TGoto}
 
-func (sc *Scanner) Scan(lexer *Lexer) (ast.Token, error) {
+func (sc *Scanner) Scan() (ast.Token, error) {
 redo:
 	var err error
 	tok := ast.Token{}
-	newline := false
 
 	ch := sc.skipWhiteSpace(whitespace1)
 	if ch == '\n' || ch == '\r' {
-		newline = true
 		ch = sc.skipWhiteSpace(whitespace2)
 	}
 
-	if ch == '(' && lexer.PrevTokenType == ')' {
-		lexer.PNewLine = newline
-	} else {
-		lexer.PNewLine = false
-	}
-
 	var _buf bytes.Buffer
 	buf := &_buf
 	tok.Pos = sc.Pos
@@ -335,11 +591,22 @@ redo:
 			tok.Type = EOF
 		case '-':
 			if sc.Peek() == '-' {
+				comment := &ast.Comment{Pos: tok.Pos, Newlines: tok.Pos.Line - sc.lastLine}
+				text := bytes.NewBufferString("-")
+				sc.raw = text
 				err = sc.skipComments(sc.Next())
+				sc.raw = nil
 				if err != nil {
 					goto finally
 				}
+				comment.Text = strings.TrimRight(text.String(), "\n")
+				sc.comments = append(sc.comments, comment)
+				sc.lastLine = comment.Pos.Line + strings.Count(comment.Text, "\n")
 				goto redo
+			} else if sc.Peek() == '=' {
+				tok.Type = TOpAssign
+				tok.Str = "-="
+				sc.Next()
 			} else {
 				tok.Type = ch
 				tok.Str = string(rune(ch))
@@ -348,6 +615,10 @@ redo:
 			tok.Type = TString
 			err = sc.scanString(ch, buf)
 			tok.Str = buf.String()
+		case '`':
+			tok.Type = TInterp
+			tok.Str = "`"
+			sc.interp, err = sc.scanInterpolation(tok.Pos)
 		case '[':
 			if c := sc.Peek(); c == '[' || c == '=' {
 				tok.Type = TString
@@ -372,13 +643,21 @@ redo:
 				tok.Str = "~="
 				sc.Next()
 			} else {
-				err = sc.Error("~", "Invalid '~' token")
+				tok.Type = ch
+				tok.Str = string(rune(ch))
 			}
 		case '<':
 			if sc.Peek() == '=' {
 				tok.Type = TLte
 				tok.Str = "<="
 				sc.Next()
+			} else if sc.Peek() == '<' {
+				sc.Next()
+				tok.Type, tok.Str = TShl, "<<"
+				if sc.Peek() == '=' {
+					tok.Type, tok.Str = TOpAssign, "<<="
+					sc.Next()
+				}
 			} else {
 				tok.Type = ch
 				tok.Str = string(rune(ch))
@@ -388,6 +667,13 @@ redo:
 				tok.Type = TGte
 				tok.Str = ">="
 				sc.Next()
+			} else if sc.Peek() == '>' {
+				sc.Next()
+				tok.Type, tok.Str = TShr, ">>"
+				if sc.Peek() == '=' {
+					tok.Type, tok.Str = TOpAssign, ">>="
+					sc.Next()
+				}
 			} else {
 				tok.Type = ch
 				tok.Str = string(rune(ch))
@@ -405,6 +691,9 @@ redo:
 				if sc.Peek() == '.' {
 					writeChar(buf, sc.Next())
 					tok.Type = T3Comma
+				} else if sc.Peek() == '=' {
+					writeChar(buf, sc.Next())
+					tok.Type = TOpAssign
 				} else {
 					tok.Type = T2Comma
 				}
@@ -421,7 +710,47 @@ redo:
 				tok.Type = ch
 				tok.Str = string(rune(ch))
 			}
-		case '+', '*', '/', '%', '^', '#', '(', ')', '{', '}', ']', ';', ',':
+		case '/':
+			if sc.Peek() == '/' {
+				sc.Next()
+				tok.Type, tok.Str = T2Slash, "//"
+				if sc.Peek() == '=' {
+					tok.Type, tok.Str = TOpAssign, "//="
+					sc.Next()
+				}
+			} else if sc.Peek() == '=' {
+				tok.Type = TOpAssign
+				tok.Str = "/="
+				sc.Next()
+			} else {
+				tok.Type = ch
+				tok.Str = string(rune(ch))
+			}
+		case '+', '*', '%', '^', '&', '|':
+			if sc.Peek() == '=' {
+				tok.Type = TOpAssign
+				tok.Str = string(rune(ch)) + "="
+				sc.Next()
+			} else {
+				tok.Type = ch
+				tok.Str = string(rune(ch))
+			}
+		case '?':
+			switch sc.Peek() {
+			case '.':
+				tok.Type, tok.Str = TQDot, "?."
+				sc.Next()
+			case ':':
+				tok.Type, tok.Str = TQColon, "?:"
+				sc.Next()
+			case '?':
+				tok.Type, tok.Str = T2Question, "??"
+				sc.Next()
+			default:
+				tok.Type = ch
+				tok.Str = string(rune(ch))
+			}
+		case '#', '(', ')', '{', '}', ']', ';', ',':
 			tok.Type = ch
 			tok.Str = string(rune(ch))
 		default:
@@ -433,56 +762,16 @@ redo:
 
 finally:
 	tok.Name = TokenName(int(tok.Type))
-	return tok, err
-}
-
-// yacc interface {{{
-
-type Lexer struct {
-	scanner       *Scanner
-	Stmts         []ast.Stmt
-	PNewLine      bool
-	Token         ast.Token
-	PrevTokenType int
-}
-
-func (lx *Lexer) Lex(lval *yySymType) int {
-	lx.PrevTokenType = lx.Token.Type
-	tok, err := lx.scanner.Scan(lx)
-	if err != nil {
-		panic(err)
+	tok.Comments, sc.comments = sc.comments, nil
+	if tok.Pos.Line != EOF {
+		tok.Newlines = tok.Pos.Line - sc.lastLine
 	}
-	if tok.Type < 0 {
-		return 0
+	if sc.Pos.Line != EOF {
+		sc.lastLine = sc.Pos.Line
 	}
-	lval.token = tok
-	lx.Token = tok
-	return int(tok.Type)
-}
-
-func (lx *Lexer) Error(message string) {
-	panic(lx.scanner.Error(lx.Token.Str, message))
-}
-
-func (lx *Lexer) TokenError(tok ast.Token, message string) {
-	panic(lx.scanner.TokenError(tok, message))
-}
-
-func Parse(reader io.Reader, name string) (chunk []ast.Stmt, err error) {
-	lexer := &Lexer{NewScanner(reader, name), nil, false, ast.Token{Str: ""}, TNil}
-	chunk = nil
-	defer func() {
-		if e := recover(); e != nil {
-			err, _ = e.(error)
-		}
-	}()
-	yyParse(lexer)
-	chunk = lexer.Stmts
-	return
+	return tok, err
 }
 
-// }}}
-
 // Dump {{{
 
 func isInlineDumpNode(rv reflect.Value) bool {
@@ -511,11 +800,14 @@ func dump(node interface{}, level int, s string) string {
 			buf = append(buf, dump(rv.Index(i).Interface(), level, s))
 		}
 	case reflect.Ptr:
+		if rv.IsNil() {
+			return strings.Repeat(s, level) + "<nil>"
+		}
 		vt := rv.Elem()
 		tt := rt.Elem()
 		indicies := []int{}
 		for i := 0; i < tt.NumField(); i++ {
-			if strings.Index(tt.Field(i).Name, "Base") > -1 {
+			if strings.Index(tt.Field(i).Name, "Base") > -1 || tt.Field(i).Name == "Node" {
 				continue
 			}
 			indicies = append(indicies, i)
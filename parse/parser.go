@@ -1,1385 +1,1291 @@
-// Code generated by goyacc -o parser.go parser.go.y. DO NOT EDIT.
-
-//line parser.go.y:2
 package parse
 
-import __yyfmt__ "fmt"
-
-//line parser.go.y:2
-
 import (
-	"github.com/zmsvDreamLang/Milk/ast"
-)
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
 
-//line parser.go.y:34
-type yySymType struct {
-	yys   int
-	token ast.Token
-
-	stmts []ast.Stmt
-	stmt  ast.Stmt
-
-	funcname *ast.FuncName
-	funcexpr *ast.FunctionExpr
-
-	exprlist []ast.Expr
-	expr     ast.Expr
-
-	fieldlist []*ast.Field
-	field     *ast.Field
-	fieldsep  string
-
-	namelist []string
-	parlist  *ast.ParList
-}
-
-const TAnd = 57346
-const TBreak = 57347
-const TDo = 57348
-const TElse = 57349
-const TElseIf = 57350
-const TEnd = 57351
-const TFalse = 57352
-const TFor = 57353
-const TFunction = 57354
-const TIf = 57355
-const TIn = 57356
-const TLocal = 57357
-const TNil = 57358
-const TNot = 57359
-const TOr = 57360
-const TReturn = 57361
-const TRepeat = 57362
-const TThen = 57363
-const TTrue = 57364
-const TUntil = 57365
-const TWhile = 57366
-const TGoto = 57367
-const TEqeq = 57368
-const TNeq = 57369
-const TLte = 57370
-const TGte = 57371
-const T2Comma = 57372
-const T3Comma = 57373
-const T2Colon = 57374
-const TIdent = 57375
-const TNumber = 57376
-const TString = 57377
-const UNARY = 57378
-
-var yyToknames = [...]string{
-	"$end",
-	"error",
-	"$unk",
-	"TAnd",
-	"TBreak",
-	"TDo",
-	"TElse",
-	"TElseIf",
-	"TEnd",
-	"TFalse",
-	"TFor",
-	"TFunction",
-	"TIf",
-	"TIn",
-	"TLocal",
-	"TNil",
-	"TNot",
-	"TOr",
-	"TReturn",
-	"TRepeat",
-	"TThen",
-	"TTrue",
-	"TUntil",
-	"TWhile",
-	"TGoto",
-	"TEqeq",
-	"TNeq",
-	"TLte",
-	"TGte",
-	"T2Comma",
-	"T3Comma",
-	"T2Colon",
-	"TIdent",
-	"TNumber",
-	"TString",
-	"'{'",
-	"'('",
-	"'>'",
-	"'<'",
-	"'+'",
-	"'-'",
-	"'*'",
-	"'/'",
-	"'%'",
-	"UNARY",
-	"'^'",
-	"';'",
-	"'='",
-	"','",
-	"':'",
-	"'.'",
-	"'['",
-	"']'",
-	"'#'",
-	"')'",
-	"'}'",
-}
-
-var yyStatenames = [...]string{}
-
-const yyEofCode = 1
-const yyErrCode = 2
-const yyInitialStackSize = 16
-
-//line parser.go.y:525
-
-func TokenName(c int) string {
-	if c >= TAnd && c-TAnd < len(yyToknames) {
-		if yyToknames[c-TAnd] != "" {
-			return yyToknames[c-TAnd]
-		}
-	}
-	return string([]byte{byte(c)})
-}
-
-//line yacctab:1
-var yyExca = [...]int8{
-	-1, 1,
-	1, -1,
-	-2, 0,
-	-1, 19,
-	48, 33,
-	49, 33,
-	-2, 70,
-	-1, 97,
-	48, 34,
-	49, 34,
-	-2, 70,
-}
-
-const yyPrivate = 57344
-
-const yyLast = 616
-
-var yyAct = [...]uint8{
-	26, 92, 52, 25, 47, 88, 158, 58, 142, 118,
-	141, 167, 54, 69, 56, 55, 35, 139, 41, 42,
-	49, 160, 34, 67, 63, 137, 50, 64, 112, 113,
-	69, 109, 51, 48, 46, 45, 147, 85, 86, 87,
-	115, 110, 24, 95, 43, 44, 99, 96, 78, 136,
-	50, 171, 143, 103, 69, 108, 51, 84, 79, 80,
-	81, 82, 83, 89, 84, 111, 110, 41, 42, 49,
-	119, 120, 121, 122, 123, 124, 125, 126, 127, 128,
-	129, 130, 131, 132, 133, 134, 81, 82, 83, 170,
-	84, 153, 155, 33, 154, 144, 9, 138, 40, 23,
-	153, 19, 62, 22, 114, 101, 146, 149, 148, 151,
-	150, 71, 100, 152, 66, 50, 65, 61, 50, 157,
-	156, 51, 57, 64, 51, 70, 116, 106, 192, 21,
-	173, 174, 172, 76, 77, 75, 74, 78, 98, 159,
-	189, 95, 161, 97, 162, 72, 73, 79, 80, 81,
-	82, 83, 68, 84, 184, 183, 177, 169, 164, 104,
-	140, 168, 117, 53, 1, 91, 135, 175, 32, 20,
-	176, 8, 178, 60, 59, 180, 179, 3, 165, 28,
-	4, 39, 2, 187, 186, 27, 37, 0, 188, 0,
-	0, 29, 0, 191, 71, 0, 0, 0, 0, 0,
-	31, 0, 93, 30, 41, 42, 22, 0, 70, 0,
-	36, 0, 0, 0, 0, 0, 76, 77, 75, 74,
-	78, 94, 0, 38, 71, 90, 0, 0, 72, 73,
-	79, 80, 81, 82, 83, 0, 84, 0, 70, 0,
-	0, 0, 0, 163, 0, 0, 76, 77, 75, 74,
-	78, 0, 0, 0, 0, 0, 0, 0, 72, 73,
-	79, 80, 81, 82, 83, 28, 84, 39, 0, 0,
-	0, 27, 37, 145, 0, 0, 0, 29, 0, 0,
-	0, 0, 0, 0, 0, 0, 31, 0, 23, 30,
-	41, 42, 22, 28, 0, 39, 36, 0, 0, 27,
-	37, 0, 0, 0, 0, 29, 0, 0, 0, 38,
-	102, 0, 0, 0, 31, 0, 93, 30, 41, 42,
-	22, 28, 0, 39, 36, 0, 0, 27, 37, 0,
-	0, 0, 0, 29, 0, 94, 71, 38, 181, 0,
-	0, 0, 31, 0, 23, 30, 41, 42, 22, 0,
-	70, 0, 36, 0, 0, 0, 0, 0, 76, 77,
-	75, 74, 78, 71, 0, 38, 0, 0, 0, 0,
-	72, 73, 79, 80, 81, 82, 83, 70, 84, 0,
-	0, 182, 0, 0, 0, 76, 77, 75, 74, 78,
-	71, 0, 190, 0, 0, 0, 0, 72, 73, 79,
-	80, 81, 82, 83, 70, 84, 0, 0, 166, 0,
-	0, 0, 76, 77, 75, 74, 78, 71, 0, 0,
-	0, 0, 0, 0, 72, 73, 79, 80, 81, 82,
-	83, 70, 84, 0, 185, 0, 0, 0, 0, 76,
-	77, 75, 74, 78, 71, 0, 0, 0, 0, 0,
-	0, 72, 73, 79, 80, 81, 82, 83, 70, 84,
-	0, 107, 0, 0, 0, 0, 76, 77, 75, 74,
-	78, 71, 0, 105, 0, 0, 0, 0, 72, 73,
-	79, 80, 81, 82, 83, 70, 84, 0, 0, 0,
-	0, 0, 0, 76, 77, 75, 74, 78, 71, 0,
-	0, 0, 0, 0, 0, 72, 73, 79, 80, 81,
-	82, 83, 70, 84, 0, 0, 0, 0, 0, 0,
-	76, 77, 75, 74, 78, 0, 0, 0, 0, 0,
-	0, 0, 72, 73, 79, 80, 81, 82, 83, 0,
-	84, 7, 10, 0, 0, 0, 0, 14, 15, 13,
-	0, 16, 71, 0, 0, 6, 12, 0, 0, 0,
-	11, 18, 0, 0, 0, 0, 0, 0, 17, 23,
-	0, 0, 0, 22, 76, 77, 75, 74, 78, 0,
-	0, 0, 0, 5, 0, 0, 72, 73, 79, 80,
-	81, 82, 83, 0, 84, 76, 77, 75, 74, 78,
-	0, 0, 0, 0, 0, 0, 0, 72, 73, 79,
-	80, 81, 82, 83, 0, 84,
-}
-
-var yyPact = [...]int16{
-	-1000, -1000, 536, -5, -1000, -1000, 311, -1000, -4, -17,
-	-1000, 311, -1000, 311, 89, 84, 90, 83, 81, -1000,
-	-1000, -1000, 311, -1000, -1000, -36, 494, -1000, -1000, -1000,
-	-1000, -1000, -1000, -17, -1000, -1000, 311, 311, 311, 26,
-	-1000, -1000, 169, 311, 66, 311, 79, -1000, 72, 255,
-	-1000, -1000, 150, -1000, 467, 104, 440, 7, 17, 26,
-	-22, -1000, 71, -8, -1000, 94, -1000, 107, -46, 311,
-	311, 311, 311, 311, 311, 311, 311, 311, 311, 311,
-	311, 311, 311, 311, 311, 11, 11, 11, -1000, -6,
-	-1000, -39, -1000, 4, 311, 494, -36, -1000, -17, 220,
-	-1000, 32, -1000, -19, -1000, -1000, 311, -1000, 311, 311,
-	67, -1000, 61, 59, 26, 311, -1000, -1000, -1000, 494,
-	548, 569, 18, 18, 18, 18, 18, 18, 18, 44,
-	44, 11, 11, 11, 11, -49, -1000, -1000, -28, -1000,
-	283, -1000, -1000, 311, 190, -1000, -1000, -1000, 149, 494,
-	-1000, 359, 5, -1000, -1000, -1000, -1000, -36, -1000, 148,
-	58, -1000, 494, 3, -1000, 123, 311, -1000, 147, -1000,
-	-1000, 311, -1000, -1000, 311, 332, 146, -1000, 494, 145,
-	413, -1000, 311, -1000, -1000, -1000, 131, 386, -1000, -1000,
-	-1000, 119, -1000,
-}
-
-var yyPgo = [...]uint8{
-	0, 163, 182, 2, 180, 178, 177, 174, 173, 171,
-	98, 7, 3, 0, 22, 93, 129, 169, 4, 168,
-	5, 166, 16, 165, 1, 160,
-}
-
-var yyR1 = [...]int8{
-	0, 1, 1, 1, 2, 2, 2, 3, 4, 4,
-	4, 4, 4, 4, 4, 4, 4, 4, 4, 4,
-	4, 4, 4, 4, 5, 5, 6, 6, 6, 7,
-	7, 8, 8, 9, 9, 10, 10, 10, 11, 11,
-	12, 12, 13, 13, 13, 13, 13, 13, 13, 13,
-	13, 13, 13, 13, 13, 13, 13, 13, 13, 13,
-	13, 13, 13, 13, 13, 13, 13, 13, 13, 14,
-	15, 15, 15, 15, 17, 16, 16, 18, 18, 18,
-	18, 19, 20, 20, 21, 21, 21, 22, 22, 23,
-	23, 23, 24, 24, 24, 25, 25,
-}
-
-var yyR2 = [...]int8{
-	0, 1, 2, 3, 0, 2, 2, 1, 3, 1,
-	3, 5, 4, 6, 8, 9, 11, 7, 3, 4,
-	4, 2, 3, 2, 0, 5, 1, 2, 1, 1,
-	3, 1, 3, 1, 3, 1, 4, 3, 1, 3,
-	1, 3, 1, 1, 1, 1, 1, 1, 1, 1,
-	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 2, 2, 2, 1,
-	1, 1, 1, 3, 3, 2, 4, 2, 3, 1,
-	1, 2, 5, 4, 1, 1, 3, 2, 3, 1,
-	3, 2, 3, 5, 1, 1, 1,
-}
-
-var yyChk = [...]int16{
-	-1000, -1, -2, -6, -4, 47, 19, 5, -9, -15,
-	6, 24, 20, 13, 11, 12, 15, 32, 25, -10,
-	-17, -16, 37, 33, 47, -12, -13, 16, 10, 22,
-	34, 31, -19, -15, -14, -22, 41, 17, 54, 12,
-	-10, 35, 36, 48, 49, 52, 51, -18, 50, 37,
-	-22, -14, -3, -1, -13, -3, -13, 33, -11, -7,
-	-8, 33, 12, -11, 33, 33, 33, -13, -16, 49,
-	18, 4, 38, 39, 29, 28, 26, 27, 30, 40,
-	41, 42, 43, 44, 46, -13, -13, -13, -20, 37,
-	56, -23, -24, 33, 52, -13, -12, -10, -15, -13,
-	33, 33, 55, -12, 9, 6, 23, 21, 48, 14,
-	49, -20, 50, 51, 33, 48, 32, 55, 55, -13,
-	-13, -13, -13, -13, -13, -13, -13, -13, -13, -13,
-	-13, -13, -13, -13, -13, -21, 55, 31, -11, 56,
-	-25, 49, 47, 48, -13, 53, -18, 55, -3, -13,
-	-3, -13, -12, 33, 33, 33, -20, -12, 55, -3,
-	49, -24, -13, 53, 9, -5, 49, 6, -3, 9,
-	31, 48, 9, 7, 8, -13, -3, 9, -13, -3,
-	-13, 6, 49, 9, 9, 21, -3, -13, -3, 9,
-	6, -3, 9,
-}
-
-var yyDef = [...]int8{
-	4, -2, 1, 2, 5, 6, 26, 28, 0, 9,
-	4, 0, 4, 0, 0, 0, 0, 0, 0, -2,
-	71, 72, 0, 35, 3, 27, 40, 42, 43, 44,
-	45, 46, 47, 48, 49, 50, 0, 0, 0, 0,
-	70, 69, 0, 0, 0, 0, 0, 75, 0, 0,
-	79, 80, 0, 7, 0, 0, 0, 38, 0, 0,
-	29, 31, 0, 21, 38, 0, 23, 0, 72, 0,
-	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	0, 0, 0, 0, 0, 66, 67, 68, 81, 0,
-	87, 0, 89, 35, 0, 94, 8, -2, 0, 0,
-	37, 0, 77, 0, 10, 4, 0, 4, 0, 0,
-	0, 18, 0, 0, 0, 0, 22, 73, 74, 41,
-	51, 52, 53, 54, 55, 56, 57, 58, 59, 60,
-	61, 62, 63, 64, 65, 0, 4, 84, 85, 88,
-	91, 95, 96, 0, 0, 36, 76, 78, 0, 12,
-	24, 0, 0, 39, 30, 32, 19, 20, 4, 0,
-	0, 90, 92, 0, 11, 0, 0, 4, 0, 83,
-	86, 0, 13, 4, 0, 0, 0, 82, 93, 0,
-	0, 4, 0, 17, 14, 4, 0, 0, 25, 15,
-	4, 0, 16,
-}
-
-var yyTok1 = [...]int8{
-	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 54, 3, 44, 3, 3,
-	37, 55, 42, 40, 49, 41, 51, 43, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 50, 47,
-	39, 48, 38, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 52, 3, 53, 46, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 36, 3, 56,
-}
-
-var yyTok2 = [...]int8{
-	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
-	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
-	22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
-	32, 33, 34, 35, 45,
-}
-
-var yyTok3 = [...]int8{
-	0,
-}
-
-var yyErrorMessages = [...]struct {
-	state int
-	token int
-	msg   string
-}{}
-
-//line yaccpar:1
-
-/*	parser for yacc output	*/
-
-var (
-	yyDebug        = 0
-	yyErrorVerbose = false
+	"github.com/zmsvDreamLang/Milk/ast"
 )
 
-type yyLexer interface {
-	Lex(lval *yySymType) int
-	Error(s string)
+// parser is a recursive descent parser of Milk chunks. It reads one token
+// ahead of the current one where the grammar needs it, like in '{x = 1}'.
+type parser struct {
+	scanner *Scanner
+	tok     ast.Token    // the current token
+	interp  ast.Expr     // the expression of the current token when it is TInterp
+	last    ast.Position // the position of the token before the current one
+	ahead   []scanned    // the tokens after the current one, once read
+	read    *[]scanned   // the tokens made current, while looking ahead
+	tokens  *[]ast.Token // the tokens read so far, if wanted
+	errors  *ErrorList   // the errors so far when recovering, nil to stop at the first one
+	classes int          // the depth of the class bodies being parsed, where 'super' is a keyword
 }
 
-type yyParser interface {
-	Parse(yyLexer) int
-	Lookahead() int
+type scanned struct {
+	tok    ast.Token
+	interp ast.Expr
+	err    error
 }
 
-type yyParserImpl struct {
-	lval  yySymType
-	stack [yyInitialStackSize]yySymType
-	char  int
+func newParser(scanner *Scanner) *parser {
+	return &parser{scanner: scanner}
 }
 
-func (p *yyParserImpl) Lookahead() int {
-	return p.char
+// parse parses a whole chunk. Errors stop it with a panic, recovered here.
+func (p *parser) parse() (chunk []ast.Stmt, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			chunk = nil
+			err, _ = e.(error)
+		}
+	}()
+	p.next()
+	chunk = p.chunk()
+	if p.tokens != nil {
+		*p.tokens = append(*p.tokens, p.tok)
+	}
+	return chunk, nil
 }
 
-func yyNewParser() yyParser {
-	return &yyParserImpl{}
+func Parse(reader io.Reader, name string) (chunk []ast.Stmt, err error) {
+	return newParser(NewScanner(reader, name)).parse()
 }
 
-const yyFlag = -1000
+// ParseTokens parses like Parse and also returns all the tokens of the chunk
+// up to EOF. Their Comments and Newlines keep the trivia that the AST throws
+// away.
+func ParseTokens(reader io.Reader, name string) (chunk []ast.Stmt, tokens []ast.Token, err error) {
+	p := newParser(NewScanner(reader, name))
+	p.tokens = &tokens
+	chunk, err = p.parse()
+	return chunk, tokens, err
+}
 
-func yyTokname(c int) string {
-	if c >= 1 && c-1 < len(yyToknames) {
-		if yyToknames[c-1] != "" {
-			return yyToknames[c-1]
-		}
+// ParseRecover parses like ParseTokens but goes on after syntax errors: the
+// statement in error is skipped up to a token that can start the next one
+// or end the block, and a missing token that closes a construct, like
+// 'end' or ')', is reported and taken as read. It returns the AST of the
+// statements that parsed and all the errors as an ErrorList.
+func ParseRecover(reader io.Reader, name string) (chunk []ast.Stmt, tokens []ast.Token, err error) {
+	errors := ErrorList{}
+	p := newParser(NewScanner(reader, name))
+	p.tokens = &tokens
+	p.errors = &errors
+	chunk, err = p.parse()
+	if err != nil {
+		// an error that is not a syntax error, like a failed read
+		return nil, tokens, err
 	}
-	return __yyfmt__.Sprintf("tok-%v", c)
+	if len(errors) > 0 {
+		return chunk, tokens, errors
+	}
+	return chunk, tokens, nil
 }
 
-func yyStatname(s int) string {
-	if s >= 0 && s < len(yyStatenames) {
-		if yyStatenames[s] != "" {
-			return yyStatenames[s]
-		}
-	}
-	return __yyfmt__.Sprintf("state-%v", s)
+// IsReservedWord reports whether name is a keyword that cannot be used as an
+// identifier.
+func IsReservedWord(name string) bool {
+	_, ok := reservedWords[name]
+	return ok
 }
 
-func yyErrorMessage(state, lookAhead int) string {
-	const TOKSTART = 4
+/* tokens {{{ */
 
-	if !yyErrorVerbose {
-		return "syntax error"
+func (p *parser) scan() scanned {
+	tok, err := p.scanner.Scan()
+	s := scanned{tok: tok, err: err}
+	if tok.Type == TInterp {
+		s.interp = p.scanner.interp
 	}
+	return s
+}
 
-	for _, e := range yyErrorMessages {
-		if e.state == state && e.token == lookAhead {
-			return "syntax error: " + e.msg
+// next makes the next token the current one. The errors of the scanner are
+// reported as the tokens in error are reached.
+func (p *parser) next() {
+	if p.tokens != nil && p.tok.Type != 0 {
+		*p.tokens = append(*p.tokens, p.tok)
+	}
+	p.last = p.tok.Pos
+	for {
+		var s scanned
+		if len(p.ahead) > 0 {
+			s, p.ahead = p.ahead[0], p.ahead[1:]
+		} else {
+			s = p.scan()
+		}
+		if p.read != nil {
+			*p.read = append(*p.read, s)
+		}
+		p.tok, p.interp = s.tok, s.interp
+		if s.err == nil {
+			return
+		}
+		err, ok := s.err.(*Error)
+		if p.errors == nil || !ok {
+			panic(s.err)
+		}
+		p.report(err)
+		if s.tok.Type == TInterp && s.interp == nil {
+			expr := &ast.InterpolatedStringExpr{}
+			expr.SetLine(s.tok.Pos.Line)
+			expr.SetColumn(s.tok.Pos.Column)
+			p.interp = expr
+		}
+		if s.tok.Type != 0 {
+			return
 		}
 	}
+}
 
-	res := "syntax error: unexpected " + yyTokname(lookAhead)
-
-	// To match Bison, suggest at most four expected tokens.
-	expected := make([]int, 0, 4)
-
-	// Look for shiftable tokens.
-	base := int(yyPact[state])
-	for tok := TOKSTART; tok-1 < len(yyToknames); tok++ {
-		if n := base + tok; n >= 0 && n < yyLast && int(yyChk[int(yyAct[n])]) == tok {
-			if len(expected) == cap(expected) {
-				return res
-			}
-			expected = append(expected, tok)
-		}
+// peek returns the token after the current one.
+func (p *parser) peek() ast.Token {
+	if len(p.ahead) == 0 {
+		p.ahead = append(p.ahead, p.scan())
 	}
+	return p.ahead[0].tok
+}
 
-	if yyDef[state] == -2 {
-		i := 0
-		for yyExca[i] != -1 || int(yyExca[i+1]) != state {
-			i += 2
+// lookahead runs parse from the current token and then puts back all the
+// tokens it read, so that the parser is where it was. It returns what parse
+// returned, false when parse stopped at an error.
+func (p *parser) lookahead(parse func() bool) (ok bool) {
+	saved := *p
+	read := []scanned{}
+	p.read, p.tokens, p.errors = &read, nil, nil
+	defer func() {
+		rcv := recover()
+		if _, isErr := rcv.(error); rcv != nil && !isErr {
+			panic(rcv)
 		}
-
-		// Look for tokens that we accept or reduce.
-		for i += 2; yyExca[i] >= 0; i += 2 {
-			tok := int(yyExca[i])
-			if tok < TOKSTART || yyExca[i+1] == 0 {
-				continue
-			}
-			if len(expected) == cap(expected) {
-				return res
-			}
-			expected = append(expected, tok)
+		ahead := append(read, p.ahead...)
+		*p = saved
+		p.ahead = ahead
+		if rcv != nil {
+			ok = false
 		}
+	}()
+	return parse()
+}
 
-		// If the default action is to accept or reduce, give up.
-		if yyExca[i+1] != 0 {
-			return res
-		}
+func (p *parser) accept(typ int) bool {
+	if p.tok.Type == typ {
+		p.next()
+		return true
 	}
+	return false
+}
 
-	for i, tok := range expected {
-		if i == 0 {
-			res += ", expecting "
-		} else {
-			res += " or "
-		}
-		res += yyTokname(tok)
+func (p *parser) expect(typ int) ast.Token {
+	tok := p.tok
+	if tok.Type != typ {
+		p.errorExpected(tokenText(typ))
 	}
-	return res
+	p.next()
+	return tok
 }
 
-func yylex1(lex yyLexer, lval *yySymType) (char, token int) {
-	token = 0
-	char = lex.Lex(lval)
-	if char <= 0 {
-		token = int(yyTok1[0])
-		goto out
-	}
-	if char < len(yyTok1) {
-		token = int(yyTok1[char])
-		goto out
-	}
-	if char >= yyPrivate {
-		if char < yyPrivate+len(yyTok2) {
-			token = int(yyTok2[char-yyPrivate])
-			goto out
-		}
+func (p *parser) expectName() ast.Token {
+	return p.expect(TIdent)
+}
+
+// expectMatch expects the token that closes the construct open started,
+// which the error names when it started on another line. When recovering,
+// a missing token is reported and taken as read, so that the construct is
+// kept.
+func (p *parser) expectMatch(typ int, open ast.Token) ast.Token {
+	tok := p.tok
+	if tok.Type == typ {
+		p.next()
+		return tok
 	}
-	for i := 0; i < len(yyTok3); i += 2 {
-		token = int(yyTok3[i+0])
-		if token == char {
-			token = int(yyTok3[i+1])
-			goto out
-		}
+	message := "syntax error, " + tokenText(typ) + " expected"
+	if open.Pos.Line != tok.Pos.Line {
+		message += fmt.Sprintf(" to close '%v' at line %v", open.Str, open.Pos.Line)
 	}
+	p.fail(&Error{tok.Pos, message, tok.Str})
+	return ast.Token{Type: typ, Pos: p.last}
+}
 
-out:
-	if token == 0 {
-		token = int(yyTok2[1]) /* unknown char */
+// expectKeyword expects one of the identifiers that are keywords only in
+// some places, like 'with' after 'match'.
+func (p *parser) expectKeyword(typ int) ast.Token {
+	if p.tok.Type == TIdent && p.tok.Str == tokenStrings[typ-TAnd] {
+		p.tok.Type = typ
 	}
-	if yyDebug >= 3 {
-		__yyfmt__.Printf("lex %s(%d)\n", yyTokname(token), uint(char))
-	}
-	return char, token
+	return p.expect(typ)
 }
 
-func yyParse(yylex yyLexer) int {
-	return yyNewParser().Parse(yylex)
+// tokenText returns how error messages name a token type.
+func tokenText(typ int) string {
+	switch typ {
+	case EOF:
+		return "'<eof>'"
+	case TIdent:
+		return "name"
+	case TNumber:
+		return "number"
+	case TString, TInterp:
+		return "string"
+	}
+	if typ >= TAnd && typ-TAnd < len(tokenStrings) {
+		return "'" + tokenStrings[typ-TAnd] + "'"
+	}
+	return "'" + string(rune(typ)) + "'"
 }
 
-func (yyrcvr *yyParserImpl) Parse(yylex yyLexer) int {
-	var yyn int
-	var yyVAL yySymType
-	var yyDollar []yySymType
-	_ = yyDollar // silence set and not used
-	yyS := yyrcvr.stack[:]
+/* }}} */
 
-	Nerrs := 0   /* number of errors */
-	Errflag := 0 /* error recovery flag */
-	yystate := 0
-	yyrcvr.char = -1
-	yytoken := -1 // yyrcvr.char translated into internal numbering
-	defer func() {
-		// Make sure we report no lookahead when not parsing.
-		yystate = -1
-		yyrcvr.char = -1
-		yytoken = -1
-	}()
-	yyp := -1
-	goto yystack
+/* errors {{{ */
 
-ret0:
-	return 0
+// error stops the parser at a syntax error. When recovering, the statement
+// being parsed catches it.
+func (p *parser) error(tok ast.Token, message string) {
+	panic(&Error{tok.Pos, message, tok.Str})
+}
 
-ret1:
-	return 1
+func (p *parser) errorExpected(what string) {
+	p.error(p.tok, "syntax error, "+what+" expected")
+}
 
-yystack:
-	/* put a state and value onto the stack */
-	if yyDebug >= 4 {
-		__yyfmt__.Printf("char %v in %v\n", yyTokname(yytoken), yyStatname(yystate))
+// fail reports an error the parser can go on after when recovering, or
+// stops it.
+func (p *parser) fail(err *Error) {
+	if p.errors == nil {
+		panic(err)
 	}
+	p.report(err)
+}
 
-	yyp++
-	if yyp >= len(yyS) {
-		nyys := make([]yySymType, len(yyS)*2)
-		copy(nyys, yyS)
-		yyS = nyys
-	}
-	yyS[yyp] = yyVAL
-	yyS[yyp].yys = yystate
+func (p *parser) report(err *Error) {
+	*p.errors = append(*p.errors, err)
+}
 
-yynewstate:
-	yyn = int(yyPact[yystate])
-	if yyn <= yyFlag {
-		goto yydefault /* simple state */
-	}
-	if yyrcvr.char < 0 {
-		yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
-	}
-	yyn += yytoken
-	if yyn < 0 || yyn >= yyLast {
-		goto yydefault
-	}
-	yyn = int(yyAct[yyn])
-	if int(yyChk[yyn]) == yytoken { /* valid shift */
-		yyrcvr.char = -1
-		yytoken = -1
-		yyVAL = yyrcvr.lval
-		yystate = yyn
-		if Errflag > 0 {
-			Errflag--
+// synchronize skips the tokens after a syntax error on line up to one that
+// can start a statement or ends a block.
+func (p *parser) synchronize(line int) {
+	for {
+		switch p.tok.Type {
+		case EOF, TEnd, TElse, TElseIf, TUntil, ';', TIf, TWhile, TDo, TFor, TRepeat, TLocal,
+			TReturn, TBreak, TGoto, T2Colon:
+			return
+		case TIdent, TFunction, '(':
+			if p.tok.Pos.Line > line {
+				return
+			}
 		}
-		goto yystack
+		p.next()
 	}
+}
 
-yydefault:
-	/* default state action */
-	yyn = int(yyDef[yystate])
-	if yyn == -2 {
-		if yyrcvr.char < 0 {
-			yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
+/* }}} */
+
+/* statements {{{ */
+
+// chunk parses the statements of a chunk up to EOF.
+func (p *parser) chunk() []ast.Stmt {
+	stmts := p.block(false)
+	for p.tok.Type != EOF {
+		switch p.tok.Type {
+		case TEnd, TElse, TElseIf, TUntil:
+			p.fail(&Error{p.tok.Pos, "syntax error, no block to close", p.tok.Str})
+		default:
+			// a statement after a return
+			p.fail(&Error{p.tok.Pos, "syntax error, " + tokenText(EOF) + " expected", p.tok.Str})
 		}
+		p.next()
+		stmts = append(stmts, p.block(false)...)
+	}
+	return stmts
+}
 
-		/* look through exception table */
-		xi := 0
-		for {
-			if yyExca[xi+0] == -1 && int(yyExca[xi+1]) == yystate {
-				break
+// block parses statements up to a token that ends the block, which is left
+// to the caller. In the block of a match arm, the next 'case' ends it too.
+func (p *parser) block(arm bool) []ast.Stmt {
+	stmts := []ast.Stmt{}
+	for {
+		last := false
+		switch p.tok.Type {
+		case EOF, TEnd, TElse, TElseIf, TUntil:
+			return stmts
+		case ';':
+			p.next()
+			continue
+		case TReturn, TBreak:
+			last = true
+		case TIdent:
+			if arm && p.tok.Str == "case" {
+				return stmts
 			}
-			xi += 2
-		}
-		for xi += 2; ; xi += 2 {
-			yyn = int(yyExca[xi+0])
-			if yyn < 0 || yyn == yytoken {
-				break
+			last = p.tok.Str == "continue" && p.startsContinue()
+		}
+		start := p.tok.Pos
+		stmt := p.statement()
+		if stmt != nil {
+			stmts = append(stmts, stmt)
+			if last {
+				p.accept(';')
+				return stmts
 			}
-		}
-		yyn = int(yyExca[xi+1])
-		if yyn < 0 {
-			goto ret0
+		} else if p.tok.Pos == start {
+			// nothing could be made of the token
+			p.next()
 		}
 	}
-	if yyn == 0 {
-		/* error ... attempt to resume parsing */
-		switch Errflag {
-		case 0: /* brand new error */
-			yylex.Error(yyErrorMessage(yystate, yytoken))
-			Nerrs++
-			if yyDebug >= 1 {
-				__yyfmt__.Printf("%s", yyStatname(yystate))
-				__yyfmt__.Printf(" saw %s\n", yyTokname(yytoken))
-			}
-			fallthrough
-
-		case 1, 2: /* incompletely recovered error ... try again */
-			Errflag = 3
-
-			/* find a state where "error" is a legal shift action */
-			for yyp >= 0 {
-				yyn = int(yyPact[yyS[yyp].yys]) + yyErrCode
-				if yyn >= 0 && yyn < yyLast {
-					yystate = int(yyAct[yyn]) /* simulate a shift of "error" */
-					if int(yyChk[yystate]) == yyErrCode {
-						goto yystack
-					}
-				}
+}
 
-				/* the current p has no shift on "error", pop stack */
-				if yyDebug >= 2 {
-					__yyfmt__.Printf("error recovery pops state %d\n", yyS[yyp].yys)
+// statement parses a statement. When recovering, a syntax error is reported
+// and the tokens of the statement skipped, nil is returned then.
+func (p *parser) statement() (stmt ast.Stmt) {
+	if p.errors != nil {
+		defer func() {
+			if e := recover(); e != nil {
+				err, ok := e.(*Error)
+				if !ok {
+					panic(e)
 				}
-				yyp--
+				p.report(err)
+				p.synchronize(err.Pos.Line)
+				stmt = nil
 			}
-			/* there is no state on the stack with an error shift ... abort */
-			goto ret1
+		}()
+	}
+	return p.stat()
+}
 
-		case 3: /* no shift yet; clobber input char */
-			if yyDebug >= 2 {
-				__yyfmt__.Printf("error recovery discards %s\n", yyTokname(yytoken))
-			}
-			if yytoken == yyEofCode {
-				goto ret1
-			}
-			yyrcvr.char = -1
-			yytoken = -1
-			goto yynewstate /* try again in the same state */
+func (p *parser) stat() ast.Stmt {
+	tok := p.tok
+	switch tok.Type {
+	case TDo:
+		p.next()
+		stmts := p.block(false)
+		end := p.expectMatch(TEnd, tok)
+		stmt := &ast.DoBlockStmt{Stmts: stmts}
+		setTokenPos(stmt, tok)
+		stmt.SetLastLine(end.Pos.Line)
+		return stmt
+	case TWhile:
+		p.next()
+		cond := p.expr()
+		p.expectMatch(TDo, tok)
+		stmts := p.block(false)
+		end := p.expectMatch(TEnd, tok)
+		stmt := &ast.WhileStmt{Condition: cond, Stmts: stmts}
+		setTokenPos(stmt, tok)
+		stmt.SetLastLine(end.Pos.Line)
+		return stmt
+	case TRepeat:
+		p.next()
+		stmts := p.block(false)
+		p.expectMatch(TUntil, tok)
+		cond := p.expr()
+		stmt := &ast.RepeatStmt{Condition: cond, Stmts: stmts}
+		setTokenPos(stmt, tok)
+		stmt.SetLastLine(cond.Line())
+		return stmt
+	case TIf:
+		return p.ifStat()
+	case TFor:
+		return p.forStat()
+	case TFunction:
+		p.next()
+		name := p.funcName()
+		fn := p.funcBody(tok)
+		fn.Name = funcNameString(name)
+		stmt := &ast.FuncDefStmt{Name: name, Func: fn}
+		setTokenPos(stmt, tok)
+		stmt.SetLastLine(fn.LastLine())
+		return stmt
+	case TLocal:
+		return p.localStat()
+	case T2Colon:
+		p.next()
+		name := p.expectName()
+		p.expect(T2Colon)
+		stmt := &ast.LabelStmt{Name: name.Str}
+		setTokenPos(stmt, tok)
+		return stmt
+	case TGoto:
+		p.next()
+		name := p.expectName()
+		stmt := &ast.GotoStmt{Label: name.Str}
+		setTokenPos(stmt, tok)
+		return stmt
+	case TReturn:
+		p.next()
+		stmt := &ast.ReturnStmt{}
+		if p.startsExpr() {
+			stmt.Exprs = p.exprList()
+		}
+		setTokenPos(stmt, tok)
+		return stmt
+	case TBreak:
+		p.next()
+		stmt := &ast.BreakStmt{}
+		setTokenPos(stmt, tok)
+		return stmt
+	case TIdent:
+		if tok.Str == "continue" && p.startsContinue() {
+			p.tok.Type = TContinue
+			p.next()
+			stmt := &ast.ContinueStmt{}
+			setTokenPos(stmt, tok)
+			return stmt
+		}
+		if tok.Str == "match" && p.startsMatch() {
+			return p.matchStat()
+		}
+		if tok.Str == "class" && p.peek().Type == TIdent {
+			p.tok.Type = TClass
+			p.next()
+			return p.classStat(tok, false)
+		}
+	case '(':
+	default:
+		p.errorExpected("statement")
+	}
+	return p.exprStat()
+}
+
+// startsContinue reports whether the current 'continue' is the statement
+// rather than a name, like in 'continue = 1' or 'continue()'.
+func (p *parser) startsContinue() bool {
+	switch p.peek().Type {
+	case '=', ',', TOpAssign, '.', '[', TQDot, ':', TQColon, '(', TString, '{':
+		return false
+	}
+	return true
+}
+
+// startsMatch reports whether the current 'match' starts a match statement
+// rather than being a name, like in 'match(x)' or 'match = 1'. The value
+// must start on the same line with a token that cannot follow a name, or be
+// followed by 'with' when it starts like the arguments of a call, like in
+// 'match (x) with'.
+func (p *parser) startsMatch() bool {
+	next := p.peek()
+	if next.Pos.Line != p.tok.Pos.Line {
+		return false
+	}
+	switch next.Type {
+	case TNumber:
+		return isDecimal(int(next.Str[0]))
+	case '-', '#', '~', TInterp, TNil, TTrue, TFalse, TNot, TFunction, TIdent:
+		return true
+	case '(', '{', TString:
+		return p.lookahead(func() bool {
+			p.next()
+			p.expr()
+			return p.tok.Type == TIdent && p.tok.Str == "with"
+		})
+	}
+	return false
+}
+
+func (p *parser) exprStat() ast.Stmt {
+	start := p.tok
+	expr, kind := p.suffixedExpr()
+	switch {
+	case p.tok.Type == '=' || p.tok.Type == ',':
+		lhs := []ast.Expr{p.checkVar(expr, kind, start)}
+		for p.accept(',') {
+			start = p.tok
+			expr, kind = p.suffixedExpr()
+			lhs = append(lhs, p.checkVar(expr, kind, start))
+		}
+		p.expect('=')
+		stmt := &ast.AssignStmt{Lhs: lhs, Rhs: p.exprList()}
+		setExprPos(stmt, lhs[0])
+		return stmt
+	case p.tok.Type == TOpAssign:
+		op := p.tok
+		p.checkVar(expr, kind, start)
+		p.next()
+		stmt := &ast.CompoundAssignStmt{Lhs: expr, Operator: strings.TrimSuffix(op.Str, "="), Rhs: p.expr()}
+		setExprPos(stmt, expr)
+		return stmt
+	case kind == exprVar:
+		p.errorExpected("'='")
+	case kind != exprCall:
+		if _, ok := expr.(*ast.FuncCallExpr); !ok {
+			p.error(start, "syntax error, function call expected")
 		}
 	}
+	stmt := &ast.FuncCallStmt{Expr: expr}
+	setExprPos(stmt, expr)
+	return stmt
+}
 
-	/* reduction by production yyn */
-	if yyDebug >= 2 {
-		__yyfmt__.Printf("reduce %v in:\n\t%v\n", yyn, yyStatname(yystate))
+// checkVar checks that the expression that start starts can be assigned.
+func (p *parser) checkVar(expr ast.Expr, kind int, start ast.Token) ast.Expr {
+	if kind != exprVar {
+		p.error(start, "syntax error, cannot assign to this expression")
 	}
+	return expr
+}
 
-	yynt := yyn
-	yypt := yyp
-	_ = yypt // guard against "declared and not used"
-
-	yyp -= int(yyR2[yyn])
-	// yyp is now the index of $0. Perform the default action. Iff the
-	// reduced production is ε, $1 is possibly out of range.
-	if yyp+1 >= len(yyS) {
-		nyys := make([]yySymType, len(yyS)*2)
-		copy(nyys, yyS)
-		yyS = nyys
+func (p *parser) ifStat() ast.Stmt {
+	tok := p.tok
+	p.next()
+	cond := p.expr()
+	p.expectMatch(TThen, tok)
+	stmt := &ast.IfStmt{Condition: cond, Then: p.block(false)}
+	setTokenPos(stmt, tok)
+	cur := stmt
+	for p.tok.Type == TElseIf {
+		elseif := p.tok
+		p.next()
+		cond := p.expr()
+		p.expectMatch(TThen, elseif)
+		next := &ast.IfStmt{Condition: cond, Then: p.block(false)}
+		setTokenPos(next, elseif)
+		cur.Else = []ast.Stmt{next}
+		cur = next
+	}
+	if p.accept(TElse) {
+		cur.Else = p.block(false)
 	}
-	yyVAL = yyS[yyp+1]
+	end := p.expectMatch(TEnd, tok)
+	stmt.SetLastLine(end.Pos.Line)
+	return stmt
+}
 
-	/* consult goto table to find next state */
-	yyn = int(yyR1[yyn])
-	yyg := int(yyPgo[yyn])
-	yyj := yyg + yyS[yyp].yys + 1
+func (p *parser) forStat() ast.Stmt {
+	tok := p.tok
+	p.next()
+	name := p.expectName()
+	if p.accept('=') {
+		stmt := &ast.NumberForStmt{Name: name.Str, Init: p.expr()}
+		p.expect(',')
+		stmt.Limit = p.expr()
+		if p.accept(',') {
+			stmt.Step = p.expr()
+		}
+		p.expectMatch(TDo, tok)
+		stmt.Stmts = p.block(false)
+		end := p.expectMatch(TEnd, tok)
+		setTokenPos(stmt, tok)
+		stmt.SetLastLine(end.Pos.Line)
+		return stmt
+	}
+	names := []string{name.Str}
+	for p.accept(',') {
+		names = append(names, p.expectName().Str)
+	}
+	p.expect(TIn)
+	stmt := &ast.GenericForStmt{Names: names, Exprs: p.exprList()}
+	p.expectMatch(TDo, tok)
+	stmt.Stmts = p.block(false)
+	end := p.expectMatch(TEnd, tok)
+	setTokenPos(stmt, tok)
+	stmt.SetLastLine(end.Pos.Line)
+	return stmt
+}
 
-	if yyj >= yyLast {
-		yystate = int(yyAct[yyg])
-	} else {
-		yystate = int(yyAct[yyj])
-		if int(yyChk[yystate]) != -yyn {
-			yystate = int(yyAct[yyg])
+func (p *parser) localStat() ast.Stmt {
+	tok := p.tok
+	p.next()
+	switch p.tok.Type {
+	case TFunction:
+		function := p.tok
+		p.next()
+		name := p.expectName()
+		fn := p.funcBody(function)
+		fn.Name = name.Str
+		stmt := &ast.LocalAssignStmt{Names: []string{name.Str}, Exprs: []ast.Expr{fn}}
+		setTokenPos(stmt, tok)
+		stmt.SetLastLine(fn.LastLine())
+		return stmt
+	case TIdent:
+		if p.tok.Str == "class" && p.peek().Type == TIdent {
+			p.tok.Type = TClass
+			p.next()
+			return p.classStat(tok, true)
 		}
 	}
-	// dummy call; replaced with literal code
-	switch yynt {
-
-	case 1:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:73
-		{
-			yyVAL.stmts = yyDollar[1].stmts
-			if l, ok := yylex.(*Lexer); ok {
-				l.Stmts = yyVAL.stmts
-			}
-		}
-	case 2:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:79
-		{
-			yyVAL.stmts = append(yyDollar[1].stmts, yyDollar[2].stmt)
-			if l, ok := yylex.(*Lexer); ok {
-				l.Stmts = yyVAL.stmts
+	// the names, and the fields of the values destructured with braces
+	fields := []*ast.DestructField{}
+	attribs := []string{}
+	destruct := false
+	for value := 0; ; value++ {
+		if open := p.tok; p.accept('{') {
+			// positional fields are numbered as in a table constructor
+			destruct = true
+			pos := 0
+			for {
+				field := p.destructField()
+				if field.Key == nil {
+					pos++
+					field.Key = &ast.NumberExpr{Value: strconv.Itoa(pos)}
+				}
+				field.Value = value
+				fields = append(fields, field)
+				attribs = append(attribs, "")
+				if !p.accept(',') {
+					break
+				}
 			}
-		}
-	case 3:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:85
-		{
-			yyVAL.stmts = append(yyDollar[1].stmts, yyDollar[2].stmt)
-			if l, ok := yylex.(*Lexer); ok {
-				l.Stmts = yyVAL.stmts
+			p.expectMatch('}', open)
+		} else {
+			name := p.expectName()
+			field := &ast.DestructField{Name: name.Str, Value: value}
+			setTokenPos(field, name)
+			fields = append(fields, field)
+			attrib := ""
+			if p.accept('<') {
+				attrib = p.expectName().Str
+				p.expect('>')
 			}
+			attribs = append(attribs, attrib)
 		}
-	case 4:
-		yyDollar = yyS[yypt-0 : yypt+1]
-//line parser.go.y:93
-		{
-			yyVAL.stmts = []ast.Stmt{}
-		}
-	case 5:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:96
-		{
-			yyVAL.stmts = append(yyDollar[1].stmts, yyDollar[2].stmt)
+		if !p.accept(',') {
+			break
 		}
-	case 6:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:99
-		{
-			yyVAL.stmts = yyDollar[1].stmts
+	}
+	var stmt *ast.LocalAssignStmt
+	if destruct {
+		p.expect('=')
+		stmt = newDestructStmt(fields, p.exprList())
+	} else {
+		stmt = &ast.LocalAssignStmt{Exprs: []ast.Expr{}}
+		for _, field := range fields {
+			stmt.Names = append(stmt.Names, field.Name)
 		}
-	case 7:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:104
-		{
-			yyVAL.stmts = yyDollar[1].stmts
+		if p.accept('=') {
+			stmt.Exprs = p.exprList()
 		}
-	case 8:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:109
-		{
-			yyVAL.stmt = &ast.AssignStmt{Lhs: yyDollar[1].exprlist, Rhs: yyDollar[3].exprlist}
-			yyVAL.stmt.SetLine(yyDollar[1].exprlist[0].Line())
+	}
+	stmt.Attribs = attribs
+	setTokenPos(stmt, tok)
+	return stmt
+}
+
+// destructField parses a field of 'local {a, b=, c=1} = expr'.
+func (p *parser) destructField() *ast.DestructField {
+	name := p.expectName()
+	field := &ast.DestructField{Name: name.Str}
+	if p.accept('=') {
+		field.Key = &ast.StringExpr{Value: name.Str}
+		if p.tok.Type != ',' && p.tok.Type != '}' {
+			field.Default = p.expr()
 		}
-	case 9:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:114
-		{
-			if _, ok := yyDollar[1].expr.(*ast.FuncCallExpr); !ok {
-				yylex.(*Lexer).Error("parse error")
+	}
+	setTokenPos(field, name)
+	return field
+}
+
+// classStat parses a class after the 'class' keyword, tok is the first
+// token of the statement. 'extends' is a keyword after the name of the
+// class, 'static' at the start of a member and 'super' in the members.
+func (p *parser) classStat(tok ast.Token, local bool) ast.Stmt {
+	name := p.expectName()
+	var base ast.Expr
+	if p.tok.Type == TIdent && p.tok.Str == "extends" {
+		p.tok.Type = TExtends
+		p.next()
+		base = p.expr()
+	}
+	p.classes++
+	defer func() { p.classes-- }()
+	members := []*ast.ClassMember{}
+	for {
+		member := p.tok
+		if member.Type == TIdent && member.Str == "static" {
+			p.tok.Type = TStatic
+			member.Type = TStatic
+		}
+		switch member.Type {
+		case TFunction:
+			p.next()
+			name := p.expectName()
+			m := &ast.ClassMember{Name: name.Str, Value: p.funcBody(member)}
+			setTokenPos(m, member)
+			members = append(members, m)
+			continue
+		case TStatic:
+			p.next()
+			m := &ast.ClassMember{Static: true}
+			if function := p.tok; p.accept(TFunction) {
+				m.Name = p.expectName().Str
+				m.Value = p.funcBody(function)
 			} else {
-				yyVAL.stmt = &ast.FuncCallStmt{Expr: yyDollar[1].expr}
-				yyVAL.stmt.SetLine(yyDollar[1].expr.Line())
+				m.Name = p.expectName().Str
+				p.expect('=')
+				m.Value = p.expr()
 			}
+			setTokenPos(m, member)
+			members = append(members, m)
+			continue
 		}
-	case 10:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:122
-		{
-			yyVAL.stmt = &ast.DoBlockStmt{Stmts: yyDollar[2].stmts}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.stmt.SetLastLine(yyDollar[3].token.Pos.Line)
-		}
-	case 11:
-		yyDollar = yyS[yypt-5 : yypt+1]
-//line parser.go.y:127
-		{
-			yyVAL.stmt = &ast.WhileStmt{Condition: yyDollar[2].expr, Stmts: yyDollar[4].stmts}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.stmt.SetLastLine(yyDollar[5].token.Pos.Line)
+		break
+	}
+	end := p.expectMatch(TEnd, tok)
+	stmt := newClassStmt(name.Str, local, base, members)
+	setTokenPos(stmt, tok)
+	stmt.SetLastLine(end.Pos.Line)
+	return stmt
+}
+
+func (p *parser) matchStat() ast.Stmt {
+	tok := p.tok
+	p.tok.Type = TMatch
+	p.next()
+	stmt := &ast.MatchStmt{Value: p.expr()}
+	p.expectKeyword(TWith)
+	for {
+		arm := p.expectKeyword(TCase)
+		m := &ast.MatchArm{Pattern: p.pattern()}
+		if p.accept(TIf) {
+			m.Guard = p.expr()
+		}
+		p.expectMatch(TThen, arm)
+		m.Stmts = p.block(true)
+		setTokenPos(m, arm)
+		stmt.Arms = append(stmt.Arms, m)
+		if p.tok.Type != TIdent || p.tok.Str != "case" {
+			break
 		}
-	case 12:
-		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.go.y:132
-		{
-			yyVAL.stmt = &ast.RepeatStmt{Condition: yyDollar[4].expr, Stmts: yyDollar[2].stmts}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.stmt.SetLastLine(yyDollar[4].expr.Line())
+	}
+	end := p.expectMatch(TEnd, tok)
+	for i, arm := range stmt.Arms {
+		if i+1 < len(stmt.Arms) {
+			arm.SetLastLine(stmt.Arms[i+1].Line())
+		} else {
+			arm.SetLastLine(end.Pos.Line)
 		}
-	case 13:
-		yyDollar = yyS[yypt-6 : yypt+1]
-//line parser.go.y:137
-		{
-			yyVAL.stmt = &ast.IfStmt{Condition: yyDollar[2].expr, Then: yyDollar[4].stmts}
-			cur := yyVAL.stmt
-			for _, elseif := range yyDollar[5].stmts {
-				cur.(*ast.IfStmt).Else = []ast.Stmt{elseif}
-				cur = elseif
+	}
+	setTokenPos(stmt, tok)
+	stmt.SetLastLine(end.Pos.Line)
+	return stmt
+}
+
+func (p *parser) pattern() ast.Expr {
+	tok := p.tok
+	switch tok.Type {
+	case TNil, TTrue, TFalse:
+		expr, _ := p.simpleExpr()
+		return expr
+	case TString:
+		p.next()
+		return stringExpr(tok)
+	case TNumber, '-':
+		lo := p.patternNumber()
+		if !p.accept(T2Comma) {
+			return lo
+		}
+		expr := &ast.RangePattern{Lo: lo, Hi: p.patternNumber()}
+		setExprPos(expr, lo)
+		return expr
+	case TIdent:
+		p.next()
+		if p.accept(':') {
+			expr := &ast.TypePattern{Name: tok.Str, Type: p.typeHint()}
+			setTokenPos(expr, tok)
+			return expr
+		}
+		expr := &ast.IdentExpr{Value: tok.Str}
+		setTokenPos(expr, tok)
+		return expr
+	case '{':
+		p.next()
+		fields := []*ast.Field{}
+		for p.tok.Type != '}' {
+			fields = append(fields, p.patternField())
+			if !p.accept(',') && !p.accept(';') {
+				break
 			}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.stmt.SetLastLine(yyDollar[6].token.Pos.Line)
 		}
-	case 14:
-		yyDollar = yyS[yypt-8 : yypt+1]
-//line parser.go.y:147
-		{
-			yyVAL.stmt = &ast.IfStmt{Condition: yyDollar[2].expr, Then: yyDollar[4].stmts}
-			cur := yyVAL.stmt
-			for _, elseif := range yyDollar[5].stmts {
-				cur.(*ast.IfStmt).Else = []ast.Stmt{elseif}
-				cur = elseif
+		p.expectMatch('}', tok)
+		expr := &ast.TableExpr{Fields: fields}
+		setTokenPos(expr, tok)
+		return expr
+	}
+	p.errorExpected("pattern")
+	return nil
+}
+
+func (p *parser) patternNumber() ast.Expr {
+	tok := p.tok
+	prefix := ""
+	if p.accept('-') {
+		prefix = "-"
+	}
+	number := p.expect(TNumber)
+	expr := &ast.NumberExpr{Value: prefix + number.Str}
+	setTokenPos(expr, tok)
+	return expr
+}
+
+func (p *parser) patternField() *ast.Field {
+	switch tok := p.tok; {
+	case tok.Type == TIdent && p.peek().Type == '=':
+		p.next()
+		p.next()
+		key := stringExpr(tok)
+		return &ast.Field{Key: key, Value: p.pattern()}
+	case tok.Type == '[':
+		p.next()
+		key := p.expr()
+		p.expectMatch(']', tok)
+		p.expect('=')
+		return &ast.Field{Key: key, Value: p.pattern()}
+	}
+	return &ast.Field{Value: p.pattern()}
+}
+
+func (p *parser) funcName() *ast.FuncName {
+	tok := p.expectName()
+	name := &ast.FuncName{Func: &ast.IdentExpr{Value: tok.Str}}
+	setTokenPos(name.Func, tok)
+	for p.accept('.') {
+		tok := p.expectName()
+		fn := &ast.AttrGetExpr{Object: name.Func, Key: stringExpr(tok)}
+		setTokenPos(fn, tok)
+		name.Func = fn
+	}
+	if p.accept(':') {
+		name = &ast.FuncName{Receiver: name.Func, Method: p.expectName().Str}
+	}
+	return name
+}
+
+// funcBody parses the parameters and the body of the function that
+// function, the 'function' keyword, starts.
+func (p *parser) funcBody(function ast.Token) *ast.FunctionExpr {
+	open := p.expect('(')
+	fn := &ast.FunctionExpr{ParList: p.parList()}
+	p.expectMatch(')', open)
+	if p.accept(':') {
+		if paren := p.tok; p.accept('(') {
+			fn.ReturnTypes = []*ast.TypeHint{p.typeHint()}
+			for p.accept(',') {
+				fn.ReturnTypes = append(fn.ReturnTypes, p.typeHint())
 			}
-			cur.(*ast.IfStmt).Else = yyDollar[7].stmts
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.stmt.SetLastLine(yyDollar[8].token.Pos.Line)
-		}
-	case 15:
-		yyDollar = yyS[yypt-9 : yypt+1]
-//line parser.go.y:158
-		{
-			yyVAL.stmt = &ast.NumberForStmt{Name: yyDollar[2].token.Str, Init: yyDollar[4].expr, Limit: yyDollar[6].expr, Stmts: yyDollar[8].stmts}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.stmt.SetLastLine(yyDollar[9].token.Pos.Line)
-		}
-	case 16:
-		yyDollar = yyS[yypt-11 : yypt+1]
-//line parser.go.y:163
-		{
-			yyVAL.stmt = &ast.NumberForStmt{Name: yyDollar[2].token.Str, Init: yyDollar[4].expr, Limit: yyDollar[6].expr, Step: yyDollar[8].expr, Stmts: yyDollar[10].stmts}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.stmt.SetLastLine(yyDollar[11].token.Pos.Line)
-		}
-	case 17:
-		yyDollar = yyS[yypt-7 : yypt+1]
-//line parser.go.y:168
-		{
-			yyVAL.stmt = &ast.GenericForStmt{Names: yyDollar[2].namelist, Exprs: yyDollar[4].exprlist, Stmts: yyDollar[6].stmts}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.stmt.SetLastLine(yyDollar[7].token.Pos.Line)
-		}
-	case 18:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:173
-		{
-			yyVAL.stmt = &ast.FuncDefStmt{Name: yyDollar[2].funcname, Func: yyDollar[3].funcexpr}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.stmt.SetLastLine(yyDollar[3].funcexpr.LastLine())
-		}
-	case 19:
-		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.go.y:178
-		{
-			yyVAL.stmt = &ast.LocalAssignStmt{Names: []string{yyDollar[3].token.Str}, Exprs: []ast.Expr{yyDollar[4].funcexpr}}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.stmt.SetLastLine(yyDollar[4].funcexpr.LastLine())
-		}
-	case 20:
-		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.go.y:183
-		{
-			yyVAL.stmt = &ast.LocalAssignStmt{Names: yyDollar[2].namelist, Exprs: yyDollar[4].exprlist}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 21:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:187
-		{
-			yyVAL.stmt = &ast.LocalAssignStmt{Names: yyDollar[2].namelist, Exprs: []ast.Expr{}}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 22:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:191
-		{
-			yyVAL.stmt = &ast.LabelStmt{Name: yyDollar[2].token.Str}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 23:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:195
-		{
-			yyVAL.stmt = &ast.GotoStmt{Label: yyDollar[2].token.Str}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 24:
-		yyDollar = yyS[yypt-0 : yypt+1]
-//line parser.go.y:201
-		{
-			yyVAL.stmts = []ast.Stmt{}
-		}
-	case 25:
-		yyDollar = yyS[yypt-5 : yypt+1]
-//line parser.go.y:204
-		{
-			yyVAL.stmts = append(yyDollar[1].stmts, &ast.IfStmt{Condition: yyDollar[3].expr, Then: yyDollar[5].stmts})
-			yyVAL.stmts[len(yyVAL.stmts)-1].SetLine(yyDollar[2].token.Pos.Line)
-		}
-	case 26:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:210
-		{
-			yyVAL.stmt = &ast.ReturnStmt{Exprs: nil}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 27:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:214
-		{
-			yyVAL.stmt = &ast.ReturnStmt{Exprs: yyDollar[2].exprlist}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 28:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:218
-		{
-			yyVAL.stmt = &ast.BreakStmt{}
-			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 29:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:224
-		{
-			yyVAL.funcname = yyDollar[1].funcname
-		}
-	case 30:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:227
-		{
-			yyVAL.funcname = &ast.FuncName{Func: nil, Receiver: yyDollar[1].funcname.Func, Method: yyDollar[3].token.Str}
-		}
-	case 31:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:232
-		{
-			yyVAL.funcname = &ast.FuncName{Func: &ast.IdentExpr{Value: yyDollar[1].token.Str}}
-			yyVAL.funcname.Func.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 32:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:236
-		{
-			key := &ast.StringExpr{Value: yyDollar[3].token.Str}
-			key.SetLine(yyDollar[3].token.Pos.Line)
-			fn := &ast.AttrGetExpr{Object: yyDollar[1].funcname.Func, Key: key}
-			fn.SetLine(yyDollar[3].token.Pos.Line)
-			yyVAL.funcname = &ast.FuncName{Func: fn}
-		}
-	case 33:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:245
-		{
-			yyVAL.exprlist = []ast.Expr{yyDollar[1].expr}
-		}
-	case 34:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:248
-		{
-			yyVAL.exprlist = append(yyDollar[1].exprlist, yyDollar[3].expr)
-		}
-	case 35:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:253
-		{
-			yyVAL.expr = &ast.IdentExpr{Value: yyDollar[1].token.Str}
-			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 36:
-		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.go.y:257
-		{
-			yyVAL.expr = &ast.AttrGetExpr{Object: yyDollar[1].expr, Key: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 37:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:261
-		{
-			key := &ast.StringExpr{Value: yyDollar[3].token.Str}
-			key.SetLine(yyDollar[3].token.Pos.Line)
-			yyVAL.expr = &ast.AttrGetExpr{Object: yyDollar[1].expr, Key: key}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 38:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:269
-		{
-			yyVAL.namelist = []string{yyDollar[1].token.Str}
-		}
-	case 39:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:272
-		{
-			yyVAL.namelist = append(yyDollar[1].namelist, yyDollar[3].token.Str)
-		}
-	case 40:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:277
-		{
-			yyVAL.exprlist = []ast.Expr{yyDollar[1].expr}
-		}
-	case 41:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:280
-		{
-			yyVAL.exprlist = append(yyDollar[1].exprlist, yyDollar[3].expr)
-		}
-	case 42:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:285
-		{
-			yyVAL.expr = &ast.NilExpr{}
-			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 43:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:289
-		{
-			yyVAL.expr = &ast.FalseExpr{}
-			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 44:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:293
-		{
-			yyVAL.expr = &ast.TrueExpr{}
-			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 45:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:297
-		{
-			yyVAL.expr = &ast.NumberExpr{Value: yyDollar[1].token.Str}
-			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 46:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:301
-		{
-			yyVAL.expr = &ast.Comma3Expr{}
-			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 47:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:305
-		{
-			yyVAL.expr = yyDollar[1].expr
-		}
-	case 48:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:308
-		{
-			yyVAL.expr = yyDollar[1].expr
-		}
-	case 49:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:311
-		{
-			yyVAL.expr = yyDollar[1].expr
-		}
-	case 50:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:314
-		{
-			yyVAL.expr = yyDollar[1].expr
-		}
-	case 51:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:317
-		{
-			yyVAL.expr = &ast.LogicalOpExpr{Lhs: yyDollar[1].expr, Operator: "or", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 52:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:321
-		{
-			yyVAL.expr = &ast.LogicalOpExpr{Lhs: yyDollar[1].expr, Operator: "and", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 53:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:325
-		{
-			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyDollar[1].expr, Operator: ">", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 54:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:329
-		{
-			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyDollar[1].expr, Operator: "<", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 55:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:333
-		{
-			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyDollar[1].expr, Operator: ">=", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 56:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:337
-		{
-			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyDollar[1].expr, Operator: "<=", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 57:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:341
-		{
-			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyDollar[1].expr, Operator: "==", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 58:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:345
-		{
-			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyDollar[1].expr, Operator: "~=", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 59:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:349
-		{
-			yyVAL.expr = &ast.StringConcatOpExpr{Lhs: yyDollar[1].expr, Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 60:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:353
-		{
-			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyDollar[1].expr, Operator: "+", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 61:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:357
-		{
-			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyDollar[1].expr, Operator: "-", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 62:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:361
-		{
-			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyDollar[1].expr, Operator: "*", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 63:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:365
-		{
-			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyDollar[1].expr, Operator: "/", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 64:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:369
-		{
-			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyDollar[1].expr, Operator: "%", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 65:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:373
-		{
-			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyDollar[1].expr, Operator: "^", Rhs: yyDollar[3].expr}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
-		}
-	case 66:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:377
-		{
-			yyVAL.expr = &ast.UnaryMinusOpExpr{Expr: yyDollar[2].expr}
-			yyVAL.expr.SetLine(yyDollar[2].expr.Line())
-		}
-	case 67:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:381
-		{
-			yyVAL.expr = &ast.UnaryNotOpExpr{Expr: yyDollar[2].expr}
-			yyVAL.expr.SetLine(yyDollar[2].expr.Line())
-		}
-	case 68:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:385
-		{
-			yyVAL.expr = &ast.UnaryLenOpExpr{Expr: yyDollar[2].expr}
-			yyVAL.expr.SetLine(yyDollar[2].expr.Line())
+			p.expectMatch(')', paren)
+		} else {
+			fn.ReturnTypes = []*ast.TypeHint{p.typeHint()}
 		}
-	case 69:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:391
-		{
-			yyVAL.expr = &ast.StringExpr{Value: yyDollar[1].token.Str}
-			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
+	}
+	fn.Stmts = p.block(false)
+	end := p.expectMatch(TEnd, function)
+	setTokenPos(fn, open)
+	fn.SetLastLine(end.Pos.Line)
+	return fn
+}
+
+func (p *parser) parList() *ast.ParList {
+	list := &ast.ParList{Names: []string{}}
+	switch p.tok.Type {
+	case ')':
+		return list
+	case T3Comma:
+		p.next()
+		list.HasVargs = true
+		return list
+	}
+	for {
+		if p.accept(T3Comma) {
+			list.HasVargs = true
+			return list
 		}
-	case 70:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:397
-		{
-			yyVAL.expr = yyDollar[1].expr
+		list.Names = append(list.Names, p.expectName().Str)
+		var typ *ast.TypeHint
+		if p.accept(':') {
+			typ = p.typeHint()
 		}
-	case 71:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:400
-		{
-			yyVAL.expr = yyDollar[1].expr
+		var def ast.Expr
+		if p.accept('=') {
+			def = p.expr()
 		}
-	case 72:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:403
-		{
-			yyVAL.expr = yyDollar[1].expr
+		list.Types = append(list.Types, typ)
+		list.Defaults = append(list.Defaults, def)
+		if !p.accept(',') {
+			return list
 		}
-	case 73:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:406
-		{
-			if ex, ok := yyDollar[2].expr.(*ast.Comma3Expr); ok {
-				ex.AdjustRet = true
+	}
+}
+
+func (p *parser) typeHint() *ast.TypeHint {
+	tok := p.tok
+	if tok.Type != TIdent && tok.Type != TFunction {
+		p.errorExpected("type")
+	}
+	p.next()
+	hint := &ast.TypeHint{Name: tok.Str, Nullable: p.accept('?')}
+	setTokenPos(hint, tok)
+	return hint
+}
+
+/* }}} */
+
+/* expressions {{{ */
+
+// the kinds of expressions, that tell what a statement starting with one is
+const (
+	exprOther = iota
+	exprVar   // a name or a field, which can be assigned
+	exprCall  // a function call, not in parentheses
+)
+
+func (p *parser) exprList() []ast.Expr {
+	exprs := []ast.Expr{p.expr()}
+	for p.accept(',') {
+		exprs = append(exprs, p.expr())
+	}
+	return exprs
+}
+
+func (p *parser) expr() ast.Expr {
+	expr, _ := p.subExpr(0)
+	return expr
+}
+
+// startsExpr reports whether the current token can start an expression.
+func (p *parser) startsExpr() bool {
+	switch p.tok.Type {
+	case TNil, TFalse, TTrue, TNumber, TString, TInterp, T3Comma, TFunction, TIdent,
+		TNot, '-', '#', '~', '{', '(':
+		return true
+	}
+	return false
+}
+
+// the priorities of the operators, the higher the tighter they bind
+const unaryPriority = 13
+
+// binaryPriority returns the priorities of a binary operator on its left
+// and right sides, 0 if typ is not one. Right associative operators bind
+// tighter on their left.
+func binaryPriority(typ int) (left, right int) {
+	switch typ {
+	case T2Question:
+		return 1, 1
+	case TOr:
+		return 2, 2
+	case TAnd:
+		return 3, 3
+	case '<', '>', TLte, TGte, TEqeq, TNeq:
+		return 4, 4
+	case '|':
+		return 5, 5
+	case '~':
+		return 6, 6
+	case '&':
+		return 7, 7
+	case TShl, TShr:
+		return 8, 8
+	case T2Comma:
+		return 10, 9
+	case '+', '-':
+		return 11, 11
+	case '*', '/', T2Slash, '%':
+		return 12, 12
+	case '^':
+		return 15, 14
+	}
+	return 0, 0
+}
+
+// subExpr parses an expression whose operators bind tighter than limit.
+func (p *parser) subExpr(limit int) (ast.Expr, int) {
+	var expr ast.Expr
+	kind := exprOther
+	switch op := p.tok; op.Type {
+	case '-', TNot, '#', '~':
+		p.next()
+		operand, _ := p.subExpr(unaryPriority)
+		expr = newUnaryExpr(op, operand)
+	default:
+		expr, kind = p.simpleExpr()
+	}
+	for {
+		op := p.tok
+		left, right := binaryPriority(op.Type)
+		if left <= limit {
+			return expr, kind
+		}
+		p.next()
+		rhs, _ := p.subExpr(right)
+		expr = newBinaryExpr(op, expr, rhs)
+		kind = exprOther
+	}
+}
+
+func newUnaryExpr(op ast.Token, operand ast.Expr) ast.Expr {
+	var expr ast.Expr
+	switch op.Type {
+	case '-':
+		expr = &ast.UnaryMinusOpExpr{Expr: operand}
+	case TNot:
+		expr = &ast.UnaryNotOpExpr{Expr: operand}
+	case '#':
+		expr = &ast.UnaryLenOpExpr{Expr: operand}
+	default:
+		expr = &ast.UnaryBNotOpExpr{Expr: operand}
+	}
+	expr.SetLine(operand.Line())
+	expr.SetColumn(op.Pos.Column)
+	return expr
+}
+
+func newBinaryExpr(op ast.Token, lhs, rhs ast.Expr) ast.Expr {
+	var expr ast.Expr
+	switch op.Type {
+	case T2Question:
+		expr = &ast.NilCoalesceOpExpr{Lhs: lhs, Rhs: rhs}
+	case TOr, TAnd:
+		expr = &ast.LogicalOpExpr{Lhs: lhs, Operator: op.Str, Rhs: rhs}
+	case '<', '>', TLte, TGte, TEqeq, TNeq:
+		expr = &ast.RelationalOpExpr{Lhs: lhs, Operator: op.Str, Rhs: rhs}
+	case T2Comma:
+		expr = &ast.StringConcatOpExpr{Lhs: lhs, Rhs: rhs}
+	default:
+		expr = &ast.ArithmeticOpExpr{Lhs: lhs, Operator: op.Str, Rhs: rhs}
+	}
+	setExprPos(expr, lhs)
+	return expr
+}
+
+func (p *parser) simpleExpr() (ast.Expr, int) {
+	tok := p.tok
+	var expr ast.Expr
+	switch tok.Type {
+	case TNil:
+		expr = &ast.NilExpr{}
+	case TFalse:
+		expr = &ast.FalseExpr{}
+	case TTrue:
+		expr = &ast.TrueExpr{}
+	case TNumber:
+		expr = &ast.NumberExpr{Value: tok.Str}
+	case T3Comma:
+		expr = &ast.Comma3Expr{}
+	case TString:
+		expr = stringExpr(tok)
+	case TInterp:
+		expr = p.interp
+		p.next()
+		return expr, exprOther
+	case '{':
+		return p.tableConstructor(), exprOther
+	case TFunction:
+		p.next()
+		body := p.funcBody(tok)
+		fn := &ast.FunctionExpr{ParList: body.ParList, ReturnTypes: body.ReturnTypes, Stmts: body.Stmts}
+		setTokenPos(fn, tok)
+		fn.SetLastLine(body.LastLine())
+		return fn, exprOther
+	default:
+		return p.suffixedExpr()
+	}
+	setTokenPos(expr, tok)
+	p.next()
+	return expr, exprOther
+}
+
+// primaryExpr parses a name, 'super' or an expression in parentheses.
+func (p *parser) primaryExpr() (ast.Expr, int) {
+	tok := p.tok
+	switch tok.Type {
+	case TIdent:
+		if tok.Str == "super" && p.classes > 0 {
+			p.tok.Type = TSuper
+			p.next()
+			expr := &ast.SuperExpr{}
+			setTokenPos(expr, tok)
+			return expr, exprOther
+		}
+		p.next()
+		expr := &ast.IdentExpr{Value: tok.Str}
+		setTokenPos(expr, tok)
+		return expr, exprVar
+	case '(':
+		p.next()
+		expr, kind := p.subExpr(0)
+		p.expectMatch(')', tok)
+		if kind == exprCall {
+			// a call in parentheses results in a single value
+			expr.(*ast.FuncCallExpr).AdjustRet = true
+			return expr, exprOther
+		}
+		if ex, ok := expr.(*ast.Comma3Expr); ok {
+			ex.AdjustRet = true
+		}
+		setTokenPos(expr, tok)
+		return expr, exprOther
+	}
+	p.errorExpected("expression")
+	return nil, exprOther
+}
+
+// suffixedExpr parses a primary expression followed by fields, method
+// names and call arguments.
+func (p *parser) suffixedExpr() (ast.Expr, int) {
+	expr, kind := p.primaryExpr()
+	for {
+		tok := p.tok
+		switch tok.Type {
+		case '.':
+			p.next()
+			field := &ast.AttrGetExpr{Object: expr, Key: stringExpr(p.expectName())}
+			setExprPos(field, expr)
+			expr, kind = field, exprVar
+		case '[':
+			p.next()
+			field := &ast.AttrGetExpr{Object: expr, Key: p.expr()}
+			p.expectMatch(']', tok)
+			setExprPos(field, expr)
+			expr, kind = field, exprVar
+		case TQDot:
+			p.next()
+			field := &ast.AttrGetExpr{Object: expr, Optional: true}
+			if open := p.tok; p.accept('[') {
+				field.Key = p.expr()
+				p.expectMatch(']', open)
+			} else {
+				field.Key = stringExpr(p.expectName())
 			}
-			yyVAL.expr = yyDollar[2].expr
-			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 74:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:415
-		{
-			yyDollar[2].expr.(*ast.FuncCallExpr).AdjustRet = true
-			yyVAL.expr = yyDollar[2].expr
-		}
-	case 75:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:421
-		{
-			yyVAL.expr = &ast.FuncCallExpr{Func: yyDollar[1].expr, Args: yyDollar[2].exprlist}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setExprPos(field, expr)
+			expr, kind = field, exprOther
+		case ':', TQColon:
+			p.next()
+			method := p.expectName()
+			call := &ast.FuncCallExpr{Method: method.Str, Receiver: expr}
+			call.Optional = tok.Type == TQColon
+			call.Args, call.NamedArgs = p.callArgs()
+			setExprPos(call, expr)
+			expr, kind = call, exprCall
+		case '(', TString, '{':
+			call := &ast.FuncCallExpr{Func: expr}
+			call.Args, call.NamedArgs = p.callArgs()
+			setExprPos(call, expr)
+			expr, kind = call, exprCall
+		default:
+			return expr, kind
 		}
-	case 76:
-		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.go.y:425
-		{
-			yyVAL.expr = &ast.FuncCallExpr{Method: yyDollar[3].token.Str, Receiver: yyDollar[1].expr, Args: yyDollar[4].exprlist}
-			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+	}
+}
+
+// callArgs parses the arguments of a call. f{...} passes named arguments to
+// functions with default parameters.
+func (p *parser) callArgs() (args []ast.Expr, named bool) {
+	tok := p.tok
+	switch tok.Type {
+	case TString:
+		p.next()
+		return []ast.Expr{stringExpr(tok)}, false
+	case '{':
+		return []ast.Expr{p.tableConstructor()}, true
+	case '(':
+		p.next()
+		args = []ast.Expr{}
+		if p.tok.Type != ')' {
+			args = p.exprList()
+		}
+		p.expectMatch(')', tok)
+		return args, false
+	}
+	p.errorExpected("function arguments")
+	return nil, false
+}
+
+func (p *parser) tableConstructor() ast.Expr {
+	open := p.expect('{')
+	fields := []*ast.Field{}
+	for p.tok.Type != '}' {
+		fields = append(fields, p.field())
+		if !p.accept(',') && !p.accept(';') {
+			break
 		}
-	case 77:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:431
-		{
-			if yylex.(*Lexer).PNewLine {
-				yylex.(*Lexer).TokenError(yyDollar[1].token, "ambiguous syntax (function call x new statement)")
-			}
-			yyVAL.exprlist = []ast.Expr{}
+	}
+	p.expectMatch('}', open)
+	expr := &ast.TableExpr{Fields: fields}
+	setTokenPos(expr, open)
+	return expr
+}
+
+func (p *parser) field() *ast.Field {
+	switch tok := p.tok; {
+	case tok.Type == TIdent && p.peek().Type == '=':
+		p.next()
+		p.next()
+		return &ast.Field{Key: stringExpr(tok), Value: p.expr()}
+	case tok.Type == '[':
+		p.next()
+		key := p.expr()
+		p.expectMatch(']', tok)
+		p.expect('=')
+		return &ast.Field{Key: key, Value: p.expr()}
+	}
+	return &ast.Field{Value: p.expr()}
+}
+
+/* }}} */
+
+/* nodes {{{ */
+
+func setTokenPos(node ast.PositionHolder, tok ast.Token) {
+	node.SetLine(tok.Pos.Line)
+	node.SetColumn(tok.Pos.Column)
+}
+
+func setExprPos(node ast.PositionHolder, expr ast.Expr) {
+	node.SetLine(expr.Line())
+	node.SetColumn(expr.Column())
+}
+
+func stringExpr(tok ast.Token) *ast.StringExpr {
+	expr := &ast.StringExpr{Value: tok.Str}
+	setTokenPos(expr, tok)
+	return expr
+}
+
+// newDestructStmt returns the statement binding the names and the fields of
+// 'local {a, b=}, c = exprs'.
+func newDestructStmt(fields []*ast.DestructField, exprs []ast.Expr) *ast.LocalAssignStmt {
+	stmt := &ast.LocalAssignStmt{Exprs: exprs, Pattern: fields}
+	for _, field := range fields {
+		if field.Key != nil {
+			field.Key.SetLine(field.Line())
+			field.Key.SetColumn(field.Column())
 		}
-	case 78:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:437
-		{
-			if yylex.(*Lexer).PNewLine {
-				yylex.(*Lexer).TokenError(yyDollar[1].token, "ambiguous syntax (function call x new statement)")
+		stmt.Names = append(stmt.Names, field.Name)
+	}
+	return stmt
+}
+
+func newClassStmt(name string, local bool, base ast.Expr, members []*ast.ClassMember) *ast.ClassStmt {
+	for _, member := range members {
+		if fn, ok := member.Value.(*ast.FunctionExpr); ok {
+			if member.Static {
+				fn.Name = name + "." + member.Name
+			} else {
+				fn.Name = name + ":" + member.Name
 			}
-			yyVAL.exprlist = yyDollar[2].exprlist
-		}
-	case 79:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:443
-		{
-			yyVAL.exprlist = []ast.Expr{yyDollar[1].expr}
-		}
-	case 80:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:446
-		{
-			yyVAL.exprlist = []ast.Expr{yyDollar[1].expr}
-		}
-	case 81:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:451
-		{
-			yyVAL.expr = &ast.FunctionExpr{ParList: yyDollar[2].funcexpr.ParList, Stmts: yyDollar[2].funcexpr.Stmts}
-			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.expr.SetLastLine(yyDollar[2].funcexpr.LastLine())
-		}
-	case 82:
-		yyDollar = yyS[yypt-5 : yypt+1]
-//line parser.go.y:458
-		{
-			yyVAL.funcexpr = &ast.FunctionExpr{ParList: yyDollar[2].parlist, Stmts: yyDollar[4].stmts}
-			yyVAL.funcexpr.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.funcexpr.SetLastLine(yyDollar[5].token.Pos.Line)
-		}
-	case 83:
-		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.go.y:463
-		{
-			yyVAL.funcexpr = &ast.FunctionExpr{ParList: &ast.ParList{HasVargs: false, Names: []string{}}, Stmts: yyDollar[3].stmts}
-			yyVAL.funcexpr.SetLine(yyDollar[1].token.Pos.Line)
-			yyVAL.funcexpr.SetLastLine(yyDollar[4].token.Pos.Line)
-		}
-	case 84:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:470
-		{
-			yyVAL.parlist = &ast.ParList{HasVargs: true, Names: []string{}}
-		}
-	case 85:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:473
-		{
-			yyVAL.parlist = &ast.ParList{HasVargs: false, Names: []string{}}
-			yyVAL.parlist.Names = append(yyVAL.parlist.Names, yyDollar[1].namelist...)
 		}
-	case 86:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:477
-		{
-			yyVAL.parlist = &ast.ParList{HasVargs: true, Names: []string{}}
-			yyVAL.parlist.Names = append(yyVAL.parlist.Names, yyDollar[1].namelist...)
-		}
-	case 87:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:484
-		{
-			yyVAL.expr = &ast.TableExpr{Fields: []*ast.Field{}}
-			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 88:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:488
-		{
-			yyVAL.expr = &ast.TableExpr{Fields: yyDollar[2].fieldlist}
-			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 89:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:495
-		{
-			yyVAL.fieldlist = []*ast.Field{yyDollar[1].field}
-		}
-	case 90:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:498
-		{
-			yyVAL.fieldlist = append(yyDollar[1].fieldlist, yyDollar[3].field)
-		}
-	case 91:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:501
-		{
-			yyVAL.fieldlist = yyDollar[1].fieldlist
-		}
-	case 92:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:506
-		{
-			yyVAL.field = &ast.Field{Key: &ast.StringExpr{Value: yyDollar[1].token.Str}, Value: yyDollar[3].expr}
-			yyVAL.field.Key.SetLine(yyDollar[1].token.Pos.Line)
-		}
-	case 93:
-		yyDollar = yyS[yypt-5 : yypt+1]
-//line parser.go.y:510
-		{
-			yyVAL.field = &ast.Field{Key: yyDollar[2].expr, Value: yyDollar[5].expr}
-		}
-	case 94:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:513
-		{
-			yyVAL.field = &ast.Field{Value: yyDollar[1].expr}
-		}
-	case 95:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:518
-		{
-			yyVAL.fieldsep = ","
-		}
-	case 96:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:521
-		{
-			yyVAL.fieldsep = ";"
+	}
+	return &ast.ClassStmt{Name: name, Local: local, Base: base, Members: members}
+}
+
+func funcNameString(fn *ast.FuncName) string {
+	if fn.Func == nil {
+		return exprNameString(fn.Receiver) + ":" + fn.Method
+	}
+	return exprNameString(fn.Func)
+}
+
+func exprNameString(expr ast.Expr) string {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		return ex.Value
+	case *ast.AttrGetExpr:
+		if key, ok := ex.Key.(*ast.StringExpr); ok {
+			return exprNameString(ex.Object) + "." + key.Value
 		}
 	}
-	goto yystack /* stack new state and value */
+	return "?"
 }
+
+/* }}} */
@@ -1,8 +1,10 @@
 package lua
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync/atomic"
@@ -62,6 +64,16 @@ func testScriptCompile(t *testing.T, script string) {
 	}
 	nop := func(s string) {}
 	nop(proto.String())
+	nop(proto.Disassemble())
+	dumped := proto.RawBytecode()
+	proto2, err4 := Undump(bytes.NewReader(dumped), script)
+	if err4 != nil {
+		t.Fatal(err4)
+		return
+	}
+	if !bytes.Equal(proto2.RawBytecode(), dumped) {
+		t.Errorf("%v: undumped chunk differs from the dumped one", script)
+	}
 }
 
 func testScriptDir(t *testing.T, tests []string, directory string) {
@@ -230,3 +242,1169 @@ func TestMergingLoadNil(t *testing.T) {
 		t.Fatalf("expected 1 LOADNIL instruction, found %d", count)
 	}
 }
+
+func TestTypedFunctionSignatures(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	function greet(name:String):String
+		return "Hello, " .. name
+	end
+	assert(greet("Milk") == "Hello, Milk")
+
+	local function add(a: number, b: number?): number
+		return a + (b or 0)
+	end
+	assert(add(1) == 1)
+	assert(add(1, 2) == 3)
+
+	local obj = {}
+	function obj:pair(t: table): (string, number?)
+		return "len", #t
+	end
+	local k, v = obj:pair({1, 2})
+	assert(k == "len" and v == 2)
+
+	local function both(): (number, number) return (function() return 1, 2 end)() end
+	local x, y = both()
+	assert(x == 1 and y == 2)
+	`)
+	errorIfScriptNotFail(t, L, `greet(1)`, `bad argument #1 'name' to 'greet' \(string expected, got number\)`)
+	errorIfScriptNotFail(t, L, `
+	local function add(a: number, b: number?) return a end
+	add(1, "2")`, `bad argument #2 'b' to 'add' \(number\? expected, got string\)`)
+	errorIfScriptNotFail(t, L, `
+	local obj = {}
+	function obj:m(t: table) end
+	obj:m(1)`, `bad argument #1 't' to 'obj:m'`)
+	errorIfScriptNotFail(t, L, `
+	local function f(): string end
+	f()`, `bad return value #1 from 'f' \(string expected, got nil\)`)
+	errorIfScriptNotFail(t, L, `
+	local function f(): (number, boolean) return 1, 2 end
+	f()`, `bad return value #2 from 'f' \(boolean expected, got number\)`)
+	errorIfScriptNotFail(t, L, `local function f(a: point) end`, `unknown type 'point' for argument #1 'a' to 'f'`)
+}
+
+func TestCompoundAssignment(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local counter = {hits = 1}
+	counter.hits += 1
+	counter.hits *= 10
+	assert(counter.hits == 20)
+
+	local s = "a"
+	s ..= "b" .. "c"
+	s ..= 1
+	assert(s == "abc1")
+
+	g = 5
+	g -= 2
+	g ^= 2
+	g %= 5
+	assert(g == 4)
+
+	local u = 7
+	local function half() u /= 2 return u end
+	assert(half() == 3.5)
+
+	local x = 1
+	x += x * 2
+	assert(x == 3)
+
+	-- the target expression is evaluated only once
+	local calls, keys = 0, 0
+	local proxy = setmetatable({}, {
+		__index = function(t, k) calls = calls + 1 return 10 end,
+		__newindex = function(t, k, v) calls = calls + 1 rawset(t, k, v) end,
+	})
+	local function key() keys = keys + 1 return "x" end
+	proxy[key()] += 5
+	assert(proxy.x == 15 and calls == 2 and keys == 1)
+	`)
+}
+
+func TestContinue(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local odd = {}
+	for i = 1, 10 do
+		if i % 2 == 0 then continue end
+		odd[#odd+1] = i
+	end
+	assert(table.concat(odd, ",") == "1,3,5,7,9")
+
+	local keys = {}
+	for k, v in pairs({a = 1, b = 2, c = 3}) do
+		if v == 2 then continue end
+		keys[#keys+1] = k
+	end
+	table.sort(keys)
+	assert(table.concat(keys, ",") == "a,c")
+
+	local i, sum = 0, 0
+	while i < 10 do
+		i = i + 1
+		if i > 3 and i < 8 then continue end
+		sum = sum + i
+	end
+	assert(sum == 1+2+3+8+9+10)
+
+	local n, seen = 0, 0
+	repeat
+		local m = n
+		n = n + 1
+		if m % 3 == 0 then continue end
+		seen = seen + 1
+	until m >= 8
+	assert(n == 9 and seen == 6)
+
+	-- every iteration closes over its own locals
+	local fns = {}
+	for i = 1, 3 do
+		local x = i * 10
+		fns[#fns+1] = function() return x end
+		if i ~= 2 then continue end
+	end
+	assert(fns[1]() == 10 and fns[2]() == 20 and fns[3]() == 30)
+
+	local fns2 = {}
+	local j = 0
+	while j < 3 do
+		j = j + 1
+		do
+			local y = j
+			fns2[j] = function() return y end
+			continue
+		end
+	end
+	assert(fns2[1]() == 1 and fns2[2]() == 2 and fns2[3]() == 3)
+
+	-- 'continue' is a name elsewhere
+	local seen = {}
+	for i = 1, 3 do
+		if i == 2 then goto continue end
+		seen[#seen + 1] = i
+		::continue::
+	end
+	assert(#seen == 2 and seen[1] == 1 and seen[2] == 3)
+	local continue = 1
+	continue = continue + 1
+	local t = {continue = continue}
+	assert(t.continue == 2)
+	`)
+	errorIfScriptNotFail(t, L, `continue`, "no loop to continue")
+	errorIfScriptNotFail(t, L, `
+	repeat
+		if true then continue end
+		local done = true
+	until done
+	`, "local 'done' used in the 'until' condition is declared after 'continue' at line 3")
+	errorIfScriptNotFail(t, L, `
+	for i = 1, 2 do
+		local f = function() continue end
+	end
+	`, "no loop to continue")
+}
+
+func TestIntegerSubtype(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	assert(math.type(1) == "integer")
+	assert(math.type(1.0) == "float")
+	assert(math.type(1e3) == "float")
+	assert(math.type(0x10) == "integer" and 0x10 == 16)
+	assert(math.type("1") == nil)
+	assert(type(1) == "number" and type(1.5) == "number")
+
+	assert(math.tointeger(3.0) == 3 and math.type(math.tointeger(3.0)) == "integer")
+	assert(math.tointeger(3.5) == nil)
+	assert(math.tointeger("3") == nil)
+
+	assert(math.type(1 + 2) == "integer")
+	assert(math.type(1 + 2.0) == "float")
+	assert(math.type(7 % 3) == "integer" and -7 % 3 == 2 and 7 % -3 == -2)
+	assert(math.type(6 / 2) == "float" and 6 / 2 == 3)
+	assert(math.type(2 ^ 2) == "float")
+	assert(math.type(-(3)) == "integer")
+	assert(math.type(#"abc") == "integer")
+	assert(math.type("10" + 1) == "integer")
+	assert(math.maxinteger + 1 == math.mininteger)
+
+	-- integers above 2^53 keep their precision
+	local big = 9007199254740993
+	assert(tostring(big) == "9007199254740993")
+	assert(big + 1 == 9007199254740994)
+	assert(big ~= 9007199254740992)
+	assert(string.format("%d", big) == "9007199254740993")
+	assert(string.format("%d", 42) == "42")
+	assert(tonumber("9007199254740993") == big)
+	assert(math.type(tonumber("12")) == "integer")
+	assert(tonumber("0xffffffffffffffff") == 0xffffffffffffffff and tonumber("0xffffffffffffffff") == -1)
+	assert(tonumber("0xfe") == 254 and tonumber("-0x10") == -16 and math.type(tonumber("0x10")) == "integer")
+	assert(0x10000000000000001 == 1 and tonumber("0x10000000000000001") == 1)
+
+	assert(1 == 1.0 and 1 < 1.5 and 2 > 1.5 and 2 <= 2.0)
+	assert(math.maxinteger < math.huge)
+
+	local t = {}
+	t[1] = "a"
+	t[2.0] = "b"
+	assert(t[1.0] == "a" and t[2] == "b" and #t == 2)
+	for k in pairs(t) do assert(math.type(k) == "integer") end
+
+	local n = 0
+	for i = 1, 3 do
+		assert(math.type(i) == "integer")
+		n = n + i
+	end
+	assert(n == 6)
+	for i = 1, 2, 0.5 do assert(math.type(i) == "float") end
+	local count = 0
+	for i = math.maxinteger - 2, math.maxinteger do count = count + 1 end
+	assert(count == 3)
+	count = 0
+	for i = 3, 1, -1 do count = count + 1 end
+	assert(count == 3)
+	for i = 1, 0 do error("must not run") end
+	for i in ipairs({"a"}) do assert(math.type(i) == "integer") end
+	assert(math.type(#"abc") == "integer" and math.type(string.len("abc")) == "integer")
+	assert(math.type(string.find("abc", "b")) == "integer" and math.type(string.byte("a")) == "integer")
+	assert(math.type(select("#", 1, 2)) == "integer")
+
+	assert(math.type(math.floor(3.7)) == "integer" and math.floor(3.7) == 3)
+	assert(math.type(math.max(1, 2.5)) == "float" and math.max(3, 2.5) == 3)
+
+	local decoded = json.decode('{"id": 9007199254740993, "ratio": 0.5}')
+	assert(decoded.id == big and math.type(decoded.id) == "integer")
+	assert(math.type(decoded.ratio) == "float")
+	assert(json.encode({id = big}) == '{"id":9007199254740993}')
+	`)
+	errorIfScriptNotFail(t, L, `return 1 % 0`, "attempt to perform 'n%0'")
+	errorIfScriptNotFail(t, L, `for i = 1, 10, 0 do end`, "for statement step must not be zero")
+}
+
+func TestBitwiseOperators(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	assert(0xF0 & 0x3C == 0x30)
+	assert(0xF0 | 0x0F == 0xFF)
+	assert(0xF0 ~ 0xFF == 0x0F)
+	assert(~0 == -1 and ~5 == -6)
+	assert(1 << 4 == 16 and 256 >> 4 == 16)
+	assert(-1 >> 60 == 15)
+	assert(1 << 64 == 0 and 1 << -1 == 0 and 2 >> -1 == 4)
+	assert(math.type(3.0 & 1) == "integer" and 3.0 & 1 == 1)
+	assert("3" | 4 == 7)
+
+	local a, b, f = 0xF0, 0x3C, 3.0
+	assert(a & b == 0x30 and a | b == 0xFC and a ~ b == 0xCC and ~a == -0xF1)
+	assert(a << 1 == 0x1E0 and a >> 4 == 0xF and a // 7 == 34)
+	assert(f & 1 == 1 and math.type(f | 0) == "integer" and f // 2 == 1.0)
+
+	-- precedence follows Lua 5.3
+	assert(1 | 2 ~ 3 & 4 == 1 | (2 ~ (3 & 4)))
+	assert(1 << 2 + 1 == 8)
+	assert(5 & 3 == 1 and 6 & 3 ~= 1)
+	assert(~1 + 1 == -1)
+
+	assert(7 // 2 == 3 and -7 // 2 == -4 and 7 // -2 == -4)
+	assert(math.type(7 // 2) == "integer")
+	assert(7.5 // 2 == 3.0 and math.type(7.5 // 2) == "float")
+	assert(1 // 0.0 == 1 / 0)
+	assert(math.mininteger // -1 == math.mininteger)
+
+	local x = 12
+	x &= 10
+	assert(x == 8)
+	x |= 1
+	x <<= 2
+	x >>= 1
+	assert(x == 18)
+	x //= 4
+	assert(x == 4)
+
+	local mt = {}
+	for _, name in ipairs({"band", "bor", "bxor", "shl", "shr", "idiv"}) do
+		mt["__" .. name] = function(a, b) return name end
+	end
+	mt.__bnot = function(a) return "bnot" end
+	local v = setmetatable({}, mt)
+	assert(v & 1 == "band" and 1 | v == "bor" and v ~ v == "bxor")
+	assert(v << 1 == "shl" and v >> 1 == "shr" and v // 2 == "idiv")
+	assert(~v == "bnot")
+	`)
+	errorIfScriptNotFail(t, L, `return 1.5 & 1`, "number has no integer representation")
+	errorIfScriptNotFail(t, L, `local x = 1.5 return ~x`, "number has no integer representation")
+	errorIfScriptNotFail(t, L, `return 1 // 0`, "attempt to perform 'n//0'")
+	errorIfScriptNotFail(t, L, `return {} & 1`, "cannot perform band operation between table and number")
+}
+
+func TestStringInterpolation(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local name, n = "milk", 3
+	local t = setmetatable({}, {__tostring = function() return "T" end})
+	assert(`+"`hello ${name}, ${n + 1}`"+` == "hello milk, 4")
+	assert(`+"`${t}:${nil}:${true}`"+` == "T:nil:true")
+	assert(`+"`plain`"+` == "plain" and `+"`${n}`"+` == "3")
+	assert(`+"`${ ({1, 2, 3})[2] } ${\"a}b\"} ${'{'}`"+` == "2 a}b {")
+	assert(`+"`outer ${`inner ${n}`}`"+` == "outer inner 3")
+	assert(`+"`\\${n} \\` $ {}`"+` == "${n} `+"`"+` $ {}")
+	local function f() return 1, 2 end
+	assert(`+"`${f()}`"+` == "1")
+	assert(`+"`a\n${n}`"+` == "a\n3")
+	`)
+	errorIfScriptNotFail(t, L, "return `a ${1 +} b`", `line:1\(column:16\) near '}':\s+syntax error`)
+	errorIfScriptNotFail(t, L, "return `a ${} b`", `line:1\(column:13\) near '}':\s+empty interpolation`)
+	errorIfScriptNotFail(t, L, "return `a ${1, 2}`", `line:1\(column:13\) near '1, 2':\s+interpolation must be a single expression`)
+	errorIfScriptNotFail(t, L, "return `a\n  ${x +* 2}`", `line:2\(column:8\) near '\*':\s+syntax error`)
+	errorIfScriptNotFail(t, L, "return `a ${x", "unterminated interpolation")
+	errorIfScriptNotFail(t, L, "return `abc", "unterminated interpolated string")
+}
+
+func TestClass(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	class Animal
+		static count = 0
+		function init(name)
+			self.name = name
+			Animal.count = Animal.count + 1
+		end
+		function speak()
+			return self.name .. " makes a sound"
+		end
+		function __eq(other)
+			return self.name == other.name
+		end
+		static function create(name)
+			return Animal(name)
+		end
+	end
+
+	local class Dog extends Animal
+		function init(name, breed)
+			super:init(name)
+			self.breed = breed
+		end
+		function speak()
+			return super:speak() .. " (woof)"
+		end
+	end
+
+	local a = Animal.create("rex")
+	local d = Dog("rex", "lab")
+	assert(a:speak() == "rex makes a sound")
+	assert(d:speak() == "rex makes a sound (woof)" and d.breed == "lab")
+	assert(Animal.count == 2 and Dog.count == 2)
+	assert(a == d, "metamethods are inherited")
+	assert(getmetatable(d) == Dog and Dog.__index == Dog)
+	assert(instanceof(d, Dog) and instanceof(d, Animal))
+	assert(not instanceof(a, Dog) and not instanceof({}, Animal) and not instanceof(1, Animal))
+	assert(string.find(tostring(d), "^Dog: 0x"))
+
+	-- plain tables interoperate with classes
+	local Base = {}
+	Base.__index = Base
+	function Base:hello() return "hello " .. self.name end
+	class Greeter extends Base end
+	assert(Greeter({}).hello == Base.hello)
+	local g = setmetatable({name = "milk"}, Greeter)
+	assert(g:hello() == "hello milk" and instanceof(g, Greeter))
+
+	-- the class keywords are names elsewhere
+	local t = {class = 1, extends = 2, static = 3, super = 4}
+	assert(t.class == 1 and t.extends == 2 and t.static == 3 and t.super == 4)
+	local super, static = 5, 6
+	assert(super + static == 11)
+	local class = "class"
+	class = class .. "es"
+	assert(class == "classes")
+	`)
+	errorIfScriptNotFail(t, L, `class X extends 1 end`, "cannot extend a number value")
+	errorIfScriptNotFail(t, L, `local function f() return super:x() end; f()`, "attempt to index a non-table object.nil. with key 'x'")
+}
+
+func TestPrivateState(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local tbl = {public = 1}
+	local private_tbl = {secret = 10}
+	function private_tbl:reveal() return self.secret end
+	assert(setprivate(tbl, private_tbl) == tbl)
+	assert(getprivate(tbl) == private_tbl and getprivate(tbl):reveal() == 10)
+	assert(getprivate({}) == nil)
+
+	local n = 0
+	for k, v in pairs(tbl) do n = n + 1; assert(v ~= private_tbl) end
+	assert(n == 1 and next(tbl, "public") == nil)
+	assert(rawget(tbl, "secret") == nil and tbl.secret == nil)
+	assert(json.encode(tbl) == '{"public":1}')
+	assert(not string.find(table.tostring(tbl), "secret"))
+
+	setprivate(tbl, nil)
+	assert(getprivate(tbl) == nil)
+	`)
+}
+
+func TestOptionalChaining(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local cfg = json.decode('{"server": {"port": 8080}}')
+	cfg.server.tags = {"a"}
+	local none = nil
+	assert(cfg?.server?.port == 8080 and cfg.server?.tags?.[1] == "a")
+	assert(cfg?.client?.port == nil and none?.a == nil and none?.["a"] == nil)
+
+	local called = false
+	local function key() called = true; return "k" end
+	local obj = {n = 1}
+	function obj:add(x) return self.n + x end
+	function obj:pair() return 1, 2 end
+	assert(none?.[key()] == nil and none?:add(key()) == nil and not called)
+	assert(obj?:add(2) == 3 and select("#", obj?:pair()) == 2)
+	assert(select("#", none?:pair()) == 1 and select("#", (obj?:pair())) == 1)
+	local function get(o) return o?:pair() end
+	assert(select("#", get()) == 1 and get() == nil)
+	assert(select("#", get(obj)) == 2)
+	local a, b = obj?:pair()
+	assert(a == 1 and b == 2)
+	a, b = none?:pair()
+	assert(a == nil and b == nil)
+	local t = {obj?:pair()}
+	assert(#t == 2 and t[2] == 2)
+	none?:add(1)
+
+	assert((false ?? 1) == false and (nil ?? 1) == 1 and (0 ?? 1) == 0)
+	assert((none ?? nil ?? "x") == "x" and (nil ?? false or "y") == "y")
+	assert((cfg?.client?.port ?? 80) == 80)
+	local x = 1
+	x = none ?? x
+	assert(x == 1)
+	local t = {}
+	t.v = none ?? x
+	assert(t.v == 1)
+	`)
+	errorIfScriptNotFail(t, L, `local a = {}; return a?.b.c`, "attempt to index a non-table object\\(nil\\)")
+}
+
+func TestCloseVariables(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local log = {}
+	local function closer(name)
+		return setmetatable({}, {__close = function(self, err)
+			log[#log + 1] = name .. ":" .. tostring(err)
+		end})
+	end
+	local function flush()
+		local s = table.concat(log, ",")
+		for k in pairs(log) do log[k] = nil end
+		return s
+	end
+
+	do
+		local a <close> = closer("a")
+		local b <close>, c <const> = closer("b"), 1
+		local d <close> = nil
+	end
+	assert(flush() == "b:nil,a:nil")
+
+	local ok, err = pcall(function()
+		local x <close> = closer("x")
+		error("boom", 0)
+	end)
+	assert(not ok and err == "boom" and flush() == "x:boom")
+
+	for i = 1, 3 do
+		local y <close> = closer("y" .. i)
+		if i == 2 then break end
+	end
+	assert(flush() == "y1:nil,y2:nil")
+
+	for i = 1, 2 do
+		local z <close> = closer("z" .. i)
+		if i == 1 then continue end
+		log[#log + 1] = "body" .. i
+	end
+	assert(flush() == "z1:nil,body2,z2:nil")
+
+	do
+		local g <close> = closer("g")
+		for i = 1, 2 do if i == 1 then goto next end ::next:: end
+		log[#log + 1] = "after"
+	end
+	assert(flush() == "after,g:nil")
+
+	local function ret()
+		local r <close> = closer("r")
+		return tostring(#log)
+	end
+	assert(ret() == "0" and flush() == "r:nil")
+
+	local ok, err = pcall(function()
+		local e <close> = setmetatable({}, {__close = function() error("in close", 0) end})
+		error("orig", 0)
+	end)
+	assert(not ok and err == "in close")
+
+	local gen = coroutine.wrap(function()
+		local a <close> = closer("a")
+		local b <close> = closer("b")
+		coroutine.yield(1)
+		error("co", 0)
+	end)
+	assert(gen() == 1 and flush() == "")
+	ok, err = pcall(gen)
+	assert(not ok and err == "co" and flush() == "b:co,a:co")
+	local co = coroutine.create(function()
+		local c <close> = closer("c")
+		error("co", 0)
+	end)
+	ok, err = coroutine.resume(co)
+	assert(not ok and err == "co" and flush() == "c:co")
+
+	local name = os.tmpname()
+	local handle
+	do
+		local w <close> = io.open(name, "w")
+		w:write("milk")
+		w:close()
+	end
+	do
+		local r <close> = io.open(name)
+		handle = r
+		assert(r:read("*a") == "milk")
+	end
+	assert(io.type(handle) == "closed file")
+	do
+		local out <close> = io.stdout
+	end
+	assert(io.type(io.stdout) == "file")
+	os.remove(name)
+	`)
+	errorIfScriptNotFail(t, L, `local f <close> = {}; return 1`, "variable 'f' got a non-closable value")
+	errorIfScriptNotFail(t, L, `do local x, f <close> = 1, {} end`, "variable 'f' got a non-closable value")
+	errorIfScriptNotFail(t, L, `local function g() local h <close> = 1 end; g()`, "variable 'h' got a non-closable value")
+	errorIfScriptNotFail(t, L, `local x <const> = 1; x = 2`, "attempt to assign to const variable 'x'")
+	errorIfScriptNotFail(t, L, `local x <const> = 1; return function() x += 1 end`, "attempt to assign to const variable 'x'")
+	errorIfScriptNotFail(t, L, `local x <close> = nil; function x() end`, "attempt to assign to const variable 'x'")
+	errorIfScriptNotFail(t, L, `local x <final> = 1`, "unknown attribute 'final'")
+	errorIfScriptNotFail(t, L, `local x <close>, y <close> = nil`, "multiple to-be-closed variables in local list")
+}
+
+func TestDestructuring(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local {x, y, z} = {10, 20}
+	assert(x == 10 and y == 20 and z == nil)
+
+	local {name=, age=, email = "none"} = {name = "milk", age = 3}
+	assert(name == "milk" and age == 3 and email == "none")
+
+	local function row() return {1, 2, id = 7} end
+	local {a, id=, b} = row()
+	assert(a == 1 and id == 7 and b == 2)
+
+	local {f = true, g = nil, h = 5} = {f = false, h = 0}
+	assert(f == false and g == nil and h == 0)
+
+	local n = 0
+	local function count() n = n + 1; return n end
+	local {p = count(), q = count(), r = count()} = {q = "set"}
+	assert(p == 1 and q == "set" and r == 2)
+
+	local v = "outer"
+	local {v = v} = {}
+	assert(v == "outer")
+
+	-- patterns mixed with names in the list
+	local function three() return {1, 2}, {k = 3}, 4 end
+	local {c1, c2}, {k=}, d = three()
+	assert(c1 == 1 and c2 == 2 and k == 3 and d == 4)
+	local e, {e1} = 0, {5}, print
+	assert(e == 0 and e1 == 5)
+	local {m = "m"}, o, {s} = {}, nil, {6}
+	assert(m == "m" and o == nil and s == 6)
+	local {t1}, t2 = {7}
+	assert(t1 == 7 and t2 == nil)
+	local w <const>, {w1} = 8, {9}
+	assert(w == 8 and w1 == 9)
+	`)
+	errorIfScriptNotFail(t, L, `local w <const>, {w1} = 8, {9}; w = 1`, "attempt to assign to const variable 'w'")
+	errorIfScriptNotFail(t, L, `local {x} = nil`, "attempt to index a non-table object\\(nil\\)")
+}
+
+func TestMatch(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local function describe(v)
+		match v with
+		case nil then return "nil"
+		case 0 then return "zero"
+		case 1 .. 9 then return "digit"
+		case -5 .. -1 then return "negative"
+		case "hi" then return "greeting"
+		case {type = "user", id = id} if id > 100 then return "admin " .. id
+		case {type = "user", id = id} then return "user " .. id
+		case {x, {y}} then return "nested " .. x .. y
+		case s: string then return "string " .. s
+		case _: boolean? then return "boolean"
+		case t then return "other " .. type(t)
+		end
+	end
+	assert(describe(nil) == "nil")
+	assert(describe(0) == "zero")
+	assert(describe(5) == "digit" and describe(9.5) == "other number")
+	assert(describe(-3) == "negative")
+	assert(describe("hi") == "greeting" and describe("yo") == "string yo")
+	assert(describe({type = "user", id = 200}) == "admin 200")
+	assert(describe({type = "user", id = 2}) == "user 2")
+	assert(describe({type = "user"}) == "other table")
+	assert(describe({"a", {"b"}}) == "nested ab")
+	assert(describe(true) == "boolean")
+
+	local function day(n)
+		local name = "?"
+		match n with
+		case 1 then name = "mon"
+		case 2 then name = "tue"
+		case 2.0 then name = "unreachable"
+		case "sat" then name = "weekend"
+		case _ then name = "other"
+		end
+		return name
+	end
+	assert(day(1) == "mon" and day(2.0) == "tue" and day("sat") == "weekend" and day(7) == "other")
+
+	local seen = "none"
+	match 3 with
+	case 1 then seen = "one"
+	case 2 then seen = "two"
+	end
+	assert(seen == "none")
+
+	local fs = {}
+	for i = 1, 3 do
+		local t = {i}
+		match t with
+		case {k} then fs[#fs + 1] = function() return k end
+		end
+	end
+	assert(fs[1]() == 1 and fs[2]() == 2 and fs[3]() == 3)
+
+	local match = string.match
+	assert(match("abc", "b") == "b" and string.match("x1", "%d") == "1")
+	local function pick(case)
+		match case with
+		case 1 then return "one"
+		case _ then return "many"
+		end
+	end
+	assert(pick(1) == "one" and pick(3) == "many")
+
+	-- subjects that start like call arguments
+	local function kind(v)
+		match (v) with
+		case "s" then return "paren"
+		end
+		match {v, 1} with
+		case {2, 1} then return "table"
+		end
+		match (v) + 1 with
+		case 4 then return "sum"
+		end
+		match "s" with
+		case _ then return "string"
+		end
+	end
+	assert(kind("s") == "paren" and kind(2) == "table" and kind(3) == "sum" and kind(0) == "string")
+	match = function(...) return select("#", ...) end
+	assert(match(1, 2) == 2 and match{1} == 1 and match "s" == 1)
+	match(1)
+	match "s"
+	`)
+	errorIfScriptNotFail(t, L, `match 1 with case x: integer then end`, "unknown type 'integer'")
+}
+
+func TestDefaultParams(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local function connect(host = "localhost", port: number = 3306, opts = {retries = port // 1000})
+		return host .. ":" .. port .. ":" .. opts.retries
+	end
+	assert(connect() == "localhost:3306:3")
+	assert(connect("db", nil) == "db:3306:3")
+	assert(connect("db", 80, {retries = 0}) == "db:80:0")
+	assert(connect{port = 3307} == "localhost:3307:3")
+	assert(connect{"remote", opts = {retries = 1}} == "remote:3306:1")
+
+	local M = {}
+	function M:greet(greeting = "hello", name = "world")
+		return greeting .. " " .. name .. (self == M and "!" or "?")
+	end
+	assert(M:greet() == "hello world!")
+	assert(M:greet{name = "milk"} == "hello milk!")
+
+	local n = 0
+	local function count(x = (function() n = n + 1; return n end)()) return x end
+	assert(count() == 1 and count(false) == false and count() == 2)
+
+	-- functions without defaults still receive the table
+	local function first(t) return t[1] end
+	assert(first{"a"} == "a")
+
+	-- and so do functions with defaults when a key names no parameter
+	local function options(opts = {}, n = 0) return opts, n end
+	local opts, n = options{verbose = true}
+	assert(opts.verbose == true and n == 0)
+	opts, n = options{"a", n = 1}
+	assert(opts == "a" and n == 1)
+	opts = options{"a", "b"}
+	assert(type(opts) == "table" and opts[2] == "b")
+
+	local params = debug.getinfo(connect).params
+	assert(#params == 3)
+	assert(params[1].name == "host" and params[1].hasdefault and params[1].default == "localhost")
+	assert(params[2].name == "port" and params[2].default == 3306)
+	assert(params[3].name == "opts" and params[3].hasdefault and params[3].default == nil)
+	params = debug.getinfo(M.greet).params
+	assert(params[1].name == "self" and not params[1].hasdefault)
+	params = debug.getinfo(function(a = -1, b = 2 * 3) end).params
+	assert(params[1].default == -1 and params[2].default == 6)
+	`)
+	errorIfScriptNotFail(t, L, `local function f(a, b = 1) end; f{b = 5, "a", "c"}`, "named argument 'b' is also given as positional argument #2")
+	errorIfScriptNotFail(t, L, `local function f(a, b = 1) end; f{b = 5, "a", nil, "c"}`, "too many positional arguments \\(expected at most 2\\)")
+	errorIfScriptNotFail(t, L, `local function f(a: number = "x") end; f()`, "bad argument #1 'a' to 'f' \\(number expected, got string\\)")
+}
+
+func TestMatchJumpTable(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader(`
+	match x with
+	case "a" then y = 1
+	case "b" then y = 2
+	case _ then y = 3
+	end
+	match x with
+	case "a" then y = 1
+	case v if v then y = 2
+	end`), "<string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto, err := Compile(chunk, "<string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proto.JumpTables) != 1 || len(proto.JumpTables[0]) != 2 {
+		t.Errorf("expected a single jump table with 2 entries, got %v", proto.JumpTables)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	src := `
+	local N <const> = 4
+	local S <const> = "n=" .. N
+	local x = N * 2 + 1
+	assert(x == 9 and math.type(N / 2) == "float")
+	assert(S == "n=4" and #S == 3 and ` + "`${S}!`" + ` == "n=4!")
+	assert(1 < 2 and "a" < "b" and not (1 == "1") and 0/0 ~= 0/0)
+	assert((false or nil) == nil and (nil ?? 3) == 3 and (1 and 2) == 2)
+	local function two() return 1, 2 end
+	assert(select("#", false or two()) == 1)
+	if N > 10 then error("dead branch") elseif N > 2 then x = 1 else error("dead branch") end
+	assert(x == 1)
+	while false do error("dead loop") end
+	local i = 0
+	repeat
+		local done <const> = true
+		i = i + 1
+	until done
+	assert(i == 1)
+	local ok = pcall(function() return 1 < "2" end)
+	assert(not ok)
+	`
+	for _, level := range []int{0, 1} {
+		OptimizationLevel = level
+		L := NewState()
+		errorIfScriptFail(t, L, src)
+		L.Close()
+	}
+	OptimizationLevel = 1
+
+	chunk, err := parse.Parse(strings.NewReader(`
+	local N <const> = 2
+	if N > 1 then y = "a" .. N else y = 0 end
+	while false do y = 1 end`), "<string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto, err := Compile(Optimize(chunk, 1), "<string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, inst := range proto.Code {
+		if op := opGetOpCode(inst); op == OP_LT || op == OP_JMP || op == OP_CONCAT {
+			t.Errorf("unexpected %v in optimized code", opProps[op].Name)
+		}
+	}
+	if len(proto.Constants) == 0 || proto.Constants[len(proto.Constants)-1] != LString("a2") {
+		t.Errorf("expected \"a2\" to be folded, got %v", proto.Constants)
+	}
+
+	chunk, err = parse.Parse(strings.NewReader(`
+	while a do
+		if b then
+			if c then x = 1 else x = 2 end
+		else
+			x = 3
+		end
+	end`), "<string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto, err = Compile(Optimize(chunk, 1), "<string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pc, inst := range proto.Code {
+		if opGetOpCode(inst) == OP_JMP {
+			if target := pc + 1 + opGetArgSbx(inst); opGetOpCode(proto.Code[target]) == OP_JMP {
+				t.Errorf("jump at %v to the jump at %v was not threaded", pc, target)
+			}
+		}
+	}
+}
+
+func TestStringDump(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local function f(a, b = 2, ...)
+		local t = {1, 2.5, true, "s", -0.0, 2^53}
+		match a with
+		case "x" then return "X"
+		case "y" then return "Y"
+		case _ then return a + b + #t
+		end
+	end
+	local g = loadstring(string.dump(f))
+	assert(g("x") == "X" and g("y") == "Y")
+	assert(g(1) == 9 and math.type(g(1)) == "integer")
+	assert(g{a = 1, b = 10} == 17)
+	assert(debug.getinfo(g).params[2].default == 2)
+	local up = 5
+	local h = loadstring(string.dump(function() return up end))
+	assert(h() == nil)
+	assert(not pcall(string.dump, print))
+	`)
+
+	proto, err := Compile(nil, "<string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := string(proto.RawBytecode())
+	for _, tc := range []struct {
+		chunk string
+		msg   string
+	}{
+		{chunk[:len(chunk)-1], "unexpected end"},
+		{chunk[:5], "unexpected end"},
+		{chunk + "x", "trailing garbage"},
+		{chunk[:4] + "\x52" + chunk[5:], "version mismatch"},
+		{chunk[:5] + "\x00" + chunk[6:], "format mismatch (not compiled by Milk)"},
+		{chunk[:5] + "\x4d" + chunk[6:], "format version mismatch (2 expected, got 1)"},
+		{chunk[:8] + "\x08" + chunk[9:], "bad header"},
+	} {
+		_, err := L.Load(strings.NewReader(tc.chunk), "chunk")
+		if err == nil || !strings.Contains(err.Error(), "chunk: "+tc.msg) {
+			t.Errorf("expected %q, got %v", tc.msg, err)
+		}
+	}
+	// an opcode out of range
+	code := append([]uint32{}, proto.Code...)
+	proto.Code = append([]uint32{opCreateABC(opCodeMax+1, 0, 0, 0)}, code...)
+	proto.DbgSourcePositions = append([]int{0}, proto.DbgSourcePositions...)
+	proto.DbgSourceColumns = append([]int{0}, proto.DbgSourceColumns...)
+	if _, err := L.Load(bytes.NewReader(proto.RawBytecode()), "chunk"); err == nil || !strings.Contains(err.Error(), "bad code") {
+		t.Errorf("expected bad code, got %v", err)
+	}
+	// registers out of range
+	for _, tc := range []struct {
+		inst uint32
+		msg  string
+	}{
+		{opCreateABC(OP_MOVE, 0, 200, 0), "bad register 200 in MOVE at instruction 1 (2 registers)"},
+		{opCreateABC(OP_LOADNIL, 0, 3, 0), "bad register 3 in LOADNIL at instruction 1"},
+		{opCreateABC(OP_CALL, 0, 100, 1), "bad register 99 in CALL at instruction 1"},
+		{opCreateABC(OP_CALL, 0, 1, 60), "bad register 58 in CALL at instruction 1"},
+		{opCreateABC(OP_RETURN, 0, 60, 0), "bad register 58 in RETURN at instruction 1"},
+		{opCreateABC(OP_GETUPVAL, 0, 3, 0), "bad upvalue 3 in GETUPVAL at instruction 1 (0 upvalues)"},
+	} {
+		proto.Code = append([]uint32{tc.inst}, code...)
+		if _, err := L.Load(bytes.NewReader(proto.RawBytecode()), "chunk"); err == nil || !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("expected %q, got %v", tc.msg, err)
+		}
+	}
+}
+
+func TestStripDebugInfo(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader(`
+	local function f(x)
+		local y = x
+		assert(debug.getinfo(1, "l").currentline == -1)
+		error("stripped")
+	end
+	f(1)`), "<string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto, err := Compile(chunk, "<string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto.StripDebugInfo()
+	L := NewState()
+	defer L.Close()
+	fn, err := L.Load(bytes.NewReader(proto.RawBytecode()), "<string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	L.Push(fn)
+	if err := L.PCall(0, 0, nil); err == nil || !strings.Contains(err.Error(), "<string>:?: stripped") {
+		t.Errorf("expected an error without line, got %v", err)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader(`local s = "a\n"
+local function f(x)
+	return s .. x, 1.0
+end
+for i = 1, 2 do f(i) end`), "test.milk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto, err := Compile(chunk, "test.milk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `main <test.milk:0,6> (12 instructions, 48 bytes)
+0+ params, 8 slots, 0 upvalues, 6 locals, 3 constants, 1 function
+	1	[1]	LOADK    	0 -1	; "a\n"
+	2	[2]	CLOSURE  	1 0	; function <test.milk:2,4>
+	3	[2]	MOVE     	0 0
+	4	[5]	LOADK    	2 -2	; 1
+	5	[5]	LOADK    	3 -3	; 2
+	6	[5]	LOADK    	4 -2	; 1
+	7	[5]	FORPREP  	2 3	; to 11
+	8	[5]	MOVEN    	6 1 1
+	9	[5]	MOVE     	7 5
+	10	[5]	CALL     	6 2 1
+	11	[5]	FORLOOP  	2 -4	; to 8
+	12	[6]	RETURN   	0 1
+constants (3):
+	1	"a\n"
+	2	1
+	3	2
+locals (6):
+	0	s	2	12
+	1	f	2	12
+	2	(for index)	4	10
+	3	(for limit)	5	10
+	4	(for step)	6	10
+	5	i	8	10
+upvalues (0):
+
+function <test.milk:2,4> (6 instructions, 24 bytes)
+1 param, 3 slots, 1 upvalue, 1 local, 1 constant, 0 functions
+	1	[3]	GETUPVAL 	1 0	; s
+	2	[3]	MOVE     	2 0
+	3	[3]	CONCAT   	1 1 2
+	4	[3]	LOADK    	2 -1	; 1.0
+	5	[3]	RETURN   	1 3
+	6	[4]	RETURN   	0 1
+constants (1):
+	1	1.0
+locals (1):
+	0	x	1	6
+upvalues (1):
+	0	s
+`
+	if listing := proto.Disassemble(); listing != expected {
+		t.Errorf("unexpected listing:\n%v", listing)
+	}
+}
+
+func TestDebugSetHook(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local events = {}
+	local function f(x)
+		local y = x + 1
+		return y
+	end
+	local function hook(event, line)
+		assert(line == nil or math.type(line) == "integer")
+		local info = debug.getinfo(2, "nS")
+		events[#events + 1] = event .. ":" .. tostring(line or info.name or info.what)
+	end
+	debug.sethook(hook, "crl")
+	local a = f(1)
+	for i = 1, 2 do
+		a = a + i
+	end
+	debug.sethook()
+	assert(table.concat(events, " ") == "return:sethook line:13 call:f line:4 line:5 return:f " ..
+		"line:14 line:15 line:14 line:15 line:14 line:17 call:sethook", table.concat(events, " "))
+
+	-- count hooks, gethook and the thread argument
+	local n = 0
+	local function count() n = n + 1 end
+	debug.sethook(count, "", 10)
+	local h, mask, c = debug.gethook()
+	assert(h == count and mask == "" and c == 10)
+	for i = 1, 100 do end
+	debug.sethook()
+	assert(n >= 10 and n <= 11, n)
+	assert(math.type(c) == "integer")
+	assert(debug.gethook() == nil)
+	local co = coroutine.create(function() return 1 end)
+	debug.sethook(co, count, "l")
+	assert(debug.gethook(co) == count and debug.gethook() == nil)
+	n = 0
+	coroutine.resume(co)
+	assert(n == 1, n)
+	`)
+
+	// errors in a hook are raised where the event happened
+	errorIfScriptNotFail(t, L, `
+	debug.sethook(function() error("in hook") end, "l")
+	local x = 1`, `<string>:2: in hook`)
+	L.SetHook(nil)
+	errorIfScriptFail(t, L, `local x = 1`)
+}
+
+func TestParseRecover(t *testing.T) {
+	src := `local t = {1, 2
+print(t)
+if x print(1) end
+local y = = 2
+function f(a)
+    return a +
+end
+print(y)
+while true do
+    g(
+`
+	chunk, tokens, err := parse.ParseRecover(strings.NewReader(src), "test.milk")
+	expected := []string{
+		`test.milk line:2(column:1) near 'print':   syntax error, '}' expected to close '{' at line 1`,
+		`test.milk line:3(column:6) near 'print':   syntax error, 'then' expected`,
+		`test.milk line:4(column:11) near '=':   syntax error, expression expected`,
+		`test.milk line:7(column:1) near 'end':   syntax error, expression expected`,
+		`test.milk at EOF:   syntax error, expression expected`,
+		`test.milk at EOF:   syntax error, 'end' expected to close 'while' at line 9`,
+	}
+	errors, ok := err.(parse.ErrorList)
+	if !ok {
+		t.Fatalf("expected an error list, got %v", err)
+	}
+	messages := []string{}
+	for _, e := range errors {
+		messages = append(messages, strings.TrimSuffix(e.Error(), "\n"))
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected errors:\n%v", strings.Join(messages, "\n"))
+	}
+	if len(tokens) < 2 || tokens[len(tokens)-2].Str != "(" {
+		t.Errorf("expected the tokens up to EOF, got %v", tokens)
+	}
+
+	// the statements in error are dropped, the missing closing tokens taken
+	// as read
+	lines := []int{}
+	for _, stmt := range chunk {
+		lines = append(lines, stmt.Line())
+	}
+	if fmt.Sprint(lines) != "[1 2 3 5 8 9]" {
+		t.Errorf("unexpected statements on lines %v", lines)
+	}
+
+	// Parse still stops at the first error
+	_, err = parse.Parse(strings.NewReader(src), "test.milk")
+	if _, ok := err.(*parse.Error); !ok || !strings.Contains(err.Error(), "line:2(column:1)") {
+		t.Errorf("expected the first error, got %v", err)
+	}
+	if _, _, err := parse.ParseRecover(strings.NewReader("print(1)\n"), "test.milk"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct{ src, message string }{
+		{"if x\nprint(x)\nend", `line:2(column:1) near 'print':   syntax error, 'then' expected to close 'if' at line 1`},
+		{"while x print(x) end", `line:1(column:9) near 'print':   syntax error, 'do' expected`},
+		{"x = {1, 2\n", `at EOF:   syntax error, '}' expected to close '{' at line 1`},
+		{"x.y", `at EOF:   syntax error, '=' expected`},
+		{"1 + x", `line:1(column:1) near '1':   syntax error, statement expected`},
+		{"(f)", `line:1(column:1) near '(':   syntax error, function call expected`},
+		{"f() = 1", `line:1(column:1) near 'f':   syntax error, cannot assign to this expression`},
+		{"x:y", `at EOF:   syntax error, function arguments expected`},
+		{"return 1 print(x)", `line:1(column:10) near 'print':   syntax error, '<eof>' expected`},
+		{"end", `line:1(column:1) near 'end':   syntax error, no block to close`},
+		{"match x case 1 then end", `line:1(column:9) near 'case':   syntax error, 'with' expected`},
+	}
+	for _, c := range cases {
+		_, err := parse.Parse(strings.NewReader(c.src), "test.milk")
+		if err == nil || strings.TrimSuffix(err.Error(), "\n") != "test.milk "+c.message {
+			t.Errorf("%q: unexpected error %v", c.src, err)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	files, err := filepath.Glob("_lua5.1-tests/*.lua")
+	if err != nil || len(files) == 0 {
+		b.Fatal("no test scripts", err)
+	}
+	sources := [][]byte{}
+	size := 0
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if bytes.HasPrefix(src, []byte("#")) {
+			// skip the '#!' line like DoFile does, keeping the line numbers
+			src = src[bytes.IndexByte(src, '\n'):]
+		}
+		sources = append(sources, src)
+		size += len(src)
+	}
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, src := range sources {
+			if _, err := parse.Parse(bytes.NewReader(src), files[j]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -5,6 +5,8 @@ package lua
 ////////////////////////////////////////////////////////
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -121,6 +123,7 @@ type Debug struct {
 	What            string
 	Source          string
 	CurrentLine     int
+	CurrentColumn   int
 	NUpvalues       int
 	LineDefined     int
 	LastLineDefined int
@@ -652,7 +655,7 @@ func newGlobal() *Global {
 
 func panicWithTraceback(L *LState) {
 	err := newApiError(ApiErrorRun, L.Get(-1))
-	err.StackTrace = L.stackTrace(0)
+	err.StackTrace = L.stackTrace(0, true)
 	panic(err)
 }
 
@@ -787,19 +790,30 @@ func (ls *LState) where(level int, skipg bool) string {
 	}
 	line := ""
 	if proto != nil {
-		line = fmt.Sprintf("%v:", proto.DbgSourcePositions[cf.Pc-1])
+		line = "?:"
+		if l := proto.lineAt(cf.Pc - 1); l >= 0 {
+			line = fmt.Sprintf("%v:", l)
+		}
 	}
 	return fmt.Sprintf("%v:%v", sourcename, line)
 }
 
-func (ls *LState) stackTrace(level int) string {
+// stackTrace returns the traceback of the calls from level. With snippets,
+// the frames of Lua functions quote their current source line.
+func (ls *LState) stackTrace(level int, snippets bool) string {
 	buf := []string{}
 	header := "stack traceback:"
 	if ls.currentFrame != nil {
 		i := 0
 		for dbg, ok := ls.GetStack(i); ok; dbg, ok = ls.GetStack(i) {
 			cf := dbg.frame
-			buf = append(buf, fmt.Sprintf("\t%v in %v", ls.Where(i), ls.formattedFrameFuncName(cf)))
+			entry := fmt.Sprintf("\t%v in %v", ls.Where(i), ls.formattedFrameFuncName(cf))
+			if snippets && !cf.Fn.IsG {
+				for _, line := range sourceSnippet(cf.Fn.Proto, cf.Pc-1) {
+					entry += "\n" + line
+				}
+			}
+			buf = append(buf, entry)
 			if !cf.Fn.IsG && cf.TailCall > 0 {
 				for tc := cf.TailCall; tc > 0; tc-- {
 					buf = append(buf, "\t(tailcall): ?")
@@ -821,6 +835,37 @@ func (ls *LState) stackTrace(level int) string {
 	return fmt.Sprintf("%s\n%s", header, strings.Join(buf, "\n"))
 }
 
+// sourceSnippet quotes the source line of the instruction at pc with a caret
+// under its column, for tracebacks. It returns nothing when the source is
+// not known.
+func sourceSnippet(proto *FunctionProto, pc int) []string {
+	text, ok := proto.sourceLine(proto.lineAt(pc))
+	if !ok {
+		return nil
+	}
+	trimmed := strings.TrimLeft(text, " \t")
+	if len(trimmed) == 0 {
+		return nil
+	}
+	lines := []string{"\t\t" + trimmed}
+	column := proto.columnAt(pc) - (len(text) - len(trimmed))
+	if column < 1 || column > len(trimmed) {
+		return lines
+	}
+	caret := make([]byte, 0, column)
+	for i := 0; i < column-1; i++ {
+		switch c := trimmed[i]; {
+		case c == '\t':
+			caret = append(caret, '\t')
+		case c&0xc0 == 0x80:
+			// a continuation byte of a multi-byte character
+		default:
+			caret = append(caret, ' ')
+		}
+	}
+	return append(lines, "\t\t"+string(caret)+"^")
+}
+
 func (ls *LState) formattedFrameFuncName(fr *callFrame) string {
 	name, ischunk := ls.frameFuncName(fr)
 	if ischunk {
@@ -945,6 +990,54 @@ func (ls *LState) closeUpvalues(idx int) { // +inline-start
 	}
 } // +inline-end
 
+type tbcVar struct {
+	index int
+	value LValue
+}
+
+// newTbc marks the value at idx as a to-be-closed variable. nil and false
+// need no closing.
+func (ls *LState) newTbc(idx int, name string) {
+	value := ls.reg.Get(idx)
+	if value == LNil || value == LFalse {
+		return
+	}
+	if ls.metaOp1(value, "__close") == LNil {
+		ls.RaiseError("variable '%v' got a non-closable value", name)
+	}
+	ls.tbcs = append(ls.tbcs, tbcVar{idx, value})
+}
+
+// closeTbcs calls the __close metamethods of the to-be-closed variables at
+// or above idx, in the reverse order of their declaration.
+func (ls *LState) closeTbcs(idx int, err LValue) {
+	for n := len(ls.tbcs); n > 0 && ls.tbcs[n-1].index >= idx; n = len(ls.tbcs) {
+		tbc := ls.tbcs[n-1]
+		ls.tbcs = ls.tbcs[:n-1]
+		ls.reg.Push(ls.metaOp1(tbc.value, "__close"))
+		ls.reg.Push(tbc.value)
+		ls.reg.Push(err)
+		ls.Call(2, 0)
+	}
+}
+
+// closeTbcsOnError closes the to-be-closed variables at or above idx while
+// an error unwinds the stack. An error raised by a __close metamethod
+// replaces the current one.
+func (ls *LState) closeTbcsOnError(idx int, err *ApiError) *ApiError {
+	for n := len(ls.tbcs); n > 0 && ls.tbcs[n-1].index >= idx; n = len(ls.tbcs) {
+		tbc := ls.tbcs[n-1]
+		ls.tbcs = ls.tbcs[:n-1]
+		ls.reg.Push(ls.metaOp1(tbc.value, "__close"))
+		ls.reg.Push(tbc.value)
+		ls.reg.Push(err.Object)
+		if cerr := ls.PCall(2, 0, nil); cerr != nil {
+			err = cerr.(*ApiError)
+		}
+	}
+	return err
+}
+
 func (ls *LState) findUpvalue(idx int) *Upvalue {
 	var prev *Upvalue
 	var next *Upvalue
@@ -1115,7 +1208,7 @@ func (ls *LState) initCallFrame(cf *callFrame) { // +inline-start
 					for i := 0; i < nvarargs; i++ {
 						argtb.RawSetInt(i+1, ls.reg.Get(cf.LocalBase+np+i))
 					}
-					argtb.RawSetString("n", LNumber(nvarargs))
+					argtb.RawSetString("n", LInteger(nvarargs))
 					//ls.reg.Set(cf.LocalBase+nargs+np, argtb)
 					ls.reg.array[cf.LocalBase+nargs+np] = argtb
 				} else {
@@ -1227,7 +1320,7 @@ func (ls *LState) pushCallFrame(cf callFrame, fn LValue, meta bool) { // +inline
 						for i := 0; i < nvarargs; i++ {
 							argtb.RawSetInt(i+1, ls.reg.Get(cf.LocalBase+np+i))
 						}
-						argtb.RawSetString("n", LNumber(nvarargs))
+						argtb.RawSetString("n", LInteger(nvarargs))
 						//ls.reg.Set(cf.LocalBase+nargs+np, argtb)
 						ls.reg.array[cf.LocalBase+nargs+np] = argtb
 					} else {
@@ -1614,6 +1707,10 @@ func (ls *LState) NewThread() (*LState, context.CancelFunc) {
 	thread := newLState(ls.Options)
 	thread.G = ls.G
 	thread.Env = ls.Env
+	if ls.hook != nil {
+		// like in Lua, a new thread inherits the hook
+		thread.hook = &hookState{fn: ls.hook.fn, value: ls.hook.value, mask: ls.hook.mask, count: ls.hook.count}
+	}
 	var f context.CancelFunc = nil
 	if ls.ctx != nil {
 		thread.mainLoop = mainLoopWithContext
@@ -1660,6 +1757,9 @@ func (ls *LState) ToInt(n int) int {
 	if lv, ok := ls.Get(n).(LNumber); ok {
 		return int(lv)
 	}
+	if lv, ok := ls.Get(n).(LInteger); ok {
+		return int(lv)
+	}
 	if lv, ok := ls.Get(n).(LString); ok {
 		if num, err := parseNumber(string(lv)); err == nil {
 			if num > math.MaxInt || num < math.MinInt {
@@ -1675,9 +1775,15 @@ func (ls *LState) ToInt64(n int) int64 {
 	if lv, ok := ls.Get(n).(LNumber); ok {
 		return int64(lv)
 	}
+	if lv, ok := ls.Get(n).(LInteger); ok {
+		return int64(lv)
+	}
 	if lv, ok := ls.Get(n).(LString); ok {
-		if num, err := parseNumber(string(lv)); err == nil {
-			return int64(num)
+		if num, err := parseNumberValue(string(lv)); err == nil {
+			if iv, ok := num.(LInteger); ok {
+				return int64(iv)
+			}
+			return int64(num.(LNumber))
 		}
 	}
 	return 0
@@ -1687,6 +1793,10 @@ func (ls *LState) ToNumber(n int) LNumber {
 	return LVAsNumber(ls.Get(n))
 }
 
+func (ls *LState) ToInteger(n int) LInteger {
+	return LVAsInteger(ls.Get(n))
+}
+
 func (ls *LState) ToString(n int) string {
 	return LVAsString(ls.Get(n))
 }
@@ -1779,7 +1889,8 @@ func (ls *LState) GetInfo(what string, dbg *Debug, fn LValue) (LValue, error) {
 		case 'l':
 			if !f.IsG && dbg.frame != nil {
 				if dbg.frame.Pc > 0 {
-					dbg.CurrentLine = f.Proto.DbgSourcePositions[dbg.frame.Pc-1]
+					dbg.CurrentLine = f.Proto.lineAt(dbg.frame.Pc - 1)
+					dbg.CurrentColumn = f.Proto.columnAt(dbg.frame.Pc - 1)
 				}
 			} else {
 				dbg.CurrentLine = -1
@@ -1966,7 +2077,7 @@ func (ls *LState) ObjLen(v1 LValue) int {
 		ls.Call(1, 1)
 		ret := ls.reg.Pop()
 		if ret.Type() == LTNumber {
-			return int(ret.(LNumber))
+			return int(LVAsNumber(ret))
 		}
 	} else if v1.Type() == LTTable {
 		return v1.(*LTable).Len()
@@ -2013,14 +2124,33 @@ func (ls *LState) Register(name string, fn LGFunction) {
 /* load and function call operations {{{ */
 
 func (ls *LState) Load(reader io.Reader, name string) (*LFunction, error) {
-	chunk, err := parse.Parse(reader, name)
+	buffered := bufio.NewReader(reader)
+	if sig, _ := buffered.Peek(len(LuaSignature)); string(sig) == LuaSignature {
+		proto, err := Undump(buffered, name)
+		if err != nil {
+			return nil, newApiErrorE(ApiErrorSyntax, err)
+		}
+		// a dumped closure gets fresh upvalues
+		fn := newLFunctionL(proto, ls.currentEnv(), int(proto.NumUpvalues))
+		for i := range fn.Upvalues {
+			fn.Upvalues[i] = &Upvalue{value: LNil, closed: true}
+		}
+		return fn, nil
+	}
+	source, err := io.ReadAll(buffered)
+	if err != nil {
+		return nil, newApiErrorE(ApiErrorFile, err)
+	}
+	chunk, err := parse.Parse(bytes.NewReader(source), name)
 	if err != nil {
 		return nil, newApiErrorE(ApiErrorSyntax, err)
 	}
+	chunk = Optimize(chunk, OptimizationLevel)
 	proto, err := Compile(chunk, name)
 	if err != nil {
 		return nil, newApiErrorE(ApiErrorSyntax, err)
 	}
+	proto.setSource(source)
 	return newLFunctionL(proto, ls.currentEnv(), 0), nil
 }
 
@@ -2047,7 +2177,7 @@ func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 				if ls.Options.IncludeGoStackTrace {
 					buf := make([]byte, 4096)
 					runtime.Stack(buf, false)
-					err.(*ApiError).StackTrace = strings.Trim(string(buf), "\000") + "\n" + ls.stackTrace(0)
+					err.(*ApiError).StackTrace = strings.Trim(string(buf), "\000") + "\n" + ls.stackTrace(0, true)
 				}
 			} else {
 				err = rcv.(*ApiError)
@@ -2065,11 +2195,11 @@ func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 							if ls.Options.IncludeGoStackTrace {
 								buf := make([]byte, 4096)
 								runtime.Stack(buf, false)
-								err.(*ApiError).StackTrace = strings.Trim(string(buf), "\000") + ls.stackTrace(0)
+								err.(*ApiError).StackTrace = strings.Trim(string(buf), "\000") + ls.stackTrace(0, true)
 							}
 						} else {
 							err = rcv.(*ApiError)
-							err.(*ApiError).StackTrace = ls.stackTrace(0)
+							err.(*ApiError).StackTrace = ls.stackTrace(0, true)
 						}
 						ls.stack.SetSp(sp)
 						ls.currentFrame = ls.stack.Last()
@@ -2079,11 +2209,12 @@ func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 				ls.Call(1, 1)
 				err = newApiError(ApiErrorError, ls.Get(-1))
 			} else if len(err.(*ApiError).StackTrace) == 0 {
-				err.(*ApiError).StackTrace = ls.stackTrace(0)
+				err.(*ApiError).StackTrace = ls.stackTrace(0, true)
 			}
 			ls.stack.SetSp(sp)
 			ls.currentFrame = ls.stack.Last()
 			ls.reg.SetTop(base)
+			err = ls.closeTbcsOnError(base, err.(*ApiError))
 		}
 		ls.stack.SetSp(sp)
 		if sp == 0 {
@@ -2,6 +2,7 @@ package lua
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -324,6 +325,86 @@ func TestPCall(t *testing.T) {
 	errorIfFalse(t, L.stack.Sp() == currentSp, "")
 }
 
+func TestStackTraceSnippets(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	err := L.DoString(`local a = {b = {c = {}}}
+local function f()
+	local x = 1 + #"é" + a.b.c.d()
+end
+f()`)
+	if err == nil {
+		t.Fatal("script should fail")
+	}
+	// the caret points at the field that is not a function, a multi-byte
+	// character takes one column
+	expected := "\t<string>:3: in function 'f'\n\t\tlocal x = 1 + #\"é\" + a.b.c.d()\n\t\t                           ^\n" +
+		"\t<string>:5: in main chunk\n\t\tf()\n\t\t^\n"
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("unexpected traceback %v", err.Error())
+	}
+
+	// a caret at the index of a nil value and none without the source
+	err = L.DoString("local t = {}\nreturn t.x.y")
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "\t\treturn t.x.y\n\t\t           ^\n"), "unexpected traceback %v", err)
+	fn, err := L.LoadString("return ({}).x.y")
+	errorIfNotNil(t, err)
+	columns := fmt.Sprint(fn.Proto.DbgSourceColumns)
+	fn, err = L.Load(strings.NewReader(string(fn.Proto.RawBytecode())), "<string>")
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, columns, fmt.Sprint(fn.Proto.DbgSourceColumns))
+	L.Push(fn)
+	err = L.PCall(0, 0, nil)
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "<string>:1: in main chunk\n\t[G]: ?"), "unexpected traceback %v", err)
+
+	// debug.traceback keeps the Lua format
+	errorIfScriptFail(t, L, `assert(not debug.traceback():find("\t\t"))`)
+}
+
+func TestSetHook(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	events := []string{}
+	L.SetHook(func(L *LState, event HookEvent, dbg *Debug) {
+		if _, err := L.GetInfo("n", dbg, LNil); err != nil {
+			t.Fatal(err)
+		}
+		switch event {
+		case HookLine:
+			events = append(events, fmt.Sprintf("line:%v", dbg.CurrentLine))
+		case HookTailCall:
+			// the name of a tail called function is unknown
+			events = append(events, event.String())
+		default:
+			events = append(events, fmt.Sprintf("%v:%v", event, dbg.Name))
+		}
+		// calls from the hook raise no events
+		L.DoString("local x = 1")
+	})
+	errorIfScriptFail(t, L, "local function f() return tostring(1) end\nreturn f()")
+	L.SetHook(nil)
+	expected := "call:main chunk line:1 line:2 tail call line:1 tail call return:tostring"
+	errorIfNotEqual(t, expected, strings.Join(events, " "))
+
+	// a count hook only
+	count := 0
+	L.SetHook(func(L *LState, event HookEvent, dbg *Debug) {
+		if event != HookCount {
+			t.Errorf("unexpected %v event", event)
+		}
+		count++
+	})
+	L.SetHookMask(0, 10)
+	errorIfScriptFail(t, L, "for i = 1, 100 do end")
+	L.SetHookMask(0, 0)
+	errorIfFalse(t, count >= 10 && count <= 11, "unexpected count of events %v", count)
+
+	// a mask without a hook does nothing
+	L.SetHookMask(HookMaskLine, 0)
+	errorIfScriptFail(t, L, "local x = 1")
+	errorIfFalse(t, L.hook == nil, "a hook was set by SetHookMask")
+}
+
 func TestCoroutineApi1(t *testing.T) {
 	L := NewState()
 	defer L.Close()
@@ -343,21 +424,21 @@ func TestCoroutineApi1(t *testing.T) {
 	errorIfNotEqual(t, ResumeYield, st)
 	errorIfNotNil(t, err)
 	errorIfNotEqual(t, 3, len(values))
-	errorIfNotEqual(t, LNumber(1), values[0].(LNumber))
-	errorIfNotEqual(t, LNumber(2), values[1].(LNumber))
-	errorIfNotEqual(t, LNumber(3), values[2].(LNumber))
+	errorIfNotEqual(t, LInteger(1), values[0].(LInteger))
+	errorIfNotEqual(t, LInteger(2), values[1].(LInteger))
+	errorIfNotEqual(t, LInteger(3), values[2].(LInteger))
 
 	st, err, values = L.Resume(co, fn, LNumber(11), LNumber(12))
 	errorIfNotEqual(t, ResumeYield, st)
 	errorIfNotNil(t, err)
 	errorIfNotEqual(t, 1, len(values))
-	errorIfNotEqual(t, LNumber(4), values[0].(LNumber))
+	errorIfNotEqual(t, LInteger(4), values[0].(LInteger))
 
 	st, err, values = L.Resume(co, fn)
 	errorIfNotEqual(t, ResumeOK, st)
 	errorIfNotNil(t, err)
 	errorIfNotEqual(t, 1, len(values))
-	errorIfNotEqual(t, LNumber(5), values[0].(LNumber))
+	errorIfNotEqual(t, LInteger(5), values[0].(LInteger))
 
 	L.Register("myyield", func(L *LState) int {
 		return L.Yield(L.ToNumber(1))
@@ -375,9 +456,9 @@ func TestCoroutineApi1(t *testing.T) {
 	errorIfNotEqual(t, ResumeYield, st)
 	errorIfNotNil(t, err)
 	errorIfNotEqual(t, 3, len(values))
-	errorIfNotEqual(t, LNumber(1), values[0].(LNumber))
-	errorIfNotEqual(t, LNumber(2), values[1].(LNumber))
-	errorIfNotEqual(t, LNumber(3), values[2].(LNumber))
+	errorIfNotEqual(t, LInteger(1), values[0].(LInteger))
+	errorIfNotEqual(t, LInteger(2), values[1].(LInteger))
+	errorIfNotEqual(t, LInteger(3), values[2].(LInteger))
 
 	st, err, values = L.Resume(co, fn)
 	errorIfNotEqual(t, ResumeYield, st)
@@ -463,7 +544,7 @@ func TestContextWithCroutine(t *testing.T) {
 	fn := L.GetGlobal("coro").(*LFunction)
 	_, err, values := L.Resume(co, fn)
 	errorIfNotNil(t, err)
-	errorIfNotEqual(t, LNumber(0), values[0])
+	errorIfNotEqual(t, LInteger(0), values[0])
 	// cancel the parent context
 	cancel()
 	_, err, values = L.Resume(co, fn)
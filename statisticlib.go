@@ -115,8 +115,8 @@ func getValues(L *LState) []float64 {
 	case LTTable:
 		tbl := L.CheckTable(1)
 		tbl.ForEach(func(key, value LValue) {
-			if num, ok := value.(LNumber); ok {
-				values = append(values, float64(num))
+			if value.Type() == LTNumber {
+				values = append(values, float64(LVAsNumber(value)))
 			} else {
 				L.RaiseError("table contains non-number value")
 			}
@@ -93,7 +93,7 @@ func (tb *LTable) Insert(i int, value LValue) {
 		return
 	}
 	if i <= 0 {
-		tb.RawSet(LNumber(i), value)
+		tb.RawSet(LInteger(i), value)
 		return
 	}
 	i -= 1
@@ -145,9 +145,10 @@ func (tb *LTable) Remove(pos int) LValue {
 // It is recommended to use `RawSetString` or `RawSetInt` for performance
 // if you already know the given LValue is a string or number.
 func (tb *LTable) RawSet(key LValue, value LValue) {
-	switch v := key.(type) {
-	case LNumber:
-		if isArrayKey(v) {
+	switch v := normalizeKey(key).(type) {
+	case LInteger:
+		key = v
+		if isArrayIndex(v) {
 			if tb.array == nil {
 				tb.array = make([]LValue, 0, defaultArrayCap)
 			}
@@ -177,7 +178,7 @@ func (tb *LTable) RawSet(key LValue, value LValue) {
 // RawSetInt sets a given LValue at a position `key` without the __newindex metamethod.
 func (tb *LTable) RawSetInt(key int, value LValue) {
 	if key < 1 || key >= MaxArrayIndex {
-		tb.RawSetH(LNumber(key), value)
+		tb.RawSetH(LInteger(key), value)
 		return
 	}
 	if tb.array == nil {
@@ -227,6 +228,7 @@ func (tb *LTable) RawSetH(key LValue, value LValue) {
 		tb.RawSetString(string(s), value)
 		return
 	}
+	key = normalizeKey(key)
 	if tb.dict == nil {
 		tb.dict = make(map[LValue]LValue, len(tb.strdict))
 	}
@@ -249,9 +251,10 @@ func (tb *LTable) RawSetH(key LValue, value LValue) {
 
 // RawGet returns an LValue associated with a given key without __index metamethod.
 func (tb *LTable) RawGet(key LValue) LValue {
-	switch v := key.(type) {
-	case LNumber:
-		if isArrayKey(v) {
+	switch v := normalizeKey(key).(type) {
+	case LInteger:
+		key = v
+		if isArrayIndex(v) {
 			if tb.array == nil {
 				return LNil
 			}
@@ -305,7 +308,7 @@ func (tb *LTable) RawGetH(key LValue) LValue {
 	if tb.dict == nil {
 		return LNil
 	}
-	if v, ok := tb.dict[key]; ok {
+	if v, ok := tb.dict[normalizeKey(key)]; ok {
 		return v
 	}
 	return LNil
@@ -327,7 +330,7 @@ func (tb *LTable) ForEach(cb func(LValue, LValue)) {
 	if tb.array != nil {
 		for i, v := range tb.array {
 			if v != LNil {
-				cb(LNumber(i+1), v)
+				cb(LInteger(i+1), v)
 			}
 		}
 	}
@@ -351,17 +354,18 @@ func (tb *LTable) ForEach(cb func(LValue, LValue)) {
 func (tb *LTable) Next(key LValue) (LValue, LValue) {
 	init := false
 	if key == LNil {
-		key = LNumber(0)
+		key = LInteger(0)
 		init = true
 	}
+	key = normalizeKey(key)
 
-	if init || key != LNumber(0) {
-		if kv, ok := key.(LNumber); ok && isInteger(kv) && int(kv) >= 0 && kv < LNumber(MaxArrayIndex) {
+	if init || key != LInteger(0) {
+		if kv, ok := key.(LInteger); ok && kv >= 0 && kv < LInteger(MaxArrayIndex) {
 			index := int(kv)
 			if tb.array != nil {
 				for ; index < len(tb.array); index++ {
 					if v := tb.array[index]; v != LNil {
-						return LNumber(index + 1), v
+						return LInteger(index + 1), v
 					}
 				}
 			}
@@ -385,3 +389,32 @@ func (tb *LTable) Next(key LValue) (LValue, LValue) {
 	}
 	return LNil, LNil
 }
+
+// SetPrivate attaches a hidden value to this table. The value is not stored
+// under any key, so it is invisible to `next`, `rawget` and the functions
+// that iterate over tables. Setting LNil removes it.
+func (tb *LTable) SetPrivate(value LValue) {
+	if value == LNil {
+		value = nil
+	}
+	tb.private = value
+}
+
+// GetPrivate returns the value attached with SetPrivate, or LNil if none.
+func (tb *LTable) GetPrivate() LValue {
+	if tb.private == nil {
+		return LNil
+	}
+	return tb.private
+}
+
+// normalizeKey converts floats with an exact integer representation to
+// integers, so that 1 and 1.0 refer to the same table slot.
+func normalizeKey(key LValue) LValue {
+	if v, ok := key.(LNumber); ok {
+		if iv, ok := floatToInteger(v); ok {
+			return iv
+		}
+	}
+	return key
+}
@@ -178,6 +178,19 @@ func TestTableRawGetH(t *testing.T) {
 	errorIfNotEqual(t, LNil, tbl.RawGetH(LTrue))
 }
 
+func TestTablePrivate(t *testing.T) {
+	tbl := newLTable(0, 0)
+	errorIfNotEqual(t, LNil, tbl.GetPrivate())
+	private := newLTable(0, 0)
+	tbl.SetPrivate(private)
+	errorIfNotEqual(t, private, tbl.GetPrivate())
+	key, value := tbl.Next(LNil)
+	errorIfNotEqual(t, LNil, key)
+	errorIfNotEqual(t, LNil, value)
+	tbl.SetPrivate(LNil)
+	errorIfNotEqual(t, LNil, tbl.GetPrivate())
+}
+
 func TestTableForEach(t *testing.T) {
 	tbl := newLTable(0, 0)
 	tbl.Append(LNumber(1))
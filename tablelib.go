@@ -39,13 +39,13 @@ func tableSort(L *LState) int {
 
 // tableGetN returns the length of the table.
 func tableGetN(L *LState) int {
-	L.Push(LNumber(L.CheckTable(1).Len()))
+	L.Push(LInteger(L.CheckTable(1).Len()))
 	return 1
 }
 
 // tableMaxN returns the maximum numerical index in the table.
 func tableMaxN(L *LState) int {
-	L.Push(LNumber(L.CheckTable(1).MaxN()))
+	L.Push(LInteger(L.CheckTable(1).MaxN()))
 	return 1
 }
 
@@ -136,7 +136,8 @@ func tableToStringRecursive(L *LState, table *LTable, indent string, visited map
 	sort.Slice(keys, func(i, j int) bool {
 		ki, kj := keys[i], keys[j]
 		if ki.Type() == LTNumber && kj.Type() == LTNumber {
-			return LVAsNumber(ki) < LVAsNumber(kj)
+			c, _ := compareNumbers(ki, kj)
+			return c < 0
 		}
 		if ki.Type() == LTNumber {
 			return true
@@ -184,7 +185,7 @@ func valueToStringInTable(value LValue) string {
 	case LTBool:
 		return fmt.Sprintf("%t", LVAsBool(value))
 	case LTNumber:
-		return fmt.Sprintf("%v", LVAsNumber(value))
+		return fmt.Sprintf("%v", value)
 	case LTString:
 		return fmt.Sprintf("%q", LVAsString(value))
 	case LTFunction:
@@ -255,7 +256,8 @@ func Equal(L *LState, a, b LValue) bool {
 	case LTBool:
 		return LVAsBool(a) == LVAsBool(b)
 	case LTNumber:
-		return LVAsNumber(a) == LVAsNumber(b)
+		c, ok := compareNumbers(a, b)
+		return ok && c == 0
 	case LTString:
 		return LVAsString(a) == LVAsString(b)
 	case LTTable:
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
@@ -136,8 +137,8 @@ func isInteger(v LNumber) bool {
 	//return frac == 0.0
 }
 
-func isArrayKey(v LNumber) bool {
-	return isInteger(v) && v < LNumber(int((^uint(0))>>1)) && v > LNumber(0) && v < LNumber(MaxArrayIndex)
+func isArrayIndex(v LInteger) bool {
+	return v > 0 && v < LInteger(MaxArrayIndex)
 }
 
 func parseNumber(number string) (LNumber, error) {
@@ -155,6 +156,91 @@ func parseNumber(number string) (LNumber, error) {
 	return value, nil
 }
 
+// parseNumberValue converts a numeral to a number value. Numerals without a
+// fraction or an exponent that fit in 64 bits become integers.
+func parseNumberValue(number string) (LValue, error) {
+	number = strings.Trim(number, " \t\n")
+	digits, neg := number, false
+	if len(digits) > 0 && (digits[0] == '-' || digits[0] == '+') {
+		digits, neg = digits[1:], digits[0] == '-'
+	}
+	if len(digits) > 2 && digits[0] == '0' && (digits[1] == 'x' || digits[1] == 'X') {
+		// hexadecimal integers wrap around
+		if v, ok := parseHexInteger(digits[2:]); ok {
+			if neg {
+				return -v, nil
+			}
+			return v, nil
+		}
+	} else if v, err := strconv.ParseInt(number, 10, LNumberBit); err == nil {
+		return LInteger(v), nil
+	}
+	v, err := strconv.ParseFloat(number, LNumberBit)
+	if err != nil {
+		return LNumber(0), err
+	}
+	return LNumber(v), nil
+}
+
+// parseHexInteger parses hexadecimal digits into an integer, keeping the
+// lowest 64 bits of a larger value.
+func parseHexInteger(digits string) (LInteger, bool) {
+	var v uint64
+	for _, c := range digits {
+		switch {
+		case '0' <= c && c <= '9':
+			v = v<<4 | uint64(c-'0')
+		case 'a' <= c && c <= 'f':
+			v = v<<4 | uint64(c-'a'+10)
+		case 'A' <= c && c <= 'F':
+			v = v<<4 | uint64(c-'A'+10)
+		default:
+			return 0, false
+		}
+	}
+	return LInteger(v), true
+}
+
+// floatToInteger converts a float with an exact integer representation to an
+// integer.
+func floatToInteger(v LNumber) (LInteger, bool) {
+	f := float64(v)
+	if f >= -9223372036854775808.0 && f < 9223372036854775808.0 && f == math.Floor(f) {
+		return LInteger(f), true
+	}
+	return 0, false
+}
+
+// lvToInteger converts a given LValue to an integer if it is an integer, a
+// float with an exact integer representation or a string holding one of them.
+func lvToInteger(v LValue) (LInteger, bool) {
+	switch lv := v.(type) {
+	case LInteger:
+		return lv, true
+	case LNumber:
+		return floatToInteger(lv)
+	case LString:
+		if num, err := parseNumberValue(string(lv)); err == nil {
+			return lvToInteger(num)
+		}
+	}
+	return 0, false
+}
+
+// lvToNumber converts a given LValue to a number value, keeping the integer
+// subtype.
+func lvToNumber(v LValue) (LValue, bool) {
+	switch lv := v.(type) {
+	case LNumber, LInteger:
+		return lv, true
+	case LString:
+		if num, err := parseNumberValue(string(lv)); err == nil {
+			return num, true
+		}
+	}
+	return LNil, false
+}
+
 func popenArgs(arg string) (string, []string) {
 	cmd := "/bin/sh"
 	args := []string{"-c"}
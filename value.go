@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 )
 
 type LValueType int
@@ -26,6 +28,37 @@ func (vt LValueType) String() string {
 	return lValueNames[int(vt)]
 }
 
+// lValueTypeByName returns the LValueType named by a type hint such as
+// `string` or `Number`. Type hint names are case-insensitive.
+func lValueTypeByName(name string) (LValueType, bool) {
+	name = strings.ToLower(name)
+	for i, n := range lValueNames {
+		if n == name {
+			return LValueType(i), true
+		}
+	}
+	return LTNil, false
+}
+
+// typeMaskString formats a type-check mask(one bit per LValueType) as it
+// would be written in a type hint, i.e. `string` or `string?`.
+func typeMaskString(mask int) string {
+	names := []string{}
+	for i := int(LTBool); i < len(lValueNames); i++ {
+		if mask&(1<<uint(i)) != 0 {
+			names = append(names, lValueNames[i])
+		}
+	}
+	name := strings.Join(names, "|")
+	if mask&(1<<uint(LTNil)) != 0 {
+		if len(names) == 0 {
+			return "nil"
+		}
+		name += "?"
+	}
+	return name
+}
+
 type LValue interface {
 	String() string
 	Type() LValueType
@@ -41,7 +74,7 @@ func LVAsBool(v LValue) bool { return v != LNil && v != LFalse }
 // if the LValue is a string or number, otherwise an empty string.
 func LVAsString(v LValue) string {
 	switch sn := v.(type) {
-	case LString, LNumber:
+	case LString, LNumber, LInteger:
 		return sn.String()
 	default:
 		return ""
@@ -52,7 +85,7 @@ func LVAsString(v LValue) string {
 // otherwise false.
 func LVCanConvToString(v LValue) bool {
 	switch v.(type) {
-	case LString, LNumber:
+	case LString, LNumber, LInteger:
 		return true
 	default:
 		return false
@@ -64,6 +97,8 @@ func LVAsNumber(v LValue) LNumber {
 	switch lv := v.(type) {
 	case LNumber:
 		return lv
+	case LInteger:
+		return LNumber(lv)
 	case LString:
 		if num, err := parseNumber(string(lv)); err == nil {
 			return num
@@ -72,6 +107,22 @@ func LVAsNumber(v LValue) LNumber {
 	return LNumber(0)
 }
 
+// LVAsInteger tries to convert a given LValue to an integer. Numbers with a
+// fractional part and strings that are not numbers convert to 0.
+func LVAsInteger(v LValue) LInteger {
+	if iv, ok := lvToInteger(v); ok {
+		return iv
+	}
+	return LInteger(0)
+}
+
+// LVIsInteger returns true if a given LValue is a number of the integer
+// subtype.
+func LVIsInteger(v LValue) bool {
+	_, ok := v.(LInteger)
+	return ok
+}
+
 type LNilType struct{}
 
 func (nl *LNilType) String() string   { return "nil" }
@@ -140,6 +191,27 @@ func (nm LNumber) Format(f fmt.State, c rune) {
 	}
 }
 
+// LInteger is the integer subtype of numbers. type() reports "number" for both
+// LNumber and LInteger, math.type tells them apart.
+type LInteger int64
+
+func (it LInteger) String() string   { return strconv.FormatInt(int64(it), 10) }
+func (it LInteger) Type() LValueType { return LTNumber }
+
+// fmt.Formatter interface
+func (it LInteger) Format(f fmt.State, c rune) {
+	switch c {
+	case 'q', 's':
+		defaultFormat(it.String(), f, c)
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		defaultFormat(float64(it), f, c)
+	case 'i':
+		defaultFormat(int64(it), f, 'd')
+	default:
+		defaultFormat(int64(it), f, c)
+	}
+}
+
 type LTable struct {
 	Metatable LValue
 
@@ -148,6 +220,7 @@ type LTable struct {
 	strdict map[string]LValue
 	keys    []LValue
 	k2i     map[LValue]int
+	private LValue
 }
 
 func (tb *LTable) String() string   { return fmt.Sprintf("table: %p", tb) }
@@ -191,8 +264,10 @@ type LState struct {
 	currentFrame *callFrame
 	wrapped      bool
 	uvcache      *Upvalue
+	tbcs         []tbcVar
 	hasErrorFunc bool
 	mainLoop     func(*LState, *callFrame)
+	hook         *hookState
 	ctx          context.Context
 	ctxCancelFn  context.CancelFunc
 }
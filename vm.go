@@ -28,6 +28,9 @@ func mainLoop(L *LState, baseframe *callFrame) {
 		cf = L.currentFrame
 		inst = cf.Fn.Proto.Code[cf.Pc]
 		cf.Pc++
+		if L.hook != nil {
+			L.hook.step(L, cf, cf.Pc-1, int(inst>>26))
+		}
 		if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
 			return
 		}
@@ -52,6 +55,9 @@ func mainLoopWithContext(L *LState, baseframe *callFrame) {
 		cf = L.currentFrame
 		inst = cf.Fn.Proto.Code[cf.Pc]
 		cf.Pc++
+		if L.hook != nil {
+			L.hook.step(L, cf, cf.Pc-1, int(inst>>26))
+		}
 		select {
 		case <-L.ctx.Done():
 			L.RaiseError(L.ctx.Err().Error())
@@ -199,7 +205,17 @@ func switchToParentThread(L *LState, nargs int, haserror bool, kill bool) {
 
 func callGFunction(L *LState, tailcall bool) bool {
 	frame := L.currentFrame
+	if L.hook != nil && L.hook.mask&HookMaskCall != 0 {
+		event := HookCall
+		if tailcall {
+			event = HookTailCall
+		}
+		L.hook.call(L, event, frame, -1)
+	}
 	gfnret := frame.Fn.GFunction(L)
+	if L.hook != nil && L.hook.mask&HookMaskReturn != 0 && gfnret >= 0 {
+		L.hook.call(L, HookReturn, frame, -1)
+	}
 	if tailcall {
 		L.currentFrame = L.RemoveCallerFrame()
 	}
@@ -271,13 +287,15 @@ func threadRun(L *LState) {
 
 	defer func() {
 		if rcv := recover(); rcv != nil {
-			var lv LValue
-			if v, ok := rcv.(*ApiError); ok {
-				lv = v.Object
-			} else {
-				lv = LString(fmt.Sprint(rcv))
+			err, ok := rcv.(*ApiError)
+			if !ok {
+				err = newApiErrorS(ApiErrorRun, fmt.Sprint(rcv))
 			}
 			if parent := L.Parent; parent != nil {
+				// the error ends the coroutine, its to-be-closed variables
+				// are closed before the error reaches the resumer
+				err = L.closeTbcsOnError(0, err)
+				lv := err.Object
 				if L.wrapped {
 					L.Push(lv)
 					parent.Panic(L)
@@ -482,7 +500,8 @@ func init() {
 			lbase := cf.LocalBase
 			A := int(inst>>18) & 0xff //GETA
 			RA := lbase + A
-			B := int(inst & 0x1ff) //GETB
+			B := int(inst & 0x1ff)    //GETB
+			C := int(inst>>9) & 0x1ff //GETC
 			for i := RA; i <= lbase+B; i++ {
 				// this section is inlined by go-inline
 				// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
@@ -505,6 +524,9 @@ func init() {
 					}
 				}
 			}
+			if C != 0 {
+				reg.SetTop(lbase + B + 1)
+			}
 			return 0
 		},
 		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_GETUPVAL
@@ -791,6 +813,8 @@ func init() {
 						rg.top = regi + 1
 					}
 				}
+			} else if iv, ok := unaryv.(LInteger); ok {
+				reg.Set(RA, reg.alloc.LInteger2I(-iv))
 			} else {
 				op := L.metaOp1(unaryv, "__unm")
 				if op.Type() == LTFunction {
@@ -914,7 +938,7 @@ func init() {
 				{
 					rg := reg
 					regi := RA
-					vali := LNumber(len(lv))
+					vali := LInteger(len(lv))
 					newSize := regi + 1
 					// this section is inlined by go-inline
 					// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
@@ -924,7 +948,7 @@ func init() {
 							rg.resize(requiredSize)
 						}
 					}
-					rg.array[regi] = rg.alloc.LNumber2I(vali)
+					rg.array[regi] = rg.alloc.LInteger2I(vali)
 					if regi >= rg.top {
 						rg.top = regi + 1
 					}
@@ -936,8 +960,7 @@ func init() {
 					reg.Push(lv)
 					L.Call(1, 1)
 					ret := reg.Pop()
-					if ret.Type() == LTNumber {
-						v, _ := ret.(LNumber)
+					if v, ok := ret.(LNumber); ok {
 						// this section is inlined by go-inline
 						// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
 						{
@@ -986,7 +1009,7 @@ func init() {
 					{
 						rg := reg
 						regi := RA
-						vali := LNumber(lv.(*LTable).Len())
+						vali := LInteger(lv.(*LTable).Len())
 						newSize := regi + 1
 						// this section is inlined by go-inline
 						// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
@@ -996,7 +1019,7 @@ func init() {
 								rg.resize(requiredSize)
 							}
 						}
-						rg.array[regi] = rg.alloc.LNumber2I(vali)
+						rg.array[regi] = rg.alloc.LInteger2I(vali)
 						if regi >= rg.top {
 							rg.top = regi + 1
 						}
@@ -1088,6 +1111,9 @@ func init() {
 			if v1, ok1 := lhs.(LNumber); ok1 {
 				if v2, ok2 := rhs.(LNumber); ok2 {
 					ret = v1 <= v2
+				} else if rhs.Type() == LTNumber {
+					c, ok := compareNumbers(lhs, rhs)
+					ret = ok && c <= 0
 				} else {
 					L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
 				}
@@ -1096,6 +1122,9 @@ func init() {
 					L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
 				}
 				switch lhs.Type() {
+				case LTNumber:
+					c, ok := compareNumbers(lhs, rhs)
+					ret = ok && c <= 0
 				case LTString:
 					ret = strCmp(string(lhs.(LString)), string(rhs.(LString))) <= 0
 				default:
@@ -1290,7 +1319,7 @@ func init() {
 									for i := 0; i < nvarargs; i++ {
 										argtb.RawSetInt(i+1, ls.reg.Get(cf.LocalBase+np+i))
 									}
-									argtb.RawSetString("n", LNumber(nvarargs))
+									argtb.RawSetString("n", LInteger(nvarargs))
 									//ls.reg.Set(cf.LocalBase+nargs+np, argtb)
 									ls.reg.array[cf.LocalBase+nargs+np] = argtb
 								} else {
@@ -1472,7 +1501,7 @@ func init() {
 									for i := 0; i < nvarargs; i++ {
 										argtb.RawSetInt(i+1, ls.reg.Get(cf.LocalBase+np+i))
 									}
-									argtb.RawSetString("n", LNumber(nvarargs))
+									argtb.RawSetString("n", LInteger(nvarargs))
 									//ls.reg.Set(cf.LocalBase+nargs+np, argtb)
 									ls.reg.array[cf.LocalBase+nargs+np] = argtb
 								} else {
@@ -1557,6 +1586,7 @@ func init() {
 					}
 				}
 			}
+			L.closeTbcs(lbase, LNil)
 			nret := B - 1
 			if B == 0 {
 				nret = reg.Top() - RA
@@ -1881,6 +1911,11 @@ func init() {
 				} else {
 					L.RaiseError("for statement limit must be a number")
 				}
+			} else if idx, ok := reg.Get(RA).(LInteger); ok {
+				if integerForLoop(L, RA, idx) {
+					Sbx := int(inst&0x3ffff) - opMaxArgSbx //GETSBX
+					cf.Pc += Sbx
+				}
 			} else {
 				L.RaiseError("for statement init must be a number")
 			}
@@ -1893,6 +1928,10 @@ func init() {
 			A := int(inst>>18) & 0xff //GETA
 			RA := lbase + A
 			Sbx := int(inst&0x3ffff) - opMaxArgSbx //GETSBX
+			if integerForPrep(L, RA) {
+				cf.Pc += Sbx
+				return 0
+			}
 			if init, ok1 := reg.Get(RA).(LNumber); ok1 {
 				if step, ok2 := reg.Get(RA + 2).(LNumber); ok2 {
 					// this section is inlined by go-inline
@@ -2098,6 +2137,7 @@ func init() {
 					}
 				}
 			}
+			L.closeTbcs(RA, LNil)
 			return 0
 		},
 		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_CLOSURE
@@ -2200,13 +2240,271 @@ func init() {
 			}
 			return 0
 		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_TYPECHECK
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			B := int(inst & 0x1ff)    //GETB
+			C := int(inst>>9) & 0x1ff //GETC
+			v := reg.Get(RA)
+			if B&(1<<uint(v.Type())) == 0 {
+				L.RaiseError("bad %v (%v expected, got %v)", cf.Fn.Proto.stringConstants[C], typeMaskString(B), v.Type().String())
+			}
+			return 0
+		},
+		opArith,   // OP_IDIV
+		opBitwise, // OP_BAND
+		opBitwise, // OP_BOR
+		opBitwise, // OP_BXOR
+		opBitwise, // OP_SHL
+		opBitwise, // OP_SHR
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_BNOT
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			B := int(inst & 0x1ff) //GETB
+			unaryv := L.rkValue(B)
+			if iv, ok := unaryv.(LInteger); ok {
+				reg.Set(RA, reg.alloc.LInteger2I(^iv))
+				return 0
+			}
+			if unaryv.Type() == LTNumber {
+				iv, ok := lvToInteger(unaryv)
+				if !ok {
+					L.RaiseError("number has no integer representation")
+				}
+				reg.Set(RA, reg.alloc.LInteger2I(^iv))
+				return 0
+			}
+			if op := L.metaOp1(unaryv, "__bnot"); op.Type() == LTFunction {
+				reg.Push(op)
+				reg.Push(unaryv)
+				L.Call(1, 1)
+				reg.Set(RA, reg.Pop())
+				return 0
+			}
+			if iv, ok := lvToInteger(unaryv); ok {
+				reg.Set(RA, reg.alloc.LInteger2I(^iv))
+				return 0
+			}
+			L.RaiseError("__bnot undefined")
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_TOSTRING
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			B := int(inst & 0x1ff) //GETB
+			v := reg.Get(lbase + B)
+			if _, ok := v.(LString); !ok {
+				v = L.ToStringMeta(v)
+			}
+			reg.Set(RA, v)
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_CLASS
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			Bx := int(inst & 0x3ffff) //GETBX
+			name := cf.Fn.Proto.Constants[Bx].String()
+			reg.Set(RA, newClass(L, name, reg.Get(RA)))
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_TBC
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			Bx := int(inst & 0x3ffff) //GETBX
+			L.newTbc(RA, cf.Fn.Proto.Constants[Bx].String())
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_TESTTYPE
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			B := int(inst & 0x1ff)    //GETB
+			C := int(inst>>9) & 0x1ff //GETC
+			if (B&(1<<uint(reg.Get(RA).Type())) != 0) != (C != 0) {
+				cf.Pc++
+			}
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_JMPTABLE
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			Bx := int(inst & 0x3ffff) //GETBX
+			if pc, ok := cf.Fn.Proto.JumpTables[Bx][normalizeKey(reg.Get(RA))]; ok {
+				cf.Pc = pc
+			}
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_NAMEDARGS
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			B := int(inst & 0x1ff) //GETB
+			args := namedArgs(L, reg.Get(RA), reg.Get(RA+B), B-1)
+			reg.SetTop(RA + B)
+			for _, arg := range args {
+				reg.Push(arg)
+			}
+			return 0
+		},
 		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_NOP
 			return 0
 		},
 	}
 }
 
-func opArith(L *LState, inst uint32, baseframe *callFrame) int { //OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW
+// namedArgs returns the arguments of a f{...} call. Functions with default
+// parameter values receive the fields of the table named after their
+// parameters from the parameter #skip+1 on, positional fields filling the
+// parameters at their index. The table itself is passed when it has no named
+// field or a key that names no parameter, and to other callees.
+func namedArgs(L *LState, fn LValue, arg LValue, skip int) []LValue {
+	lf, ok := fn.(*LFunction)
+	tb, istable := arg.(*LTable)
+	if !ok || lf.IsG || !istable || !lf.Proto.acceptsNamedArgs() {
+		return []LValue{arg}
+	}
+	params := lf.Proto.Params[intMin(skip, len(lf.Proto.Params)):]
+	names := make(map[string]bool, len(params))
+	for _, param := range params {
+		names[param.Name] = true
+	}
+	named, remap := false, true
+	positional := []int{}
+	tb.ForEach(func(key, _ LValue) {
+		switch k := key.(type) {
+		case LString:
+			named = named || names[string(k)]
+			remap = remap && names[string(k)]
+		case LInteger:
+			if k < 1 {
+				remap = false
+			} else {
+				positional = append(positional, int(k))
+			}
+		default:
+			remap = false
+		}
+	})
+	if !named || !remap {
+		return []LValue{arg}
+	}
+	args := make([]LValue, len(params))
+	for i, param := range params {
+		args[i] = tb.RawGetString(param.Name)
+	}
+	// report the first positional field without a parameter of its own
+	bad := 0
+	for _, k := range positional {
+		if (k > len(params) || args[k-1] != LNil) && (bad == 0 || k < bad) {
+			bad = k
+		}
+	}
+	switch {
+	case bad > len(params):
+		L.RaiseError("too many positional arguments (expected at most %v)", len(params))
+	case bad > 0:
+		L.RaiseError("named argument '%v' is also given as positional argument #%v", params[bad-1].Name, bad)
+	}
+	for _, k := range positional {
+		args[k-1] = tb.RawGetInt(k)
+	}
+	return args
+}
+
+// integerForPrep prepares a numeric for loop whose initial value and step are
+// integers. The limit register is replaced with the number of iterations left.
+// Loops with a float initial value or step are converted to float loops.
+func integerForPrep(L *LState, RA int) bool {
+	reg := L.reg
+	init, ok1 := reg.Get(RA).(LInteger)
+	step, ok2 := reg.Get(RA + 2).(LInteger)
+	if !ok1 || !ok2 {
+		for i := RA; i < RA+3; i++ {
+			if iv, ok := reg.Get(i).(LInteger); ok {
+				reg.Set(i, LNumber(iv))
+			}
+		}
+		return false
+	}
+	if step == 0 {
+		L.RaiseError("for statement step must not be zero")
+	}
+	var limit LInteger
+	switch lv := reg.Get(RA + 1).(type) {
+	case LInteger:
+		limit = lv
+	case LNumber:
+		f := float64(lv)
+		if step > 0 {
+			f = math.Floor(f)
+		} else {
+			f = math.Ceil(f)
+		}
+		switch {
+		case math.IsNaN(f):
+			limit, step = 0, 1
+			init = 1
+		case f >= 9223372036854775808.0:
+			limit = math.MaxInt64
+		case f < -9223372036854775808.0:
+			limit = math.MinInt64
+		default:
+			limit = LInteger(f)
+		}
+	default:
+		L.RaiseError("for statement limit must be a number")
+	}
+	var count uint64
+	if step > 0 && init <= limit {
+		count = (uint64(limit)-uint64(init))/uint64(step) + 1
+	} else if step < 0 && init >= limit {
+		count = (uint64(init)-uint64(limit))/(uint64(-(step+1))+1) + 1
+	}
+	reg.Set(RA, init-step)
+	reg.Set(RA+1, LInteger(count))
+	reg.Set(RA+2, step)
+	return true
+}
+
+// integerForLoop advances an integer numeric for loop. It reports whether the
+// loop body runs again.
+func integerForLoop(L *LState, RA int, idx LInteger) bool {
+	reg := L.reg
+	count := uint64(reg.Get(RA + 1).(LInteger))
+	if count == 0 {
+		reg.SetTop(RA + 1)
+		return false
+	}
+	idx += reg.Get(RA + 2).(LInteger)
+	v := reg.alloc.LInteger2I(idx)
+	reg.Set(RA, v)
+	reg.Set(RA+1, reg.alloc.LInteger2I(LInteger(count-1)))
+	reg.Set(RA+3, v)
+	return true
+}
+
+func opArith(L *LState, inst uint32, baseframe *callFrame) int { //OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW, OP_IDIV
 	reg := L.reg
 	cf := L.currentFrame
 	lbase := cf.LocalBase
@@ -2241,6 +2539,12 @@ func opArith(L *LState, inst uint32, baseframe *callFrame) int { //OP_ADD, OP_SU
 				rg.top = regi + 1
 			}
 		}
+	} else if i1, ok := lhs.(LInteger); ok && isIntegerOp(opcode) {
+		if i2, ok := rhs.(LInteger); ok {
+			reg.Set(RA, reg.alloc.LInteger2I(integerArith(L, opcode, i1, i2)))
+		} else {
+			reg.Set(RA, objectArith(L, opcode, lhs, rhs))
+		}
 	} else {
 		v := objectArith(L, opcode, lhs, rhs)
 		// this section is inlined by go-inline
@@ -2267,6 +2571,27 @@ func opArith(L *LState, inst uint32, baseframe *callFrame) int { //OP_ADD, OP_SU
 	return 0
 }
 
+func opBitwise(L *LState, inst uint32, baseframe *callFrame) int { //OP_BAND, OP_BOR, OP_BXOR, OP_SHL, OP_SHR
+	reg := L.reg
+	cf := L.currentFrame
+	lbase := cf.LocalBase
+	A := int(inst>>18) & 0xff //GETA
+	RA := lbase + A
+	opcode := int(inst >> 26) //GETOPCODE
+	B := int(inst & 0x1ff)    //GETB
+	C := int(inst>>9) & 0x1ff //GETC
+	lhs := L.rkValue(B)
+	rhs := L.rkValue(C)
+	if i1, ok := lhs.(LInteger); ok {
+		if i2, ok := rhs.(LInteger); ok {
+			reg.Set(RA, reg.alloc.LInteger2I(bitwiseArith(opcode, i1, i2)))
+			return 0
+		}
+	}
+	reg.Set(RA, objectArith(L, opcode, lhs, rhs))
+	return 0
+}
+
 func luaModulo(lhs, rhs LNumber) LNumber {
 	flhs := float64(lhs)
 	frhs := float64(rhs)
@@ -2277,6 +2602,192 @@ func luaModulo(lhs, rhs LNumber) LNumber {
 	return LNumber(v)
 }
 
+// isIntegerOp reports whether an arithmetic opcode keeps the integer subtype
+// when both of its operands are integers.
+func isIntegerOp(opcode int) bool {
+	switch opcode {
+	case OP_ADD, OP_SUB, OP_MUL, OP_MOD, OP_IDIV:
+		return true
+	}
+	return false
+}
+
+// isBitwiseOp reports whether an opcode is a binary bitwise operation. Its
+// operands are always converted to integers.
+func isBitwiseOp(opcode int) bool {
+	switch opcode {
+	case OP_BAND, OP_BOR, OP_BXOR, OP_SHL, OP_SHR:
+		return true
+	}
+	return false
+}
+
+func integerArith(L *LState, opcode int, lhs, rhs LInteger) LInteger {
+	switch opcode {
+	case OP_ADD:
+		return lhs + rhs
+	case OP_SUB:
+		return lhs - rhs
+	case OP_MUL:
+		return lhs * rhs
+	case OP_MOD:
+		if rhs == 0 {
+			L.RaiseError("attempt to perform '%v'", "n%0")
+		}
+		if rhs == -1 {
+			return 0
+		}
+		v := lhs % rhs
+		if v != 0 && (v^rhs) < 0 {
+			v += rhs
+		}
+		return v
+	case OP_IDIV:
+		if rhs == 0 {
+			L.RaiseError("attempt to perform '%v'", "n//0")
+		}
+		if rhs == -1 {
+			return -lhs
+		}
+		v := lhs / rhs
+		if lhs%rhs != 0 && (lhs^rhs) < 0 {
+			v--
+		}
+		return v
+	}
+	panic("should not reach here")
+}
+
+func bitwiseArith(opcode int, lhs, rhs LInteger) LInteger {
+	switch opcode {
+	case OP_BAND:
+		return lhs & rhs
+	case OP_BOR:
+		return lhs | rhs
+	case OP_BXOR:
+		return lhs ^ rhs
+	case OP_SHL:
+		return shiftLeft(lhs, rhs)
+	case OP_SHR:
+		return shiftLeft(lhs, -rhs)
+	}
+	panic("should not reach here")
+}
+
+// shiftLeft shifts v logically by n bits, to the right if n is negative.
+// Shifts by 64 bits or more result in zero.
+func shiftLeft(v, n LInteger) LInteger {
+	switch {
+	case n <= -64 || n >= 64:
+		return 0
+	case n < 0:
+		return LInteger(uint64(v) >> uint(-n))
+	}
+	return LInteger(uint64(v) << uint(n))
+}
+
+// bitwiseNumbers performs a bitwise operation if both operands are numbers.
+// Floats are accepted only if they have an exact integer representation.
+func bitwiseNumbers(L *LState, opcode int, lhs, rhs LValue) (LValue, bool) {
+	if lhs.Type() != LTNumber || rhs.Type() != LTNumber {
+		return LNil, false
+	}
+	i1, ok1 := lvToInteger(lhs)
+	i2, ok2 := lvToInteger(rhs)
+	if !ok1 || !ok2 {
+		L.RaiseError("number has no integer representation")
+	}
+	return bitwiseArith(opcode, i1, i2), true
+}
+
+// arithNumbers performs an arithmetic operation if both operands are numbers.
+// The result is an integer if both operands are integers and the operation
+// keeps the integer subtype, otherwise it is a float.
+func arithNumbers(L *LState, opcode int, lhs, rhs LValue) (LValue, bool) {
+	if isBitwiseOp(opcode) {
+		return bitwiseNumbers(L, opcode, lhs, rhs)
+	}
+	switch v1 := lhs.(type) {
+	case LNumber:
+		switch v2 := rhs.(type) {
+		case LNumber:
+			return numberArith(L, opcode, v1, v2), true
+		case LInteger:
+			return numberArith(L, opcode, v1, LNumber(v2)), true
+		}
+	case LInteger:
+		switch v2 := rhs.(type) {
+		case LNumber:
+			return numberArith(L, opcode, LNumber(v1), v2), true
+		case LInteger:
+			if isIntegerOp(opcode) {
+				return integerArith(L, opcode, v1, v2), true
+			}
+			return numberArith(L, opcode, LNumber(v1), LNumber(v2)), true
+		}
+	}
+	return LNil, false
+}
+
+// compareNumbers compares two numbers of any subtype without losing
+// precision. It returns false if the numbers are not ordered (NaN).
+func compareNumbers(lhs, rhs LValue) (int, bool) {
+	switch v1 := lhs.(type) {
+	case LNumber:
+		switch v2 := rhs.(type) {
+		case LNumber:
+			switch {
+			case v1 < v2:
+				return -1, true
+			case v1 > v2:
+				return 1, true
+			case v1 == v2:
+				return 0, true
+			}
+			return 0, false
+		case LInteger:
+			c, ok := compareIntegerFloat(v2, v1)
+			return -c, ok
+		}
+	case LInteger:
+		switch v2 := rhs.(type) {
+		case LNumber:
+			return compareIntegerFloat(v1, v2)
+		case LInteger:
+			switch {
+			case v1 < v2:
+				return -1, true
+			case v1 > v2:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func compareIntegerFloat(i LInteger, f LNumber) (int, bool) {
+	fv := float64(f)
+	switch {
+	case math.IsNaN(fv):
+		return 0, false
+	case fv >= 9223372036854775808.0:
+		return -1, true
+	case fv < -9223372036854775808.0:
+		return 1, true
+	}
+	floor := math.Floor(fv)
+	switch fi := LInteger(floor); {
+	case i < fi:
+		return -1, true
+	case i > fi:
+		return 1, true
+	case floor < fv:
+		return -1, true
+	}
+	return 0, true
+}
+
 func numberArith(L *LState, opcode int, lhs, rhs LNumber) LNumber {
 	switch opcode {
 	case OP_ADD:
@@ -2289,6 +2800,8 @@ func numberArith(L *LState, opcode int, lhs, rhs LNumber) LNumber {
 		return lhs / rhs
 	case OP_MOD:
 		return luaModulo(lhs, rhs)
+	case OP_IDIV:
+		return LNumber(math.Floor(float64(lhs / rhs)))
 	case OP_POW:
 		flhs := float64(lhs)
 		frhs := float64(rhs)
@@ -2313,6 +2826,21 @@ func objectArith(L *LState, opcode int, lhs, rhs LValue) LValue {
 		event = "__mod"
 	case OP_POW:
 		event = "__pow"
+	case OP_IDIV:
+		event = "__idiv"
+	case OP_BAND:
+		event = "__band"
+	case OP_BOR:
+		event = "__bor"
+	case OP_BXOR:
+		event = "__bxor"
+	case OP_SHL:
+		event = "__shl"
+	case OP_SHR:
+		event = "__shr"
+	}
+	if v, ok := arithNumbers(L, opcode, lhs, rhs); ok {
+		return v
 	}
 	op := L.metaOp2(lhs, rhs, event)
 	if _, ok := op.(*LFunction); ok {
@@ -2323,19 +2851,17 @@ func objectArith(L *LState, opcode int, lhs, rhs LValue) LValue {
 		return L.reg.Pop()
 	}
 	if str, ok := lhs.(LString); ok {
-		if lnum, err := parseNumber(string(str)); err == nil {
+		if lnum, err := parseNumberValue(string(str)); err == nil {
 			lhs = lnum
 		}
 	}
 	if str, ok := rhs.(LString); ok {
-		if rnum, err := parseNumber(string(str)); err == nil {
+		if rnum, err := parseNumberValue(string(str)); err == nil {
 			rhs = rnum
 		}
 	}
-	if v1, ok1 := lhs.(LNumber); ok1 {
-		if v2, ok2 := rhs.(LNumber); ok2 {
-			return numberArith(L, opcode, LNumber(v1), LNumber(v2))
-		}
+	if v, ok := arithNumbers(L, opcode, lhs, rhs); ok {
+		return v
 	}
 	L.RaiseError(fmt.Sprintf("cannot perform %v operation between %v and %v",
 		strings.TrimLeft(event, "_"), lhs.Type().String(), rhs.Type().String()))
@@ -2386,7 +2912,9 @@ func lessThan(L *LState, lhs, rhs LValue) bool {
 		if v2, ok2 := rhs.(LNumber); ok2 {
 			return v1 < v2
 		}
-		L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
+		if rhs.Type() != LTNumber {
+			L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
+		}
 	}
 	if lhs.Type() != rhs.Type() {
 		L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
@@ -2394,6 +2922,9 @@ func lessThan(L *LState, lhs, rhs LValue) bool {
 	}
 	ret := false
 	switch lhs.Type() {
+	case LTNumber:
+		c, ok := compareNumbers(lhs, rhs)
+		ret = ok && c < 0
 	case LTString:
 		ret = strCmp(string(lhs.(LString)), string(rhs.(LString))) < 0
 	default:
@@ -2413,9 +2944,14 @@ func equals(L *LState, lhs, rhs LValue, raw bool) bool {
 	case LTNil:
 		ret = true
 	case LTNumber:
-		v1, _ := lhs.(LNumber)
-		v2, _ := rhs.(LNumber)
-		ret = v1 == v2
+		if v1, ok := lhs.(LNumber); ok {
+			if v2, ok := rhs.(LNumber); ok {
+				ret = v1 == v2
+				break
+			}
+		}
+		c, ok := compareNumbers(lhs, rhs)
+		ret = ok && c == 0
 	case LTBool:
 		ret = bool(lhs.(LBool)) == bool(rhs.(LBool))
 	case LTString: